@@ -2,6 +2,7 @@ package access_log
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 
 type AccessLogRecord struct {
 	Request              *http.Request
+	ClientAddr           string
 	StatusCode           int
 	RouteEndpoint        *route.Endpoint
 	StartedAt            time.Time
@@ -22,6 +24,7 @@ type AccessLogRecord struct {
 	BodyBytesSent        int
 	RequestBytesReceived int
 	ExtraHeadersToLog    []string
+	TraceId              string
 	record               string
 }
 
@@ -37,6 +40,15 @@ func (r *AccessLogRecord) FormatRequestHeader(k string) (v string) {
 	return
 }
 
+// RemoteAddr is the address of the client, which differs from the address
+// the request was received from when it came through a trusted proxy.
+func (r *AccessLogRecord) RemoteAddr() string {
+	if r.ClientAddr != "" {
+		return r.ClientAddr
+	}
+	return r.Request.RemoteAddr
+}
+
 func (r *AccessLogRecord) ResponseTime() float64 {
 	return float64(r.FinishedAt.UnixNano()-r.StartedAt.UnixNano()) / float64(time.Second)
 }
@@ -51,7 +63,7 @@ func (r *AccessLogRecord) getRecord() string {
 }
 
 func (r *AccessLogRecord) makeRecord() string {
-	statusCode, responseTime, appId, extraHeaders := "-", "-", "-", ""
+	statusCode, responseTime, appId, backend, traceId, extraHeaders := "-", "-", "-", "-", "", ""
 
 	if r.StatusCode != 0 {
 		statusCode = strconv.Itoa(r.StatusCode)
@@ -63,13 +75,20 @@ func (r *AccessLogRecord) makeRecord() string {
 
 	if r.RouteEndpoint != nil {
 		appId = r.RouteEndpoint.ApplicationId
+		if addr := r.RouteEndpoint.CanonicalAddr(); addr != "" {
+			backend = addr
+		}
+	}
+
+	if r.TraceId != "" {
+		traceId = " trace_id:" + r.TraceId
 	}
 
 	if r.ExtraHeadersToLog != nil && len(r.ExtraHeadersToLog) > 0 {
 		extraHeaders = r.ExtraHeaders()
 	}
 
-	return fmt.Sprintf(`%s - [%s] "%s %s %s" %s %d %d "%s" "%s" %s x_forwarded_for:"%s" x_forwarded_proto:"%s" vcap_request_id:%s response_time:%s app_id:%s%s`+"\n",
+	return fmt.Sprintf(`%s - [%s] "%s %s %s" %s %d %d "%s" "%s" %s x_forwarded_for:"%s" x_forwarded_proto:"%s" vcap_request_id:%s x_request_id:%s x_request_start:%s response_time:%s app_id:%s backend:%s%s%s`+"\n",
 		r.Request.Host,
 		r.FormatStartedAt(),
 		r.Request.Method,
@@ -80,20 +99,150 @@ func (r *AccessLogRecord) makeRecord() string {
 		r.BodyBytesSent,
 		r.FormatRequestHeader("Referer"),
 		r.FormatRequestHeader("User-Agent"),
-		r.Request.RemoteAddr,
+		r.RemoteAddr(),
 		r.FormatRequestHeader("X-Forwarded-For"),
 		r.FormatRequestHeader("X-Forwarded-Proto"),
 		r.FormatRequestHeader("X-Vcap-Request-Id"),
+		r.FormatRequestHeader("X-Request-Id"),
+		r.FormatRequestHeader("X-Request-Start"),
 		responseTime,
 		appId,
+		backend,
+		traceId,
 		extraHeaders)
 }
 
+type jsonRecord struct {
+	Timestamp         string            `json:"timestamp"`
+	ClientIp          string            `json:"client_ip"`
+	Method            string            `json:"method"`
+	Host              string            `json:"host"`
+	Path              string            `json:"path"`
+	Proto             string            `json:"proto"`
+	StatusCode        int               `json:"status_code,omitempty"`
+	RequestBytes      int               `json:"request_bytes"`
+	ResponseBytes     int               `json:"response_bytes"`
+	ResponseTime      *float64          `json:"response_time,omitempty"`
+	Backend           string            `json:"backend,omitempty"`
+	AppId             string            `json:"app_id,omitempty"`
+	Referer           string            `json:"referer,omitempty"`
+	UserAgent         string            `json:"user_agent,omitempty"`
+	XForwardedFor     string            `json:"x_forwarded_for,omitempty"`
+	XForwardedProto   string            `json:"x_forwarded_proto,omitempty"`
+	VcapRequestId     string            `json:"vcap_request_id,omitempty"`
+	RequestId         string            `json:"request_id,omitempty"`
+	RequestStart      string            `json:"request_start,omitempty"`
+	TraceId           string            `json:"trace_id,omitempty"`
+	ExtraHeadersToLog map[string]string `json:"extra_headers,omitempty"`
+}
+
+// slowRecord adds the breakdown of the response time to the JSON record: the
+// time until the backend's response started, and the time spent sending it.
+type slowRecord struct {
+	jsonRecord
+	FirstByteTime *float64 `json:"first_byte_time,omitempty"`
+	TransferTime  *float64 `json:"transfer_time,omitempty"`
+}
+
+func (r *AccessLogRecord) makeJSONRecord() ([]byte, error) {
+	b, err := json.Marshal(r.toJSONRecord())
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (r *AccessLogRecord) makeSlowRecord() ([]byte, error) {
+	s := slowRecord{jsonRecord: r.toJSONRecord()}
+
+	if !r.FirstByteAt.IsZero() {
+		firstByteTime := r.FirstByteAt.Sub(r.StartedAt).Seconds()
+		transferTime := r.FinishedAt.Sub(r.FirstByteAt).Seconds()
+		s.FirstByteTime = &firstByteTime
+		s.TransferTime = &transferTime
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (r *AccessLogRecord) toJSONRecord() jsonRecord {
+	j := jsonRecord{
+		Timestamp:       r.StartedAt.Format(time.RFC3339Nano),
+		ClientIp:        r.RemoteAddr(),
+		Method:          r.Request.Method,
+		Host:            r.Request.Host,
+		Path:            r.Request.URL.RequestURI(),
+		Proto:           r.Request.Proto,
+		StatusCode:      r.StatusCode,
+		RequestBytes:    r.RequestBytesReceived,
+		ResponseBytes:   r.BodyBytesSent,
+		AppId:           r.ApplicationId(),
+		Referer:         r.Request.Header.Get("Referer"),
+		UserAgent:       r.Request.Header.Get("User-Agent"),
+		XForwardedFor:   r.Request.Header.Get("X-Forwarded-For"),
+		XForwardedProto: r.Request.Header.Get("X-Forwarded-Proto"),
+		VcapRequestId:   r.Request.Header.Get("X-Vcap-Request-Id"),
+		RequestId:       r.Request.Header.Get("X-Request-Id"),
+		RequestStart:    r.Request.Header.Get("X-Request-Start"),
+		TraceId:         r.TraceId,
+	}
+
+	if responseTime := r.ResponseTime(); responseTime >= 0 {
+		j.ResponseTime = &responseTime
+	}
+
+	if r.RouteEndpoint != nil {
+		if addr := r.RouteEndpoint.CanonicalAddr(); addr != "" {
+			j.Backend = addr
+		}
+	}
+
+	if len(r.ExtraHeadersToLog) > 0 {
+		j.ExtraHeadersToLog = make(map[string]string, len(r.ExtraHeadersToLog))
+		for _, header := range r.ExtraHeadersToLog {
+			j.ExtraHeadersToLog[header] = r.Request.Header.Get(header)
+		}
+	}
+
+	return j
+}
+
 func (r *AccessLogRecord) WriteTo(w io.Writer) (int64, error) {
 	recordBuffer := bytes.NewBufferString(r.getRecord())
 	return recordBuffer.WriteTo(w)
 }
 
+func (r *AccessLogRecord) WriteJSONTo(w io.Writer) (int64, error) {
+	b, err := r.makeJSONRecord()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// IsSlow tells whether the request took at least the threshold to complete.
+func (r *AccessLogRecord) IsSlow(threshold time.Duration) bool {
+	return !r.FinishedAt.IsZero() && r.FinishedAt.Sub(r.StartedAt) >= threshold
+}
+
+// WriteSlowTo writes the record as JSON along with the breakdown of its
+// response time.
+func (r *AccessLogRecord) WriteSlowTo(w io.Writer) (int64, error) {
+	b, err := r.makeSlowRecord()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
 func (r *AccessLogRecord) ApplicationId() string {
 	if r.RouteEndpoint == nil || r.RouteEndpoint.ApplicationId == "" {
 		return ""
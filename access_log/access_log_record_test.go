@@ -8,6 +8,8 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"time"
@@ -30,6 +32,8 @@ var _ = Describe("AccessLogRecord", func() {
 					"X-Forwarded-For":               []string{"FakeProxy1, FakeProxy2"},
 					"X-Forwarded-Proto":             []string{"FakeOriginalRequestProto"},
 					router_http.VcapRequestIdHeader: []string{"abc-123-xyz-pdq"},
+					router_http.RequestIdHeader:     []string{"some-request-id"},
+					"X-Request-Start":               []string{"946684800000"},
 				},
 				RemoteAddr: "FakeRemoteAddr",
 			},
@@ -55,8 +59,11 @@ var _ = Describe("AccessLogRecord", func() {
 			"x_forwarded_for:\"FakeProxy1, FakeProxy2\" " +
 			"x_forwarded_proto:\"FakeOriginalRequestProto\" " +
 			"vcap_request_id:abc-123-xyz-pdq " +
+			"x_request_id:some-request-id " +
+			"x_request_start:946684800000 " +
 			"response_time:60 " +
-			"app_id:FakeApplicationId" +
+			"app_id:FakeApplicationId " +
+			"backend:-" +
 			"\n"
 
 		Expect(record.LogMessage()).To(Equal(recordString))
@@ -92,13 +99,152 @@ var _ = Describe("AccessLogRecord", func() {
 			"x_forwarded_for:\"-\" " +
 			"x_forwarded_proto:\"-\" " +
 			"vcap_request_id:- " +
+			"x_request_id:- " +
+			"x_request_start:- " +
 			"response_time:- " +
-			"app_id:FakeApplicationId" +
+			"app_id:FakeApplicationId " +
+			"backend:-" +
 			"\n"
 
 		Expect(record.LogMessage()).To(Equal(recordString))
 	})
 
+	It("includes the address of the backend", func() {
+		record := AccessLogRecord{
+			Request: &http.Request{
+				Host:       "FakeRequestHost",
+				Method:     "GET",
+				Proto:      "HTTP/1.1",
+				URL:        &url.URL{Path: "/"},
+				Header:     http.Header{},
+				RemoteAddr: "FakeRemoteAddr",
+			},
+			RouteEndpoint: route.NewEndpoint("FakeApplicationId", "10.0.0.1", 8080, "", nil, -1, ""),
+			StartedAt:     time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+		}
+
+		Expect(record.LogMessage()).To(HaveSuffix("app_id:FakeApplicationId backend:10.0.0.1:8080\n"))
+	})
+
+	It("includes the trace ID when known", func() {
+		record := AccessLogRecord{
+			Request: &http.Request{
+				Host:       "FakeRequestHost",
+				Method:     "GET",
+				Proto:      "HTTP/1.1",
+				URL:        &url.URL{Path: "/"},
+				Header:     http.Header{},
+				RemoteAddr: "FakeRemoteAddr",
+			},
+			RouteEndpoint: route.NewEndpoint("FakeApplicationId", "10.0.0.1", 8080, "", nil, -1, ""),
+			StartedAt:     time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+			TraceId:       "4bf92f3577b34da6a3ce929d0e0e4736",
+		}
+
+		Expect(record.LogMessage()).To(HaveSuffix("backend:10.0.0.1:8080 trace_id:4bf92f3577b34da6a3ce929d0e0e4736\n"))
+	})
+
+	It("logs the client address in place of the remote address when known", func() {
+		record := AccessLogRecord{
+			Request: &http.Request{
+				Host:       "FakeRequestHost",
+				Method:     "GET",
+				Proto:      "HTTP/1.1",
+				URL:        &url.URL{Path: "/"},
+				Header:     http.Header{},
+				RemoteAddr: "10.0.0.1:4567",
+			},
+			ClientAddr:    "1.2.3.4",
+			RouteEndpoint: route.NewEndpoint("FakeApplicationId", "10.0.0.2", 8080, "", nil, -1, ""),
+			StartedAt:     time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+		}
+
+		Expect(record.LogMessage()).To(ContainSubstring(`"-" "-" 1.2.3.4 x_forwarded_for:`))
+		Expect(record.LogMessage()).NotTo(ContainSubstring("10.0.0.1:4567"))
+	})
+
+	Describe("WriteJSONTo", func() {
+		It("writes the record as a single line of JSON", func() {
+			record := AccessLogRecord{
+				Request: &http.Request{
+					Host:   "FakeRequestHost",
+					Method: "POST",
+					Proto:  "HTTP/1.1",
+					URL:    &url.URL{Path: "/some/path", RawQuery: "q=1"},
+					Header: http.Header{
+						"User-Agent":                    []string{"FakeUserAgent"},
+						"X-Forwarded-For":               []string{"FakeProxy1"},
+						"Cache-Control":                 []string{"no-cache"},
+						router_http.VcapRequestIdHeader: []string{"abc-123-xyz-pdq"},
+					},
+					RemoteAddr: "1.2.3.4:5678",
+				},
+				StatusCode:           201,
+				BodyBytesSent:        23,
+				RequestBytesReceived: 30,
+				RouteEndpoint:        route.NewEndpoint("FakeApplicationId", "10.0.0.1", 8080, "", nil, -1, ""),
+				StartedAt:            time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+				FinishedAt:           time.Date(2000, time.January, 1, 0, 0, 1, 500000000, time.UTC),
+				ExtraHeadersToLog:    []string{"Cache-Control"},
+				TraceId:              "4bf92f3577b34da6a3ce929d0e0e4736",
+			}
+
+			var buf bytes.Buffer
+			_, err := record.WriteJSONTo(&buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buf.String()).To(HaveSuffix("}\n"))
+
+			var fields map[string]interface{}
+			Expect(json.Unmarshal(buf.Bytes(), &fields)).To(Succeed())
+
+			Expect(fields).To(Equal(map[string]interface{}{
+				"timestamp":       "2000-01-01T00:00:00Z",
+				"client_ip":       "1.2.3.4:5678",
+				"method":          "POST",
+				"host":            "FakeRequestHost",
+				"path":            "/some/path?q=1",
+				"proto":           "HTTP/1.1",
+				"status_code":     float64(201),
+				"request_bytes":   float64(30),
+				"response_bytes":  float64(23),
+				"response_time":   1.5,
+				"backend":         "10.0.0.1:8080",
+				"app_id":          "FakeApplicationId",
+				"user_agent":      "FakeUserAgent",
+				"x_forwarded_for": "FakeProxy1",
+				"vcap_request_id": "abc-123-xyz-pdq",
+				"trace_id":        "4bf92f3577b34da6a3ce929d0e0e4736",
+				"extra_headers":   map[string]interface{}{"Cache-Control": "no-cache"},
+			}))
+		})
+
+		It("omits the values that are not known", func() {
+			record := AccessLogRecord{
+				Request: &http.Request{
+					Host:       "FakeRequestHost",
+					Method:     "GET",
+					Proto:      "HTTP/1.1",
+					URL:        &url.URL{Path: "/"},
+					Header:     http.Header{},
+					RemoteAddr: "1.2.3.4:5678",
+				},
+				StartedAt: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+			}
+
+			var buf bytes.Buffer
+			_, err := record.WriteJSONTo(&buf)
+			Expect(err).ToNot(HaveOccurred())
+
+			var fields map[string]interface{}
+			Expect(json.Unmarshal(buf.Bytes(), &fields)).To(Succeed())
+
+			Expect(fields).ToNot(HaveKey("status_code"))
+			Expect(fields).ToNot(HaveKey("response_time"))
+			Expect(fields).ToNot(HaveKey("backend"))
+			Expect(fields).ToNot(HaveKey("app_id"))
+		})
+	})
+
 	It("does not create a log message when route endpoint missing", func() {
 		record := AccessLogRecord{}
 		Expect(record.LogMessage()).To(Equal(""))
@@ -139,8 +285,11 @@ var _ = Describe("AccessLogRecord", func() {
 			"x_forwarded_for:\"-\" " +
 			"x_forwarded_proto:\"-\" " +
 			"vcap_request_id:- " +
+			"x_request_id:- " +
+			"x_request_start:- " +
 			"response_time:- " +
 			"app_id:FakeApplicationId " +
+			"backend:- " +
 			"cache_control:\"no-cache\" " +
 			"accept_encoding:\"gzip, deflate\" " +
 			"if_match:\"\\\"737060cd8c284d8af7ad3082f209582d\\\"\" " +
@@ -10,7 +10,7 @@ import (
 
 func CreateRunningAccessLogger(config *config.Config) (AccessLogger, error) {
 
-	if config.AccessLog == "" && !config.Logging.LoggregatorEnabled {
+	if config.AccessLog == "" && !config.Logging.LoggregatorEnabled && config.SlowRequestLog == "" {
 		return &NullAccessLogger{}, nil
 	}
 
@@ -18,7 +18,9 @@ func CreateRunningAccessLogger(config *config.Config) (AccessLogger, error) {
 
 	var err error
 	var file *os.File
-	if config.AccessLog != "" {
+	if config.AccessLog == "stdout" {
+		file = os.Stdout
+	} else if config.AccessLog != "" {
 		file, err = os.OpenFile(config.AccessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
 		if err != nil {
 			logger.Errorf("Error creating accesslog file, %s: (%s)", config.AccessLog, err.Error())
@@ -26,12 +28,26 @@ func CreateRunningAccessLogger(config *config.Config) (AccessLogger, error) {
 		}
 	}
 
+	var slowFile *os.File
+	if config.SlowRequestLog == "stdout" {
+		slowFile = os.Stdout
+	} else if config.SlowRequestLog != "" {
+		slowFile, err = os.OpenFile(config.SlowRequestLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			logger.Errorf("Error creating slow request log file, %s: (%s)", config.SlowRequestLog, err.Error())
+			return nil, err
+		}
+	}
+
 	var dropsondeSourceInstance string
 	if config.Logging.LoggregatorEnabled {
 		dropsondeSourceInstance = strconv.FormatUint(uint64(config.Index), 10)
 	}
 
-	accessLogger := NewFileAndLoggregatorAccessLogger(file, dropsondeSourceInstance)
+	accessLogger := NewFileAndLoggregatorAccessLogger(file, dropsondeSourceInstance, config.AccessLogFormat)
+	if slowFile != nil {
+		accessLogger.LogSlowRequests(slowFile, config.SlowRequestThreshold)
+	}
 	go accessLogger.Run()
 	return accessLogger, nil
 }
@@ -6,6 +6,9 @@ import (
 	"github.com/cloudfoundry/gorouter/config"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"os"
+	"time"
 )
 
 var _ = Describe("AccessLog", func() {
@@ -48,6 +51,34 @@ var _ = Describe("AccessLog", func() {
 
 	})
 
+	It("writes the access log to stdout when configured to", func() {
+		config := config.DefaultConfig()
+		config.AccessLog = "stdout"
+
+		accessLogger, err := CreateRunningAccessLogger(config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(accessLogger.(*FileAndLoggregatorAccessLogger).FileWriter()).To(Equal(os.Stdout))
+	})
+
+	It("uses the configured format", func() {
+		config := config.DefaultConfig()
+		config.AccessLog = "/dev/null"
+		config.AccessLogFormat = "json"
+
+		accessLogger, _ := CreateRunningAccessLogger(config)
+		Expect(accessLogger.(*FileAndLoggregatorAccessLogger).Format()).To(Equal("json"))
+	})
+
+	It("creates a slow request log if one is specified", func() {
+		config := config.DefaultConfig()
+		config.SlowRequestLog = "stdout"
+		config.SlowRequestThreshold = time.Second
+
+		accessLogger, err := CreateRunningAccessLogger(config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(accessLogger.(*FileAndLoggregatorAccessLogger).SlowRequestWriter()).To(Equal(os.Stdout))
+	})
+
 	It("reports an error if the access log location is invalid", func() {
 		config := config.DefaultConfig()
 		config.AccessLog = "/this\\is/illegal"
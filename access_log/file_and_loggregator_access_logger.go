@@ -3,8 +3,10 @@ package access_log
 import (
 	"io"
 	"regexp"
+	"time"
 
 	"github.com/cloudfoundry/dropsonde/logs"
+	"github.com/cloudfoundry/gorouter/config"
 )
 
 type FileAndLoggregatorAccessLogger struct {
@@ -12,12 +14,17 @@ type FileAndLoggregatorAccessLogger struct {
 	channel                 chan AccessLogRecord
 	stopCh                  chan struct{}
 	writer                  io.Writer
+	format                  string
+
+	slowWriter    io.Writer
+	slowThreshold time.Duration
 }
 
-func NewFileAndLoggregatorAccessLogger(f io.Writer, dropsondeSourceInstance string) *FileAndLoggregatorAccessLogger {
+func NewFileAndLoggregatorAccessLogger(f io.Writer, dropsondeSourceInstance string, format string) *FileAndLoggregatorAccessLogger {
 	a := &FileAndLoggregatorAccessLogger{
 		dropsondeSourceInstance: dropsondeSourceInstance,
 		writer:                  f,
+		format:                  format,
 		channel:                 make(chan AccessLogRecord, 128),
 		stopCh:                  make(chan struct{}),
 	}
@@ -30,7 +37,15 @@ func (x *FileAndLoggregatorAccessLogger) Run() {
 		select {
 		case record := <-x.channel:
 			if x.writer != nil {
-				record.WriteTo(x.writer)
+				if x.format == config.AccessLogFormatJSON {
+					record.WriteJSONTo(x.writer)
+				} else {
+					record.WriteTo(x.writer)
+				}
+			}
+
+			if x.slowWriter != nil && record.IsSlow(x.slowThreshold) {
+				record.WriteSlowTo(x.slowWriter)
 			}
 
 			if x.dropsondeSourceInstance != "" && record.ApplicationId() != "" {
@@ -42,10 +57,26 @@ func (x *FileAndLoggregatorAccessLogger) Run() {
 	}
 }
 
+// LogSlowRequests also writes the requests taking at least the threshold to
+// complete to w, with the breakdown of their response time. It must be
+// called before Run.
+func (x *FileAndLoggregatorAccessLogger) LogSlowRequests(w io.Writer, threshold time.Duration) {
+	x.slowWriter = w
+	x.slowThreshold = threshold
+}
+
+func (x *FileAndLoggregatorAccessLogger) SlowRequestWriter() io.Writer {
+	return x.slowWriter
+}
+
 func (x *FileAndLoggregatorAccessLogger) FileWriter() io.Writer {
 	return x.writer
 }
 
+func (x *FileAndLoggregatorAccessLogger) Format() string {
+	return x.format
+}
+
 func (x *FileAndLoggregatorAccessLogger) DropsondeSourceInstance() string {
 	return x.dropsondeSourceInstance
 }
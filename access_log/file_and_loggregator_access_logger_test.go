@@ -4,12 +4,14 @@ import (
 	"github.com/cloudfoundry/dropsonde/log_sender/fake"
 	"github.com/cloudfoundry/dropsonde/logs"
 	. "github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/config"
 	"github.com/cloudfoundry/gorouter/route"
 	"github.com/cloudfoundry/gorouter/test_util"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"time"
@@ -23,7 +25,7 @@ var _ = Describe("AccessLog", func() {
 			fakeLogSender := fake.NewFakeLogSender()
 			logs.Initialize(fakeLogSender)
 
-			accessLogger := NewFileAndLoggregatorAccessLogger(nil, "42")
+			accessLogger := NewFileAndLoggregatorAccessLogger(nil, "42", config.AccessLogFormatText)
 			go accessLogger.Run()
 
 			accessLogger.Log(*CreateAccessLogRecord())
@@ -43,7 +45,7 @@ var _ = Describe("AccessLog", func() {
 			fakeLogSender := fake.NewFakeLogSender()
 			logs.Initialize(fakeLogSender)
 
-			accessLogger := NewFileAndLoggregatorAccessLogger(nil, "43")
+			accessLogger := NewFileAndLoggregatorAccessLogger(nil, "43", config.AccessLogFormatText)
 
 			routeEndpoint := route.NewEndpoint("", "127.0.0.1", 4567, "", nil, -1, "")
 
@@ -63,7 +65,7 @@ var _ = Describe("AccessLog", func() {
 		It("writes to the log file", func() {
 			var fakeFile = new(test_util.FakeFile)
 
-			accessLogger := NewFileAndLoggregatorAccessLogger(fakeFile, "")
+			accessLogger := NewFileAndLoggregatorAccessLogger(fakeFile, "", config.AccessLogFormatText)
 			go accessLogger.Run()
 			accessLogger.Log(*CreateAccessLogRecord())
 
@@ -76,6 +78,71 @@ var _ = Describe("AccessLog", func() {
 
 			accessLogger.Stop()
 		})
+
+		It("writes JSON records when configured to", func() {
+			var fakeFile = new(test_util.FakeFile)
+
+			accessLogger := NewFileAndLoggregatorAccessLogger(fakeFile, "", config.AccessLogFormatJSON)
+			go accessLogger.Run()
+			accessLogger.Log(*CreateAccessLogRecord())
+
+			var payload []byte
+			Eventually(func() int {
+				n, _ := fakeFile.Read(&payload)
+				return n
+			}).ShouldNot(Equal(0))
+
+			var fields map[string]interface{}
+			Expect(json.Unmarshal(payload, &fields)).To(Succeed())
+			Expect(fields["host"]).To(Equal("foo.bar"))
+			Expect(fields["client_ip"]).To(Equal("1.2.3.4:5678"))
+			Expect(fields["method"]).To(Equal("GET"))
+			Expect(fields["path"]).To(Equal("/quz?wat"))
+			Expect(fields["backend"]).To(Equal("127.0.0.1:4567"))
+			Expect(fields["status_code"]).To(Equal(float64(200)))
+			Expect(fields["response_bytes"]).To(Equal(float64(42)))
+			Expect(fields["response_time"]).To(BeNumerically("~", 0.2, 0.001))
+
+			accessLogger.Stop()
+		})
+	})
+
+	Context("with a slow request log", func() {
+		It("writes only the requests over the threshold to it", func() {
+			var fakeFile = new(test_util.FakeFile)
+			var slowFile = new(test_util.FakeFile)
+
+			accessLogger := NewFileAndLoggregatorAccessLogger(fakeFile, "", config.AccessLogFormatText)
+			accessLogger.LogSlowRequests(slowFile, 150*time.Millisecond)
+			go accessLogger.Run()
+
+			slow := CreateAccessLogRecord()
+			slow.Request.URL.Path = "/slow"
+			accessLogger.Log(*slow)
+
+			fast := CreateAccessLogRecord()
+			fast.Request.URL.Path = "/fast"
+			fast.FinishedAt = fast.StartedAt.Add(100 * time.Millisecond)
+			accessLogger.Log(*fast)
+
+			// the records are written in order, the fast one comes last
+			var payload []byte
+			Eventually(func() string {
+				fakeFile.Read(&payload)
+				return string(payload)
+			}).Should(ContainSubstring("/fast"))
+
+			slowFile.Read(&payload)
+			var fields map[string]interface{}
+			Expect(json.Unmarshal(payload, &fields)).To(Succeed())
+			Expect(fields["path"]).To(Equal("/slow?wat"))
+			Expect(fields["backend"]).To(Equal("127.0.0.1:4567"))
+			Expect(fields["response_time"]).To(BeNumerically("~", 0.2, 0.001))
+			Expect(fields["first_byte_time"]).To(BeNumerically("~", 0.1, 0.001))
+			Expect(fields["transfer_time"]).To(BeNumerically("~", 0.1, 0.001))
+
+			accessLogger.Stop()
+		})
 	})
 
 	Measure("Log write speed", func(b Benchmarker) {
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,6 +24,7 @@ type VcapComponent struct {
 	Varz       *Varz                     `json:"-"`
 	Healthz    *Healthz                  `json:"-"`
 	InfoRoutes map[string]json.Marshaler `json:"-"`
+	Handlers   map[string]http.Handler   `json:"-"`
 	Logger     *steno.Logger             `json:"-"`
 
 	listener net.Listener
@@ -146,6 +148,13 @@ func (c *VcapComponent) ListenAndServe() {
 	hs.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Connection", "close")
 		w.Header().Set("Content-Type", "text/plain")
+
+		if !c.Healthz.Healthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "unavailable")
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 
 		fmt.Fprintf(w, c.Healthz.Value())
@@ -173,8 +182,14 @@ func (c *VcapComponent) ListenAndServe() {
 		})
 	}
 
+	for path, handler := range c.Handlers {
+		hs.Handle(path, handler)
+	}
+
 	f := func(user, password string) bool {
-		return user == c.Varz.Credentials[0] && password == c.Varz.Credentials[1]
+		userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(c.Varz.Credentials[0]))
+		passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(c.Varz.Credentials[1]))
+		return userMatch&passwordMatch == 1
 	}
 
 	s := &http.Server{
@@ -103,6 +103,30 @@ var _ = Describe("Component", func() {
 		Expect(body).To(Equal(`{"key":"value2"}` + "\n"))
 	})
 
+	It("serves additional handlers behind basic auth", func() {
+		path := "/metrics"
+
+		component.Handlers = map[string]http.Handler{
+			path: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte("some_metric 1\n"))
+			}),
+		}
+		serveComponent(component)
+
+		req := buildGetRequest(component, path)
+		code, _, _ := doGetRequest(req)
+		Expect(code).To(Equal(401))
+
+		req = buildGetRequest(component, path)
+		req.SetBasicAuth("username", "password")
+
+		code, header, body := doGetRequest(req)
+		Expect(code).To(Equal(200))
+		Expect(header.Get("Content-Type")).To(Equal("text/plain"))
+		Expect(body).To(Equal("some_metric 1\n"))
+	})
+
 	It("allows authorized access", func() {
 		path := "/test"
 
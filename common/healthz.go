@@ -1,8 +1,15 @@
 package common
 
 type Healthz struct {
+	// Health reports whether the component is ready for traffic. A nil
+	// Health always reports healthy.
+	Health func() bool
 }
 
 func (v *Healthz) Value() string {
 	return "ok"
 }
+
+func (v *Healthz) Healthy() bool {
+	return v == nil || v.Health == nil || v.Health()
+}
@@ -12,4 +12,18 @@ var _ = Describe("Healthz", func() {
 		ok := healthz.Value()
 		Expect(ok).To(Equal("ok"))
 	})
+
+	It("is healthy without a health check", func() {
+		healthz := &Healthz{}
+		Expect(healthz.Healthy()).To(BeTrue())
+	})
+
+	It("reports the result of its health check", func() {
+		healthy := false
+		healthz := &Healthz{Health: func() bool { return healthy }}
+		Expect(healthz.Healthy()).To(BeFalse())
+
+		healthy = true
+		Expect(healthz.Healthy()).To(BeTrue())
+	})
 })
@@ -25,7 +25,8 @@ func extractCredentials(req *http.Request) []string {
 		return nil
 	}
 
-	z := strings.Split(string(y), ":")
+	// the password may itself contain colons
+	z := strings.SplitN(string(y), ":", 2)
 	if len(z) != 2 {
 		return nil
 	}
@@ -37,7 +38,7 @@ func (x *BasicAuth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	y := extractCredentials(req)
 	// Beware of the hack
 	if req.URL.Path != "/healthz" && (y == nil || !x.Authenticator(y[0], y[1])) {
-		w.Header().Set("WWW-Authenticate", "Basic")
+		w.Header().Set("WWW-Authenticate", `Basic realm="gorouter"`)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte(fmt.Sprintf("%d Unauthorized\n", http.StatusUnauthorized)))
 	} else {
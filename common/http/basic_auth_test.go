@@ -50,6 +50,7 @@ var _ = Describe("http", func() {
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(resp.Header.Get("WWW-Authenticate")).To(Equal(`Basic realm="gorouter"`))
 		})
 
 		It("with invalid header", func() {
@@ -93,4 +94,20 @@ var _ = Describe("http", func() {
 		Expect(err).ToNot(HaveOccurred())
 		Expect(resp.StatusCode).To(Equal(http.StatusOK))
 	})
+
+	It("accepts passwords containing colons", func() {
+		f := func(u, p string) bool {
+			Expect(u).To(Equal("user"))
+			Expect(p).To(Equal("go:od"))
+			return true
+		}
+
+		req := bootstrap(f)
+
+		req.SetBasicAuth("user", "go:od")
+
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
 })
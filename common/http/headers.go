@@ -5,6 +5,10 @@ const (
 	CfRouteEndpointHeader = "X-Cf-RouteEndpoint"
 	VcapRouterHeader      = "X-Vcap-Router"
 	VcapRequestIdHeader   = "X-Vcap-Request-Id"
+	RequestIdHeader       = "X-Request-Id"
 	VcapTraceHeader       = "X-Vcap-Trace"
 	CfInstanceIdHeader    = "X-CF-InstanceID"
+	TraceparentHeader     = "Traceparent"
+	TracestateHeader      = "Tracestate"
+	GorouterTimeHeader    = "X-Gorouter-Time"
 )
@@ -2,8 +2,17 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
 	"net/url"
+	"path/filepath"
+	"text/template"
 
 	"github.com/cloudfoundry-incubator/candiedyaml"
 	token_fetcher "github.com/cloudfoundry-incubator/uaa-token-fetcher"
@@ -16,16 +25,36 @@ import (
 	"time"
 )
 
+const (
+	LoadBalancingRoundRobin       = "round-robin"
+	LoadBalancingLeastConnections = "least-connections"
+	LoadBalancingRandom           = "random"
+	LoadBalancingHeaderHash       = "header-hash"
+	LoadBalancingPowerOfTwo       = "power-of-two"
+
+	MaxConnsPolicyReject    = "reject"
+	MaxConnsPolicyQueue     = "queue"
+	MaxConnsPolicyFairQueue = "fair-queue"
+
+	AccessLogFormatText = "text"
+	AccessLogFormatJSON = "json"
+
+	ClientCertFormatPEM         = "pem"
+	ClientCertFormatFingerprint = "fingerprint"
+)
+
 type StatusConfig struct {
-	Port uint16 `yaml:"port"`
-	User string `yaml:"user"`
-	Pass string `yaml:"pass"`
+	Port           uint16 `yaml:"port"`
+	User           string `yaml:"user"`
+	Pass           string `yaml:"pass"`
+	PrometheusPath string `yaml:"prometheus_path"`
 }
 
 var defaultStatusConfig = StatusConfig{
-	Port: 8082,
-	User: "",
-	Pass: "",
+	Port:           8082,
+	User:           "",
+	Pass:           "",
+	PrometheusPath: "/metrics",
 }
 
 type NatsConfig struct {
@@ -35,6 +64,71 @@ type NatsConfig struct {
 	Pass string `yaml:"pass"`
 }
 
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// RetryBackoffConfig spaces out the attempts of a request, see
+// proxy.RetryBackoff.
+type RetryBackoffConfig struct {
+	DelayInMilliseconds        int  `yaml:"delay"`
+	Jitter                     bool `yaml:"jitter"`
+	MaxRetryTimeInMilliseconds int  `yaml:"max_retry_time"`
+
+	// These fields are populated by the `Process` function.
+	Delay        time.Duration `yaml:"-"`
+	MaxRetryTime time.Duration `yaml:"-"`
+}
+
+// OutlierDetectionConfig sets when a backend failing more often than the other
+// backends of its route is ejected. A DeviationFactor of 0 disables it.
+type OutlierDetectionConfig struct {
+	IntervalInSeconds     int     `yaml:"interval"`
+	MinRequests           int     `yaml:"min_requests"`
+	DeviationFactor       float64 `yaml:"deviation_factor"`
+	EjectionTimeInSeconds int     `yaml:"ejection_time"`
+
+	// These fields are populated by the `Process` function.
+	Interval     time.Duration `yaml:"-"`
+	EjectionTime time.Duration `yaml:"-"`
+}
+
+// HealthCheckConfig is the response load balancers get for their checks of
+// the router.
+type HealthCheckConfig struct {
+	Status int    `yaml:"status"`
+	Body   string `yaml:"body"`
+}
+
+// ResponseHeadersConfig lists the headers set on and removed from every
+// response proxied from a backend.
+type ResponseHeadersConfig struct {
+	Add    []HeaderConfig `yaml:"add"`
+	Remove []string       `yaml:"remove"`
+}
+
+type HeaderConfig struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// ErrorPage replaces the body of the responses the router generates for a
+// status code. The template is read from File when it is set, and is given
+// the request's Host and RequestId.
+type ErrorPage struct {
+	File        string `yaml:"file"`
+	Template    string `yaml:"template"`
+	ContentType string `yaml:"content_type"`
+
+	// This field is populated by the `Process` function.
+	Body ErrorPageTemplate `yaml:"-"`
+}
+
+type ErrorPageTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
 type RoutingApiConfig struct {
 	Uri          string `yaml:"uri"`
 	Port         int    `yaml:"port"`
@@ -75,27 +169,122 @@ type Config struct {
 	GoMaxProcs        int    `yaml:"go_max_procs,omitempty"`
 	TraceKey          string `yaml:"trace_key"`
 	AccessLog         string `yaml:"access_log"`
+	AccessLogFormat   string `yaml:"access_log_format"`
+	SlowRequestLog    string `yaml:"slow_request_log"`
 	DebugAddr         string `yaml:"debug_addr"`
 	EnableSSL         bool   `yaml:"enable_ssl"`
 	SSLPort           uint16 `yaml:"ssl_port"`
+	ForceHTTPS        bool   `yaml:"force_https"`
 	SSLCertPath       string `yaml:"ssl_cert_path"`
 	SSLKeyPath        string `yaml:"ssl_key_path"`
 	SSLCertificate    tls.Certificate
 	SSLSkipValidation bool `yaml:"ssl_skip_validation"`
 
+	ClientCACertsPath string `yaml:"client_ca_certs_path"`
+	ClientCACerts     *x509.CertPool
+
+	ForwardedClientCertHeader string `yaml:"forwarded_client_cert_header"`
+	ForwardedClientCertFormat string `yaml:"forwarded_client_cert_format"`
+
+	TLSPassthroughPort uint16 `yaml:"tls_passthrough_port"`
+
 	CipherString string `yaml:"cipher_suites"`
 	CipherSuites []uint16
 
+	MinTLSVersionString string `yaml:"min_tls_version"`
+	MinTLSVersion       uint16
+
 	PublishStartMessageIntervalInSeconds int `yaml:"publish_start_message_interval"`
 	PruneStaleDropletsIntervalInSeconds  int `yaml:"prune_stale_droplets_interval"`
 	DropletStaleThresholdInSeconds       int `yaml:"droplet_stale_threshold"`
 	PublishActiveAppsIntervalInSeconds   int `yaml:"publish_active_apps_interval"`
 	StartResponseDelayIntervalInSeconds  int `yaml:"start_response_delay_interval"`
 	EndpointTimeoutInSeconds             int `yaml:"endpoint_timeout"`
+	DialTimeoutInSeconds                 int `yaml:"dial_timeout"`
 	RouteServiceTimeoutInSeconds         int `yaml:"route_service_timeout"`
+	ClientReadTimeoutInSeconds           int `yaml:"client_read_timeout"`
+	ClientWriteTimeoutInSeconds          int `yaml:"client_write_timeout"`
+	IdleTimeoutInSeconds                 int `yaml:"idle_timeout"`
+	SlowRequestThresholdInMilliseconds   int `yaml:"slow_request_threshold"`
 
 	DrainTimeoutInSeconds int  `yaml:"drain_timeout,omitempty"`
 	SecureCookies         bool `yaml:"secure_cookies"`
+	EnableProxyProtocol   bool `yaml:"enable_proxy_protocol"`
+
+	LoadBalancing           string `yaml:"load_balancing"`
+	LoadBalancingHashHeader string `yaml:"load_balancing_hash_header"`
+	StickyCookieName        string `yaml:"sticky_cookie_name"`
+	MaxRetries              int    `yaml:"max_retries"`
+
+	RetryBackoff        RetryBackoffConfig `yaml:"retry_backoff"`
+	RetryBodyBufferSize int64              `yaml:"retry_body_buffer_size"`
+
+	MaxURILength           int   `yaml:"max_uri_length"`
+	MaxRequestBodySize     int64 `yaml:"max_request_body_size"`
+	MaxRequestHeaderBytes  int   `yaml:"max_request_header_bytes"`
+	MaxResponseHeaderBytes int64 `yaml:"max_response_header_bytes"`
+
+	CompressResponses  bool  `yaml:"compress_responses"`
+	CompressionMinSize int64 `yaml:"compression_min_size"`
+
+	ResponseCacheSize int64 `yaml:"response_cache_size"`
+
+	IdempotencyCacheSize       int64 `yaml:"idempotency_cache_size"`
+	IdempotencyKeyTTLInSeconds int   `yaml:"idempotency_key_ttl"`
+
+	ResponseBufferSize int64 `yaml:"response_buffer_size"`
+
+	ProxyBufferSize int `yaml:"proxy_buffer_size"`
+
+	MaxIdleConnsPerBackend      int `yaml:"max_idle_conns_per_backend"`
+	BackendIdleTimeoutInSeconds int `yaml:"backend_idle_timeout"`
+	BackendKeepAliveInSeconds   int `yaml:"backend_keep_alive"`
+
+	MaxConnsPerBackend            int    `yaml:"max_conns_per_backend"`
+	MaxConnsPolicy                string `yaml:"max_conns_policy"`
+	MaxConnsQueueTimeoutInSeconds int    `yaml:"max_conns_queue_timeout"`
+
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests"`
+
+	MaxRequestsPerConn int `yaml:"max_requests_per_conn"`
+
+	MaxClientConns    int `yaml:"max_client_conns"`
+	ResumeClientConns int `yaml:"resume_client_conns"`
+
+	TrustedProxyCIDRs []string `yaml:"trusted_proxy_cidrs"`
+
+	IPAllowList []string `yaml:"ip_allow_list"`
+	IPDenyList  []string `yaml:"ip_deny_list"`
+
+	LoadBalancerHealthCheckPath string            `yaml:"load_balancer_health_check_path"`
+	HealthCheck                 HealthCheckConfig `yaml:"health_check"`
+
+	HealthCheckPath                 string `yaml:"health_check_path"`
+	HealthCheckIntervalInSeconds    int    `yaml:"health_check_interval"`
+	HealthCheckUnhealthyThreshold   int    `yaml:"health_check_unhealthy_threshold"`
+	HealthCheckHealthyThreshold     int    `yaml:"health_check_healthy_threshold"`
+	HealthCheckGracePeriodInSeconds int    `yaml:"health_check_grace_period"`
+
+	CircuitBreakerThreshold         int `yaml:"circuit_breaker_threshold"`
+	CircuitBreakerCooldownInSeconds int `yaml:"circuit_breaker_cooldown"`
+
+	SlowStartDurationInSeconds int `yaml:"slow_start_duration"`
+
+	OutlierDetection OutlierDetectionConfig `yaml:"outlier_detection"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	ErrorPages map[int]ErrorPage `yaml:"error_pages"`
+
+	ResponseHeaders ResponseHeadersConfig `yaml:"response_headers"`
+
+	ViaPseudonym string `yaml:"via_pseudonym"`
+
+	HopByHopHeaders []string `yaml:"hop_by_hop_headers"`
+
+	ExposeTimingHeader bool `yaml:"expose_timing_header"`
+
+	DefaultRoute string `yaml:"default_route"`
 
 	OAuth                  token_fetcher.OAuthConfig `yaml:"oauth"`
 	RoutingApi             RoutingApiConfig          `yaml:"routing_api"`
@@ -108,8 +297,24 @@ type Config struct {
 	PublishActiveAppsInterval  time.Duration `yaml:"-"`
 	StartResponseDelayInterval time.Duration `yaml:"-"`
 	EndpointTimeout            time.Duration `yaml:"-"`
+	DialTimeout                time.Duration `yaml:"-"`
 	RouteServiceTimeout        time.Duration `yaml:"-"`
+	ClientReadTimeout          time.Duration `yaml:"-"`
+	ClientWriteTimeout         time.Duration `yaml:"-"`
+	IdleTimeout                time.Duration `yaml:"-"`
 	DrainTimeout               time.Duration `yaml:"-"`
+	SlowRequestThreshold       time.Duration `yaml:"-"`
+	IdempotencyKeyTTL          time.Duration `yaml:"-"`
+	HealthCheckInterval        time.Duration `yaml:"-"`
+	HealthCheckGracePeriod     time.Duration `yaml:"-"`
+	CircuitBreakerCooldown     time.Duration `yaml:"-"`
+	SlowStartDuration          time.Duration `yaml:"-"`
+	BackendIdleTimeout         time.Duration `yaml:"-"`
+	BackendKeepAlive           time.Duration `yaml:"-"`
+	MaxConnsQueueTimeout       time.Duration `yaml:"-"`
+	TrustedProxyNetworks       []*net.IPNet  `yaml:"-"`
+	IPAllowNetworks            []*net.IPNet  `yaml:"-"`
+	IPDenyNetworks             []*net.IPNet  `yaml:"-"`
 	Ip                         string        `yaml:"-"`
 	RouteServiceEnabled        bool          `yaml:"-"`
 
@@ -128,6 +333,7 @@ var defaultConfig = Config{
 	SSLPort:    443,
 
 	EndpointTimeoutInSeconds:     60,
+	DialTimeoutInSeconds:         5,
 	RouteServiceTimeoutInSeconds: 60,
 
 	PublishStartMessageIntervalInSeconds: 30,
@@ -135,6 +341,45 @@ var defaultConfig = Config{
 	DropletStaleThresholdInSeconds:       120,
 	PublishActiveAppsIntervalInSeconds:   0,
 	StartResponseDelayIntervalInSeconds:  5,
+
+	AccessLogFormat: AccessLogFormatText,
+
+	LoadBalancing:    LoadBalancingRoundRobin,
+	StickyCookieName: "JSESSIONID",
+	MaxRetries:       2,
+
+	RetryBodyBufferSize: 64 * 1024,
+
+	CompressionMinSize: 1024,
+
+	ProxyBufferSize: 32 * 1024,
+
+	IdempotencyKeyTTLInSeconds: 86400,
+
+	BackendIdleTimeoutInSeconds: 90,
+	BackendKeepAliveInSeconds:   15,
+
+	MaxConnsPolicy:                MaxConnsPolicyReject,
+	MaxConnsQueueTimeoutInSeconds: 1,
+
+	HealthCheck: HealthCheckConfig{
+		Status: 200,
+		Body:   "ok\n",
+	},
+
+	HealthCheckIntervalInSeconds:  10,
+	HealthCheckUnhealthyThreshold: 3,
+	HealthCheckHealthyThreshold:   1,
+
+	CircuitBreakerCooldownInSeconds: 30,
+
+	OutlierDetection: OutlierDetectionConfig{
+		IntervalInSeconds:     10,
+		MinRequests:           20,
+		EjectionTimeInSeconds: 30,
+	},
+
+	ViaPseudonym: "gorouter",
 }
 
 func DefaultConfig() *Config {
@@ -157,7 +402,27 @@ func (c *Config) Process() {
 	c.PublishActiveAppsInterval = time.Duration(c.PublishActiveAppsIntervalInSeconds) * time.Second
 	c.StartResponseDelayInterval = time.Duration(c.StartResponseDelayIntervalInSeconds) * time.Second
 	c.EndpointTimeout = time.Duration(c.EndpointTimeoutInSeconds) * time.Second
+	c.DialTimeout = time.Duration(c.DialTimeoutInSeconds) * time.Second
 	c.RouteServiceTimeout = time.Duration(c.RouteServiceTimeoutInSeconds) * time.Second
+	c.ClientReadTimeout = time.Duration(c.ClientReadTimeoutInSeconds) * time.Second
+	c.ClientWriteTimeout = time.Duration(c.ClientWriteTimeoutInSeconds) * time.Second
+	c.IdleTimeout = time.Duration(c.IdleTimeoutInSeconds) * time.Second
+	c.HealthCheckInterval = time.Duration(c.HealthCheckIntervalInSeconds) * time.Second
+	if c.HealthCheckGracePeriodInSeconds < 0 {
+		c.HealthCheckGracePeriodInSeconds = 0
+	}
+	c.HealthCheckGracePeriod = time.Duration(c.HealthCheckGracePeriodInSeconds) * time.Second
+	c.CircuitBreakerCooldown = time.Duration(c.CircuitBreakerCooldownInSeconds) * time.Second
+	if c.SlowStartDurationInSeconds < 0 {
+		c.SlowStartDurationInSeconds = 0
+	}
+	c.SlowStartDuration = time.Duration(c.SlowStartDurationInSeconds) * time.Second
+	c.BackendIdleTimeout = time.Duration(c.BackendIdleTimeoutInSeconds) * time.Second
+	if c.BackendKeepAliveInSeconds < 0 {
+		c.BackendKeepAliveInSeconds = 0
+	}
+	c.BackendKeepAlive = time.Duration(c.BackendKeepAliveInSeconds) * time.Second
+	c.MaxConnsQueueTimeout = time.Duration(c.MaxConnsQueueTimeoutInSeconds) * time.Second
 	c.Logging.JobName = "gorouter"
 	if c.StartResponseDelayInterval > c.DropletStaleThreshold {
 		c.DropletStaleThreshold = c.StartResponseDelayInterval
@@ -171,6 +436,14 @@ func (c *Config) Process() {
 	}
 	c.DrainTimeout = time.Duration(drain) * time.Second
 
+	if c.SlowRequestThresholdInMilliseconds < 0 {
+		c.SlowRequestThresholdInMilliseconds = 0
+	}
+	c.SlowRequestThreshold = time.Duration(c.SlowRequestThresholdInMilliseconds) * time.Millisecond
+	if c.SlowRequestLog != "" && c.SlowRequestThreshold == 0 {
+		panic("slow_request_log needs a slow_request_threshold")
+	}
+
 	c.Ip, err = localip.LocalIP()
 	if err != nil {
 		panic(err)
@@ -178,16 +451,312 @@ func (c *Config) Process() {
 
 	if c.EnableSSL {
 		c.CipherSuites = c.processCipherSuites()
+		c.MinTLSVersion = c.processMinTLSVersion()
 		cert, err := tls.LoadX509KeyPair(c.SSLCertPath, c.SSLKeyPath)
 		if err != nil {
 			panic(err)
 		}
 		c.SSLCertificate = cert
+
+		if c.ClientCACertsPath != "" {
+			c.ClientCACerts = c.processClientCACerts()
+		}
+	} else if c.ForceHTTPS {
+		panic("force_https requires enable_ssl")
 	}
 
+	c.TrustedProxyNetworks = processCIDRs("trusted proxy", c.TrustedProxyCIDRs)
+	c.IPAllowNetworks = processCIDRs("IP allow list", c.IPAllowList)
+	c.IPDenyNetworks = processCIDRs("IP deny list", c.IPDenyList)
+	c.ErrorPages = c.processErrorPages()
+	c.ResponseHeaders = c.processResponseHeaders()
+
 	if c.RouteServiceSecret != "" {
 		c.RouteServiceEnabled = true
 	}
+
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	}
+	if c.RetryBodyBufferSize < 0 {
+		c.RetryBodyBufferSize = 0
+	}
+
+	if c.RetryBackoff.DelayInMilliseconds < 0 {
+		c.RetryBackoff.DelayInMilliseconds = 0
+	}
+	if c.RetryBackoff.MaxRetryTimeInMilliseconds < 0 {
+		c.RetryBackoff.MaxRetryTimeInMilliseconds = 0
+	}
+	c.RetryBackoff.Delay = time.Duration(c.RetryBackoff.DelayInMilliseconds) * time.Millisecond
+	c.RetryBackoff.MaxRetryTime = time.Duration(c.RetryBackoff.MaxRetryTimeInMilliseconds) * time.Millisecond
+
+	if c.MaxURILength < 0 {
+		c.MaxURILength = 0
+	}
+
+	if c.MaxRequestBodySize < 0 {
+		c.MaxRequestBodySize = 0
+	}
+
+	if c.MaxRequestHeaderBytes < 0 {
+		c.MaxRequestHeaderBytes = 0
+	}
+
+	if c.MaxResponseHeaderBytes < 0 {
+		c.MaxResponseHeaderBytes = 0
+	}
+
+	if c.CompressionMinSize < 0 {
+		c.CompressionMinSize = 0
+	}
+
+	if c.ResponseCacheSize < 0 {
+		c.ResponseCacheSize = 0
+	}
+
+	if c.IdempotencyCacheSize < 0 {
+		c.IdempotencyCacheSize = 0
+	}
+	if c.IdempotencyCacheSize > 0 && c.IdempotencyKeyTTLInSeconds <= 0 {
+		panic("idempotency_key_ttl must be positive")
+	}
+	c.IdempotencyKeyTTL = time.Duration(c.IdempotencyKeyTTLInSeconds) * time.Second
+
+	if c.ResponseBufferSize < 0 {
+		c.ResponseBufferSize = 0
+	}
+
+	if c.ProxyBufferSize < 0 {
+		c.ProxyBufferSize = 0
+	}
+
+	if c.MaxIdleConnsPerBackend < 0 {
+		c.MaxIdleConnsPerBackend = 0
+	}
+
+	if c.MaxConnsPerBackend < 0 {
+		c.MaxConnsPerBackend = 0
+	}
+
+	if c.MaxConcurrentRequests < 0 {
+		c.MaxConcurrentRequests = 0
+	}
+
+	if c.LoadBalancerHealthCheckPath != "" && !strings.HasPrefix(c.LoadBalancerHealthCheckPath, "/") {
+		errMsg := fmt.Sprintf("invalid load balancer health check path configuration: %s, it must start with /", c.LoadBalancerHealthCheckPath)
+		panic(errMsg)
+	}
+
+	if c.HealthCheck.Status < 100 || c.HealthCheck.Status > 599 {
+		errMsg := fmt.Sprintf("invalid health check status configuration: %d", c.HealthCheck.Status)
+		panic(errMsg)
+	}
+
+	if c.MaxRequestsPerConn < 0 {
+		c.MaxRequestsPerConn = 0
+	}
+
+	if c.MaxClientConns < 0 {
+		c.MaxClientConns = 0
+	}
+	// accepting resumes once a tenth of the connections have closed, unless
+	// configured otherwise
+	if c.ResumeClientConns <= 0 || c.ResumeClientConns >= c.MaxClientConns {
+		c.ResumeClientConns = c.MaxClientConns * 9 / 10
+	}
+
+	if c.HealthCheckUnhealthyThreshold < 1 {
+		c.HealthCheckUnhealthyThreshold = 1
+	}
+	if c.HealthCheckHealthyThreshold < 1 {
+		c.HealthCheckHealthyThreshold = 1
+	}
+
+	if c.CircuitBreakerThreshold < 0 {
+		c.CircuitBreakerThreshold = 0
+	}
+
+	switch c.AccessLogFormat {
+	case "":
+		c.AccessLogFormat = AccessLogFormatText
+	case AccessLogFormatText, AccessLogFormatJSON:
+	default:
+		errMsg := fmt.Sprintf("invalid access log format configuration: %s, please choose from %v", c.AccessLogFormat,
+			[]string{AccessLogFormatText, AccessLogFormatJSON})
+		panic(errMsg)
+	}
+
+	switch c.LoadBalancing {
+	case "":
+		c.LoadBalancing = LoadBalancingRoundRobin
+	case LoadBalancingRoundRobin, LoadBalancingLeastConnections, LoadBalancingRandom, LoadBalancingPowerOfTwo:
+	case LoadBalancingHeaderHash:
+		if c.LoadBalancingHashHeader == "" {
+			panic("header-hash load balancing requires load_balancing_hash_header")
+		}
+	default:
+		errMsg := fmt.Sprintf("invalid load balancing configuration: %s, please choose from %v", c.LoadBalancing,
+			[]string{LoadBalancingRoundRobin, LoadBalancingLeastConnections, LoadBalancingRandom, LoadBalancingHeaderHash, LoadBalancingPowerOfTwo})
+		panic(errMsg)
+	}
+
+	switch c.ForwardedClientCertFormat {
+	case "":
+		c.ForwardedClientCertFormat = ClientCertFormatPEM
+	case ClientCertFormatPEM, ClientCertFormatFingerprint:
+	default:
+		errMsg := fmt.Sprintf("invalid forwarded client cert format configuration: %s, please choose from %v", c.ForwardedClientCertFormat,
+			[]string{ClientCertFormatPEM, ClientCertFormatFingerprint})
+		panic(errMsg)
+	}
+
+	switch c.MaxConnsPolicy {
+	case "":
+		c.MaxConnsPolicy = MaxConnsPolicyReject
+	case MaxConnsPolicyReject, MaxConnsPolicyQueue:
+	case MaxConnsPolicyFairQueue:
+		// the backend a request is sent to has to be the least busy one
+		// for a backend at capacity to mean that all of them are
+		if c.LoadBalancing != LoadBalancingLeastConnections {
+			panic("fair-queue max conns policy requires least-connections load balancing")
+		}
+	default:
+		errMsg := fmt.Sprintf("invalid max conns policy configuration: %s, please choose from %v", c.MaxConnsPolicy,
+			[]string{MaxConnsPolicyReject, MaxConnsPolicyQueue, MaxConnsPolicyFairQueue})
+		panic(errMsg)
+	}
+
+	// connecting to a backend is never left to the OS default
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = time.Duration(defaultConfig.DialTimeoutInSeconds) * time.Second
+	}
+
+	if c.ClientReadTimeout < 0 {
+		c.ClientReadTimeout = 0
+	}
+	if c.ClientWriteTimeout < 0 {
+		c.ClientWriteTimeout = 0
+	}
+	if c.IdleTimeout < 0 {
+		c.IdleTimeout = 0
+	}
+
+	if c.OutlierDetection.DeviationFactor < 0 {
+		c.OutlierDetection.DeviationFactor = 0
+	}
+	if c.OutlierDetection.MinRequests < 0 {
+		c.OutlierDetection.MinRequests = 0
+	}
+	c.OutlierDetection.Interval = time.Duration(c.OutlierDetection.IntervalInSeconds) * time.Second
+	c.OutlierDetection.EjectionTime = time.Duration(c.OutlierDetection.EjectionTimeInSeconds) * time.Second
+
+	if c.RateLimit.RequestsPerSecond < 0 {
+		c.RateLimit.RequestsPerSecond = 0
+	}
+	// by default a client may send a second's worth of requests at once
+	if c.RateLimit.RequestsPerSecond > 0 && c.RateLimit.Burst < 1 {
+		c.RateLimit.Burst = int(math.Ceil(c.RateLimit.RequestsPerSecond))
+	}
+
+	// the pseudonym is a single token of the Via header
+	if strings.ContainsAny(c.ViaPseudonym, " \t,") {
+		errMsg := fmt.Sprintf("invalid via pseudonym configuration: %q, it may not contain spaces or commas", c.ViaPseudonym)
+		panic(errMsg)
+	}
+
+	// the default route stands in for the Host header of a request, it is
+	// matched like one
+	c.DefaultRoute = strings.ToLower(strings.TrimSuffix(c.DefaultRoute, "."))
+	if strings.ContainsAny(c.DefaultRoute, " \t/") {
+		errMsg := fmt.Sprintf("invalid default route configuration: %q, it must be a host name", c.DefaultRoute)
+		panic(errMsg)
+	}
+
+	// rejecting is queueing for no time at all
+	if c.MaxConnsPolicy == MaxConnsPolicyReject || c.MaxConnsQueueTimeout < 0 {
+		c.MaxConnsQueueTimeout = 0
+	}
+}
+
+func processCIDRs(kind string, cidrs []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			errMsg := fmt.Sprintf("invalid %s CIDR: %s", kind, cidr)
+			panic(errMsg)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func (c *Config) processErrorPages() map[int]ErrorPage {
+	if len(c.ErrorPages) == 0 {
+		return nil
+	}
+
+	pages := make(map[int]ErrorPage, len(c.ErrorPages))
+	for status, page := range c.ErrorPages {
+		if page.File != "" {
+			b, err := ioutil.ReadFile(page.File)
+			if err != nil {
+				panic(err)
+			}
+			page.Template = string(b)
+
+			if page.ContentType == "" {
+				page.ContentType = mime.TypeByExtension(filepath.Ext(page.File))
+			}
+		}
+
+		if page.Template == "" {
+			errMsg := fmt.Sprintf("error page for status %d needs a file or a template", status)
+			panic(errMsg)
+		}
+
+		if page.ContentType == "" {
+			page.ContentType = "text/html; charset=utf-8"
+		}
+
+		var err error
+		name := fmt.Sprintf("error_page_%d", status)
+		if strings.Contains(page.ContentType, "html") {
+			page.Body, err = htmltemplate.New(name).Parse(page.Template)
+		} else {
+			page.Body, err = template.New(name).Parse(page.Template)
+		}
+		if err != nil {
+			errMsg := fmt.Sprintf("invalid error page for status %d: %s", status, err)
+			panic(errMsg)
+		}
+
+		pages[status] = page
+	}
+	return pages
+}
+
+func (c *Config) processResponseHeaders() ResponseHeadersConfig {
+	var headers ResponseHeadersConfig
+
+	for _, header := range c.ResponseHeaders.Add {
+		if header.Name == "" {
+			panic("response header to add needs a name")
+		}
+		header.Name = http.CanonicalHeaderKey(header.Name)
+		headers.Add = append(headers.Add, header)
+	}
+
+	// header names are compared in their canonical form
+	for _, name := range c.ResponseHeaders.Remove {
+		if name == "" {
+			panic("response header to remove needs a name")
+		}
+		headers.Remove = append(headers.Remove, http.CanonicalHeaderKey(name))
+	}
+
+	return headers
 }
 
 func (c *Config) processCipherSuites() []uint16 {
@@ -213,6 +782,40 @@ func (c *Config) processCipherSuites() []uint16 {
 	return convertCipherStringToInt(ciphers, cipherMap)
 }
 
+func (c *Config) processClientCACerts() *x509.CertPool {
+	b, err := ioutil.ReadFile(c.ClientCACertsPath)
+	if err != nil {
+		panic(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		errMsg := fmt.Sprintf("invalid client ca certs configuration: no certificates in %s", c.ClientCACertsPath)
+		panic(errMsg)
+	}
+	return pool
+}
+
+func (c *Config) processMinTLSVersion() uint16 {
+	versionMap := map[string]uint16{
+		"TLSv1.0": tls.VersionTLS10,
+		"TLSv1.1": tls.VersionTLS11,
+		"TLSv1.2": tls.VersionTLS12,
+	}
+
+	if c.MinTLSVersionString == "" {
+		return 0
+	}
+
+	version, ok := versionMap[c.MinTLSVersionString]
+	if !ok {
+		errMsg := fmt.Sprintf("invalid min tls version configuration: %s, please choose from %v", c.MinTLSVersionString,
+			[]string{"TLSv1.0", "TLSv1.1", "TLSv1.2"})
+		panic(errMsg)
+	}
+	return version
+}
+
 func convertCipherStringToInt(cipherStrs []string, cipherMap map[string]uint16) []uint16 {
 	ciphers := []uint16{}
 	for _, cipher := range cipherStrs {
@@ -1,7 +1,10 @@
 package config_test
 
 import (
+	"bytes"
 	"crypto/tls"
+	"io/ioutil"
+	"os"
 
 	. "github.com/cloudfoundry/gorouter/config"
 
@@ -36,6 +39,21 @@ status:
 
 		})
 
+		It("defaults the prometheus path", func() {
+			Expect(config.Status.PrometheusPath).To(Equal("/metrics"))
+		})
+
+		It("sets the prometheus path", func() {
+			var b = []byte(`
+status:
+  prometheus_path: /prometheus
+`)
+
+			config.Initialize(b)
+
+			Expect(config.Status.PrometheusPath).To(Equal("/prometheus"))
+		})
+
 		It("sets endpoint timeout", func() {
 			var b = []byte(`
 endpoint_timeout: 10
@@ -179,6 +197,20 @@ ssl_skip_validation: true
 			Expect(config.SSLSkipValidation).To(BeFalse())
 		})
 
+		It("defaults the sticky cookie name to JSESSIONID", func() {
+			Expect(config.StickyCookieName).To(Equal("JSESSIONID"))
+		})
+
+		It("sets the sticky cookie name", func() {
+			var b = []byte(`
+sticky_cookie_name: SESSIONID
+`)
+
+			config.Initialize(b)
+
+			Expect(config.StickyCookieName).To(Equal("SESSIONID"))
+		})
+
 		It("sets the route service secret config", func() {
 			var b = []byte(`
 route_services_secret: super-route-service-secret 
@@ -385,6 +417,87 @@ cipher_suites: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
 
 			})
 
+			Context("When it is given a min TLS version", func() {
+				var b = []byte(`
+enable_ssl: true
+ssl_cert_path: ../test/assets/public.pem
+ssl_key_path: ../test/assets/private.pem
+cipher_suites: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+min_tls_version: TLSv1.2
+`)
+
+				It("converts it to the tls version", func() {
+					config.Initialize(b)
+					config.Process()
+
+					Expect(config.MinTLSVersion).To(Equal(uint16(tls.VersionTLS12)))
+				})
+			})
+
+			Context("When it is not given a min TLS version", func() {
+				var b = []byte(`
+enable_ssl: true
+ssl_cert_path: ../test/assets/public.pem
+ssl_key_path: ../test/assets/private.pem
+cipher_suites: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+`)
+
+				It("leaves the default of the tls package", func() {
+					config.Initialize(b)
+					config.Process()
+
+					Expect(config.MinTLSVersion).To(Equal(uint16(0)))
+				})
+			})
+
+			Context("When it is given an invalid min TLS version", func() {
+				var b = []byte(`
+enable_ssl: true
+ssl_cert_path: ../test/assets/public.pem
+ssl_key_path: ../test/assets/private.pem
+cipher_suites: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+min_tls_version: SSLv3
+`)
+
+				It("panics", func() {
+					config.Initialize(b)
+
+					Expect(config.Process).To(Panic())
+				})
+			})
+
+			Context("When it is given client CA certs", func() {
+				It("loads them to verify client certificates", func() {
+					var b = []byte(`
+enable_ssl: true
+ssl_cert_path: ../test/assets/public.pem
+ssl_key_path: ../test/assets/private.pem
+cipher_suites: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+client_ca_certs_path: ../test/assets/public.pem
+`)
+
+					config.Initialize(b)
+					config.Process()
+
+					Expect(config.ClientCACerts).NotTo(BeNil())
+					Expect(config.ClientCACerts.Subjects()).To(HaveLen(1))
+				})
+
+				It("panics for a file without certificates", func() {
+					var b = []byte(`
+enable_ssl: true
+ssl_cert_path: ../test/assets/public.pem
+ssl_key_path: ../test/assets/private.pem
+cipher_suites: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+client_ca_certs_path: ../test/assets/private.pem
+`)
+
+					config.Initialize(b)
+
+					Expect(config.Process).To(Panic())
+				})
+			})
+
 			Context("When it is given invalid values for a certificate", func() {
 				var b = []byte(`
 enable_ssl: true
@@ -459,6 +572,37 @@ cipher_suites: TLS_RSA_WITH_RC4_128_SHA
 
 		})
 
+		Describe("ForceHTTPS", func() {
+			It("is disabled by default", func() {
+				Expect(config.ForceHTTPS).To(BeFalse())
+			})
+
+			It("sets force https", func() {
+				var b = []byte(`
+enable_ssl: true
+ssl_cert_path: ../test/assets/public.pem
+ssl_key_path: ../test/assets/private.pem
+cipher_suites: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+force_https: true
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ForceHTTPS).To(BeTrue())
+			})
+
+			It("panics without ssl enabled", func() {
+				var b = []byte(`
+force_https: true
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
 		Context("When given no cipher suites", func() {
 			var b = []byte(`
 enable_ssl: true
@@ -473,6 +617,1475 @@ ssl_key_path: ../test/assets/private.pem
 			})
 		})
 
+		Describe("ForwardedClientCert", func() {
+			It("forwards no certificate by default", func() {
+				config.Process()
+
+				Expect(config.ForwardedClientCertHeader).To(BeEmpty())
+				Expect(config.ForwardedClientCertFormat).To(Equal(ClientCertFormatPEM))
+			})
+
+			It("sets the header and format", func() {
+				var b = []byte(`
+forwarded_client_cert_header: X-Forwarded-Client-Cert
+forwarded_client_cert_format: fingerprint
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ForwardedClientCertHeader).To(Equal("X-Forwarded-Client-Cert"))
+				Expect(config.ForwardedClientCertFormat).To(Equal(ClientCertFormatFingerprint))
+			})
+
+			It("panics for an unknown format", func() {
+				var b = []byte(`
+forwarded_client_cert_format: der
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("TLSPassthroughPort", func() {
+			It("is disabled by default", func() {
+				Expect(config.TLSPassthroughPort).To(Equal(uint16(0)))
+			})
+
+			It("sets the tls passthrough port", func() {
+				var b = []byte(`
+tls_passthrough_port: 8444
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.TLSPassthroughPort).To(Equal(uint16(8444)))
+			})
+		})
+
+		Describe("MaxRetries", func() {
+			It("defaults to 2", func() {
+				Expect(config.MaxRetries).To(Equal(2))
+			})
+
+			It("sets max retries", func() {
+				var b = []byte(`
+max_retries: 5
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxRetries).To(Equal(5))
+			})
+
+			It("does not allow a negative value", func() {
+				var b = []byte(`
+max_retries: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxRetries).To(Equal(0))
+			})
+		})
+
+		Describe("RetryBodyBufferSize", func() {
+			It("defaults to 64 KB", func() {
+				Expect(config.RetryBodyBufferSize).To(Equal(int64(64 * 1024)))
+			})
+
+			It("sets the retry body buffer size", func() {
+				var b = []byte(`
+retry_body_buffer_size: 1024
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.RetryBodyBufferSize).To(Equal(int64(1024)))
+			})
+
+			It("treats a negative size as zero", func() {
+				var b = []byte(`
+retry_body_buffer_size: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.RetryBodyBufferSize).To(Equal(int64(0)))
+			})
+		})
+
+		Describe("RetryBackoff", func() {
+			It("retries right away by default", func() {
+				config.Process()
+
+				Expect(config.RetryBackoff.Delay).To(BeZero())
+				Expect(config.RetryBackoff.Jitter).To(BeFalse())
+				Expect(config.RetryBackoff.MaxRetryTime).To(BeZero())
+			})
+
+			It("sets the backoff", func() {
+				var b = []byte(`
+retry_backoff:
+  delay: 50
+  jitter: true
+  max_retry_time: 2000
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.RetryBackoff.Delay).To(Equal(50 * time.Millisecond))
+				Expect(config.RetryBackoff.Jitter).To(BeTrue())
+				Expect(config.RetryBackoff.MaxRetryTime).To(Equal(2 * time.Second))
+			})
+
+			It("does not allow negative values", func() {
+				var b = []byte(`
+retry_backoff:
+  delay: -1
+  max_retry_time: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.RetryBackoff.Delay).To(BeZero())
+				Expect(config.RetryBackoff.MaxRetryTime).To(BeZero())
+			})
+		})
+
+		Describe("SlowRequestThreshold", func() {
+			It("is disabled by default", func() {
+				config.Process()
+
+				Expect(config.SlowRequestLog).To(BeEmpty())
+				Expect(config.SlowRequestThreshold).To(BeZero())
+			})
+
+			It("sets the slow request log and threshold", func() {
+				var b = []byte(`
+slow_request_log: /var/vcap/sys/log/slow.log
+slow_request_threshold: 1500
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.SlowRequestLog).To(Equal("/var/vcap/sys/log/slow.log"))
+				Expect(config.SlowRequestThreshold).To(Equal(1500 * time.Millisecond))
+			})
+
+			It("panics on a slow request log without a threshold", func() {
+				var b = []byte(`
+slow_request_log: stdout
+slow_request_threshold: -1
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("MaxURILength", func() {
+			It("is unlimited by default", func() {
+				Expect(config.MaxURILength).To(Equal(0))
+			})
+
+			It("sets the max URI length", func() {
+				var b = []byte(`
+max_uri_length: 8192
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxURILength).To(Equal(8192))
+			})
+
+			It("treats a negative value as unlimited", func() {
+				var b = []byte(`
+max_uri_length: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxURILength).To(Equal(0))
+			})
+		})
+
+		Describe("MaxRequestBodySize", func() {
+			It("is unlimited by default", func() {
+				Expect(config.MaxRequestBodySize).To(Equal(int64(0)))
+			})
+
+			It("sets the max request body size", func() {
+				var b = []byte(`
+max_request_body_size: 1048576
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxRequestBodySize).To(Equal(int64(1048576)))
+			})
+
+			It("treats a negative value as unlimited", func() {
+				var b = []byte(`
+max_request_body_size: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxRequestBodySize).To(Equal(int64(0)))
+			})
+		})
+
+		Describe("MaxRequestHeaderBytes", func() {
+			It("leaves the limit to net/http by default", func() {
+				Expect(config.MaxRequestHeaderBytes).To(Equal(0))
+			})
+
+			It("sets the max request header size", func() {
+				var b = []byte(`
+max_request_header_bytes: 16384
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxRequestHeaderBytes).To(Equal(16384))
+			})
+
+			It("treats a negative value as the default", func() {
+				var b = []byte(`
+max_request_header_bytes: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxRequestHeaderBytes).To(Equal(0))
+			})
+		})
+
+		Describe("MaxResponseHeaderBytes", func() {
+			It("leaves the limit to net/http by default", func() {
+				Expect(config.MaxResponseHeaderBytes).To(Equal(int64(0)))
+			})
+
+			It("sets the max response header size", func() {
+				var b = []byte(`
+max_response_header_bytes: 65536
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxResponseHeaderBytes).To(Equal(int64(65536)))
+			})
+
+			It("treats a negative value as the default", func() {
+				var b = []byte(`
+max_response_header_bytes: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxResponseHeaderBytes).To(Equal(int64(0)))
+			})
+		})
+
+		Describe("MaxIdleConnsPerBackend", func() {
+			It("disables backend keep-alive by default", func() {
+				config.Process()
+
+				Expect(config.MaxIdleConnsPerBackend).To(Equal(0))
+				Expect(config.BackendIdleTimeout).To(Equal(90 * time.Second))
+			})
+
+			It("sets the backend keep-alive properties", func() {
+				var b = []byte(`
+max_idle_conns_per_backend: 8
+backend_idle_timeout: 30
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxIdleConnsPerBackend).To(Equal(8))
+				Expect(config.BackendIdleTimeout).To(Equal(30 * time.Second))
+			})
+
+			It("treats a negative value as disabled", func() {
+				var b = []byte(`
+max_idle_conns_per_backend: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxIdleConnsPerBackend).To(Equal(0))
+			})
+		})
+
+		Describe("BackendKeepAlive", func() {
+			It("defaults to 15 seconds", func() {
+				config.Process()
+
+				Expect(config.BackendKeepAlive).To(Equal(15 * time.Second))
+			})
+
+			It("sets the backend keepalive period", func() {
+				var b = []byte(`
+backend_keep_alive: 60
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.BackendKeepAlive).To(Equal(60 * time.Second))
+			})
+
+			It("treats a negative value as disabled", func() {
+				var b = []byte(`
+backend_keep_alive: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.BackendKeepAlive).To(Equal(time.Duration(0)))
+			})
+		})
+
+		Describe("DialTimeout", func() {
+			It("defaults to 5 seconds", func() {
+				config.Process()
+
+				Expect(config.DialTimeout).To(Equal(5 * time.Second))
+			})
+
+			It("sets the dial timeout", func() {
+				var b = []byte(`
+dial_timeout: 2
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.DialTimeout).To(Equal(2 * time.Second))
+			})
+
+			It("falls back to the default for a non-positive value", func() {
+				var b = []byte(`
+dial_timeout: 0
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.DialTimeout).To(Equal(5 * time.Second))
+			})
+		})
+
+		Describe("EnableProxyProtocol", func() {
+			It("is disabled by default", func() {
+				config.Process()
+
+				Expect(config.EnableProxyProtocol).To(BeFalse())
+			})
+
+			It("can be enabled", func() {
+				var b = []byte(`
+enable_proxy_protocol: true
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.EnableProxyProtocol).To(BeTrue())
+			})
+		})
+
+		Describe("IdleTimeout", func() {
+			It("keeps idle client connections open by default", func() {
+				config.Process()
+
+				Expect(config.IdleTimeout).To(Equal(time.Duration(0)))
+			})
+
+			It("sets the idle timeout", func() {
+				var b = []byte(`
+idle_timeout: 90
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.IdleTimeout).To(Equal(90 * time.Second))
+			})
+
+			It("treats a negative value as no limit", func() {
+				var b = []byte(`
+idle_timeout: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.IdleTimeout).To(Equal(time.Duration(0)))
+			})
+		})
+
+		Describe("ClientReadTimeout", func() {
+			It("does not time out client connections by default", func() {
+				config.Process()
+
+				Expect(config.ClientReadTimeout).To(Equal(time.Duration(0)))
+				Expect(config.ClientWriteTimeout).To(Equal(time.Duration(0)))
+			})
+
+			It("sets the client connection timeouts", func() {
+				var b = []byte(`
+client_read_timeout: 5
+client_write_timeout: 10
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ClientReadTimeout).To(Equal(5 * time.Second))
+				Expect(config.ClientWriteTimeout).To(Equal(10 * time.Second))
+			})
+
+			It("treats a negative value as no limit", func() {
+				var b = []byte(`
+client_read_timeout: -1
+client_write_timeout: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ClientReadTimeout).To(Equal(time.Duration(0)))
+				Expect(config.ClientWriteTimeout).To(Equal(time.Duration(0)))
+			})
+		})
+
+		Describe("ErrorPages", func() {
+			render := func(page ErrorPage) string {
+				var buf bytes.Buffer
+				err := page.Body.Execute(&buf, struct{ Host, RequestId string }{"example.com", "abc"})
+				Expect(err).NotTo(HaveOccurred())
+				return buf.String()
+			}
+
+			It("has no error pages by default", func() {
+				config.Process()
+
+				Expect(config.ErrorPages).To(BeEmpty())
+			})
+
+			It("parses inline templates as HTML by default", func() {
+				var b = []byte(`
+error_pages:
+  404:
+    template: "<p>{{.Host}} not found ({{.RequestId}})</p>"
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				page := config.ErrorPages[404]
+				Expect(page.ContentType).To(Equal("text/html; charset=utf-8"))
+				Expect(render(page)).To(Equal("<p>example.com not found (abc)</p>"))
+			})
+
+			It("reads templates from files", func() {
+				file, err := ioutil.TempFile("", "error_page")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.Remove(file.Name())
+
+				path := file.Name() + ".json"
+				Expect(ioutil.WriteFile(path, []byte(`{"host":"{{.Host}}"}`), 0644)).To(Succeed())
+				defer os.Remove(path)
+
+				var b = []byte(`
+error_pages:
+  503:
+    file: ` + path + `
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				page := config.ErrorPages[503]
+				Expect(page.ContentType).To(Equal("application/json"))
+				Expect(render(page)).To(Equal(`{"host":"example.com"}`))
+			})
+
+			It("panics on a page without a template", func() {
+				var b = []byte(`
+error_pages:
+  502:
+    content_type: text/plain
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+
+			It("panics on an invalid template", func() {
+				var b = []byte(`
+error_pages:
+  502:
+    template: "{{.Host"
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("ResponseHeaders", func() {
+			It("leaves responses unchanged by default", func() {
+				config.Process()
+
+				Expect(config.ResponseHeaders.Add).To(BeEmpty())
+				Expect(config.ResponseHeaders.Remove).To(BeEmpty())
+			})
+
+			It("canonicalizes the header names", func() {
+				var b = []byte(`
+response_headers:
+  add:
+  - name: x-frame-options
+    value: DENY
+  remove: [server, X-POWERED-BY]
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ResponseHeaders.Add).To(Equal([]HeaderConfig{{Name: "X-Frame-Options", Value: "DENY"}}))
+				Expect(config.ResponseHeaders.Remove).To(Equal([]string{"Server", "X-Powered-By"}))
+			})
+
+			It("panics on a header without a name", func() {
+				var b = []byte(`
+response_headers:
+  add:
+  - value: DENY
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("HopByHopHeaders", func() {
+			It("adds none by default", func() {
+				Expect(config.HopByHopHeaders).To(BeEmpty())
+			})
+
+			It("sets the extra hop-by-hop headers", func() {
+				var b = []byte(`
+hop_by_hop_headers:
+  - X-Edge-Session
+  - X-Lb-Token
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.HopByHopHeaders).To(Equal([]string{"X-Edge-Session", "X-Lb-Token"}))
+			})
+		})
+
+		Describe("ExposeTimingHeader", func() {
+			It("defaults to false", func() {
+				Expect(config.ExposeTimingHeader).To(BeFalse())
+			})
+
+			It("sets the timing header option", func() {
+				var b = []byte(`
+expose_timing_header: true
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ExposeTimingHeader).To(BeTrue())
+			})
+		})
+
+		Describe("ViaPseudonym", func() {
+			It("identifies the router as gorouter by default", func() {
+				config.Process()
+
+				Expect(config.ViaPseudonym).To(Equal("gorouter"))
+			})
+
+			It("sets the pseudonym", func() {
+				var b = []byte(`
+via_pseudonym: edge-router-1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ViaPseudonym).To(Equal("edge-router-1"))
+			})
+
+			It("can be disabled", func() {
+				var b = []byte(`
+via_pseudonym: ""
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ViaPseudonym).To(BeEmpty())
+			})
+
+			It("panics on a pseudonym with spaces", func() {
+				var b = []byte(`
+via_pseudonym: edge router
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("DefaultRoute", func() {
+			It("has no default route by default", func() {
+				config.Process()
+
+				Expect(config.DefaultRoute).To(BeEmpty())
+			})
+
+			It("sets the default route as a normalized host", func() {
+				var b = []byte(`
+default_route: Legacy.Example.com.
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.DefaultRoute).To(Equal("legacy.example.com"))
+			})
+
+			It("panics on a route with a path", func() {
+				var b = []byte(`
+default_route: legacy.example.com/app
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("MaxConcurrentRequests", func() {
+			It("does not limit requests by default", func() {
+				config.Process()
+
+				Expect(config.MaxConcurrentRequests).To(Equal(0))
+			})
+
+			It("sets the concurrent request limit", func() {
+				var b = []byte(`
+max_concurrent_requests: 1000
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxConcurrentRequests).To(Equal(1000))
+			})
+
+			It("treats a negative value as unlimited", func() {
+				var b = []byte(`
+max_concurrent_requests: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxConcurrentRequests).To(Equal(0))
+			})
+		})
+
+		Describe("MaxRequestsPerConn", func() {
+			It("does not limit the requests of a connection by default", func() {
+				config.Process()
+
+				Expect(config.MaxRequestsPerConn).To(Equal(0))
+			})
+
+			It("sets the requests per connection limit", func() {
+				var b = []byte(`
+max_requests_per_conn: 100
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxRequestsPerConn).To(Equal(100))
+			})
+
+			It("treats a negative value as unlimited", func() {
+				var b = []byte(`
+max_requests_per_conn: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxRequestsPerConn).To(Equal(0))
+			})
+		})
+
+		Describe("MaxClientConns", func() {
+			It("does not limit client connections by default", func() {
+				config.Process()
+
+				Expect(config.MaxClientConns).To(Equal(0))
+				Expect(config.ResumeClientConns).To(Equal(0))
+			})
+
+			It("sets the high and low water marks", func() {
+				var b = []byte(`
+max_client_conns: 1000
+resume_client_conns: 500
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxClientConns).To(Equal(1000))
+				Expect(config.ResumeClientConns).To(Equal(500))
+			})
+
+			It("resumes at 90% of the limit by default", func() {
+				var b = []byte(`
+max_client_conns: 1000
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ResumeClientConns).To(Equal(900))
+			})
+
+			It("does not resume at or above the limit", func() {
+				var b = []byte(`
+max_client_conns: 1000
+resume_client_conns: 1000
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ResumeClientConns).To(Equal(900))
+			})
+
+			It("treats a negative value as unlimited", func() {
+				var b = []byte(`
+max_client_conns: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxClientConns).To(Equal(0))
+				Expect(config.ResumeClientConns).To(Equal(0))
+			})
+		})
+
+		Describe("RateLimit", func() {
+			It("does not limit clients by default", func() {
+				config.Process()
+
+				Expect(config.RateLimit.RequestsPerSecond).To(BeZero())
+				Expect(config.RateLimit.Burst).To(BeZero())
+			})
+
+			It("sets the rate limit properties", func() {
+				var b = []byte(`
+rate_limit:
+  requests_per_second: 10
+  burst: 50
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.RateLimit.RequestsPerSecond).To(Equal(10.0))
+				Expect(config.RateLimit.Burst).To(Equal(50))
+			})
+
+			It("allows a second's worth of requests at once by default", func() {
+				var b = []byte(`
+rate_limit:
+  requests_per_second: 2.5
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.RateLimit.Burst).To(Equal(3))
+			})
+		})
+
+		Describe("MaxConnsPerBackend", func() {
+			It("does not limit connections by default", func() {
+				config.Process()
+
+				Expect(config.MaxConnsPerBackend).To(Equal(0))
+				Expect(config.MaxConnsPolicy).To(Equal(MaxConnsPolicyReject))
+				Expect(config.MaxConnsQueueTimeout).To(Equal(time.Duration(0)))
+			})
+
+			It("queues requests for the configured timeout", func() {
+				var b = []byte(`
+max_conns_per_backend: 10
+max_conns_policy: queue
+max_conns_queue_timeout: 3
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxConnsPerBackend).To(Equal(10))
+				Expect(config.MaxConnsPolicy).To(Equal(MaxConnsPolicyQueue))
+				Expect(config.MaxConnsQueueTimeout).To(Equal(3 * time.Second))
+			})
+
+			It("does not queue requests when rejecting", func() {
+				var b = []byte(`
+max_conns_per_backend: 10
+max_conns_queue_timeout: 3
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxConnsQueueTimeout).To(Equal(time.Duration(0)))
+			})
+
+			It("treats a negative value as unlimited", func() {
+				var b = []byte(`
+max_conns_per_backend: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxConnsPerBackend).To(Equal(0))
+			})
+
+			It("queues requests fairly across backends", func() {
+				var b = []byte(`
+load_balancing: least-connections
+max_conns_per_backend: 10
+max_conns_policy: fair-queue
+max_conns_queue_timeout: 3
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.MaxConnsPolicy).To(Equal(MaxConnsPolicyFairQueue))
+				Expect(config.MaxConnsQueueTimeout).To(Equal(3 * time.Second))
+			})
+
+			It("panics on fair queueing without least-connections load balancing", func() {
+				var b = []byte(`
+max_conns_per_backend: 10
+max_conns_policy: fair-queue
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+
+			It("panics on an unknown policy", func() {
+				var b = []byte(`
+max_conns_policy: drop
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("TrustedProxyCIDRs", func() {
+			It("trusts no proxies by default", func() {
+				config.Process()
+
+				Expect(config.TrustedProxyNetworks).To(BeEmpty())
+			})
+
+			It("parses the trusted networks", func() {
+				var b = []byte(`
+trusted_proxy_cidrs:
+  - 10.0.0.0/8
+  - 192.168.1.1/32
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.TrustedProxyNetworks).To(HaveLen(2))
+				Expect(config.TrustedProxyNetworks[0].String()).To(Equal("10.0.0.0/8"))
+				Expect(config.TrustedProxyNetworks[1].String()).To(Equal("192.168.1.1/32"))
+			})
+
+			It("panics on an invalid CIDR", func() {
+				var b = []byte(`
+trusted_proxy_cidrs:
+  - 10.0.0.1
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("IPAllowList and IPDenyList", func() {
+			It("allows every client by default", func() {
+				config.Process()
+
+				Expect(config.IPAllowNetworks).To(BeEmpty())
+				Expect(config.IPDenyNetworks).To(BeEmpty())
+			})
+
+			It("parses the allowed and denied networks", func() {
+				var b = []byte(`
+ip_allow_list:
+  - 10.0.0.0/8
+ip_deny_list:
+  - 10.1.0.0/16
+  - 192.168.1.1/32
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.IPAllowNetworks).To(HaveLen(1))
+				Expect(config.IPAllowNetworks[0].String()).To(Equal("10.0.0.0/8"))
+				Expect(config.IPDenyNetworks).To(HaveLen(2))
+				Expect(config.IPDenyNetworks[0].String()).To(Equal("10.1.0.0/16"))
+				Expect(config.IPDenyNetworks[1].String()).To(Equal("192.168.1.1/32"))
+			})
+
+			It("panics on an invalid CIDR", func() {
+				var b = []byte(`
+ip_deny_list:
+  - 192.168.1.1
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("CompressResponses", func() {
+			It("is disabled by default", func() {
+				Expect(config.CompressResponses).To(BeFalse())
+				Expect(config.CompressionMinSize).To(Equal(int64(1024)))
+			})
+
+			It("sets the compression properties", func() {
+				var b = []byte(`
+compress_responses: true
+compression_min_size: 256
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.CompressResponses).To(BeTrue())
+				Expect(config.CompressionMinSize).To(Equal(int64(256)))
+			})
+
+			It("treats a negative minimum size as zero", func() {
+				var b = []byte(`
+compression_min_size: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.CompressionMinSize).To(Equal(int64(0)))
+			})
+		})
+
+		Describe("ResponseCacheSize", func() {
+			It("is disabled by default", func() {
+				Expect(config.ResponseCacheSize).To(Equal(int64(0)))
+			})
+
+			It("sets the response cache size", func() {
+				var b = []byte(`
+response_cache_size: 1048576
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ResponseCacheSize).To(Equal(int64(1048576)))
+			})
+
+			It("treats a negative size as zero", func() {
+				var b = []byte(`
+response_cache_size: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ResponseCacheSize).To(Equal(int64(0)))
+			})
+		})
+
+		Describe("IdempotencyCacheSize", func() {
+			It("is disabled by default, keeping keys for a day", func() {
+				config.Process()
+
+				Expect(config.IdempotencyCacheSize).To(Equal(int64(0)))
+				Expect(config.IdempotencyKeyTTL).To(Equal(24 * time.Hour))
+			})
+
+			It("sets the idempotency cache size and key ttl", func() {
+				var b = []byte(`
+idempotency_cache_size: 1048576
+idempotency_key_ttl: 3600
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.IdempotencyCacheSize).To(Equal(int64(1048576)))
+				Expect(config.IdempotencyKeyTTL).To(Equal(time.Hour))
+			})
+
+			It("panics on a cache without a key ttl", func() {
+				var b = []byte(`
+idempotency_cache_size: 1048576
+idempotency_key_ttl: 0
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("ResponseBufferSize", func() {
+			It("defaults to 0", func() {
+				Expect(config.ResponseBufferSize).To(Equal(int64(0)))
+			})
+
+			It("sets the response buffer size", func() {
+				var b = []byte(`
+response_buffer_size: 65536
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ResponseBufferSize).To(Equal(int64(65536)))
+			})
+
+			It("treats a negative size as zero", func() {
+				var b = []byte(`
+response_buffer_size: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ResponseBufferSize).To(Equal(int64(0)))
+			})
+		})
+
+		Describe("ProxyBufferSize", func() {
+			It("defaults to 32 KB", func() {
+				Expect(config.ProxyBufferSize).To(Equal(32 * 1024))
+			})
+
+			It("sets the proxy buffer size", func() {
+				var b = []byte(`
+proxy_buffer_size: 262144
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ProxyBufferSize).To(Equal(262144))
+			})
+
+			It("treats a negative size as zero", func() {
+				var b = []byte(`
+proxy_buffer_size: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.ProxyBufferSize).To(Equal(0))
+			})
+		})
+
+		Describe("AccessLogFormat", func() {
+			It("defaults to text", func() {
+				Expect(config.AccessLogFormat).To(Equal(AccessLogFormatText))
+			})
+
+			It("sets the access log format", func() {
+				var b = []byte(`
+access_log_format: json
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.AccessLogFormat).To(Equal(AccessLogFormatJSON))
+			})
+
+			It("panics on an unknown format", func() {
+				var b = []byte(`
+access_log_format: xml
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("LoadBalancerHealthCheckPath", func() {
+			It("is disabled by default", func() {
+				Expect(config.LoadBalancerHealthCheckPath).To(Equal(""))
+			})
+
+			It("sets the path", func() {
+				var b = []byte(`
+load_balancer_health_check_path: /lb-healthz
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.LoadBalancerHealthCheckPath).To(Equal("/lb-healthz"))
+			})
+
+			It("panics on a relative path", func() {
+				var b = []byte(`
+load_balancer_health_check_path: lb-healthz
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("HealthCheck response", func() {
+			It("answers 200 ok by default", func() {
+				config.Process()
+
+				Expect(config.HealthCheck.Status).To(Equal(200))
+				Expect(config.HealthCheck.Body).To(Equal("ok\n"))
+			})
+
+			It("sets the status and body", func() {
+				var b = []byte(`
+health_check:
+  status: 204
+  body: ""
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.HealthCheck.Status).To(Equal(204))
+				Expect(config.HealthCheck.Body).To(Equal(""))
+			})
+
+			It("panics on an invalid status", func() {
+				var b = []byte(`
+health_check:
+  status: 42
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
+		Describe("HealthCheck", func() {
+			It("is disabled by default", func() {
+				Expect(config.HealthCheckPath).To(Equal(""))
+				Expect(config.HealthCheckInterval).To(Equal(10 * time.Second))
+				Expect(config.HealthCheckUnhealthyThreshold).To(Equal(3))
+				Expect(config.HealthCheckHealthyThreshold).To(Equal(1))
+				Expect(config.HealthCheckGracePeriod).To(Equal(time.Duration(0)))
+			})
+
+			It("sets the health check properties", func() {
+				var b = []byte(`
+health_check_path: /health
+health_check_interval: 5
+health_check_unhealthy_threshold: 4
+health_check_healthy_threshold: 2
+health_check_grace_period: 30
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.HealthCheckPath).To(Equal("/health"))
+				Expect(config.HealthCheckInterval).To(Equal(5 * time.Second))
+				Expect(config.HealthCheckUnhealthyThreshold).To(Equal(4))
+				Expect(config.HealthCheckHealthyThreshold).To(Equal(2))
+				Expect(config.HealthCheckGracePeriod).To(Equal(30 * time.Second))
+			})
+
+			It("requires at least one failure to mark an endpoint unhealthy", func() {
+				var b = []byte(`
+health_check_unhealthy_threshold: 0
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.HealthCheckUnhealthyThreshold).To(Equal(1))
+			})
+
+			It("requires at least one success to mark an endpoint healthy", func() {
+				var b = []byte(`
+health_check_healthy_threshold: 0
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.HealthCheckHealthyThreshold).To(Equal(1))
+			})
+
+			It("does not allow a negative grace period", func() {
+				var b = []byte(`
+health_check_grace_period: -5
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.HealthCheckGracePeriod).To(Equal(time.Duration(0)))
+			})
+		})
+
+		Describe("CircuitBreaker", func() {
+			It("is disabled by default", func() {
+				config.Process()
+
+				Expect(config.CircuitBreakerThreshold).To(Equal(0))
+				Expect(config.CircuitBreakerCooldown).To(Equal(30 * time.Second))
+			})
+
+			It("sets the circuit breaker properties", func() {
+				var b = []byte(`
+circuit_breaker_threshold: 5
+circuit_breaker_cooldown: 10
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.CircuitBreakerThreshold).To(Equal(5))
+				Expect(config.CircuitBreakerCooldown).To(Equal(10 * time.Second))
+			})
+
+			It("disables the circuit breaker for a negative threshold", func() {
+				var b = []byte(`
+circuit_breaker_threshold: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.CircuitBreakerThreshold).To(Equal(0))
+			})
+		})
+
+		Describe("SlowStartDuration", func() {
+			It("is disabled by default", func() {
+				config.Process()
+
+				Expect(config.SlowStartDuration).To(Equal(time.Duration(0)))
+			})
+
+			It("sets the slow start duration", func() {
+				var b = []byte(`
+slow_start_duration: 30
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.SlowStartDuration).To(Equal(30 * time.Second))
+			})
+
+			It("disables slow start for a negative duration", func() {
+				var b = []byte(`
+slow_start_duration: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.SlowStartDuration).To(Equal(time.Duration(0)))
+			})
+		})
+
+		Describe("OutlierDetection", func() {
+			It("is disabled by default", func() {
+				config.Process()
+
+				Expect(config.OutlierDetection.DeviationFactor).To(Equal(0.0))
+				Expect(config.OutlierDetection.Interval).To(Equal(10 * time.Second))
+				Expect(config.OutlierDetection.MinRequests).To(Equal(20))
+				Expect(config.OutlierDetection.EjectionTime).To(Equal(30 * time.Second))
+			})
+
+			It("sets the outlier detection thresholds", func() {
+				var b = []byte(`
+outlier_detection:
+  interval: 5
+  min_requests: 100
+  deviation_factor: 2.5
+  ejection_time: 60
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.OutlierDetection.Interval).To(Equal(5 * time.Second))
+				Expect(config.OutlierDetection.MinRequests).To(Equal(100))
+				Expect(config.OutlierDetection.DeviationFactor).To(Equal(2.5))
+				Expect(config.OutlierDetection.EjectionTime).To(Equal(60 * time.Second))
+			})
+
+			It("disables outlier detection for a negative deviation factor", func() {
+				var b = []byte(`
+outlier_detection:
+  deviation_factor: -1
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.OutlierDetection.DeviationFactor).To(Equal(0.0))
+			})
+		})
+
+		Describe("LoadBalancing", func() {
+			It("defaults to round-robin", func() {
+				Expect(config.LoadBalancing).To(Equal(LoadBalancingRoundRobin))
+			})
+
+			It("accepts least-connections", func() {
+				var b = []byte(`
+load_balancing: least-connections
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.LoadBalancing).To(Equal(LoadBalancingLeastConnections))
+			})
+
+			It("accepts random", func() {
+				var b = []byte(`
+load_balancing: random
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.LoadBalancing).To(Equal(LoadBalancingRandom))
+			})
+
+			It("accepts power-of-two", func() {
+				var b = []byte(`
+load_balancing: power-of-two
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.LoadBalancing).To(Equal(LoadBalancingPowerOfTwo))
+			})
+
+			It("accepts header-hash with a header", func() {
+				var b = []byte(`
+load_balancing: header-hash
+load_balancing_hash_header: X-Tenant-Id
+`)
+
+				config.Initialize(b)
+				config.Process()
+
+				Expect(config.LoadBalancing).To(Equal(LoadBalancingHeaderHash))
+				Expect(config.LoadBalancingHashHeader).To(Equal("X-Tenant-Id"))
+			})
+
+			It("panics on header-hash without a header", func() {
+				var b = []byte(`
+load_balancing: header-hash
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+
+			It("panics on an unknown policy", func() {
+				var b = []byte(`
+load_balancing: fastest
+`)
+
+				config.Initialize(b)
+
+				Expect(config.Process).To(Panic())
+			})
+		})
+
 		Describe("Timeout", func() {
 			It("converts timeouts to a duration", func() {
 				var b = []byte(`
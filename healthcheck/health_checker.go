@@ -0,0 +1,178 @@
+package healthcheck
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+type HealthChecker struct {
+	RouteRegistry      registry.RegistryInterface
+	Path               string
+	CheckInterval      time.Duration
+	UnhealthyThreshold int
+	HealthyThreshold   int
+	GracePeriod        time.Duration
+
+	logger *steno.Logger
+	client *http.Client
+	ticker *time.Ticker
+	states map[string]*probeState
+}
+
+// probeState counts the probes in a row an endpoint has failed or passed. An
+// endpoint is marked unhealthy once it has failed UnhealthyThreshold probes
+// and has kept failing for GracePeriod, and healthy again once it has passed
+// HealthyThreshold probes.
+type probeState struct {
+	failures     int
+	successes    int
+	failingSince time.Time
+	unhealthy    bool
+}
+
+type target struct {
+	endpoint *route.Endpoint
+	pools    []*route.Pool
+}
+
+func NewHealthChecker(logger *steno.Logger, routeRegistry registry.RegistryInterface, cfg *config.Config) *HealthChecker {
+	return &HealthChecker{
+		RouteRegistry:      routeRegistry,
+		Path:               cfg.HealthCheckPath,
+		CheckInterval:      cfg.HealthCheckInterval,
+		UnhealthyThreshold: cfg.HealthCheckUnhealthyThreshold,
+		HealthyThreshold:   cfg.HealthCheckHealthyThreshold,
+		GracePeriod:        cfg.HealthCheckGracePeriod,
+
+		logger: logger,
+		client: &http.Client{Timeout: cfg.HealthCheckInterval},
+		states: make(map[string]*probeState),
+	}
+}
+
+func (h *HealthChecker) StartCheckCycle() {
+	if h.CheckInterval > 0 {
+		h.ticker = time.NewTicker(h.CheckInterval)
+
+		go func() {
+			for {
+				select {
+				case <-h.ticker.C:
+					h.Check()
+				}
+			}
+		}()
+	}
+}
+
+func (h *HealthChecker) StopCheckCycle() {
+	if h.ticker != nil {
+		h.ticker.Stop()
+	}
+}
+
+// Check probes every registered endpoint once and updates its health in all
+// the pools it is registered in.
+func (h *HealthChecker) Check() {
+	targets := make(map[string]*target)
+	for _, pool := range h.RouteRegistry.Pools() {
+		pool.Each(func(endpoint *route.Endpoint) {
+			addr := endpoint.CanonicalAddr()
+			t, ok := targets[addr]
+			if !ok {
+				t = &target{endpoint: endpoint}
+				targets[addr] = t
+			}
+			t.pools = append(t.pools, pool)
+		})
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(targets))
+
+	for addr, t := range targets {
+		wg.Add(1)
+		go func(addr string, endpoint *route.Endpoint) {
+			defer wg.Done()
+
+			err := h.probe(endpoint)
+
+			lock.Lock()
+			results[addr] = err
+			lock.Unlock()
+		}(addr, t.endpoint)
+	}
+	wg.Wait()
+
+	for addr := range h.states {
+		if _, ok := targets[addr]; !ok {
+			delete(h.states, addr)
+		}
+	}
+
+	now := time.Now()
+	for addr, t := range targets {
+		state, ok := h.states[addr]
+		if !ok {
+			state = &probeState{}
+			h.states[addr] = state
+		}
+
+		if err := results[addr]; err != nil {
+			state.failed(now)
+			if !state.unhealthy && state.failures >= h.UnhealthyThreshold && now.Sub(state.failingSince) >= h.GracePeriod {
+				h.logger.Warnf("health-check: endpoint %s is unhealthy: %s", addr, err)
+				state.unhealthy = true
+			}
+		} else {
+			state.passed()
+			if state.unhealthy && state.successes >= h.HealthyThreshold {
+				h.logger.Infof("health-check: endpoint %s is healthy again", addr)
+				state.unhealthy = false
+			}
+		}
+
+		for _, p := range t.pools {
+			if state.unhealthy {
+				p.MarkUnhealthy(t.endpoint)
+			} else {
+				p.MarkHealthy(t.endpoint)
+			}
+		}
+	}
+}
+
+func (s *probeState) failed(now time.Time) {
+	if s.failures == 0 {
+		s.failingSince = now
+	}
+	s.failures++
+	s.successes = 0
+}
+
+func (s *probeState) passed() {
+	s.successes++
+	s.failures = 0
+}
+
+func (h *HealthChecker) probe(endpoint *route.Endpoint) error {
+	res, err := h.client.Get(fmt.Sprintf("http://%s%s", endpoint.CanonicalAddr(), h.Path))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	return nil
+}
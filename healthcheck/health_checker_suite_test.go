@@ -0,0 +1,13 @@
+package healthcheck_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "HealthCheck Suite")
+}
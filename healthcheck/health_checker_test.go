@@ -0,0 +1,242 @@
+package healthcheck_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	testRegistry "github.com/cloudfoundry/gorouter/registry/fakes"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/gosteno"
+
+	. "github.com/cloudfoundry/gorouter/healthcheck"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type backend struct {
+	server   *httptest.Server
+	endpoint *route.Endpoint
+	failing  int32
+	path     atomic.Value
+}
+
+func newBackend() *backend {
+	b := &backend{}
+	b.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b.path.Store(req.URL.Path)
+		if atomic.LoadInt32(&b.failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	host, portStr, err := net.SplitHostPort(b.server.Listener.Addr().String())
+	Expect(err).ToNot(HaveOccurred())
+	port, err := strconv.Atoi(portStr)
+	Expect(err).ToNot(HaveOccurred())
+
+	b.endpoint = route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+	return b
+}
+
+func (b *backend) setFailing(failing bool) {
+	var v int32
+	if failing {
+		v = 1
+	}
+	atomic.StoreInt32(&b.failing, v)
+}
+
+var _ = Describe("HealthChecker", func() {
+	var (
+		cfg      *config.Config
+		registry *testRegistry.FakeRegistryInterface
+		checker  *HealthChecker
+		pool     *route.Pool
+		healthy  *backend
+		flapping *backend
+	)
+
+	BeforeEach(func() {
+		cfg = config.DefaultConfig()
+		cfg.HealthCheckPath = "/health"
+		cfg.HealthCheckUnhealthyThreshold = 2
+
+		healthy = newBackend()
+		flapping = newBackend()
+
+		pool = route.NewPool(2*time.Minute, "")
+		pool.Put(healthy.endpoint)
+		pool.Put(flapping.endpoint)
+
+		registry = &testRegistry.FakeRegistryInterface{}
+		registry.PoolsReturns([]*route.Pool{pool})
+
+		checker = NewHealthChecker(gosteno.NewLogger("health_checker_test"), registry, cfg)
+	})
+
+	AfterEach(func() {
+		healthy.server.Close()
+		flapping.server.Close()
+	})
+
+	It("probes the configured path", func() {
+		checker.Check()
+
+		Expect(healthy.path.Load()).To(Equal("/health"))
+		Expect(flapping.path.Load()).To(Equal("/health"))
+	})
+
+	It("keeps endpoints answering with 2xx in rotation", func() {
+		checker.Check()
+
+		Expect(pool.IsHealthy(healthy.endpoint)).To(BeTrue())
+		Expect(pool.IsHealthy(flapping.endpoint)).To(BeTrue())
+	})
+
+	It("marks an endpoint unhealthy once the threshold is reached", func() {
+		flapping.setFailing(true)
+
+		checker.Check()
+		Expect(pool.IsHealthy(flapping.endpoint)).To(BeTrue())
+
+		checker.Check()
+		Expect(pool.IsHealthy(flapping.endpoint)).To(BeFalse())
+		Expect(pool.IsHealthy(healthy.endpoint)).To(BeTrue())
+
+		for i := 0; i < 10; i++ {
+			Expect(pool.Endpoints("").Next()).To(Equal(healthy.endpoint))
+		}
+	})
+
+	It("returns an endpoint to rotation once it passes again", func() {
+		flapping.setFailing(true)
+		checker.Check()
+		checker.Check()
+		Expect(pool.IsHealthy(flapping.endpoint)).To(BeFalse())
+
+		flapping.setFailing(false)
+		checker.Check()
+		Expect(pool.IsHealthy(flapping.endpoint)).To(BeTrue())
+	})
+
+	It("resets the failure count after a successful probe", func() {
+		flapping.setFailing(true)
+		checker.Check()
+
+		flapping.setFailing(false)
+		checker.Check()
+
+		flapping.setFailing(true)
+		checker.Check()
+		Expect(pool.IsHealthy(flapping.endpoint)).To(BeTrue())
+	})
+
+	Context("with a grace period", func() {
+		BeforeEach(func() {
+			checker.GracePeriod = 100 * time.Millisecond
+		})
+
+		It("keeps an endpoint in rotation until it has failed for the grace period", func() {
+			flapping.setFailing(true)
+
+			checker.Check()
+			Expect(pool.IsHealthy(flapping.endpoint)).To(BeTrue())
+
+			checker.Check()
+			checker.Check()
+			Expect(pool.IsHealthy(flapping.endpoint)).To(BeTrue())
+
+			time.Sleep(100 * time.Millisecond)
+
+			checker.Check()
+			Expect(pool.IsHealthy(flapping.endpoint)).To(BeFalse())
+		})
+
+		It("starts the grace period over after a successful probe", func() {
+			flapping.setFailing(true)
+			checker.Check()
+			time.Sleep(100 * time.Millisecond)
+
+			flapping.setFailing(false)
+			checker.Check()
+
+			flapping.setFailing(true)
+			checker.Check()
+			checker.Check()
+			Expect(pool.IsHealthy(flapping.endpoint)).To(BeTrue())
+		})
+	})
+
+	Context("with a healthy threshold", func() {
+		BeforeEach(func() {
+			checker.HealthyThreshold = 3
+		})
+
+		It("returns an endpoint to rotation once it passes enough probes in a row", func() {
+			flapping.setFailing(true)
+			checker.Check()
+			checker.Check()
+			Expect(pool.IsHealthy(flapping.endpoint)).To(BeFalse())
+
+			flapping.setFailing(false)
+			checker.Check()
+			checker.Check()
+			Expect(pool.IsHealthy(flapping.endpoint)).To(BeFalse())
+
+			flapping.setFailing(true)
+			checker.Check()
+			flapping.setFailing(false)
+			checker.Check()
+			checker.Check()
+			Expect(pool.IsHealthy(flapping.endpoint)).To(BeFalse())
+
+			checker.Check()
+			Expect(pool.IsHealthy(flapping.endpoint)).To(BeTrue())
+		})
+	})
+
+	It("marks endpoints that refuse connections unhealthy", func() {
+		flapping.server.Close()
+
+		checker.Check()
+		checker.Check()
+
+		Expect(pool.IsHealthy(flapping.endpoint)).To(BeFalse())
+	})
+
+	It("updates every pool the endpoint is registered in", func() {
+		other := route.NewPool(2*time.Minute, "")
+		other.Put(flapping.endpoint)
+		registry.PoolsReturns([]*route.Pool{pool, other})
+
+		flapping.setFailing(true)
+		checker.Check()
+		checker.Check()
+
+		Expect(pool.IsHealthy(flapping.endpoint)).To(BeFalse())
+		Expect(other.IsHealthy(flapping.endpoint)).To(BeFalse())
+	})
+
+	Describe("StartCheckCycle", func() {
+		It("probes endpoints periodically", func() {
+			checker.CheckInterval = 10 * time.Millisecond
+			flapping.setFailing(true)
+
+			checker.StartCheckCycle()
+			defer checker.StopCheckCycle()
+
+			Eventually(func() bool {
+				return pool.IsHealthy(flapping.endpoint)
+			}).Should(BeFalse())
+			Expect(registry.PoolsCallCount()).To(BeNumerically(">=", 2))
+		})
+	})
+})
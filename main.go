@@ -12,6 +12,7 @@ import (
 	vcap "github.com/cloudfoundry/gorouter/common"
 	"github.com/cloudfoundry/gorouter/common/secure"
 	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/healthcheck"
 	"github.com/cloudfoundry/gorouter/proxy"
 	rregistry "github.com/cloudfoundry/gorouter/registry"
 	"github.com/cloudfoundry/gorouter/route_fetcher"
@@ -22,7 +23,9 @@ import (
 
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"syscall"
 	"time"
@@ -76,8 +79,17 @@ func main() {
 	logger.Info("Setting up routing_api route fetcher")
 	setupRouteFetcher(c, registry, logger)
 
+	setupHealthChecker(c, registry)
+
 	varz := rvarz.NewVarz(registry)
-	compositeReporter := metrics.NewCompositeReporter(varz, metricsReporter)
+	prometheusReporter := metrics.NewPrometheusReporter()
+	compositeReporter := metrics.NewCompositeReporter(varz,
+		metrics.NewCompositeReporter(metricsReporter, prometheusReporter))
+
+	statusHandlers := map[string]http.Handler{}
+	if c.Status.PrometheusPath != "" {
+		statusHandlers[c.Status.PrometheusPath] = prometheusReporter
+	}
 
 	accessLogger, err := access_log.CreateRunningAccessLogger(c)
 	if err != nil {
@@ -95,7 +107,11 @@ func main() {
 
 	proxy := buildProxy(c, registry, accessLogger, compositeReporter, crypto, cryptoPrev)
 
-	router, err := router.NewRouter(c, proxy, natsClient, registry, varz, logCounter, nil)
+	if configFile != "" {
+		go reloadOnSighup(configFile, proxy, logger)
+	}
+
+	router, err := router.NewRouter(c, proxy, natsClient, registry, varz, logCounter, statusHandlers, nil)
 	if err != nil {
 		logger.Errorf("An error occurred: %s", err.Error())
 		os.Exit(1)
@@ -122,6 +138,25 @@ func launchRouter(c *config.Config, router *router.Router, logger *steno.Logger)
 	os.Exit(0)
 }
 
+// reloadOnSighup reloads the proxy from the configuration file whenever the
+// process receives a SIGHUP. An invalid file is logged and ignored.
+func reloadOnSighup(path string, p proxy.Proxy, logger *steno.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					logger.Errorf("gorouter.reload.failed: %v", err)
+				}
+			}()
+
+			p.Reload(config.InitConfigFromFile(path))
+		}()
+	}
+}
+
 func createCrypto(secret string, logger *steno.Logger) *secure.AesGCM {
 	// generate secure encryption key using key derivation function (pbkdf2)
 	secretPbkdf2 := secure.NewPbkdf2([]byte(secret), 16)
@@ -136,6 +171,7 @@ func createCrypto(secret string, logger *steno.Logger) *secure.AesGCM {
 func buildProxy(c *config.Config, registry rregistry.RegistryInterface, accessLogger access_log.AccessLogger, reporter metrics.ProxyReporter, crypto secure.Crypto, cryptoPrev secure.Crypto) proxy.Proxy {
 	args := proxy.ProxyArgs{
 		EndpointTimeout: c.EndpointTimeout,
+		DialTimeout:     c.DialTimeout,
 		Ip:              c.Ip,
 		TraceKey:        c.TraceKey,
 		Registry:        registry,
@@ -146,11 +182,72 @@ func buildProxy(c *config.Config, registry rregistry.RegistryInterface, accessLo
 			CipherSuites:       c.CipherSuites,
 			InsecureSkipVerify: c.SSLSkipValidation,
 		},
-		RouteServiceEnabled: c.RouteServiceEnabled,
-		RouteServiceTimeout: c.RouteServiceTimeout,
-		Crypto:              crypto,
-		CryptoPrev:          cryptoPrev,
-		ExtraHeadersToLog:   c.ExtraHeadersToLog,
+		RouteServiceEnabled:    c.RouteServiceEnabled,
+		RouteServiceTimeout:    c.RouteServiceTimeout,
+		Crypto:                 crypto,
+		CryptoPrev:             cryptoPrev,
+		ExtraHeadersToLog:      c.ExtraHeadersToLog,
+		LoadBalancing:          c.LoadBalancing,
+		HashHeader:             c.LoadBalancingHashHeader,
+		StickyCookieName:       c.StickyCookieName,
+		MaxRetries:             proxy.RetriesArg(c.MaxRetries),
+		RetryBodyBufferSize:    c.RetryBodyBufferSize,
+		MaxURILength:           c.MaxURILength,
+		MaxRequestBodySize:     c.MaxRequestBodySize,
+		MaxResponseHeaderBytes: c.MaxResponseHeaderBytes,
+		CompressResponses:      c.CompressResponses,
+		CompressionMinSize:     c.CompressionMinSize,
+		ResponseCacheSize:      c.ResponseCacheSize,
+		IdempotencyCacheSize:   c.IdempotencyCacheSize,
+		IdempotencyKeyTTL:      c.IdempotencyKeyTTL,
+		HopByHopHeaders:        c.HopByHopHeaders,
+		ResponseBufferSize:     c.ResponseBufferSize,
+		BufferSize:             c.ProxyBufferSize,
+
+		MaxIdleConnsPerBackend: c.MaxIdleConnsPerBackend,
+		BackendIdleTimeout:     c.BackendIdleTimeout,
+		BackendKeepAlive:       c.BackendKeepAlive,
+
+		MaxConnsPerBackend:   c.MaxConnsPerBackend,
+		MaxConnsQueueTimeout: c.MaxConnsQueueTimeout,
+		MaxConnsFairQueue:    c.MaxConnsPolicy == config.MaxConnsPolicyFairQueue,
+
+		MaxConcurrentRequests: c.MaxConcurrentRequests,
+
+		MaxRequestsPerConn: c.MaxRequestsPerConn,
+
+		TrustedProxyNetworks: c.TrustedProxyNetworks,
+
+		IPAllowNetworks: c.IPAllowNetworks,
+		IPDenyNetworks:  c.IPDenyNetworks,
+
+		RateLimit:      c.RateLimit.RequestsPerSecond,
+		RateLimitBurst: c.RateLimit.Burst,
+
+		ErrorPages: c.ErrorPages,
+
+		ResponseHeaders: c.ResponseHeaders,
+
+		ViaPseudonym:       c.ViaPseudonym,
+		ExposeTimingHeader: c.ExposeTimingHeader,
+
+		RetryBackoff: proxy.RetryBackoff{
+			Delay:        c.RetryBackoff.Delay,
+			Jitter:       c.RetryBackoff.Jitter,
+			MaxRetryTime: c.RetryBackoff.MaxRetryTime,
+		},
+
+		DefaultRoute: c.DefaultRoute,
+
+		LoadBalancerHealthCheckPath:   c.LoadBalancerHealthCheckPath,
+		LoadBalancerHealthCheckStatus: c.HealthCheck.Status,
+		LoadBalancerHealthCheckBody:   c.HealthCheck.Body,
+
+		ForwardedClientCertHeader: c.ForwardedClientCertHeader,
+		ForwardedClientCertFormat: c.ForwardedClientCertFormat,
+	}
+	if c.ForceHTTPS {
+		args.HTTPSRedirectPort = c.SSLPort
 	}
 	return proxy.NewProxy(args)
 }
@@ -166,6 +263,13 @@ func setupRouteFetcher(c *config.Config, registry rregistry.RegistryInterface, l
 	}
 }
 
+func setupHealthChecker(c *config.Config, registry rregistry.RegistryInterface) {
+	if c.HealthCheckPath != "" {
+		healthChecker := healthcheck.NewHealthChecker(steno.NewLogger("router.health_checker"), registry, c)
+		healthChecker.StartCheckCycle()
+	}
+}
+
 func newTokenFetcher(c *config.Config, logger *steno.Logger) token_fetcher.TokenFetcher {
 	if c.RoutingApi.AuthDisabled {
 		logger.Info("using noop token fetcher")
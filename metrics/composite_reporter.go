@@ -33,7 +33,32 @@ func (c *CompositeReporter) CaptureRoutingRequest(b *route.Endpoint, req *http.R
 	c.second.CaptureRoutingRequest(b, req)
 }
 
-func (c *CompositeReporter) CaptureRoutingResponse(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration) {
-	c.first.CaptureRoutingResponse(b, res, t, d)
-	c.second.CaptureRoutingResponse(b, res, t, d)
+func (c *CompositeReporter) CaptureRoutingResponse(b *route.Endpoint, uri route.Uri, res *http.Response, t time.Time, d time.Duration) {
+	c.first.CaptureRoutingResponse(b, uri, res, t, d)
+	c.second.CaptureRoutingResponse(b, uri, res, t, d)
+}
+
+func (c *CompositeReporter) CaptureProxyResponse(status int) {
+	c.first.CaptureProxyResponse(status)
+	c.second.CaptureProxyResponse(status)
+}
+
+func (c *CompositeReporter) CaptureBackendFailure(b *route.Endpoint, err error) {
+	c.first.CaptureBackendFailure(b, err)
+	c.second.CaptureBackendFailure(b, err)
+}
+
+func (c *CompositeReporter) CaptureSelectionTime(d time.Duration) {
+	c.first.CaptureSelectionTime(d)
+	c.second.CaptureSelectionTime(d)
+}
+
+func (c *CompositeReporter) CaptureQueueTime(d time.Duration) {
+	c.first.CaptureQueueTime(d)
+	c.second.CaptureQueueTime(d)
+}
+
+func (c *CompositeReporter) CaptureRouteBytes(uri route.Uri, received, sent int64) {
+	c.first.CaptureRouteBytes(uri, received, sent)
+	c.second.CaptureRouteBytes(uri, received, sent)
 }
@@ -6,6 +6,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/cloudfoundry/gorouter/metrics/fakes"
 
+	"errors"
 	"net/http"
 	"github.com/cloudfoundry/gorouter/metrics"
 	"github.com/cloudfoundry/gorouter/route"
@@ -70,21 +71,49 @@ var _ = Describe("CompositeReporter", func() {
 	})
 
 	It("forwards CaptureRoutingResponse to both reporters", func() {
-		composite.CaptureRoutingResponse(endpoint, response, responseTime, responseDuration)
+		composite.CaptureRoutingResponse(endpoint, "example.com", response, responseTime, responseDuration)
 
 		Expect(fakeReporter1.CaptureRoutingResponseCallCount()).To(Equal(1))
 		Expect(fakeReporter2.CaptureRoutingResponseCallCount()).To(Equal(1))
 
-		callEndpoint, callResponse, callTime, callDuration := fakeReporter1.CaptureRoutingResponseArgsForCall(0)
+		callEndpoint, callUri, callResponse, callTime, callDuration := fakeReporter1.CaptureRoutingResponseArgsForCall(0)
 		Expect(callEndpoint).To(Equal(endpoint))
+		Expect(callUri).To(Equal(route.Uri("example.com")))
 		Expect(callResponse).To(Equal(response))
 		Expect(callTime).To(Equal(responseTime))
 		Expect(callDuration).To(Equal(responseDuration))
 
-		callEndpoint, callResponse, callTime, callDuration = fakeReporter2.CaptureRoutingResponseArgsForCall(0)
+		callEndpoint, callUri, callResponse, callTime, callDuration = fakeReporter2.CaptureRoutingResponseArgsForCall(0)
 		Expect(callEndpoint).To(Equal(endpoint))
+		Expect(callUri).To(Equal(route.Uri("example.com")))
 		Expect(callResponse).To(Equal(response))
 		Expect(callTime).To(Equal(responseTime))
 		Expect(callDuration).To(Equal(responseDuration))
 	})
+
+	It("forwards CaptureProxyResponse to both reporters", func() {
+		composite.CaptureProxyResponse(http.StatusNotFound)
+
+		Expect(fakeReporter1.CaptureProxyResponseCallCount()).To(Equal(1))
+		Expect(fakeReporter2.CaptureProxyResponseCallCount()).To(Equal(1))
+
+		Expect(fakeReporter1.CaptureProxyResponseArgsForCall(0)).To(Equal(http.StatusNotFound))
+		Expect(fakeReporter2.CaptureProxyResponseArgsForCall(0)).To(Equal(http.StatusNotFound))
+	})
+
+	It("forwards CaptureBackendFailure to both reporters", func() {
+		err := errors.New("connection refused")
+		composite.CaptureBackendFailure(endpoint, err)
+
+		Expect(fakeReporter1.CaptureBackendFailureCallCount()).To(Equal(1))
+		Expect(fakeReporter2.CaptureBackendFailureCallCount()).To(Equal(1))
+
+		callEndpoint, callErr := fakeReporter1.CaptureBackendFailureArgsForCall(0)
+		Expect(callEndpoint).To(Equal(endpoint))
+		Expect(callErr).To(Equal(err))
+
+		callEndpoint, callErr = fakeReporter2.CaptureBackendFailureArgsForCall(0)
+		Expect(callEndpoint).To(Equal(endpoint))
+		Expect(callErr).To(Equal(err))
+	})
 })
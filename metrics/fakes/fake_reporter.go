@@ -27,14 +27,43 @@ type FakeReporter struct {
 		b   *route.Endpoint
 		req *http.Request
 	}
-	CaptureRoutingResponseStub        func(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration)
+	CaptureRoutingResponseStub        func(b *route.Endpoint, uri route.Uri, res *http.Response, t time.Time, d time.Duration)
 	captureRoutingResponseMutex       sync.RWMutex
 	captureRoutingResponseArgsForCall []struct {
 		b   *route.Endpoint
+		uri route.Uri
 		res *http.Response
 		t   time.Time
 		d   time.Duration
 	}
+	CaptureProxyResponseStub        func(status int)
+	captureProxyResponseMutex       sync.RWMutex
+	captureProxyResponseArgsForCall []struct {
+		status int
+	}
+	CaptureBackendFailureStub        func(b *route.Endpoint, err error)
+	captureBackendFailureMutex       sync.RWMutex
+	captureBackendFailureArgsForCall []struct {
+		b   *route.Endpoint
+		err error
+	}
+	CaptureSelectionTimeStub        func(d time.Duration)
+	captureSelectionTimeMutex       sync.RWMutex
+	captureSelectionTimeArgsForCall []struct {
+		d time.Duration
+	}
+	CaptureQueueTimeStub        func(d time.Duration)
+	captureQueueTimeMutex       sync.RWMutex
+	captureQueueTimeArgsForCall []struct {
+		d time.Duration
+	}
+	CaptureRouteBytesStub        func(uri route.Uri, received, sent int64)
+	captureRouteBytesMutex       sync.RWMutex
+	captureRouteBytesArgsForCall []struct {
+		uri      route.Uri
+		received int64
+		sent     int64
+	}
 }
 
 func (fake *FakeReporter) CaptureBadRequest(req *http.Request) {
@@ -107,17 +136,18 @@ func (fake *FakeReporter) CaptureRoutingRequestArgsForCall(i int) (*route.Endpoi
 	return fake.captureRoutingRequestArgsForCall[i].b, fake.captureRoutingRequestArgsForCall[i].req
 }
 
-func (fake *FakeReporter) CaptureRoutingResponse(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration) {
+func (fake *FakeReporter) CaptureRoutingResponse(b *route.Endpoint, uri route.Uri, res *http.Response, t time.Time, d time.Duration) {
 	fake.captureRoutingResponseMutex.Lock()
 	fake.captureRoutingResponseArgsForCall = append(fake.captureRoutingResponseArgsForCall, struct {
 		b   *route.Endpoint
+		uri route.Uri
 		res *http.Response
 		t   time.Time
 		d   time.Duration
-	}{b, res, t, d})
+	}{b, uri, res, t, d})
 	fake.captureRoutingResponseMutex.Unlock()
 	if fake.CaptureRoutingResponseStub != nil {
-		fake.CaptureRoutingResponseStub(b, res, t, d)
+		fake.CaptureRoutingResponseStub(b, uri, res, t, d)
 	}
 }
 
@@ -127,10 +157,128 @@ func (fake *FakeReporter) CaptureRoutingResponseCallCount() int {
 	return len(fake.captureRoutingResponseArgsForCall)
 }
 
-func (fake *FakeReporter) CaptureRoutingResponseArgsForCall(i int) (*route.Endpoint, *http.Response, time.Time, time.Duration) {
+func (fake *FakeReporter) CaptureRoutingResponseArgsForCall(i int) (*route.Endpoint, route.Uri, *http.Response, time.Time, time.Duration) {
 	fake.captureRoutingResponseMutex.RLock()
 	defer fake.captureRoutingResponseMutex.RUnlock()
-	return fake.captureRoutingResponseArgsForCall[i].b, fake.captureRoutingResponseArgsForCall[i].res, fake.captureRoutingResponseArgsForCall[i].t, fake.captureRoutingResponseArgsForCall[i].d
+	return fake.captureRoutingResponseArgsForCall[i].b, fake.captureRoutingResponseArgsForCall[i].uri, fake.captureRoutingResponseArgsForCall[i].res, fake.captureRoutingResponseArgsForCall[i].t, fake.captureRoutingResponseArgsForCall[i].d
+}
+
+func (fake *FakeReporter) CaptureProxyResponse(status int) {
+	fake.captureProxyResponseMutex.Lock()
+	fake.captureProxyResponseArgsForCall = append(fake.captureProxyResponseArgsForCall, struct {
+		status int
+	}{status})
+	fake.captureProxyResponseMutex.Unlock()
+	if fake.CaptureProxyResponseStub != nil {
+		fake.CaptureProxyResponseStub(status)
+	}
+}
+
+func (fake *FakeReporter) CaptureProxyResponseCallCount() int {
+	fake.captureProxyResponseMutex.RLock()
+	defer fake.captureProxyResponseMutex.RUnlock()
+	return len(fake.captureProxyResponseArgsForCall)
+}
+
+func (fake *FakeReporter) CaptureProxyResponseArgsForCall(i int) int {
+	fake.captureProxyResponseMutex.RLock()
+	defer fake.captureProxyResponseMutex.RUnlock()
+	return fake.captureProxyResponseArgsForCall[i].status
+}
+
+func (fake *FakeReporter) CaptureBackendFailure(b *route.Endpoint, err error) {
+	fake.captureBackendFailureMutex.Lock()
+	fake.captureBackendFailureArgsForCall = append(fake.captureBackendFailureArgsForCall, struct {
+		b   *route.Endpoint
+		err error
+	}{b, err})
+	fake.captureBackendFailureMutex.Unlock()
+	if fake.CaptureBackendFailureStub != nil {
+		fake.CaptureBackendFailureStub(b, err)
+	}
+}
+
+func (fake *FakeReporter) CaptureBackendFailureCallCount() int {
+	fake.captureBackendFailureMutex.RLock()
+	defer fake.captureBackendFailureMutex.RUnlock()
+	return len(fake.captureBackendFailureArgsForCall)
+}
+
+func (fake *FakeReporter) CaptureBackendFailureArgsForCall(i int) (*route.Endpoint, error) {
+	fake.captureBackendFailureMutex.RLock()
+	defer fake.captureBackendFailureMutex.RUnlock()
+	return fake.captureBackendFailureArgsForCall[i].b, fake.captureBackendFailureArgsForCall[i].err
+}
+
+func (fake *FakeReporter) CaptureSelectionTime(d time.Duration) {
+	fake.captureSelectionTimeMutex.Lock()
+	fake.captureSelectionTimeArgsForCall = append(fake.captureSelectionTimeArgsForCall, struct {
+		d time.Duration
+	}{d})
+	fake.captureSelectionTimeMutex.Unlock()
+	if fake.CaptureSelectionTimeStub != nil {
+		fake.CaptureSelectionTimeStub(d)
+	}
+}
+
+func (fake *FakeReporter) CaptureSelectionTimeCallCount() int {
+	fake.captureSelectionTimeMutex.RLock()
+	defer fake.captureSelectionTimeMutex.RUnlock()
+	return len(fake.captureSelectionTimeArgsForCall)
+}
+
+func (fake *FakeReporter) CaptureSelectionTimeArgsForCall(i int) time.Duration {
+	fake.captureSelectionTimeMutex.RLock()
+	defer fake.captureSelectionTimeMutex.RUnlock()
+	return fake.captureSelectionTimeArgsForCall[i].d
+}
+
+func (fake *FakeReporter) CaptureQueueTime(d time.Duration) {
+	fake.captureQueueTimeMutex.Lock()
+	fake.captureQueueTimeArgsForCall = append(fake.captureQueueTimeArgsForCall, struct {
+		d time.Duration
+	}{d})
+	fake.captureQueueTimeMutex.Unlock()
+	if fake.CaptureQueueTimeStub != nil {
+		fake.CaptureQueueTimeStub(d)
+	}
+}
+
+func (fake *FakeReporter) CaptureQueueTimeCallCount() int {
+	fake.captureQueueTimeMutex.RLock()
+	defer fake.captureQueueTimeMutex.RUnlock()
+	return len(fake.captureQueueTimeArgsForCall)
+}
+
+func (fake *FakeReporter) CaptureQueueTimeArgsForCall(i int) time.Duration {
+	fake.captureQueueTimeMutex.RLock()
+	defer fake.captureQueueTimeMutex.RUnlock()
+	return fake.captureQueueTimeArgsForCall[i].d
+}
+
+func (fake *FakeReporter) CaptureRouteBytes(uri route.Uri, received, sent int64) {
+	fake.captureRouteBytesMutex.Lock()
+	fake.captureRouteBytesArgsForCall = append(fake.captureRouteBytesArgsForCall, struct {
+		uri      route.Uri
+		received int64
+		sent     int64
+	}{uri, received, sent})
+	fake.captureRouteBytesMutex.Unlock()
+	if fake.CaptureRouteBytesStub != nil {
+		fake.CaptureRouteBytesStub(uri, received, sent)
+	}
+}
+
+func (fake *FakeReporter) CaptureRouteBytesCallCount() int {
+	fake.captureRouteBytesMutex.RLock()
+	defer fake.captureRouteBytesMutex.RUnlock()
+	return len(fake.captureRouteBytesArgsForCall)
+}
+
+func (fake *FakeReporter) CaptureRouteBytesArgsForCall(i int) (route.Uri, int64, int64) {
+	fake.captureRouteBytesMutex.RLock()
+	defer fake.captureRouteBytesMutex.RUnlock()
+	return fake.captureRouteBytesArgsForCall[i].uri, fake.captureRouteBytesArgsForCall[i].received, fake.captureRouteBytesArgsForCall[i].sent
 }
 
 var _ metrics.ProxyReporter = new(FakeReporter)
@@ -37,7 +37,7 @@ func (m *MetricsReporter) CaptureRoutingRequest(b *route.Endpoint, req *http.Req
 	}
 }
 
-func (m *MetricsReporter) CaptureRoutingResponse(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration) {
+func (m *MetricsReporter) CaptureRoutingResponse(b *route.Endpoint, uri route.Uri, res *http.Response, t time.Time, d time.Duration) {
 	dropsondeMetrics.BatchIncrementCounter(getResponseCounterName(res))
 	dropsondeMetrics.BatchIncrementCounter("responses")
 
@@ -51,17 +51,43 @@ func (m *MetricsReporter) CaptureRoutingResponse(b *route.Endpoint, res *http.Re
 	}
 }
 
+func (m *MetricsReporter) CaptureProxyResponse(status int) {
+	dropsondeMetrics.BatchIncrementCounter("proxy_" + getStatusCounterName(status))
+}
+
+func (m *MetricsReporter) CaptureBackendFailure(b *route.Endpoint, err error) {
+	dropsondeMetrics.BatchIncrementCounter("backend_failures")
+}
+
+func (m *MetricsReporter) CaptureSelectionTime(d time.Duration) {
+	dropsondeMetrics.SendValue("backend_selection_time", float64(d)/float64(time.Millisecond), "ms")
+}
+
+func (m *MetricsReporter) CaptureQueueTime(d time.Duration) {
+	dropsondeMetrics.SendValue("backend_queue_time", float64(d)/float64(time.Millisecond), "ms")
+}
+
+func (m *MetricsReporter) CaptureRouteBytes(uri route.Uri, received, sent int64) {
+	dropsondeMetrics.BatchAddCounter("request_bytes", uint64(received))
+	dropsondeMetrics.BatchAddCounter("response_bytes", uint64(sent))
+}
+
 func (c *MetricsReporter) CaptureRouteStats(totalRoutes int, msSinceLastUpdate uint64) {
 	dropsondeMetrics.SendValue("total_routes", float64(totalRoutes), "")
 	dropsondeMetrics.SendValue("ms_since_last_registry_update", float64(msSinceLastUpdate), "ms")
 }
 
 func getResponseCounterName(res *http.Response) string {
-	var statusCode int
+	var status int
 
 	if res != nil {
-		statusCode = res.StatusCode / 100
+		status = res.StatusCode
 	}
+	return getStatusCounterName(status)
+}
+
+func getStatusCounterName(status int) string {
+	statusCode := status / 100
 	if statusCode >= 2 && statusCode <= 5 {
 		return fmt.Sprintf("responses.%dxx", statusCode)
 	}
@@ -1,6 +1,8 @@
 package metrics_test
 
 import (
+	"errors"
+
 	"github.com/cloudfoundry/gorouter/metrics"
 	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
 	dropsondeMetrics "github.com/cloudfoundry/dropsonde/metrics"
@@ -95,16 +97,32 @@ var _ = Describe("MetricsReporter", func() {
 	})
 
 
+	It("increments the backend_failures metric", func() {
+		metricsReporter.CaptureBackendFailure(endpoint, errors.New("connection refused"))
+		Eventually(func() uint64 { return sender.GetCounter("backend_failures") }).Should(BeEquivalentTo(1))
+	})
+
+	It("increments the proxy response metrics by status class", func() {
+		metricsReporter.CaptureProxyResponse(http.StatusOK)
+		metricsReporter.CaptureProxyResponse(http.StatusNotFound)
+		metricsReporter.CaptureProxyResponse(http.StatusNotFound)
+		metricsReporter.CaptureProxyResponse(0)
+
+		Eventually(func() uint64 { return sender.GetCounter("proxy_responses.2xx") }).Should(BeEquivalentTo(1))
+		Eventually(func() uint64 { return sender.GetCounter("proxy_responses.4xx") }).Should(BeEquivalentTo(2))
+		Eventually(func() uint64 { return sender.GetCounter("proxy_responses.xxx") }).Should(BeEquivalentTo(1))
+	})
+
 	Context("increments the response metrics", func() {
 		It("increments the 2XX response metrics", func() {
 			response := http.Response {
 				StatusCode: 200,
 			}
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.2xx")}).Should(BeEquivalentTo(1))
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.2xx")}).Should(BeEquivalentTo(2))
 		})
 
@@ -113,10 +131,10 @@ var _ = Describe("MetricsReporter", func() {
 				StatusCode: 304,
 			}
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.3xx")}).Should(BeEquivalentTo(1))
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.3xx")}).Should(BeEquivalentTo(2))
 		})
 
@@ -125,10 +143,10 @@ var _ = Describe("MetricsReporter", func() {
 				StatusCode: 401,
 			}
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.4xx")}).Should(BeEquivalentTo(1))
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.4xx")}).Should(BeEquivalentTo(2))
 		})
 
@@ -137,10 +155,10 @@ var _ = Describe("MetricsReporter", func() {
 				StatusCode: 504,
 			}
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.5xx")}).Should(BeEquivalentTo(1))
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.5xx")}).Should(BeEquivalentTo(2))
 		})
 
@@ -149,18 +167,18 @@ var _ = Describe("MetricsReporter", func() {
 				StatusCode: 100,
 			}
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.xxx")}).Should(BeEquivalentTo(1))
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.xxx")}).Should(BeEquivalentTo(2))
 		})
 
 		It("increments the XXX response metrics with null response", func() {
-			metricsReporter.CaptureRoutingResponse(endpoint, nil, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", nil, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.xxx")}).Should(BeEquivalentTo(1))
 
-			metricsReporter.CaptureRoutingResponse(endpoint, nil, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", nil, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses.xxx")}).Should(BeEquivalentTo(2))
 		})
 
@@ -172,10 +190,10 @@ var _ = Describe("MetricsReporter", func() {
 				StatusCode: 401,
 			}
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response2xx, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response2xx, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses")}).Should(BeEquivalentTo(1))
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response4xx, time.Now(), time.Millisecond)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response4xx, time.Now(), time.Millisecond)
 			Eventually(func() uint64 { return sender.GetCounter("responses")}).Should(BeEquivalentTo(2))
 
 		})
@@ -185,7 +203,7 @@ var _ = Describe("MetricsReporter", func() {
 				StatusCode: 401,
 			}
 
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), 2 * time.Second)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), 2 * time.Second)
 			Eventually(func() fake.Metric { return sender.GetValue("latency") }).Should(Equal(
 				fake.Metric {
 					Value: 2000,
@@ -199,7 +217,7 @@ var _ = Describe("MetricsReporter", func() {
 			}
 
 			endpoint.Tags["component"] = "CloudController"
-			metricsReporter.CaptureRoutingResponse(endpoint, &response, time.Now(), 2 * time.Second)
+			metricsReporter.CaptureRoutingResponse(endpoint, "example.com", &response, time.Now(), 2 * time.Second)
 			Eventually(func() fake.Metric { return sender.GetValue("latency.CloudController") }).Should(Equal(
 				fake.Metric {
 					Value: 2000,
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var statusClasses = []string{"2xx", "3xx", "4xx", "5xx", "xxx"}
+
+// PrometheusReporter keeps the counters reported to varz and serves them in
+// the Prometheus text exposition format.
+type PrometheusReporter struct {
+	sync.Mutex
+
+	badRequests     uint64
+	badGateways     uint64
+	backendRequests uint64
+	backendFailures uint64
+	responses       map[string]uint64
+	proxyResponses  map[string]uint64
+
+	latencyCounts []uint64
+	latencyCount  uint64
+	latencySum    float64
+
+	selectionCount uint64
+	selectionSum   float64
+	queueCount     uint64
+	queueSum       float64
+
+	requestBytes  uint64
+	responseBytes uint64
+}
+
+func NewPrometheusReporter() *PrometheusReporter {
+	return &PrometheusReporter{
+		responses:      make(map[string]uint64),
+		proxyResponses: make(map[string]uint64),
+		latencyCounts:  make([]uint64, len(latencyBuckets)),
+	}
+}
+
+func (p *PrometheusReporter) CaptureBadRequest(req *http.Request) {
+	p.Lock()
+	p.badRequests++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureBadGateway(req *http.Request) {
+	p.Lock()
+	p.badGateways++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureRoutingRequest(b *route.Endpoint, req *http.Request) {
+	p.Lock()
+	p.backendRequests++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureRoutingResponse(b *route.Endpoint, uri route.Uri, res *http.Response, t time.Time, d time.Duration) {
+	seconds := d.Seconds()
+
+	p.Lock()
+	p.responses[statusClass(res)]++
+
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			p.latencyCounts[i]++
+		}
+	}
+	p.latencyCount++
+	p.latencySum += seconds
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureProxyResponse(status int) {
+	p.Lock()
+	p.proxyResponses[statusCodeClass(status)]++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureBackendFailure(b *route.Endpoint, err error) {
+	p.Lock()
+	p.backendFailures++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureSelectionTime(d time.Duration) {
+	p.Lock()
+	p.selectionCount++
+	p.selectionSum += d.Seconds()
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureQueueTime(d time.Duration) {
+	p.Lock()
+	p.queueCount++
+	p.queueSum += d.Seconds()
+	p.Unlock()
+}
+
+// CaptureRouteBytes adds to the byte totals of all routes; the exposition
+// has no per route series, the number of routes is unbounded.
+func (p *PrometheusReporter) CaptureRouteBytes(uri route.Uri, received, sent int64) {
+	p.Lock()
+	p.requestBytes += uint64(received)
+	p.responseBytes += uint64(sent)
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+
+	p.Lock()
+	writeCounter(&buf, "gorouter_bad_requests_total", "Requests rejected before being routed.", p.badRequests)
+	writeCounter(&buf, "gorouter_bad_gateways_total", "Requests that could not be served by a backend.", p.badGateways)
+	writeCounter(&buf, "gorouter_backend_requests_total", "Requests routed to a backend.", p.backendRequests)
+	writeCounter(&buf, "gorouter_backend_failures_total", "Attempts to reach a backend that failed.", p.backendFailures)
+	writeCounter(&buf, "gorouter_request_bytes_total", "Bytes of routed requests, headers included.", p.requestBytes)
+	writeCounter(&buf, "gorouter_response_bytes_total", "Bytes of the responses to routed requests, headers included.", p.responseBytes)
+
+	fmt.Fprintf(&buf, "# HELP gorouter_backend_responses_total Backend responses by status class.\n")
+	fmt.Fprintf(&buf, "# TYPE gorouter_backend_responses_total counter\n")
+	for _, class := range statusClasses {
+		fmt.Fprintf(&buf, "gorouter_backend_responses_total{status_class=%q} %d\n", class, p.responses[class])
+	}
+
+	fmt.Fprintf(&buf, "# HELP gorouter_proxy_responses_total Responses sent to clients by status class.\n")
+	fmt.Fprintf(&buf, "# TYPE gorouter_proxy_responses_total counter\n")
+	for _, class := range statusClasses {
+		fmt.Fprintf(&buf, "gorouter_proxy_responses_total{status_class=%q} %d\n", class, p.proxyResponses[class])
+	}
+
+	fmt.Fprintf(&buf, "# HELP gorouter_backend_response_latency_seconds Time taken by backends to respond.\n")
+	fmt.Fprintf(&buf, "# TYPE gorouter_backend_response_latency_seconds histogram\n")
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(&buf, "gorouter_backend_response_latency_seconds_bucket{le=%q} %d\n",
+			strconv.FormatFloat(le, 'g', -1, 64), p.latencyCounts[i])
+	}
+	fmt.Fprintf(&buf, "gorouter_backend_response_latency_seconds_bucket{le=\"+Inf\"} %d\n", p.latencyCount)
+	fmt.Fprintf(&buf, "gorouter_backend_response_latency_seconds_sum %s\n", strconv.FormatFloat(p.latencySum, 'g', -1, 64))
+	fmt.Fprintf(&buf, "gorouter_backend_response_latency_seconds_count %d\n", p.latencyCount)
+
+	writeSummary(&buf, "gorouter_backend_selection_seconds", "Time taken to select a backend.", p.selectionCount, p.selectionSum)
+	writeSummary(&buf, "gorouter_backend_queue_seconds", "Time requests waited for a connection slot of a backend.", p.queueCount, p.queueSum)
+	p.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func writeCounter(buf *bytes.Buffer, name, help string, value uint64) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s counter\n", name)
+	fmt.Fprintf(buf, "%s %d\n", name, value)
+}
+
+func writeSummary(buf *bytes.Buffer, name, help string, count uint64, sum float64) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s summary\n", name)
+	fmt.Fprintf(buf, "%s_sum %s\n", name, strconv.FormatFloat(sum, 'g', -1, 64))
+	fmt.Fprintf(buf, "%s_count %d\n", name, count)
+}
+
+func statusClass(res *http.Response) string {
+	var status int
+
+	if res != nil {
+		status = res.StatusCode
+	}
+	return statusCodeClass(status)
+}
+
+func statusCodeClass(status int) string {
+	statusCode := status / 100
+	if statusCode >= 2 && statusCode <= 5 {
+		return fmt.Sprintf("%dxx", statusCode)
+	}
+	return "xxx"
+}
@@ -0,0 +1,131 @@
+package metrics_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/metrics"
+	"github.com/cloudfoundry/gorouter/route"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PrometheusReporter", func() {
+	var reporter *metrics.PrometheusReporter
+	var req *http.Request
+	var endpoint *route.Endpoint
+
+	BeforeEach(func() {
+		reporter = metrics.NewPrometheusReporter()
+		req, _ = http.NewRequest("GET", "https://example.com", nil)
+		endpoint = route.NewEndpoint("someId", "host", 2222, "privateId", map[string]string{}, 30, "")
+	})
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		reporter.ServeHTTP(recorder, req)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+		return recorder.Body.String()
+	}
+
+	It("exports zeroed counters before any request", func() {
+		body := scrape()
+
+		Expect(body).To(ContainSubstring("# TYPE gorouter_bad_requests_total counter\ngorouter_bad_requests_total 0\n"))
+		Expect(body).To(ContainSubstring("gorouter_bad_gateways_total 0\n"))
+		Expect(body).To(ContainSubstring("gorouter_backend_requests_total 0\n"))
+		Expect(body).To(ContainSubstring("gorouter_backend_response_latency_seconds_count 0\n"))
+	})
+
+	It("counts bad requests and bad gateways", func() {
+		reporter.CaptureBadRequest(req)
+		reporter.CaptureBadRequest(req)
+		reporter.CaptureBadGateway(req)
+
+		body := scrape()
+
+		Expect(body).To(ContainSubstring("gorouter_bad_requests_total 2\n"))
+		Expect(body).To(ContainSubstring("gorouter_bad_gateways_total 1\n"))
+	})
+
+	It("counts backend requests and responses by status class", func() {
+		reporter.CaptureRoutingRequest(endpoint, req)
+		reporter.CaptureRoutingResponse(endpoint, "example.com", &http.Response{StatusCode: 200}, time.Now(), time.Millisecond)
+		reporter.CaptureRoutingResponse(endpoint, "example.com", &http.Response{StatusCode: 503}, time.Now(), time.Millisecond)
+		reporter.CaptureRoutingResponse(endpoint, "example.com", nil, time.Now(), time.Millisecond)
+
+		body := scrape()
+
+		Expect(body).To(ContainSubstring("gorouter_backend_requests_total 1\n"))
+		Expect(body).To(ContainSubstring(`gorouter_backend_responses_total{status_class="2xx"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_backend_responses_total{status_class="4xx"} 0` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_backend_responses_total{status_class="5xx"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_backend_responses_total{status_class="xxx"} 1` + "\n"))
+	})
+
+	It("counts failed attempts to reach a backend", func() {
+		reporter.CaptureBackendFailure(endpoint, errors.New("connection refused"))
+		reporter.CaptureBackendFailure(endpoint, errors.New("connection refused"))
+
+		Expect(scrape()).To(ContainSubstring("# TYPE gorouter_backend_failures_total counter\ngorouter_backend_failures_total 2\n"))
+	})
+
+	It("counts responses sent to clients by status class", func() {
+		reporter.CaptureProxyResponse(http.StatusOK)
+		reporter.CaptureProxyResponse(http.StatusNotFound)
+		reporter.CaptureProxyResponse(http.StatusBadGateway)
+
+		body := scrape()
+
+		Expect(body).To(ContainSubstring("# TYPE gorouter_proxy_responses_total counter\n"))
+		Expect(body).To(ContainSubstring(`gorouter_proxy_responses_total{status_class="2xx"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_proxy_responses_total{status_class="3xx"} 0` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_proxy_responses_total{status_class="4xx"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_proxy_responses_total{status_class="5xx"} 1` + "\n"))
+	})
+
+	It("exports latency as a cumulative histogram", func() {
+		reporter.CaptureRoutingResponse(endpoint, "example.com", nil, time.Now(), 20*time.Millisecond)
+		reporter.CaptureRoutingResponse(endpoint, "example.com", nil, time.Now(), 300*time.Millisecond)
+		reporter.CaptureRoutingResponse(endpoint, "example.com", nil, time.Now(), 20*time.Second)
+
+		body := scrape()
+
+		Expect(body).To(ContainSubstring("# TYPE gorouter_backend_response_latency_seconds histogram\n"))
+		Expect(body).To(ContainSubstring(`gorouter_backend_response_latency_seconds_bucket{le="0.01"} 0` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_backend_response_latency_seconds_bucket{le="0.025"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_backend_response_latency_seconds_bucket{le="0.5"} 2` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_backend_response_latency_seconds_bucket{le="10"} 2` + "\n"))
+		Expect(body).To(ContainSubstring(`gorouter_backend_response_latency_seconds_bucket{le="+Inf"} 3` + "\n"))
+		Expect(body).To(ContainSubstring("gorouter_backend_response_latency_seconds_sum 20.32\n"))
+		Expect(body).To(ContainSubstring("gorouter_backend_response_latency_seconds_count 3\n"))
+	})
+
+	It("exports selection and queue times as summaries", func() {
+		reporter.CaptureSelectionTime(time.Millisecond)
+		reporter.CaptureQueueTime(250 * time.Millisecond)
+		reporter.CaptureQueueTime(500 * time.Millisecond)
+
+		body := scrape()
+
+		Expect(body).To(ContainSubstring("# TYPE gorouter_backend_selection_seconds summary\n"))
+		Expect(body).To(ContainSubstring("gorouter_backend_selection_seconds_sum 0.001\n"))
+		Expect(body).To(ContainSubstring("gorouter_backend_selection_seconds_count 1\n"))
+		Expect(body).To(ContainSubstring("gorouter_backend_queue_seconds_sum 0.75\n"))
+		Expect(body).To(ContainSubstring("gorouter_backend_queue_seconds_count 2\n"))
+	})
+
+	It("counts the bytes of all routes together", func() {
+		reporter.CaptureRouteBytes("foo.vcap.me", 100, 2000)
+		reporter.CaptureRouteBytes("bar.vcap.me", 50, 1000)
+
+		body := scrape()
+
+		Expect(body).To(ContainSubstring("gorouter_request_bytes_total 150\n"))
+		Expect(body).To(ContainSubstring("gorouter_response_bytes_total 3000\n"))
+	})
+})
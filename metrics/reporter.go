@@ -9,7 +9,12 @@ type ProxyReporter interface {
 	CaptureBadRequest(req *http.Request)
 	CaptureBadGateway(req *http.Request)
 	CaptureRoutingRequest(b *route.Endpoint, req *http.Request)
-	CaptureRoutingResponse(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration)
+	CaptureRoutingResponse(b *route.Endpoint, uri route.Uri, res *http.Response, t time.Time, d time.Duration)
+	CaptureProxyResponse(status int)
+	CaptureBackendFailure(b *route.Endpoint, err error)
+	CaptureSelectionTime(d time.Duration)
+	CaptureQueueTime(d time.Duration)
+	CaptureRouteBytes(uri route.Uri, received, sent int64)
 }
 
 type RouteReporter interface {
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"net"
+	"time"
+)
+
+// defaultDialTimeout bounds connecting to a backend when no timeout is set,
+// as dial_timeout does by default.
+const defaultDialTimeout = 5 * time.Second
+
+// NewBackendDialer returns the dialer connections to backends, and to the
+// backends of WebSocket and TCP upgrades, are opened with. A timeout of zero
+// is taken to be 5 seconds, so that an unreachable backend cannot hold up a
+// request for as long as the operating system tries to connect. Their TCP
+// keepalive probes are sent every keepAlive, so that connections that died
+// silently while idle are detected; zero disables the probes, rather than
+// have net fall back to its own period.
+func NewBackendDialer(timeout time.Duration, keepAlive time.Duration) *net.Dialer {
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	if keepAlive <= 0 {
+		keepAlive = -1
+	}
+
+	return &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: keepAlive,
+	}
+}
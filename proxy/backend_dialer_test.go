@@ -0,0 +1,27 @@
+package proxy_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/gorouter/proxy"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BackendDialer", func() {
+	It("connects within the timeout", func() {
+		dialer := proxy.NewBackendDialer(2*time.Second, time.Minute)
+		Expect(dialer.Timeout).To(Equal(2 * time.Second))
+		Expect(dialer.KeepAlive).To(Equal(time.Minute))
+	})
+
+	It("connects within 5 seconds without a timeout", func() {
+		Expect(proxy.NewBackendDialer(0, time.Minute).Timeout).To(Equal(5 * time.Second))
+		Expect(proxy.NewBackendDialer(-time.Second, time.Minute).Timeout).To(Equal(5 * time.Second))
+	})
+
+	It("sends no keepalive probes without a period", func() {
+		Expect(proxy.NewBackendDialer(time.Second, 0).KeepAlive).To(BeNumerically("<", 0))
+	})
+})
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+var backendAtCapacity = errors.New("Backend connection limit reached")
+
+// backendLimiter caps the number of requests proxied to a single backend at
+// the same time. Backends are identified by address, so the limit holds
+// across all the routes a backend is registered under. A nil limiter
+// imposes no limit.
+type backendLimiter struct {
+	lock         sync.Mutex
+	max          int
+	queueTimeout time.Duration
+	conns        map[string]int
+
+	// waiters are the queued requests, oldest first
+	waiters []*waiter
+
+	// fairQueue lets a queued request take the slot of whichever backend
+	// releases one first, rather than wait for the backend it was sent to
+	fairQueue bool
+}
+
+// waiter is a request queued for a slot of the backend at addr.
+type waiter struct {
+	addr   string
+	queued bool
+
+	// wake is sent to once the waiter is taken off the queue for a release
+	wake chan struct{}
+}
+
+func newBackendLimiter(max int, queueTimeout time.Duration, fairQueue bool) *backendLimiter {
+	if max <= 0 {
+		return nil
+	}
+
+	return &backendLimiter{
+		max:          max,
+		queueTimeout: queueTimeout,
+		conns:        make(map[string]int),
+		fairQueue:    fairQueue,
+	}
+}
+
+// acquire takes a slot for the endpoint, waiting up to the queue timeout for
+// one to be released when the backend is at capacity. With fair queueing
+// the request is instead dispatched to the endpoint next returns once any
+// slot is released, if that one has a slot free. It returns the endpoint the
+// slot was taken for and how long it waited.
+func (l *backendLimiter) acquire(endpoint *route.Endpoint, next func() *route.Endpoint) (*route.Endpoint, time.Duration, bool) {
+	if l == nil {
+		return endpoint, 0, true
+	}
+
+	w, ok := l.tryAcquire(endpoint, nil)
+	if ok {
+		return endpoint, 0, true
+	}
+
+	if l.queueTimeout <= 0 {
+		l.leave(w)
+		return endpoint, 0, false
+	}
+
+	queuedAt := time.Now()
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.wake:
+		case <-timer.C:
+			l.leave(w)
+			return endpoint, time.Since(queuedAt), false
+		}
+
+		if _, ok = l.tryAcquire(endpoint, w); ok {
+			return endpoint, time.Since(queuedAt), true
+		}
+
+		// the slot released may be another backend's, which the request
+		// is then sent to instead
+		if l.fairQueue {
+			if e := next(); e != nil && e != endpoint {
+				endpoint = e
+				if _, ok = l.tryAcquire(endpoint, w); ok {
+					return endpoint, time.Since(queuedAt), true
+				}
+			}
+		}
+	}
+}
+
+// tryAcquire takes a slot for the endpoint if it has one free. Otherwise it
+// queues the waiter for the endpoint, a new one if w is nil, ahead of the
+// others if it was woken before, and returns it.
+func (l *backendLimiter) tryAcquire(endpoint *route.Endpoint, w *waiter) (*waiter, bool) {
+	addr := endpoint.CanonicalAddr()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.conns[addr] < l.max {
+		if w != nil {
+			l.leaveLocked(w)
+		}
+		l.conns[addr]++
+		return nil, true
+	}
+
+	if w == nil {
+		w = &waiter{wake: make(chan struct{}, 1)}
+		l.waiters = append(l.waiters, w)
+		w.queued = true
+	} else if !w.queued && len(w.wake) == 0 {
+		// a waiter woken again in the meantime retries right away
+		l.waiters = append([]*waiter{w}, l.waiters...)
+		w.queued = true
+	}
+	w.addr = addr
+
+	return w, false
+}
+
+// leave takes a waiter that gave up off the queue. A release that woke it
+// in the meantime wakes the next waiter instead.
+func (l *backendLimiter) leave(w *waiter) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.leaveLocked(w)
+}
+
+func (l *backendLimiter) leaveLocked(w *waiter) {
+	if w.queued {
+		l.dequeue(w)
+		return
+	}
+
+	select {
+	case <-w.wake:
+		l.wake(w.addr)
+	default:
+	}
+}
+
+func (l *backendLimiter) release(endpoint *route.Endpoint) {
+	if l == nil {
+		return
+	}
+
+	addr := endpoint.CanonicalAddr()
+
+	l.lock.Lock()
+	if l.conns[addr] > 1 {
+		l.conns[addr]--
+	} else {
+		delete(l.conns, addr)
+	}
+	l.wake(addr)
+	l.lock.Unlock()
+}
+
+// wake wakes the request queued longest for a slot of the backend at addr,
+// or with fair queueing for any backend if none is. The lock must be held.
+func (l *backendLimiter) wake(addr string) {
+	for _, w := range l.waiters {
+		if w.addr == addr {
+			l.dequeue(w)
+			w.wake <- struct{}{}
+			return
+		}
+	}
+
+	if l.fairQueue && len(l.waiters) > 0 {
+		w := l.waiters[0]
+		l.dequeue(w)
+		w.wake <- struct{}{}
+	}
+}
+
+// dequeue takes the waiter off the queue. The lock must be held.
+func (l *backendLimiter) dequeue(w *waiter) {
+	for i, queued := range l.waiters {
+		if queued == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			break
+		}
+	}
+	w.queued = false
+}
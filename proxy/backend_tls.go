@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+type serverNameKey struct{}
+
+// withServerName records the name a TLS backend's certificate is verified
+// against for the connection the request is sent on.
+func withServerName(request *http.Request, serverName string) *http.Request {
+	if serverName == "" {
+		return request
+	}
+	return request.WithContext(context.WithValue(request.Context(), serverNameKey{}, serverName))
+}
+
+func clientHandshake(ctx context.Context, conn net.Conn, addr string, config *tls.Config) (net.Conn, error) {
+	var tlsConfig *tls.Config
+	if config != nil {
+		tlsConfig = config.Clone()
+	} else {
+		tlsConfig = &tls.Config{}
+	}
+
+	if serverName, ok := ctx.Value(serverNameKey{}).(string); ok {
+		tlsConfig.ServerName = serverName
+	} else if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		tlsConfig.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
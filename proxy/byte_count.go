@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// requestHeaderSize is the size of the request line and headers of the
+// request as received. net/http does not keep the raw header, so the size is
+// rebuilt from the parsed one, which can differ from the wire by a few bytes
+// of whitespace.
+func requestHeaderSize(request *http.Request) int64 {
+	// "GET /path HTTP/1.1\r\n"
+	size := len(request.Method) + 1 + len(request.RequestURI) + 1 + len(request.Proto) + 2
+	if request.Host != "" {
+		size += len("Host: ") + len(request.Host) + 2
+	}
+
+	return int64(size + headerSize(request.Header) + 2)
+}
+
+// responseHeaderSize is the size of the status line and headers of the
+// response sent to the client. Headers net/http adds on its own, such as
+// Date, are not included.
+func responseHeaderSize(status int, header http.Header) int64 {
+	// "HTTP/1.1 200 OK\r\n"
+	size := len("HTTP/1.1 200 ") + len(http.StatusText(status)) + 2
+
+	return int64(size + headerSize(header) + 2)
+}
+
+func headerSize(header http.Header) int {
+	size := 0
+	for name, values := range header {
+		for _, value := range values {
+			size += len(name) + len(": ") + len(value) + 2
+		}
+	}
+	return size
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// setRequestForwardedClientCert replaces the header with the certificate the
+// client presented over TLS. A value sent by the client is always removed,
+// so that a backend can trust the header to come from the router.
+func setRequestForwardedClientCert(request *http.Request, header string, format string) {
+	request.Header.Del(header)
+
+	if request.TLS == nil || len(request.TLS.PeerCertificates) == 0 {
+		return
+	}
+
+	cert := request.TLS.PeerCertificates[0]
+	switch format {
+	case config.ClientCertFormatFingerprint:
+		sum := sha256.Sum256(cert.Raw)
+		request.Header.Set(header, hex.EncodeToString(sum[:]))
+	default:
+		// the PEM block without its BEGIN and END lines and line breaks,
+		// which header values cannot hold
+		request.Header.Set(header, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func shouldCompress(request *http.Request, response *http.Response, minSize int64) bool {
+	if request.Method == "HEAD" || !acceptsGzip(request) {
+		return false
+	}
+
+	switch response.StatusCode {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+
+	encoding := response.Header.Get("Content-Encoding")
+	if encoding != "" && encoding != "identity" {
+		return false
+	}
+
+	if response.Body == nil || response.ContentLength == 0 {
+		return false
+	}
+
+	// a body of unknown length is streamed, waiting for enough of it to
+	// reach the threshold would hold the response back
+	return response.ContentLength < 0 || response.ContentLength >= minSize
+}
+
+func acceptsGzip(request *http.Request) bool {
+	for _, value := range request.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(value, ",") {
+			parts := strings.Split(coding, ";")
+			if strings.TrimSpace(parts[0]) != "gzip" {
+				continue
+			}
+
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					return err == nil && q > 0
+				}
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func compressResponse(response *http.Response) {
+	response.Header.Set("Content-Encoding", "gzip")
+	response.Header.Del("Content-Length")
+	response.Header.Add("Vary", "Accept-Encoding")
+	response.ContentLength = -1
+	response.Body = newGzipBody(response.Body)
+}
+
+// gzipBody compresses the wrapped body as it is read. The compressed data is
+// flushed after every read from the backend so that streamed responses are
+// not held back.
+type gzipBody struct {
+	*io.PipeReader
+	source io.ReadCloser
+}
+
+func newGzipBody(source io.ReadCloser) io.ReadCloser {
+	reader, writer := io.Pipe()
+
+	go func() {
+		gz := gzip.NewWriter(writer)
+		buf := make([]byte, 32*1024)
+
+		var err error
+		for err == nil {
+			var n int
+			n, err = source.Read(buf)
+			if n > 0 {
+				if _, werr := gz.Write(buf[:n]); werr != nil {
+					err = werr
+				} else if ferr := gz.Flush(); ferr != nil {
+					err = ferr
+				}
+			}
+		}
+
+		if err == io.EOF {
+			err = gz.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return &gzipBody{PipeReader: reader, source: source}
+}
+
+func (b *gzipBody) Close() error {
+	b.PipeReader.Close()
+	return b.source.Close()
+}
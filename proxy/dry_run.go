@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// RoutingDecision tells where the proxy would send a request.
+type RoutingDecision struct {
+	Uri             route.Uri         `json:"uri"`
+	RouteServiceUrl string            `json:"route_service_url,omitempty"`
+	Candidates      []*route.Endpoint `json:"candidates"`
+	Selected        *route.Endpoint   `json:"selected"`
+}
+
+// DryRun looks the request up the way ServeHTTP does, without sending it
+// anywhere. It returns the registered URI matching the request, the
+// endpoints of the route, or of its route group, that the request may be
+// sent to, and the endpoint a request arriving now would be sent to first,
+// if any can take requests. The turn of that endpoint is not taken. It
+// returns nil when no route matches.
+func (p *proxy) DryRun(request *http.Request) *RoutingDecision {
+	routePool := p.lookup(request)
+	if routePool == nil {
+		return nil
+	}
+
+	routePool = routePool.RouteGroup(request, p.resolveClientAddr(request))
+
+	decision := &RoutingDecision{
+		Uri:             routePool.Uri(),
+		RouteServiceUrl: routePool.RouteServiceUrl(),
+		Candidates:      []*route.Endpoint{},
+	}
+	routePool.Each(func(e *route.Endpoint) {
+		decision.Candidates = append(decision.Candidates, e)
+	})
+
+	leastConnection := p.loadBalancing == config.LoadBalancingLeastConnections
+	decision.Selected = routePool.Preview(p.getStickySession(request), leastConnection, p.backendSelector, request)
+
+	return decision
+}
@@ -45,6 +45,9 @@ type FakeProxyResponseWriter struct {
 	FlushStub        func()
 	flushMutex       sync.RWMutex
 	flushArgsForCall []struct{}
+	FlushOnWriteStub        func()
+	flushOnWriteMutex       sync.RWMutex
+	flushOnWriteArgsForCall []struct{}
 	StatusStub        func() int
 	statusMutex       sync.RWMutex
 	statusArgsForCall []struct{}
@@ -195,6 +198,21 @@ func (fake *FakeProxyResponseWriter) FlushCallCount() int {
 	return len(fake.flushArgsForCall)
 }
 
+func (fake *FakeProxyResponseWriter) FlushOnWrite() {
+	fake.flushOnWriteMutex.Lock()
+	fake.flushOnWriteArgsForCall = append(fake.flushOnWriteArgsForCall, struct{}{})
+	fake.flushOnWriteMutex.Unlock()
+	if fake.FlushOnWriteStub != nil {
+		fake.FlushOnWriteStub()
+	}
+}
+
+func (fake *FakeProxyResponseWriter) FlushOnWriteCallCount() int {
+	fake.flushOnWriteMutex.RLock()
+	defer fake.flushOnWriteMutex.RUnlock()
+	return len(fake.flushOnWriteArgsForCall)
+}
+
 func (fake *FakeProxyResponseWriter) Status() int {
 	fake.statusMutex.Lock()
 	fake.statusArgsForCall = append(fake.statusArgsForCall, struct{}{})
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// resolveClientAddr returns the address of the client a request originates
+// from. X-Forwarded-For is only honored on requests received from a trusted
+// proxy, and is cut down to the entries added by trusted proxies after the
+// rightmost untrusted one, which is taken as the client. Without trusted
+// proxies configured every X-Forwarded-For is passed on as is.
+func (p *proxy) resolveClientAddr(request *http.Request) string {
+	if len(p.trustedProxies) == 0 {
+		return request.RemoteAddr
+	}
+
+	peer, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil || !p.isTrustedProxy(peer) {
+		request.Header.Del("X-Forwarded-For")
+		return request.RemoteAddr
+	}
+
+	var hops []string
+	for _, value := range request.Header["X-Forwarded-For"] {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	if len(hops) == 0 {
+		return request.RemoteAddr
+	}
+
+	client := len(hops) - 1
+	for client > 0 && p.isTrustedProxy(hops[client]) {
+		client--
+	}
+
+	request.Header.Set("X-Forwarded-For", strings.Join(hops[client:], ", "))
+	return hops[client]
+}
+
+func (p *proxy) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders only concern the connection they arrive on and are never
+// forwarded, see RFC 7230 section 6.1.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders strips the hop-by-hop headers, those the Connection
+// header names and the extra ones from the header. An upgrade keeps its
+// Connection and Upgrade headers, without which the other side would not
+// switch protocols.
+func removeHopByHopHeaders(header http.Header, extra []string, upgrade bool) {
+	for _, value := range header["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !(upgrade && strings.EqualFold(name, "Upgrade")) {
+				header.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		if upgrade && (name == "Connection" || name == "Upgrade") {
+			continue
+		}
+		header.Del(name)
+	}
+
+	for _, name := range extra {
+		header.Del(name)
+	}
+}
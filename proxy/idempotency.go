@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+var idempotencyKeyReused = errors.New("Idempotency-Key reused for another request")
+
+// idempotencyCache keeps the responses to unsafe requests carrying an
+// Idempotency-Key for ttl, so that a request repeated with the same key, by
+// the same caller for the same route, is answered with the response to the
+// first one instead of reaching a backend again. A repeat arriving while the
+// first request is in flight waits for it. A nil cache stores nothing.
+type idempotencyCache struct {
+	responses *responseCache
+	ttl       time.Duration
+
+	lock     sync.Mutex
+	inFlight map[string]*pendingRequest
+}
+
+// pendingRequest is the request in flight with a key, done is closed once
+// it has a response or failed to get one.
+type pendingRequest struct {
+	request string
+	done    chan struct{}
+}
+
+func newIdempotencyCache(maxSize int64, ttl time.Duration) *idempotencyCache {
+	if maxSize <= 0 || ttl <= 0 {
+		return nil
+	}
+
+	return &idempotencyCache{
+		responses: newResponseCache(maxSize),
+		ttl:       ttl,
+		inFlight:  make(map[string]*pendingRequest),
+	}
+}
+
+// idempotencyKey returns the key the response to the request is kept under,
+// or an empty one when the request is safe or carries no Idempotency-Key.
+// Keys are those of a caller, told apart by its credentials, so that one
+// caller cannot be answered with the response to another.
+func idempotencyKey(request *http.Request, uri route.Uri) string {
+	key := request.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return ""
+	}
+
+	switch request.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return ""
+	}
+
+	caller := sha256.New()
+	caller.Write([]byte(request.Header.Get("Authorization")))
+	caller.Write([]byte{0})
+	caller.Write([]byte(request.Header.Get("Cookie")))
+
+	return uri.String() + " " + hex.EncodeToString(caller.Sum(nil)) + " " + key
+}
+
+// idempotentRequest identifies the request a key was first sent with, which
+// its repeats must match.
+func idempotentRequest(request *http.Request) string {
+	return request.Method + " " + request.RequestURI
+}
+
+// claim returns the response kept for the key, waiting for the request with
+// the same key in flight, if any. Without a response the caller handles the
+// request itself and must call release once its response has been stored,
+// or has failed to be, letting the next request with the key go ahead. A
+// key first sent with another method or path is reported as reused.
+func (c *idempotencyCache) claim(request *http.Request, key string) (cached *cachedResponse, release func(), err error) {
+	first := idempotentRequest(request)
+
+	for {
+		c.lock.Lock()
+		if cached := c.responses.get(key); cached != nil {
+			c.lock.Unlock()
+			if cached.request != first {
+				return nil, nil, idempotencyKeyReused
+			}
+			return cached, nil, nil
+		}
+
+		pending, ok := c.inFlight[key]
+		if !ok {
+			pending = &pendingRequest{request: first, done: make(chan struct{})}
+			c.inFlight[key] = pending
+			c.lock.Unlock()
+
+			return nil, func() {
+				c.lock.Lock()
+				delete(c.inFlight, key)
+				c.lock.Unlock()
+				close(pending.done)
+			}, nil
+		}
+		c.lock.Unlock()
+
+		if pending.request != first {
+			return nil, nil, idempotencyKeyReused
+		}
+
+		select {
+		case <-pending.done:
+		case <-request.Context().Done():
+			return nil, func() {}, nil
+		}
+	}
+}
+
+// store keeps the response of the backend to the request under the key,
+// once its body has been read to the end.
+func (c *idempotencyCache) store(key string, request *http.Request, response *http.Response) {
+	if cached := c.responses.store(key, response, c.ttl); cached != nil {
+		cached.request = idempotentRequest(request)
+	}
+}
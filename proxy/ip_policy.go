@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net"
+)
+
+// ipPolicy decides which clients may use the router. A client in a denied
+// network is always blocked. When allowed networks are given, a client has
+// to be in one of them, otherwise every client that is not denied passes.
+type ipPolicy struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func newIPPolicy(allow, deny []*net.IPNet) *ipPolicy {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+
+	return &ipPolicy{
+		allow: allow,
+		deny:  deny,
+	}
+}
+
+// allows reports whether the client may be served. A nil policy allows every
+// client.
+func (p *ipPolicy) allows(clientAddr string) bool {
+	if p == nil {
+		return true
+	}
+
+	client, _, err := net.SplitHostPort(clientAddr)
+	if err != nil {
+		client = clientAddr
+	}
+
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return len(p.allow) == 0
+	}
+
+	if containsIP(p.deny, ip) {
+		return false
+	}
+	return len(p.allow) == 0 || containsIP(p.allow, ip)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetIPPolicy replaces the networks clients are allowed and denied from.
+// Requests already being served are not affected.
+func (p *proxy) SetIPPolicy(allow, deny []*net.IPNet) {
+	p.ipPolicy.Store(newIPPolicy(allow, deny))
+}
+
+func (p *proxy) clientAllowed(clientAddr string) bool {
+	policy, _ := p.ipPolicy.Load().(*ipPolicy)
+	return policy.allows(clientAddr)
+}
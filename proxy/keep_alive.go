@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+type connRequestsKey struct{}
+
+// ConnContext is meant for the ConnContext of the http.Server serving the
+// proxy. It gives every client connection the count of its requests that
+// max_requests_per_conn is enforced with; without it connections are never
+// closed for the number of their requests.
+func (p *proxy) ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connRequestsKey{}, new(int64))
+}
+
+// countConnRequest answers with Connection: close, which has the server
+// close the connection after the response, once the connection the request
+// arrived on served max requests.
+func countConnRequest(responseWriter http.ResponseWriter, request *http.Request, max int) {
+	requests, ok := request.Context().Value(connRequestsKey{}).(*int64)
+	if !ok {
+		return
+	}
+
+	if atomic.AddInt64(requests, 1) >= int64(max) {
+		responseWriter.Header().Set("Connection", "close")
+	}
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// rewriteLocation points the Location and Content-Location headers of the
+// response that name the endpoint, by its address or its host header, at the
+// host the client sent the request to, with the scheme the proxy told the
+// backend in X-Forwarded-Proto. Relative URLs and URLs of other hosts are
+// left as they are.
+func rewriteLocation(rsp *http.Response, request *http.Request, endpoint *route.Endpoint) {
+	scheme := "http"
+	if rsp.Request != nil {
+		if proto := rsp.Request.Header.Get("X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		}
+	}
+
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := rsp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+
+		location, err := url.Parse(value)
+		if err != nil || location.Host == "" || !isEndpointHost(location.Host, endpoint) {
+			continue
+		}
+
+		location.Scheme = scheme
+		location.Host = request.Host
+		rsp.Header.Set(name, location.String())
+	}
+}
+
+// isEndpointHost tells whether the host, with or without a port, is the one
+// the endpoint is reached at.
+func isEndpointHost(host string, endpoint *route.Endpoint) bool {
+	addr := endpoint.CanonicalAddr()
+	if strings.EqualFold(host, addr) {
+		return true
+	}
+
+	if endpoint.HostHeader != "" && strings.EqualFold(host, endpoint.HostHeader) {
+		return true
+	}
+
+	// a URL on the default port leaves the port out
+	addrHost, _, err := net.SplitHostPort(addr)
+	return err == nil && strings.EqualFold(host, addrHost)
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// mirrorBodyLimit is the largest request body copied to a mirror. Requests
+// with a larger or unknown body are not mirrored, as the copy of their body
+// is held in memory until the mirror has read it.
+const mirrorBodyLimit = 64 * 1024
+
+// mirrorsInFlightLimit is the number of mirrored requests in flight at the
+// same time. Requests are not mirrored while it is reached, so that a slow
+// shadow backend cannot pile up requests in the router.
+const mirrorsInFlightLimit = 100
+
+// defaultMirrorTimeout bounds a mirrored request when the proxy has no
+// endpoint timeout.
+const defaultMirrorTimeout = 60 * time.Second
+
+// mirror sends a copy of the request to an endpoint of the group in the
+// background, for the given percentage of requests. The response of the
+// mirror is discarded and its failures are only logged, the client sees the
+// response of the route's own endpoints alone. The body of the copy is the
+// one of the request, as it is read to be sent to the route's endpoints.
+func (p *proxy) mirror(group *route.Pool, percent int, request *http.Request, timeout time.Duration) {
+	if percent < 100 && rand.Intn(100) >= percent {
+		return
+	}
+
+	if request.ContentLength < 0 || request.ContentLength > mirrorBodyLimit {
+		return
+	}
+
+	endpoint := group.Endpoints("").Next()
+	if endpoint == nil {
+		return
+	}
+
+	select {
+	case p.mirrors <- struct{}{}:
+	default:
+		return
+	}
+
+	shadow := new(http.Request)
+	*shadow = *request
+	shadow.RequestURI = ""
+	shadow.Header = request.Header.Clone()
+	shadow.Body = http.NoBody
+	if request.ContentLength > 0 {
+		body := newMirrorBody()
+		request.Body = &teeBody{ReadCloser: request.Body, mirror: body}
+		shadow.Body = body
+	}
+
+	shadowURL := *request.URL
+	shadow.URL = &shadowURL
+	shadow.URL.Host = endpoint.CanonicalAddr()
+	if endpoint.HostHeader != "" {
+		shadow.Host = endpoint.HostHeader
+	}
+	if endpoint.PathRewrite != nil {
+		endpoint.PathRewrite.Rewrite(shadow.URL)
+	}
+
+	if timeout <= 0 {
+		timeout = defaultMirrorTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	shadow = shadow.WithContext(ctx)
+
+	shadow.URL.Scheme = "http"
+	if endpoint.TLS {
+		shadow.URL.Scheme = "https"
+		shadow = withServerName(shadow, endpoint.ServerName)
+	}
+
+	go func() {
+		defer func() { <-p.mirrors }()
+		defer cancel()
+
+		rsp, err := p.transport.RoundTrip(shadow)
+		if err != nil {
+			p.logger.Warnd(map[string]interface{}{
+				"uri":     group.Uri(),
+				"address": endpoint.CanonicalAddr(),
+				"error":   err.Error(),
+			}, "proxy.mirror.failed")
+			return
+		}
+
+		io.Copy(ioutil.Discard, rsp.Body)
+		rsp.Body.Close()
+	}()
+}
+
+// teeBody copies the body of a request to its mirror as it is read.
+type teeBody struct {
+	io.ReadCloser
+	mirror *mirrorBody
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mirror.write(p[:n], err)
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	// a body closed before it was read through is cut short for the mirror
+	b.mirror.write(nil, io.ErrUnexpectedEOF)
+	return b.ReadCloser.Close()
+}
+
+// mirrorBody is the body of a mirrored request. It holds what was read of
+// the request's body until the mirror reads it, and makes the mirror wait
+// for the rest. It never makes the request wait for the mirror.
+type mirrorBody struct {
+	lock  sync.Mutex
+	ready *sync.Cond
+	buf   bytes.Buffer
+
+	// err is returned once buf is read, io.EOF when the request's body was
+	// read through
+	err error
+}
+
+func newMirrorBody() *mirrorBody {
+	b := &mirrorBody{}
+	b.ready = sync.NewCond(&b.lock)
+	return b
+}
+
+func (b *mirrorBody) write(p []byte, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.err != nil {
+		return
+	}
+
+	b.buf.Write(p)
+	b.err = err
+	b.ready.Broadcast()
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for b.buf.Len() == 0 && b.err == nil {
+		b.ready.Wait()
+	}
+
+	if b.buf.Len() > 0 {
+		return b.buf.Read(p)
+	}
+	return 0, b.err
+}
+
+// Close gives up on the rest of the body, which is dropped as it is read.
+func (b *mirrorBody) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.err == nil {
+		b.err = io.ErrClosedPipe
+	}
+	b.buf.Reset()
+	b.ready.Broadcast()
+	return nil
+}
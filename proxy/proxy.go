@@ -1,20 +1,28 @@
 package proxy
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/cloudfoundry/gorouter/access_log"
 	router_http "github.com/cloudfoundry/gorouter/common/http"
 	"github.com/cloudfoundry/gorouter/common/secure"
+	"github.com/cloudfoundry/gorouter/config"
 	"github.com/cloudfoundry/gorouter/route"
 	"github.com/cloudfoundry/gorouter/route_service"
 	steno "github.com/cloudfoundry/gosteno"
@@ -24,11 +32,26 @@ import (
 const (
 	VcapCookieId    = "__VCAP_ID__"
 	StickyCookieKey = "JSESSIONID"
-	maxRetries      = 3
+
+	// the further endpoints a request is tried against when ProxyArgs
+	// leave MaxRetries unset, as with the default configuration
+	defaultMaxRetries = 2
+
+	// seconds a client is asked to wait when a route has no available endpoints
+	retryAfterNoEndpoints = 5
+
+	// how long a request expecting 100 Continue waits for the backend to ask
+	// for the body, backends that never do get it sent after that
+	expectContinueTimeout = 1 * time.Second
 )
 
 var noEndpointsAvailable = errors.New("No endpoints available")
 
+var DrainTimeout = errors.New("proxy: Drain timeout")
+
+var routerDraining = errors.New("Router is draining")
+var routerAtCapacity = errors.New("Router concurrent request limit reached")
+
 type LookupRegistry interface {
 	Lookup(uri route.Uri) *route.Pool
 }
@@ -37,22 +60,107 @@ type AfterRoundTrip func(rsp *http.Response, endpoint *route.Endpoint, err error
 
 type Proxy interface {
 	ServeHTTP(responseWriter http.ResponseWriter, request *http.Request)
+	Drain(timeout time.Duration) error
+	SetIPPolicy(allow, deny []*net.IPNet)
+	Reload(c *config.Config)
+	DryRun(request *http.Request) *RoutingDecision
+	ConnContext(ctx context.Context, conn net.Conn) context.Context
 }
 
 type ProxyArgs struct {
-	EndpointTimeout     time.Duration
-	Ip                  string
-	TraceKey            string
-	Registry            LookupRegistry
-	Reporter            metrics.ProxyReporter
-	AccessLogger        access_log.AccessLogger
-	SecureCookies       bool
-	TLSConfig           *tls.Config
-	RouteServiceEnabled bool
-	RouteServiceTimeout time.Duration
-	Crypto              secure.Crypto
-	CryptoPrev          secure.Crypto
-	ExtraHeadersToLog   []string
+	EndpointTimeout        time.Duration
+	DialTimeout            time.Duration
+	Ip                     string
+	TraceKey               string
+	Registry               LookupRegistry
+	Reporter               metrics.ProxyReporter
+	AccessLogger           access_log.AccessLogger
+	SecureCookies          bool
+	TLSConfig              *tls.Config
+	RouteServiceEnabled    bool
+	RouteServiceTimeout    time.Duration
+	Crypto                 secure.Crypto
+	CryptoPrev             secure.Crypto
+	ExtraHeadersToLog      []string
+	LoadBalancing          string
+	HashHeader             string
+	BackendSelector        route.BackendSelector
+	StickyCookieName       string
+	MaxRetries             int
+	RetryBackoff           RetryBackoff
+	RetryBodyBufferSize    int64
+	MaxURILength           int
+	MaxRequestBodySize     int64
+	MaxResponseHeaderBytes int64
+	CompressResponses      bool
+	CompressionMinSize     int64
+	ResponseCacheSize      int64
+	IdempotencyCacheSize   int64
+	IdempotencyKeyTTL      time.Duration
+	HopByHopHeaders        []string
+	ResponseBufferSize     int64
+	BufferSize             int
+
+	MaxIdleConnsPerBackend int
+	BackendIdleTimeout     time.Duration
+	// BackendKeepAlive is the period of the TCP keepalive probes on
+	// connections to backends, zero disables them.
+	BackendKeepAlive time.Duration
+
+	MaxConnsPerBackend   int
+	MaxConnsQueueTimeout time.Duration
+	// MaxConnsFairQueue dispatches requests queued for a backend at capacity
+	// to whichever backend releases a slot first
+	MaxConnsFairQueue bool
+
+	MaxConcurrentRequests int
+
+	// MaxRequestsPerConn closes a client connection after the response to
+	// its MaxRequestsPerConn-th request, when the server serving the proxy
+	// uses its ConnContext. Zero means no limit.
+	MaxRequestsPerConn int
+
+	TrustedProxyNetworks []*net.IPNet
+
+	IPAllowNetworks []*net.IPNet
+	IPDenyNetworks  []*net.IPNet
+
+	RateLimit      float64
+	RateLimitBurst int
+
+	ErrorPages map[int]config.ErrorPage
+
+	ResponseHeaders config.ResponseHeadersConfig
+
+	ViaPseudonym string
+
+	// ExposeTimingHeader adds the milliseconds the router has spent on a
+	// request until the response headers arrived to the response, in
+	// X-Gorouter-Time.
+	ExposeTimingHeader bool
+
+	// DefaultRoute is the host requests without a usable Host header are
+	// routed to. Empty answers them with 400.
+	DefaultRoute string
+
+	// ForwardedClientCertHeader names the header passing the certificate
+	// the client presented over TLS on to the backend, in
+	// ForwardedClientCertFormat. Empty forwards no certificate.
+	ForwardedClientCertHeader string
+	ForwardedClientCertFormat string
+
+	// LoadBalancerHealthCheckPath is answered with ok, like requests from
+	// the HTTP-Monitor user agent, instead of being routed. Empty disables it.
+	LoadBalancerHealthCheckPath string
+
+	// LoadBalancerHealthCheckStatus and LoadBalancerHealthCheckBody make up
+	// the answer to load balancer checks. A zero status answers 200 ok.
+	LoadBalancerHealthCheckStatus int
+	LoadBalancerHealthCheckBody   string
+
+	// HTTPSRedirectPort redirects requests received without TLS to the
+	// TLS listener on this port. Zero proxies them.
+	HTTPSRedirectPort uint16
 }
 
 type proxy struct {
@@ -65,13 +173,63 @@ type proxy struct {
 	transport          *http.Transport
 	secureCookies      bool
 	routeServiceConfig *route_service.RouteServiceConfig
-	ExtraHeadersToLog  []string
+	dialer             *net.Dialer
+	loadBalancing      string
+	backendSelector    route.BackendSelector
+	stickyCookieName   string
+	responseCache      *responseCache
+	idempotency        *idempotencyCache
+	hopByHopHeaders    []string
+	retryBufferSize    int64
+	responseBufferSize int64
+	bufferPool         *bufferPool
+	backendLimiter     *backendLimiter
+	maxRequests        int
+	trustedProxies     []*net.IPNet
+	rateLimiter        *rateLimiter
+	heartbeatPath      string
+	heartbeatStatus    int
+	heartbeatBody      string
+	defaultRoute       string
+
+	// a slot for each mirrored request in flight
+	mirrors chan struct{}
+
+	// the *settings in effect, replaced by Reload
+	currentSettings atomic.Value
+
+	// the *ipPolicy in effect, replaced by SetIPPolicy
+	ipPolicy atomic.Value
+
+	drainLock      sync.Mutex
+	draining       bool
+	activeRequests int
+	drainDone      chan struct{}
+	backendConns   map[net.Conn]struct{}
 }
 
 func NewProxy(args ProxyArgs) Proxy {
 	routeServiceConfig := route_service.NewRouteServiceConfig(args.RouteServiceEnabled, args.RouteServiceTimeout, args.Crypto, args.CryptoPrev)
 
-	p := &proxy{
+	var p *proxy
+	dialer := NewBackendDialer(args.DialTimeout, args.BackendKeepAlive)
+	dial := func(network, addr string) (net.Conn, error) {
+		conn, err := dialer.Dial(network, addr)
+		if err != nil {
+			return conn, err
+		}
+		endpointTimeout := p.settings().endpointTimeout
+		if endpointTimeout > 0 {
+			err = conn.SetDeadline(time.Now().Add(endpointTimeout))
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return p.trackBackendConn(conn, endpointTimeout), nil
+	}
+
+	p = &proxy{
 		accessLogger: args.AccessLogger,
 		traceKey:     args.TraceKey,
 		ip:           args.Ip,
@@ -79,43 +237,113 @@ func NewProxy(args ProxyArgs) Proxy {
 		registry:     args.Registry,
 		reporter:     args.Reporter,
 		transport: &http.Transport{
-			Dial: func(network, addr string) (net.Conn, error) {
-				conn, err := net.DialTimeout(network, addr, 5*time.Second)
+			Dial: dial,
+			DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dial(network, addr)
 				if err != nil {
-					return conn, err
+					if conn != nil {
+						conn.Close()
+					}
+					return nil, err
 				}
-				if args.EndpointTimeout > 0 {
-					err = conn.SetDeadline(time.Now().Add(args.EndpointTimeout))
-				}
-				return conn, err
+				return clientHandshake(ctx, conn, addr, args.TLSConfig)
 			},
-			DisableKeepAlives:  true,
-			DisableCompression: true,
-			TLSClientConfig:    args.TLSConfig,
+			DisableKeepAlives:      args.MaxIdleConnsPerBackend <= 0,
+			MaxIdleConnsPerHost:    args.MaxIdleConnsPerBackend,
+			IdleConnTimeout:        args.BackendIdleTimeout,
+			DisableCompression:     true,
+			TLSClientConfig:        args.TLSConfig,
+			MaxResponseHeaderBytes: args.MaxResponseHeaderBytes,
+			ExpectContinueTimeout:  expectContinueTimeout,
+			ReadBufferSize:         args.BufferSize,
+			WriteBufferSize:        args.BufferSize,
 		},
 		secureCookies:      args.SecureCookies,
 		routeServiceConfig: routeServiceConfig,
-		ExtraHeadersToLog:  args.ExtraHeadersToLog,
+		dialer:             dialer,
+		loadBalancing:      args.LoadBalancing,
+		backendSelector:    args.BackendSelector,
+		stickyCookieName:   args.StickyCookieName,
+		responseCache:      newResponseCache(args.ResponseCacheSize),
+		idempotency:        newIdempotencyCache(args.IdempotencyCacheSize, args.IdempotencyKeyTTL),
+		hopByHopHeaders:    args.HopByHopHeaders,
+		retryBufferSize:    args.RetryBodyBufferSize,
+		responseBufferSize: args.ResponseBufferSize,
+		bufferPool:         newBufferPool(args.BufferSize),
+		backendLimiter:     newBackendLimiter(args.MaxConnsPerBackend, args.MaxConnsQueueTimeout, args.MaxConnsFairQueue),
+		maxRequests:        args.MaxConcurrentRequests,
+		trustedProxies:     args.TrustedProxyNetworks,
+		rateLimiter:        newRateLimiter(args.RateLimit, args.RateLimitBurst),
+		heartbeatPath:      args.LoadBalancerHealthCheckPath,
+		heartbeatStatus:    args.LoadBalancerHealthCheckStatus,
+		heartbeatBody:      args.LoadBalancerHealthCheckBody,
+		defaultRoute:       args.DefaultRoute,
+		backendConns:       make(map[net.Conn]struct{}),
+		mirrors:            make(chan struct{}, mirrorsInFlightLimit),
+	}
+
+	p.currentSettings.Store(newSettings(args))
+	p.SetIPPolicy(args.IPAllowNetworks, args.IPDenyNetworks)
+
+	if p.stickyCookieName == "" {
+		p.stickyCookieName = StickyCookieKey
+	}
+
+	if p.heartbeatStatus == 0 {
+		p.heartbeatStatus = http.StatusOK
+		if p.heartbeatBody == "" {
+			p.heartbeatBody = "ok\n"
+		}
+	}
+
+	if p.backendSelector == nil {
+		switch p.loadBalancing {
+		case config.LoadBalancingRandom:
+			p.backendSelector = route.NewRandomSelector()
+		case config.LoadBalancingHeaderHash:
+			p.backendSelector = route.NewHeaderHashSelector(args.HashHeader)
+		case config.LoadBalancingPowerOfTwo:
+			p.backendSelector = route.NewTwoChoicesSelector()
+		}
 	}
 
 	return p
 }
 
-func hostWithoutPort(req *http.Request) string {
+// normalizedHost returns the host of the request the way routes are
+// registered: lowercase, without a port and without the trailing dot of a
+// fully qualified name.
+func normalizedHost(req *http.Request) string {
 	host := req.Host
 
-	// Remove :<port>
-	pos := strings.Index(host, ":")
-	if pos >= 0 {
+	// Remove :<port>, the colons of an IPv6 literal are within brackets
+	pos := strings.LastIndex(host, ":")
+	if pos >= 0 && pos > strings.LastIndex(host, "]") {
 		host = host[0:pos]
 	}
 
-	return host
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// httpsURL returns the URL of the request on the TLS listener at the port,
+// which is left out of the URL when it is the default one.
+func httpsURL(request *http.Request, port uint16) string {
+	host := request.Host
+	pos := strings.LastIndex(host, ":")
+	if pos >= 0 && pos > strings.LastIndex(host, "]") {
+		host = host[0:pos]
+	}
+
+	if port != 443 {
+		host = fmt.Sprintf("%s:%d", host, port)
+	}
+
+	return "https://" + host + request.RequestURI
 }
 
 func (p *proxy) getStickySession(request *http.Request) string {
 	// Try choosing a backend using sticky session
-	if _, err := request.Cookie(StickyCookieKey); err == nil {
+	if _, err := request.Cookie(p.stickyCookieName); err == nil {
 		if sticky, err := request.Cookie(VcapCookieId); err == nil {
 			return sticky.Value
 		}
@@ -124,36 +352,119 @@ func (p *proxy) getStickySession(request *http.Request) string {
 }
 
 func (p *proxy) lookup(request *http.Request) *route.Pool {
-	uri := route.Uri(hostWithoutPort(request) + request.RequestURI)
-	return p.registry.Lookup(uri)
+	// a CONNECT names only the authority to tunnel to
+	uri := route.Uri(normalizedHost(request) + request.RequestURI)
+	if isConnect(request) {
+		uri = route.Uri(normalizedHost(request))
+	}
+
+	pool := p.registry.Lookup(uri)
+	// a route only registered for TLS passthrough takes no requests
+	if pool != nil && pool.PassthroughOnly() {
+		return nil
+	}
+	return pool
 }
 
 func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
 	startedAt := time.Now()
+	s := p.settings()
+	if s.maxRequestsPerConn > 0 {
+		countConnRequest(responseWriter, request, s.maxRequestsPerConn)
+	}
+
 	accessLog := access_log.AccessLogRecord{
 		Request:           request,
+		ClientAddr:        p.resolveClientAddr(request),
 		StartedAt:         startedAt,
-		ExtraHeadersToLog: p.ExtraHeadersToLog,
+		ExtraHeadersToLog: s.extraHeadersToLog,
+	}
+
+	var requestBodyLimiter *limitedReadCloser
+	if s.maxRequestBodySize > 0 {
+		requestBodyLimiter = &limitedReadCloser{delegate: request.Body, remaining: s.maxRequestBodySize}
+		request.Body = requestBodyLimiter
 	}
 
 	requestBodyCounter := &countingReadCloser{delegate: request.Body}
 	request.Body = requestBodyCounter
 
+	// taken before the router adds its own headers for the backend
+	requestHeaderBytes := requestHeaderSize(request)
+
 	proxyWriter := NewProxyResponseWriter(responseWriter)
-	handler := NewRequestHandler(request, proxyWriter, p.reporter, &accessLog)
+	handler := NewRequestHandler(request, proxyWriter, p.reporter, &accessLog, s.errorPages, p.dialer, s.maxAttempts)
 
 	defer func() {
 		accessLog.RequestBytesReceived = requestBodyCounter.count
 		p.accessLogger.Log(accessLog)
+		p.reporter.CaptureProxyResponse(accessLog.StatusCode)
 	}()
 
+	if err := p.startRequest(); err != nil {
+		if err == routerAtCapacity {
+			handler.HandleRouterAtCapacity(err)
+		} else {
+			handler.HandleDraining()
+		}
+		return
+	}
+	defer p.finishRequest()
+
 	if !isProtocolSupported(request) {
 		handler.HandleUnsupportedProtocol()
 		return
 	}
 
-	if isLoadBalancerHeartbeat(request) {
-		handler.HandleHeartbeat()
+	if isLoadBalancerHeartbeat(request) || p.isHeartbeatPath(request) {
+		handler.HandleHeartbeat(p.heartbeatStatus, p.heartbeatBody)
+		return
+	}
+
+	// HTTP/1.0 clients may leave the Host header out
+	if normalizedHost(request) == "" {
+		if p.defaultRoute == "" {
+			p.reporter.CaptureBadRequest(request)
+			handler.HandleMissingHost()
+			return
+		}
+		request.Host = p.defaultRoute
+	}
+
+	if !p.clientAllowed(accessLog.ClientAddr) {
+		handler.HandleClientBlocked()
+		return
+	}
+
+	if ok, retryAfter := p.rateLimiter.allow(accessLog.ClientAddr); !ok {
+		handler.HandleRateLimited(retryAfter)
+		return
+	}
+
+	if s.httpsRedirectPort != 0 && request.TLS == nil {
+		handler.HandleHTTPSRedirect(httpsURL(request, s.httpsRedirectPort))
+		return
+	}
+
+	// set before the request is copied for the backend so that the access
+	// log records the same values
+	setRequestXRequestStart(request)
+	setRequestXRequestId(request, handler.Logger())
+	setRequestXVcapRequestId(request, handler.Logger())
+	accessLog.TraceId = setRequestTraceparent(request)
+	if s.forwardedClientCertHeader != "" {
+		setRequestForwardedClientCert(request, s.forwardedClientCertHeader, s.forwardedClientCertFormat)
+	}
+
+	if s.maxURILength > 0 && len(request.RequestURI) > s.maxURILength {
+		p.reporter.CaptureBadRequest(request)
+		handler.HandleURITooLong()
+		return
+	}
+
+	if s.maxRequestBodySize > 0 && request.ContentLength > s.maxRequestBodySize {
+		p.reporter.CaptureBadRequest(request)
+		handler.HandleRequestEntityTooLarge()
 		return
 	}
 
@@ -164,17 +475,57 @@ func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 		return
 	}
 
+	uri := routePool.Uri()
+	defer func() {
+		p.reporter.CaptureRouteBytes(uri,
+			requestHeaderBytes+int64(requestBodyCounter.count),
+			responseHeaderSize(proxyWriter.Status(), proxyWriter.Header())+int64(proxyWriter.Size()))
+	}()
+
+	if cors := routePool.CORS(); cors != nil && route.IsPreflight(request) {
+		handler.HandleCorsPreflight(cors)
+		return
+	}
+
+	mirrorGroup, mirrorPercent := routePool.MirrorGroup()
+	routePool = routePool.RouteGroup(request, accessLog.ClientAddr)
+
+	// the timeout of the endpoint the current attempt is sent to
+	endpointTimeout := s.endpointTimeout
+
 	stickyEndpointId := p.getStickySession(request)
 	iter := &wrappedIterator{
-		nested: routePool.Endpoints(stickyEndpointId),
+		nested: p.endpoints(routePool, stickyEndpointId, request),
 
 		afterNext: func(endpoint *route.Endpoint) {
 			if endpoint != nil {
 				handler.Logger().Set("RouteEndpoint", endpoint.ToLogData())
 				accessLog.RouteEndpoint = endpoint
 				p.reporter.CaptureRoutingRequest(endpoint, request)
+
+				endpointTimeout = s.endpointTimeout
+				if endpoint.Timeout > 0 {
+					endpointTimeout = endpoint.Timeout
+				}
 			}
 		},
+
+		reporter: p.reporter,
+
+		pool: routePool,
+		circuitOpened: func(endpoint *route.Endpoint) {
+			p.logger.Warnd(map[string]interface{}{
+				"uri":     routePool.Uri(),
+				"address": endpoint.CanonicalAddr(),
+				"app":     endpoint.ApplicationId,
+			}, "proxy.circuit.opened")
+		},
+	}
+
+	if isConnect(request) {
+		handler.HandleConnectRequest(iter)
+		accessLog.FinishedAt = time.Now()
+		return
 	}
 
 	if isTcpUpgrade(request) {
@@ -184,6 +535,7 @@ func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 	}
 
 	if isWebSocketUpgrade(request) {
+		removeHopByHopHeaders(request.Header, p.hopByHopHeaders, true)
 		handler.HandleWebSocketRequest(iter)
 		accessLog.FinishedAt = time.Now()
 		return
@@ -222,6 +574,57 @@ func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 		}
 	}
 
+	// responses of route services are never cached, their answer may
+	// depend on more than the request URI
+	cacheable := p.responseCache != nil && routeServiceUrl == "" && isCacheableRequest(request)
+	if cacheable && !wantsFreshResponse(request) {
+		if cached := p.responseCache.get(cacheKey(request, routePool)); cached != nil {
+			handler.HandleCachedResponse(cached)
+			accessLog.FinishedAt = time.Now()
+			accessLog.BodyBytesSent = proxyWriter.Size()
+			return
+		}
+	}
+
+	// a request on its way to a route service is deduplicated once it is
+	// back, its first pass would otherwise wait for the second
+	var idempotentKey string
+	if p.idempotency != nil && backend {
+		idempotentKey = idempotencyKey(request, routePool.Uri())
+	}
+	if idempotentKey != "" {
+		cached, release, err := p.idempotency.claim(request, idempotentKey)
+		if err != nil {
+			handler.HandleIdempotencyKeyReused(err)
+			accessLog.FinishedAt = time.Now()
+			return
+		}
+		if cached != nil {
+			handler.HandleReplayedResponse(cached)
+			accessLog.FinishedAt = time.Now()
+			accessLog.BodyBytesSent = proxyWriter.Size()
+			return
+		}
+		defer release()
+	}
+
+	// a request on its way to a route service is mirrored once it is back
+	if mirrorGroup != nil && backend {
+		p.mirror(mirrorGroup, mirrorPercent, request, s.endpointTimeout)
+	}
+
+	// the connection the response is read from, so that event streams can
+	// be exempted from the endpoint timeout
+	var backendConnection net.Conn
+	request = request.WithContext(httptrace.WithClientTrace(request.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			backendConnection = info.Conn
+			if conn, ok := asBackendConn(info.Conn); ok {
+				conn.setTimeout(endpointTimeout)
+			}
+		},
+	}))
+
 	after := func(rsp *http.Response, endpoint *route.Endpoint, err error) {
 		accessLog.FirstByteAt = time.Now()
 		if rsp != nil {
@@ -234,39 +637,275 @@ func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 
 		latency := time.Since(startedAt)
 
-		p.reporter.CaptureRoutingResponse(endpoint, rsp, startedAt, latency)
+		p.reporter.CaptureRoutingResponse(endpoint, routePool.Uri(), rsp, startedAt, latency)
+
+		if err != nil && requestBodyLimiter != nil && requestBodyLimiter.Exceeded() {
+			p.reporter.CaptureBadRequest(request)
+			handler.HandleRequestEntityTooLarge()
+			return
+		}
 
 		if err != nil {
 			p.reporter.CaptureBadGateway(request)
-			handler.HandleBadGateway(err)
+			if timeoutError(err) {
+				handler.HandleGatewayTimeout(err)
+			} else {
+				handler.HandleBadGateway(err)
+			}
 			return
 		}
 
+		removeHopByHopHeaders(rsp.Header, p.hopByHopHeaders, rsp.StatusCode == http.StatusSwitchingProtocols)
+
+		if s.viaPseudonym != "" {
+			rsp.Header.Add("Via", viaEntry(rsp.ProtoMajor, rsp.ProtoMinor, s.viaPseudonym))
+		}
+
+		// configured headers are stripped, added ones replace the backend's
+		for _, name := range s.responseHeaders.Remove {
+			rsp.Header.Del(name)
+		}
+		for _, header := range s.responseHeaders.Add {
+			rsp.Header.Set(header.Name, header.Value)
+		}
+		// those registered with the endpoint win over the configured ones
+		for name, value := range endpoint.ResponseHeaders {
+			rsp.Header.Set(name, value)
+		}
+
+		if endpoint.RewriteLocation {
+			rewriteLocation(rsp, request, endpoint)
+		}
+
+		if s.exposeTimingHeader {
+			rsp.Header.Set(router_http.GorouterTimeHeader, strconv.FormatInt(int64(latency/time.Millisecond), 10))
+		}
+
+		// the headers of a HEAD response describe the body a GET would
+		// get, Content-Length included, but a body is never sent
+		if request.Method == "HEAD" && rsp.Body != nil {
+			rsp.Body.Close()
+			rsp.Body = http.NoBody
+		}
+
+		if cacheable {
+			p.responseCache.store(cacheKey(request, routePool), rsp, cacheLifetime(rsp))
+		}
+
+		if idempotentKey != "" {
+			p.idempotency.store(idempotentKey, request, rsp)
+		}
+
+		// small bodies are read before the client gets them, so that a slow
+		// client does not hold on to the backend
+		if shouldBuffer(rsp, p.responseBufferSize) {
+			bufferResponse(rsp)
+		}
+
+		if s.compressResponses && shouldCompress(request, rsp, s.compressionMinSize) {
+			compressResponse(rsp)
+		}
+
+		eventStream := isEventStream(rsp)
+
+		// streamed bodies are forwarded chunk by chunk as they arrive from
+		// the backend
+		if rsp.ContentLength < 0 || eventStream {
+			proxyWriter.FlushOnWrite()
+		}
+
+		// an event stream stays open for as long as the backend has events
+		// to send, the endpoint timeout would cut it off
+		if conn, ok := asBackendConn(backendConnection); ok && eventStream {
+			conn.clearDeadline()
+		}
+
 		if endpoint.PrivateInstanceId != "" {
-			setupStickySession(responseWriter, rsp, endpoint, stickyEndpointId, p.secureCookies, routePool.ContextPath())
+			setupStickySession(responseWriter, rsp, endpoint, stickyEndpointId, p.stickyCookieName, p.secureCookies, routePool.ContextPath())
 		}
 	}
 
 	roundTripper := NewProxyRoundTripper(backend,
-		dropsonde.InstrumentedRoundTripper(p.transport), iter, handler, after)
+		dropsonde.InstrumentedRoundTripper(p.transport), iter, handler, after, s.maxAttempts, s.retryBackoff, p.retryBufferSize,
+		p.backendLimiter)
 
-	newReverseProxy(roundTripper, request, routeServiceArgs, p.routeServiceConfig).ServeHTTP(proxyWriter, request)
+	newReverseProxy(roundTripper, request, routeServiceArgs, p.routeServiceConfig, s.viaPseudonym, p.bufferPool,
+		p.hopByHopHeaders).ServeHTTP(proxyWriter, request)
 
 	accessLog.FinishedAt = time.Now()
 	accessLog.BodyBytesSent = proxyWriter.Size()
 }
 
+// Drain rejects new requests with a 503 and waits for the active ones to
+// complete. Backend connections still open after the timeout are closed.
+// Draining again while a drain is in progress waits for the same requests.
+func (p *proxy) Drain(timeout time.Duration) error {
+	drained := make(chan struct{})
+
+	p.drainLock.Lock()
+	p.draining = true
+
+	p.logger.Infof("Draining with %d outstanding active requests", p.activeRequests)
+
+	switch {
+	case p.drainDone != nil:
+		drained = p.drainDone
+	case p.activeRequests == 0:
+		close(drained)
+	default:
+		p.drainDone = drained
+	}
+	p.drainLock.Unlock()
+
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		p.logger.Warn("proxy.drain.timed-out")
+		p.closeBackendConns()
+		return DrainTimeout
+	}
+
+	p.transport.CloseIdleConnections()
+	return nil
+}
+
+func (p *proxy) startRequest() error {
+	p.drainLock.Lock()
+	defer p.drainLock.Unlock()
+
+	if p.draining {
+		return routerDraining
+	}
+
+	if p.maxRequests > 0 && p.activeRequests >= p.maxRequests {
+		return routerAtCapacity
+	}
+
+	p.activeRequests++
+	return nil
+}
+
+func (p *proxy) finishRequest() {
+	p.drainLock.Lock()
+	p.activeRequests--
+
+	if p.drainDone != nil && p.activeRequests == 0 {
+		close(p.drainDone)
+		p.drainDone = nil
+	}
+	p.drainLock.Unlock()
+}
+
+func (p *proxy) trackBackendConn(conn net.Conn, timeout time.Duration) net.Conn {
+	p.drainLock.Lock()
+	p.backendConns[conn] = struct{}{}
+	p.drainLock.Unlock()
+
+	return &backendConn{Conn: conn, proxy: p, timeout: int64(timeout)}
+}
+
+func (p *proxy) closeBackendConns() {
+	p.drainLock.Lock()
+	defer p.drainLock.Unlock()
+
+	for conn := range p.backendConns {
+		conn.Close()
+	}
+}
+
+type backendConn struct {
+	net.Conn
+	proxy *proxy
+
+	// the endpoint timeout of the request sent on the connection, a
+	// time.Duration
+	timeout int64
+
+	// set once a response has been read, so that the next request on a
+	// kept-alive connection gets the full endpoint timeout again
+	read int32
+}
+
+func (c *backendConn) Read(b []byte) (int, error) {
+	atomic.StoreInt32(&c.read, 1)
+	return c.Conn.Read(b)
+}
+
+func (c *backendConn) Write(b []byte) (int, error) {
+	timeout := time.Duration(atomic.LoadInt64(&c.timeout))
+	if timeout > 0 && atomic.CompareAndSwapInt32(&c.read, 1, 0) {
+		c.Conn.SetDeadline(time.Now().Add(timeout))
+	}
+	return c.Conn.Write(b)
+}
+
+// setTimeout applies the timeout of the endpoint the next request on the
+// connection is sent to, starting now.
+func (c *backendConn) setTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.timeout, int64(timeout))
+
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.Conn.SetDeadline(deadline)
+}
+
+// clearDeadline lifts the endpoint timeout for the response being read. The
+// next request written on the connection sets it again.
+func (c *backendConn) clearDeadline() {
+	c.Conn.SetDeadline(time.Time{})
+}
+
+// asBackendConn finds the backendConn under the TLS connection to a backend.
+func asBackendConn(conn net.Conn) (*backendConn, bool) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+
+	c, ok := conn.(*backendConn)
+	return c, ok
+}
+
+func (c *backendConn) Close() error {
+	c.proxy.drainLock.Lock()
+	delete(c.proxy.backendConns, c.Conn)
+	c.proxy.drainLock.Unlock()
+
+	return c.Conn.Close()
+}
+
+func (p *proxy) endpoints(routePool *route.Pool, stickyEndpointId string, request *http.Request) route.EndpointIterator {
+	if p.backendSelector != nil {
+		return routePool.SelectorEndpoints(stickyEndpointId, p.backendSelector, request)
+	}
+	if p.loadBalancing == config.LoadBalancingLeastConnections {
+		return routePool.LeastConnectionEndpoints(stickyEndpointId)
+	}
+	return routePool.Endpoints(stickyEndpointId)
+}
+
 func newReverseProxy(proxyTransport http.RoundTripper, req *http.Request,
 	routeServiceArgs route_service.RouteServiceArgs,
-	routeServiceConfig *route_service.RouteServiceConfig) http.Handler {
+	routeServiceConfig *route_service.RouteServiceConfig, viaPseudonym string, bufferPool *bufferPool,
+	extraHopByHopHeaders []string) http.Handler {
 	rproxy := &httputil.ReverseProxy{
 		Director: func(request *http.Request) {
+			removeHopByHopHeaders(request.Header, extraHopByHopHeaders, upgradeHeader(request) != "")
 			SetupProxyRequest(req, request, routeServiceArgs, routeServiceConfig)
+			if viaPseudonym != "" {
+				request.Header.Add("Via", viaEntry(req.ProtoMajor, req.ProtoMinor, viaPseudonym))
+			}
 		},
 		Transport:     proxyTransport,
 		FlushInterval: 50 * time.Millisecond,
 	}
 
+	// a nil pool must not end up in the interface
+	if bufferPool != nil {
+		rproxy.BufferPool = bufferPool
+	}
+
 	return rproxy
 }
 
@@ -278,8 +917,7 @@ func SetupProxyRequest(source *http.Request, target *http.Request,
 	target.URL.Opaque = source.RequestURI
 	target.URL.RawQuery = ""
 
-	setRequestXRequestStart(source)
-	setRequestXVcapRequestId(source, nil)
+	setRequestXForwardedProto(target)
 
 	sig := target.Header.Get(route_service.RouteServiceSignature)
 	if forwardingToRouteService(routeServiceArgs.UrlString, sig) {
@@ -292,6 +930,12 @@ func SetupProxyRequest(source *http.Request, target *http.Request,
 	}
 }
 
+// viaEntry is the entry of the router in a Via header, naming the protocol
+// version the message was received with, as in "1.1 gorouter".
+func viaEntry(protoMajor, protoMinor int, pseudonym string) string {
+	return fmt.Sprintf("%d.%d %s", protoMajor, protoMinor, pseudonym)
+}
+
 func newRouteServiceEndpoint() *route.Endpoint {
 	return &route.Endpoint{
 		Tags: map[string]string{},
@@ -301,20 +945,75 @@ func newRouteServiceEndpoint() *route.Endpoint {
 type wrappedIterator struct {
 	nested    route.EndpointIterator
 	afterNext func(*route.Endpoint)
+
+	// reporter is given the time each endpoint took to select
+	reporter metrics.ProxyReporter
+
+	// circuitOpened is called when a failure opens the circuit breaker of
+	// an endpoint of pool
+	pool          *route.Pool
+	circuitOpened func(*route.Endpoint)
 }
 
 func (i *wrappedIterator) Next() *route.Endpoint {
+	e := i.next()
+	i.report(e)
+	return e
+}
+
+// next returns the next endpoint, timing its selection, without reporting
+// that the request is routed to it.
+func (i *wrappedIterator) next() *route.Endpoint {
+	startedAt := time.Now()
 	e := i.nested.Next()
+	if i.reporter != nil {
+		i.reporter.CaptureSelectionTime(time.Since(startedAt))
+	}
+	return e
+}
+
+// pick returns the next endpoint without timing or reporting it, for the
+// endpoints a request queued for a backend tries as slots are released.
+func (i *wrappedIterator) pick() *route.Endpoint {
+	return i.nested.Next()
+}
+
+// report records that the request is routed to the endpoint.
+func (i *wrappedIterator) report(e *route.Endpoint) {
 	if i.afterNext != nil {
 		i.afterNext(e)
 	}
-	return e
 }
 
 func (i *wrappedIterator) EndpointFailed() {
 	i.nested.EndpointFailed()
 }
 
+func (i *wrappedIterator) PreRequest(e *route.Endpoint) {
+	i.nested.PreRequest(e)
+}
+
+func (i *wrappedIterator) PostRequest(e *route.Endpoint) {
+	i.nested.PostRequest(e)
+}
+
+func (i *wrappedIterator) RecordSuccess(e *route.Endpoint) {
+	i.nested.RecordSuccess(e)
+}
+
+func (i *wrappedIterator) RecordFailure(e *route.Endpoint) {
+	if i.pool == nil || i.circuitOpened == nil {
+		i.nested.RecordFailure(e)
+		return
+	}
+
+	wasOpen := i.pool.IsCircuitOpen(e)
+	i.nested.RecordFailure(e)
+	if !wasOpen && i.pool.IsCircuitOpen(e) {
+		i.circuitOpened(e)
+	}
+}
+
 func buildRouteServiceArgs(routeServiceConfig *route_service.RouteServiceConfig, routeServiceUrl, forwardedUrlRaw string) (route_service.RouteServiceArgs, error) {
 	var routeServiceArgs route_service.RouteServiceArgs
 	sig, metadata, err := routeServiceConfig.GenerateSignatureAndMetadata(forwardedUrlRaw)
@@ -339,6 +1038,7 @@ func buildRouteServiceArgs(routeServiceConfig *route_service.RouteServiceConfig,
 func setupStickySession(responseWriter http.ResponseWriter, response *http.Response,
 	endpoint *route.Endpoint,
 	originalEndpointId string,
+	stickyCookieName string,
 	secureCookies bool,
 	path string) {
 
@@ -348,7 +1048,7 @@ func setupStickySession(responseWriter http.ResponseWriter, response *http.Respo
 	sticky := originalEndpointId != "" && originalEndpointId != endpoint.PrivateInstanceId
 
 	for _, v := range response.Cookies() {
-		if v.Name == StickyCookieKey {
+		if v.Name == stickyCookieName {
 			sticky = true
 			if v.MaxAge < 0 {
 				maxAge = v.MaxAge
@@ -387,6 +1087,19 @@ func isLoadBalancerHeartbeat(request *http.Request) bool {
 	return request.UserAgent() == "HTTP-Monitor/1.1"
 }
 
+func (p *proxy) isHeartbeatPath(request *http.Request) bool {
+	return p.heartbeatPath != "" && request.URL.Path == p.heartbeatPath
+}
+
+func isEventStream(response *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+func isConnect(request *http.Request) bool {
+	return request.Method == "CONNECT"
+}
+
 func isWebSocketUpgrade(request *http.Request) bool {
 	// websocket should be case insensitive per RFC6455 4.2.1
 	return strings.ToLower(upgradeHeader(request)) == "websocket"
@@ -428,3 +1141,42 @@ func (crc *countingReadCloser) Read(b []byte) (int, error) {
 func (crc *countingReadCloser) Close() error {
 	return crc.delegate.Close()
 }
+
+var requestBodyTooLarge = errors.New("Request body too large")
+
+// limitedReadCloser fails the read that would go past the configured number
+// of bytes, so the excess is never forwarded to the backend.
+type limitedReadCloser struct {
+	delegate  io.ReadCloser
+	remaining int64
+	exceeded  int32
+}
+
+func (l *limitedReadCloser) Read(b []byte) (int, error) {
+	if l.Exceeded() {
+		return 0, requestBodyTooLarge
+	}
+
+	if int64(len(b)) > l.remaining+1 {
+		b = b[:l.remaining+1]
+	}
+
+	n, err := l.delegate.Read(b)
+	if int64(n) <= l.remaining {
+		l.remaining -= int64(n)
+		return n, err
+	}
+
+	n = int(l.remaining)
+	l.remaining = 0
+	atomic.StoreInt32(&l.exceeded, 1)
+	return n, requestBodyTooLarge
+}
+
+func (l *limitedReadCloser) Close() error {
+	return l.delegate.Close()
+}
+
+func (l *limitedReadCloser) Exceeded() bool {
+	return atomic.LoadInt32(&l.exceeded) == 1
+}
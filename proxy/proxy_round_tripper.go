@@ -1,35 +1,57 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloudfoundry/gorouter/route"
 )
 
 func NewProxyRoundTripper(backend bool, transport http.RoundTripper, endpointIterator route.EndpointIterator,
-	handler RequestHandler, afterRoundTrip AfterRoundTrip) http.RoundTripper {
+	handler RequestHandler, afterRoundTrip AfterRoundTrip, maxAttempts int, backoff RetryBackoff, bodyBufferSize int64,
+	limiter *backendLimiter) http.RoundTripper {
 	if backend {
 		return &BackendRoundTripper{
-			transport: transport,
-			iter:      endpointIterator,
-			handler:   &handler,
-			after:     afterRoundTrip,
+			transport:      transport,
+			iter:           endpointIterator,
+			handler:        &handler,
+			after:          afterRoundTrip,
+			maxAttempts:    maxAttempts,
+			backoff:        backoff,
+			bodyBufferSize: bodyBufferSize,
+			limiter:        limiter,
 		}
 	} else {
 		return &RouteServiceRoundTripper{
-			transport: transport,
-			handler:   &handler,
-			after:     afterRoundTrip,
+			transport:      transport,
+			handler:        &handler,
+			after:          afterRoundTrip,
+			maxAttempts:    maxAttempts,
+			backoff:        backoff,
+			bodyBufferSize: bodyBufferSize,
 		}
 	}
 }
 
 type BackendRoundTripper struct {
-	iter      route.EndpointIterator
-	transport http.RoundTripper
-	after     AfterRoundTrip
-	handler   *RequestHandler
+	iter           route.EndpointIterator
+	transport      http.RoundTripper
+	after          AfterRoundTrip
+	handler        *RequestHandler
+	maxAttempts    int
+	backoff        RetryBackoff
+	bodyBufferSize int64
+	limiter        *backendLimiter
 }
 
 func (rt *BackendRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
@@ -37,22 +59,77 @@ func (rt *BackendRoundTripper) RoundTrip(request *http.Request) (*http.Response,
 	var res *http.Response
 	var endpoint *route.Endpoint
 
-	for retry := 0; retry < maxRetries; retry++ {
+	// an endpoint's host header override or path rewrite must not leak
+	// into a retry
+	clientHost := request.Host
+	clientURL := *request.URL
+
+	if rt.maxAttempts > 1 {
+		bufferBody(request, rt.bodyBufferSize)
+	}
+
+	started := time.Now()
+	for retry := 0; retry < rt.maxAttempts; retry++ {
+		if retry > 0 && (!replayable(request) || !rt.backoff.wait(request, started)) {
+			break
+		}
+		rewindBody(request)
+
 		endpoint, err = rt.selectEndpoint(request)
 		if err != nil {
 			return nil, err
 		}
 
-		rt.setupRequest(request, endpoint)
+		var queued time.Duration
+		var ok bool
+		endpoint, queued, ok = rt.acquire(endpoint)
+		if queued > 0 {
+			rt.handler.reporter.CaptureQueueTime(queued)
+		}
+		if !ok {
+			err = backendAtCapacity
+			rt.handler.reporter.CaptureBadGateway(request)
+			rt.handler.HandleBackendAtCapacity(err)
+			return nil, err
+		}
 
-		res, err = rt.transport.RoundTrip(request)
-		if err == nil || !retryableError(err) {
+		request = rt.setupRequest(request, endpoint, clientHost, &clientURL)
+
+		// whether the backend began to respond, which tells a response that
+		// is not HTTP apart from a connection that broke
+		responded := false
+		attempt := request.WithContext(httptrace.WithClientTrace(request.Context(), &httptrace.ClientTrace{
+			GotFirstResponseByte: func() {
+				responded = true
+			},
+		}))
+
+		rt.iter.PreRequest(endpoint)
+		res, err = rt.transport.RoundTrip(attempt)
+		if err != nil {
+			err = classifyBackendError(err, responded)
+			rt.iter.PostRequest(endpoint)
+			rt.limiter.release(endpoint)
+			rt.handler.reporter.CaptureBackendFailure(endpoint, err)
+		}
+
+		if err != nil || (res != nil && res.StatusCode >= http.StatusInternalServerError) {
+			rt.iter.RecordFailure(endpoint)
+		} else {
+			rt.iter.RecordSuccess(endpoint)
+		}
+
+		if err == nil || !(retryableError(err) || retryableRequest(request, err)) {
 			break
 		}
 
 		rt.reportError(err)
 	}
 
+	if res != nil && res.Body != nil {
+		res.Body = newInFlightBody(res.Body, rt.iter, endpoint, rt.limiter)
+	}
+
 	if rt.after != nil {
 		rt.after(res, endpoint, err)
 	}
@@ -60,23 +137,69 @@ func (rt *BackendRoundTripper) RoundTrip(request *http.Request) (*http.Response,
 	return res, err
 }
 
+// reportingIterator is an iterator that reports the endpoints it returns,
+// which can also be picked without, so that a request queued for a backend
+// only reports the endpoint it is sent to.
+type reportingIterator interface {
+	next() *route.Endpoint
+	pick() *route.Endpoint
+	report(*route.Endpoint)
+}
+
 func (rt *BackendRoundTripper) selectEndpoint(request *http.Request) (*route.Endpoint, error) {
-	endpoint := rt.iter.Next()
+	var endpoint *route.Endpoint
+	if iter, ok := rt.iter.(reportingIterator); ok && rt.limiter != nil {
+		endpoint = iter.next()
+	} else {
+		endpoint = rt.iter.Next()
+	}
 
 	if endpoint == nil {
 		rt.handler.reporter.CaptureBadGateway(request)
 		err := noEndpointsAvailable
-		rt.handler.HandleBadGateway(err)
+		rt.handler.HandleServiceUnavailable(err)
 		return nil, err
 	}
 	return endpoint, nil
 }
 
-func (rt *BackendRoundTripper) setupRequest(request *http.Request, endpoint *route.Endpoint) {
+// acquire takes a slot of the limiter for the endpoint, or for the one the
+// request is dispatched to instead, and reports the endpoint the slot was
+// taken for.
+func (rt *BackendRoundTripper) acquire(endpoint *route.Endpoint) (*route.Endpoint, time.Duration, bool) {
+	iter, ok := rt.iter.(reportingIterator)
+	if !ok || rt.limiter == nil {
+		return rt.limiter.acquire(endpoint, rt.iter.Next)
+	}
+
+	endpoint, queued, ok := rt.limiter.acquire(endpoint, iter.pick)
+	if ok {
+		iter.report(endpoint)
+	}
+	return endpoint, queued, ok
+}
+
+func (rt *BackendRoundTripper) setupRequest(request *http.Request, endpoint *route.Endpoint, clientHost string, clientURL *url.URL) *http.Request {
 	rt.handler.Logger().Debug("proxy.backend")
+	*request.URL = *clientURL
 	request.URL.Host = endpoint.CanonicalAddr()
+	request.Host = clientHost
+	if endpoint.HostHeader != "" {
+		request.Host = endpoint.HostHeader
+	}
+	if endpoint.PathRewrite != nil {
+		endpoint.PathRewrite.Rewrite(request.URL)
+	}
 	request.Header.Set("X-CF-ApplicationID", endpoint.ApplicationId)
 	setRequestXCfInstanceId(request, endpoint)
+
+	if !endpoint.TLS {
+		request.URL.Scheme = "http"
+		return request
+	}
+
+	request.URL.Scheme = "https"
+	return withServerName(request, endpoint.ServerName)
 }
 
 func (rt *BackendRoundTripper) reportError(err error) {
@@ -85,17 +208,58 @@ func (rt *BackendRoundTripper) reportError(err error) {
 	rt.handler.Logger().Warnf("proxy.endpoint.failed")
 }
 
+// inFlightBody releases the endpoint's in-flight and connection limit slots
+// once the response body has been consumed and closed by the reverse proxy.
+type inFlightBody struct {
+	io.ReadCloser
+	once     sync.Once
+	iter     route.EndpointIterator
+	endpoint *route.Endpoint
+	limiter  *backendLimiter
+}
+
+func newInFlightBody(body io.ReadCloser, iter route.EndpointIterator, endpoint *route.Endpoint, limiter *backendLimiter) io.ReadCloser {
+	return &inFlightBody{
+		ReadCloser: body,
+		iter:       iter,
+		endpoint:   endpoint,
+		limiter:    limiter,
+	}
+}
+
+func (b *inFlightBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.iter.PostRequest(b.endpoint)
+		b.limiter.release(b.endpoint)
+	})
+	return err
+}
+
 type RouteServiceRoundTripper struct {
-	transport http.RoundTripper
-	after     AfterRoundTrip
-	handler   *RequestHandler
+	transport      http.RoundTripper
+	after          AfterRoundTrip
+	handler        *RequestHandler
+	maxAttempts    int
+	backoff        RetryBackoff
+	bodyBufferSize int64
 }
 
 func (rt *RouteServiceRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
 	var err error
 	var res *http.Response
 
-	for retry := 0; retry < maxRetries; retry++ {
+	if rt.maxAttempts > 1 {
+		bufferBody(request, rt.bodyBufferSize)
+	}
+
+	started := time.Now()
+	for retry := 0; retry < rt.maxAttempts; retry++ {
+		if retry > 0 && (!replayable(request) || !rt.backoff.wait(request, started)) {
+			break
+		}
+		rewindBody(request)
+
 		res, err = rt.transport.RoundTrip(request)
 		if err == nil || !retryableError(err) {
 			break
@@ -117,6 +281,35 @@ func (rs *RouteServiceRoundTripper) reportError(err error) {
 	rs.handler.Logger().Warnf("proxy.route-service.failed")
 }
 
+// invalidResponseError is the failure of a backend to send a valid HTTP
+// response, such as a backend writing garbage on the connection.
+type invalidResponseError struct {
+	error
+}
+
+func (invalidResponseError) InvalidResponse() bool { return true }
+
+func (e invalidResponseError) Unwrap() error { return e.error }
+
+// classifyBackendError tells responses net/http failed to parse apart from
+// the other errors of a round trip: once the backend began to respond, every
+// error other than those of the connection is one of parsing the response.
+func classifyBackendError(err error, responded bool) error {
+	if !responded {
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return invalidResponseError{err}
+}
+
 func retryableError(err error) bool {
 	ne, netErr := err.(*net.OpError)
 	if netErr && ne.Op == "dial" {
@@ -125,3 +318,78 @@ func retryableError(err error) bool {
 
 	return false
 }
+
+// retryableRequest reports whether a request that failed after the
+// connection was established can safely be sent to another endpoint.
+func retryableRequest(request *http.Request, err error) bool {
+	switch request.Method {
+	case "GET", "HEAD", "OPTIONS":
+	default:
+		return false
+	}
+
+	if !replayable(request) {
+		return false
+	}
+
+	return !timeoutError(err)
+}
+
+// bufferBody reads a request body of at most maxSize bytes into memory, so
+// that it can be sent again on a retry. Larger bodies, and those of unknown
+// size, are streamed to the endpoint as they arrive and the request is not
+// retried, as the transport consumes and closes the body on the first
+// attempt, even one that fails to connect. So are the bodies of clients
+// expecting 100 Continue, which wait for the endpoint's answer before they
+// send the body: reading it here would answer for the endpoint.
+func bufferBody(request *http.Request, maxSize int64) {
+	if !hasBody(request) || request.ContentLength < 0 || request.ContentLength > maxSize {
+		return
+	}
+
+	if strings.EqualFold(request.Header.Get("Expect"), "100-continue") {
+		return
+	}
+
+	body := make([]byte, request.ContentLength)
+	n, err := io.ReadFull(request.Body, body)
+	if err != nil {
+		// the endpoint still gets the whole body, and the error, as sent
+		request.Body = &struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body[:n]), request.Body), request.Body}
+		return
+	}
+
+	request.Body.Close()
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+// rewindBody starts a buffered request body over for an attempt.
+func rewindBody(request *http.Request) {
+	if request.GetBody != nil {
+		request.Body, _ = request.GetBody()
+	}
+}
+
+// replayable reports whether the request can be sent again, which takes a
+// body buffered by bufferBody, if it has one.
+func replayable(request *http.Request) bool {
+	return !hasBody(request) || request.GetBody != nil
+}
+
+func hasBody(request *http.Request) bool {
+	return request.Body != nil && request.Body != http.NoBody && request.ContentLength != 0
+}
+
+func timeoutError(err error) bool {
+	if retryableError(err) {
+		return false
+	}
+
+	ne, netErr := err.(net.Error)
+	return netErr && ne.Timeout()
+}
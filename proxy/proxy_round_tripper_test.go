@@ -2,8 +2,12 @@ package proxy_test
 
 import (
 	"errors"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry/gorouter/access_log"
 	"github.com/cloudfoundry/gorouter/proxy"
@@ -41,7 +45,7 @@ var _ = Describe("ProxyRoundTripper", func() {
 			nullVarz := nullVarz{}
 			nullAccessRecord := &access_log.AccessLogRecord{}
 
-			handler = proxy.NewRequestHandler(req, resp, nullVarz, nullAccessRecord)
+			handler = proxy.NewRequestHandler(req, resp, nullVarz, nullAccessRecord, nil, nil, 3)
 			transport = &proxyfakes.FakeRoundTripper{}
 
 			after = func(rsp *http.Response, endpoint *route.Endpoint, err error) {
@@ -60,7 +64,7 @@ var _ = Describe("ProxyRoundTripper", func() {
 
 				servingBackend := true
 				proxyRoundTripper = proxy.NewProxyRoundTripper(
-					servingBackend, transport, endpointIterator, handler, after)
+					servingBackend, transport, endpointIterator, handler, after, 3, proxy.RetryBackoff{}, 0, nil)
 			})
 
 			Context("when backend is unavailable", func() {
@@ -76,6 +80,165 @@ var _ = Describe("ProxyRoundTripper", func() {
 					Expect(err).To(HaveOccurred())
 					Expect(endpointIterator.NextCallCount()).To(Equal(3))
 				})
+
+				It("records a failure for every attempt", func() {
+					resp.HeaderReturns(make(http.Header))
+					proxyRoundTripper.RoundTrip(req)
+					Expect(endpointIterator.RecordFailureCallCount()).To(Equal(3))
+					Expect(endpointIterator.RecordSuccessCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the backend responds", func() {
+				var statusCode int
+
+				BeforeEach(func() {
+					transport.RoundTripStub = func(req *http.Request) (*http.Response, error) {
+						return &http.Response{StatusCode: statusCode}, nil
+					}
+				})
+
+				It("records a success", func() {
+					statusCode = http.StatusNotFound
+					proxyRoundTripper.RoundTrip(req)
+					Expect(endpointIterator.RecordSuccessCallCount()).To(Equal(1))
+					Expect(endpointIterator.RecordFailureCallCount()).To(Equal(0))
+				})
+
+				It("records a failure for a server error", func() {
+					statusCode = http.StatusInternalServerError
+					proxyRoundTripper.RoundTrip(req)
+					Expect(endpointIterator.RecordFailureCallCount()).To(Equal(1))
+					Expect(endpointIterator.RecordSuccessCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the backend closes the connection", func() {
+				var roundTripCallCount int
+
+				BeforeEach(func() {
+					roundTripCallCount = 0
+					transport.RoundTripStub = func(req *http.Request) (*http.Response, error) {
+						roundTripCallCount++
+						return nil, io.ErrUnexpectedEOF
+					}
+				})
+
+				It("retries idempotent requests", func() {
+					resp.HeaderReturns(make(http.Header))
+					_, err := proxyRoundTripper.RoundTrip(req)
+					Expect(err).To(HaveOccurred())
+					Expect(roundTripCallCount).To(Equal(3))
+					Expect(endpointIterator.EndpointFailedCallCount()).To(Equal(3))
+				})
+
+				It("does not retry non-idempotent requests", func() {
+					req.Method = "POST"
+
+					_, err := proxyRoundTripper.RoundTrip(req)
+					Expect(err).To(HaveOccurred())
+					Expect(roundTripCallCount).To(Equal(1))
+				})
+
+				It("does not retry requests with a body", func() {
+					req.Body = ioutil.NopCloser(strings.NewReader("body"))
+					req.ContentLength = 4
+
+					_, err := proxyRoundTripper.RoundTrip(req)
+					Expect(err).To(HaveOccurred())
+					Expect(roundTripCallCount).To(Equal(1))
+				})
+			})
+
+			Context("when the backend times out", func() {
+				var roundTripCallCount int
+
+				BeforeEach(func() {
+					roundTripCallCount = 0
+					transport.RoundTripStub = func(req *http.Request) (*http.Response, error) {
+						roundTripCallCount++
+						return nil, &net.OpError{Op: "read", Err: timeoutErr{}}
+					}
+				})
+
+				It("does not retry", func() {
+					_, err := proxyRoundTripper.RoundTrip(req)
+					Expect(err).To(HaveOccurred())
+					Expect(roundTripCallCount).To(Equal(1))
+				})
+			})
+
+			Context("with a single attempt configured", func() {
+				BeforeEach(func() {
+					transport.RoundTripStub = func(req *http.Request) (*http.Response, error) {
+						return nil, dialError
+					}
+					proxyRoundTripper = proxy.NewProxyRoundTripper(
+						true, transport, endpointIterator, handler, after, 1, proxy.RetryBackoff{}, 0, nil)
+				})
+
+				It("does not retry", func() {
+					_, err := proxyRoundTripper.RoundTrip(req)
+					Expect(err).To(HaveOccurred())
+					Expect(endpointIterator.NextCallCount()).To(Equal(1))
+				})
+			})
+
+			Context("with a retry backoff", func() {
+				var attempts []time.Time
+
+				BeforeEach(func() {
+					attempts = nil
+					transport.RoundTripStub = func(req *http.Request) (*http.Response, error) {
+						attempts = append(attempts, time.Now())
+						return nil, dialError
+					}
+				})
+
+				spacing := func() []time.Duration {
+					var d []time.Duration
+					for i := 1; i < len(attempts); i++ {
+						d = append(d, attempts[i].Sub(attempts[i-1]))
+					}
+					return d
+				}
+
+				It("waits the delay between attempts", func() {
+					proxyRoundTripper = proxy.NewProxyRoundTripper(
+						true, transport, endpointIterator, handler, after, 3, proxy.RetryBackoff{Delay: 100 * time.Millisecond}, 0, nil)
+
+					_, err := proxyRoundTripper.RoundTrip(req)
+					Expect(err).To(HaveOccurred())
+
+					Expect(attempts).To(HaveLen(3))
+					for _, d := range spacing() {
+						Expect(d).To(BeNumerically("~", 100*time.Millisecond, 40*time.Millisecond))
+					}
+				})
+
+				It("waits between half the delay and the delay with jitter", func() {
+					proxyRoundTripper = proxy.NewProxyRoundTripper(
+						true, transport, endpointIterator, handler, after, 3, proxy.RetryBackoff{Delay: 200 * time.Millisecond, Jitter: true}, 0, nil)
+
+					proxyRoundTripper.RoundTrip(req)
+
+					Expect(attempts).To(HaveLen(3))
+					for _, d := range spacing() {
+						Expect(d).To(BeNumerically(">=", 100*time.Millisecond))
+						Expect(d).To(BeNumerically("<", 240*time.Millisecond))
+					}
+				})
+
+				It("gives up once the retry time is up", func() {
+					proxyRoundTripper = proxy.NewProxyRoundTripper(
+						true, transport, endpointIterator, handler, after, 3,
+						proxy.RetryBackoff{Delay: 100 * time.Millisecond, MaxRetryTime: 150 * time.Millisecond}, 0, nil)
+
+					_, err := proxyRoundTripper.RoundTrip(req)
+					Expect(err).To(Equal(dialError))
+
+					Expect(attempts).To(HaveLen(2))
+				})
 			})
 
 			Context("when there are no more endpoints available", func() {
@@ -83,13 +246,15 @@ var _ = Describe("ProxyRoundTripper", func() {
 					endpointIterator.NextReturns(nil)
 				})
 
-				It("returns a 502 BadGateway error", func() {
-					resp.HeaderReturns(make(http.Header))
+				It("returns a 503 ServiceUnavailable error", func() {
+					header := make(http.Header)
+					resp.HeaderReturns(header)
 					backendRes, err := proxyRoundTripper.RoundTrip(req)
 					Expect(err).To(HaveOccurred())
 					Expect(backendRes).To(BeNil())
 					Expect(resp.WriteHeaderCallCount()).To(Equal(1))
-					Expect(resp.WriteHeaderArgsForCall(0)).To(Equal(http.StatusBadGateway))
+					Expect(resp.WriteHeaderArgsForCall(0)).To(Equal(http.StatusServiceUnavailable))
+					Expect(header.Get("Retry-After")).To(Equal("5"))
 				})
 			})
 
@@ -125,7 +290,7 @@ var _ = Describe("ProxyRoundTripper", func() {
 				req.Header.Set(route_service.RouteServiceForwardedUrl, "http://myapp.com/")
 				servingBackend := false
 				proxyRoundTripper = proxy.NewProxyRoundTripper(
-					servingBackend, transport, endpointIterator, handler, after)
+					servingBackend, transport, endpointIterator, handler, after, 3, proxy.RetryBackoff{}, 0, nil)
 			})
 
 			It("does not fetch the next endpoint", func() {
@@ -178,3 +343,9 @@ var _ = Describe("ProxyRoundTripper", func() {
 		})
 	})
 })
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
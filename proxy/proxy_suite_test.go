@@ -10,8 +10,10 @@ import (
 	"github.com/cloudfoundry/gorouter/access_log"
 	"github.com/cloudfoundry/gorouter/common/secure"
 	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/metrics"
 	"github.com/cloudfoundry/gorouter/proxy"
 	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
 	"github.com/cloudfoundry/gorouter/test_util"
 	"github.com/cloudfoundry/yagnats/fakeyagnats"
 
@@ -32,6 +34,9 @@ var (
 	accessLogFile *test_util.FakeFile
 	crypto        secure.Crypto
 	cryptoPrev    secure.Crypto
+	proxyReporter metrics.ProxyReporter
+
+	backendSelector route.BackendSelector
 )
 
 func TestProxy(t *testing.T) {
@@ -50,6 +55,9 @@ var _ = BeforeEach(func() {
 	conf = config.DefaultConfig()
 	conf.TraceKey = "my_trace_key"
 	conf.EndpointTimeout = 500 * time.Millisecond
+
+	proxyReporter = nullVarz{}
+	backendSelector = nil
 })
 
 var _ = JustBeforeEach(func() {
@@ -61,7 +69,7 @@ var _ = JustBeforeEach(func() {
 	dropsonde.InitializeWithEmitter(fakeEmitter)
 
 	accessLogFile = new(test_util.FakeFile)
-	accessLog = access_log.NewFileAndLoggregatorAccessLogger(accessLogFile, "")
+	accessLog = access_log.NewFileAndLoggregatorAccessLogger(accessLogFile, "", config.AccessLogFormatText)
 	go accessLog.Run()
 
 	conf.EnableSSL = true
@@ -72,25 +80,89 @@ var _ = JustBeforeEach(func() {
 		InsecureSkipVerify: conf.SSLSkipValidation,
 	}
 
-	p = proxy.NewProxy(proxy.ProxyArgs{
-		EndpointTimeout:     conf.EndpointTimeout,
-		Ip:                  conf.Ip,
-		TraceKey:            conf.TraceKey,
-		Registry:            r,
-		Reporter:            nullVarz{},
-		AccessLogger:        accessLog,
-		SecureCookies:       conf.SecureCookies,
-		TLSConfig:           tlsConfig,
-		RouteServiceEnabled: conf.RouteServiceEnabled,
-		RouteServiceTimeout: conf.RouteServiceTimeout,
-		Crypto:              crypto,
-		CryptoPrev:          cryptoPrev,
-	})
+	args := proxy.ProxyArgs{
+		EndpointTimeout:        conf.EndpointTimeout,
+		DialTimeout:            conf.DialTimeout,
+		Ip:                     conf.Ip,
+		TraceKey:               conf.TraceKey,
+		Registry:               r,
+		Reporter:               proxyReporter,
+		AccessLogger:           accessLog,
+		SecureCookies:          conf.SecureCookies,
+		TLSConfig:              tlsConfig,
+		RouteServiceEnabled:    conf.RouteServiceEnabled,
+		RouteServiceTimeout:    conf.RouteServiceTimeout,
+		Crypto:                 crypto,
+		CryptoPrev:             cryptoPrev,
+		LoadBalancing:          conf.LoadBalancing,
+		HashHeader:             conf.LoadBalancingHashHeader,
+		BackendSelector:        backendSelector,
+		StickyCookieName:       conf.StickyCookieName,
+		MaxRetries:             proxy.RetriesArg(conf.MaxRetries),
+		RetryBodyBufferSize:    conf.RetryBodyBufferSize,
+		MaxURILength:           conf.MaxURILength,
+		MaxRequestBodySize:     conf.MaxRequestBodySize,
+		MaxResponseHeaderBytes: conf.MaxResponseHeaderBytes,
+		CompressResponses:      conf.CompressResponses,
+		CompressionMinSize:     conf.CompressionMinSize,
+		ResponseCacheSize:      conf.ResponseCacheSize,
+		IdempotencyCacheSize:   conf.IdempotencyCacheSize,
+		IdempotencyKeyTTL:      conf.IdempotencyKeyTTL,
+		HopByHopHeaders:        conf.HopByHopHeaders,
+		ResponseBufferSize:     conf.ResponseBufferSize,
+		BufferSize:             conf.ProxyBufferSize,
+
+		MaxIdleConnsPerBackend: conf.MaxIdleConnsPerBackend,
+		BackendIdleTimeout:     conf.BackendIdleTimeout,
+		BackendKeepAlive:       conf.BackendKeepAlive,
+
+		MaxConnsPerBackend:   conf.MaxConnsPerBackend,
+		MaxConnsQueueTimeout: conf.MaxConnsQueueTimeout,
+		MaxConnsFairQueue:    conf.MaxConnsPolicy == config.MaxConnsPolicyFairQueue,
+
+		MaxConcurrentRequests: conf.MaxConcurrentRequests,
+
+		MaxRequestsPerConn: conf.MaxRequestsPerConn,
+
+		TrustedProxyNetworks: conf.TrustedProxyNetworks,
+
+		IPAllowNetworks: conf.IPAllowNetworks,
+		IPDenyNetworks:  conf.IPDenyNetworks,
+
+		RateLimit:      conf.RateLimit.RequestsPerSecond,
+		RateLimitBurst: conf.RateLimit.Burst,
+
+		ErrorPages: conf.ErrorPages,
+
+		ResponseHeaders: conf.ResponseHeaders,
+
+		ViaPseudonym:       conf.ViaPseudonym,
+		ExposeTimingHeader: conf.ExposeTimingHeader,
+
+		RetryBackoff: proxy.RetryBackoff{
+			Delay:        conf.RetryBackoff.Delay,
+			Jitter:       conf.RetryBackoff.Jitter,
+			MaxRetryTime: conf.RetryBackoff.MaxRetryTime,
+		},
+
+		DefaultRoute: conf.DefaultRoute,
+
+		LoadBalancerHealthCheckPath:   conf.LoadBalancerHealthCheckPath,
+		LoadBalancerHealthCheckStatus: conf.HealthCheck.Status,
+		LoadBalancerHealthCheckBody:   conf.HealthCheck.Body,
+
+		ForwardedClientCertHeader: conf.ForwardedClientCertHeader,
+		ForwardedClientCertFormat: conf.ForwardedClientCertFormat,
+	}
+	if conf.ForceHTTPS {
+		args.HTTPSRedirectPort = conf.SSLPort
+	}
+	p = proxy.NewProxy(args)
 
 	proxyServer, err = net.Listen("tcp", "127.0.0.1:0")
 	Expect(err).NotTo(HaveOccurred())
 
-	server := http.Server{Handler: p}
+	server := http.Server{Handler: p, ConnContext: p.ConnContext}
 	go server.Serve(proxyServer)
 })
 
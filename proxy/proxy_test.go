@@ -1,8 +1,13 @@
 package proxy_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,21 +15,32 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/cloudfoundry/dropsonde/emitter/fake"
-	"github.com/cloudfoundry/sonde-go/events"
 	router_http "github.com/cloudfoundry/gorouter/common/http"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/healthcheck"
+	"github.com/cloudfoundry/gorouter/metrics"
+	"github.com/cloudfoundry/gorouter/metrics/fakes"
+	"github.com/cloudfoundry/gorouter/proxy"
 	"github.com/cloudfoundry/gorouter/registry"
 	"github.com/cloudfoundry/gorouter/route"
 	"github.com/cloudfoundry/gorouter/stats"
 	"github.com/cloudfoundry/gorouter/test_util"
+	"github.com/cloudfoundry/gorouter/varz"
+	steno "github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -41,7 +57,13 @@ func (_ nullVarz) ActiveApps() *stats.ActiveApps                              {
 func (_ nullVarz) CaptureBadRequest(*http.Request)                            {}
 func (_ nullVarz) CaptureBadGateway(*http.Request)                            {}
 func (_ nullVarz) CaptureRoutingRequest(b *route.Endpoint, req *http.Request) {}
-func (_ nullVarz) CaptureRoutingResponse(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration) {
+func (_ nullVarz) CaptureProxyResponse(status int)                            {}
+func (_ nullVarz) CaptureBackendFailure(b *route.Endpoint, err error)         {}
+func (_ nullVarz) CaptureSelectionTime(d time.Duration)                       {}
+func (_ nullVarz) CaptureQueueTime(d time.Duration)                           {}
+func (_ nullVarz) CaptureRouteBytes(uri route.Uri, received, sent int64)      {}
+func (_ nullVarz) Counters() varz.Counters                                    { return varz.Counters{} }
+func (_ nullVarz) CaptureRoutingResponse(b *route.Endpoint, uri route.Uri, res *http.Response, t time.Time, d time.Duration) {
 }
 
 var _ = Describe("Proxy", func() {
@@ -131,6 +153,60 @@ var _ = Describe("Proxy", func() {
 		conn.CheckLine("HTTP/1.0 200 OK")
 	})
 
+	It("responds to http/1.0 without a host with a 400", func() {
+		conn := dialProxy(proxyServer)
+
+		conn.WriteLines([]string{
+			"GET / HTTP/1.0",
+		})
+
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("missing_host"))
+	})
+
+	Context("with a default route", func() {
+		BeforeEach(func() {
+			conf.DefaultRoute = "legacy"
+		})
+
+		It("routes http/1.0 requests without a host to it", func() {
+			ln := registerHandler(r, "legacy", func(conn *test_util.HttpConn) {
+				req, _ := conn.ReadRequest()
+				Expect(req.Host).To(Equal("legacy"))
+
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			conn.WriteLines([]string{
+				"GET / HTTP/1.0",
+			})
+
+			conn.CheckLine("HTTP/1.0 200 OK")
+		})
+
+		It("routes requests with a host as usual", func() {
+			ln := registerHandler(r, "test", func(conn *test_util.HttpConn) {
+				conn.CheckLine("GET / HTTP/1.1")
+
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			conn.WriteLines([]string{
+				"GET / HTTP/1.0",
+				"Host: test",
+			})
+
+			conn.CheckLine("HTTP/1.0 200 OK")
+		})
+	})
+
 	It("responds to HTTP/1.1", func() {
 		ln := registerHandler(r, "test", func(conn *test_util.HttpConn) {
 			conn.CheckLine("GET / HTTP/1.1")
@@ -150,946 +226,5373 @@ var _ = Describe("Proxy", func() {
 		Expect(resp.StatusCode).To(Equal(http.StatusOK))
 	})
 
-	It("does not respond to unsupported HTTP versions", func() {
-		conn := dialProxy(proxyServer)
+	It("rewrites the Host of requests to a backend registered with a host header override", func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer ln.Close()
 
-		conn.WriteLines([]string{
-			"GET / HTTP/0.9",
-			"Host: test",
+		go runBackendInstance(ln, func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(req.Host).To(Equal("backend.internal"))
+			Expect(req.Header.Get("X-Forwarded-For")).To(Equal("127.0.0.1"))
+
+			conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+			conn.Close()
 		})
 
-		resp, _ := conn.ReadResponse()
-		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
-	})
+		host, portStr, err := net.SplitHostPort(ln.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		port, err := strconv.Atoi(portStr)
+		Expect(err).NotTo(HaveOccurred())
+
+		endpoint := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+		endpoint.HostHeader = "backend.internal"
+		r.Register(route.Uri("host-override"), endpoint)
 
-	It("responds to load balancer check", func() {
 		conn := dialProxy(proxyServer)
 
-		req := test_util.NewRequest("GET", "", "/", nil)
-		req.Header.Set("User-Agent", "HTTP-Monitor/1.1")
-		conn.WriteRequest(req)
+		conn.WriteRequest(test_util.NewRequest("GET", "host-override", "/", nil))
 
-		resp, body := conn.ReadResponse()
-		Expect(resp.Header.Get("Cache-Control")).To(Equal("private, max-age=0"))
-		Expect(resp.Header.Get("Expires")).To(Equal("0"))
-		Expect(body).To(Equal("ok\n"))
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
 	})
 
-	It("responds to unknown host with 404", func() {
-		conn := dialProxy(proxyServer)
+	It("rewrites the path of requests to a backend registered with a path rewrite", func() {
+		paths := make(chan string, 4)
 
-		req := test_util.NewRequest("GET", "unknown", "/", nil)
-		conn.WriteRequest(req)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer ln.Close()
 
-		resp, body := conn.ReadResponse()
-		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
-		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("unknown_route"))
-		Expect(body).To(Equal("404 Not Found: Requested route ('unknown') does not exist.\n"))
-	})
+		go runBackendInstance(ln, func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Expect(err).NotTo(HaveOccurred())
+			paths <- req.RequestURI
 
-	It("responds to misbehaving host with 502", func() {
-		ln := registerHandler(r, "enfant-terrible", func(conn *test_util.HttpConn) {
+			conn.WriteResponse(test_util.NewResponse(http.StatusOK))
 			conn.Close()
 		})
-		defer ln.Close()
 
-		conn := dialProxy(proxyServer)
+		// a backend that refuses the connection, so that a retry to the
+		// other one is rewritten from the client's path again
+		dead, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		dead.Close()
+
+		for _, l := range []net.Listener{ln, dead} {
+			host, portStr, err := net.SplitHostPort(l.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+			endpoint.PathRewrite = &route.PathRewrite{StripPrefix: "/api/v2"}
+			r.Register(route.Uri("path-rewrite"), endpoint)
+		}
 
-		req := test_util.NewRequest("GET", "enfant-terrible", "/", nil)
-		conn.WriteRequest(req)
+		for i := 0; i < 2; i++ {
+			conn := dialProxy(proxyServer)
 
-		resp, body := conn.ReadResponse()
-		Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
-		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("endpoint_failure"))
-		Expect(body).To(Equal("502 Bad Gateway: Registered endpoint failed to handle the request.\n"))
+			conn.WriteRequest(test_util.NewRequest("GET", "path-rewrite", "/api/v2/x?q=1", nil))
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(paths).To(Receive(Equal("/x?q=1")))
+		}
 	})
 
-	It("trace headers added on correct TraceKey", func() {
-		ln := registerHandler(r, "trace-test", func(conn *test_util.HttpConn) {
+	It("stops sending requests to a draining backend while its running request completes", func() {
+		received := make(chan struct{})
+		release := make(chan struct{})
+
+		draining := registerHandler(r, "drain", func(conn *test_util.HttpConn) {
 			_, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			Expect(err).NotTo(HaveOccurred())
+			close(received)
+			<-release
 
 			resp := test_util.NewResponse(http.StatusOK)
+			resp.Body = ioutil.NopCloser(strings.NewReader("draining"))
 			conn.WriteResponse(resp)
 			conn.Close()
 		})
-		defer ln.Close()
-
-		conn := dialProxy(proxyServer)
-
-		req := test_util.NewRequest("GET", "trace-test", "/", nil)
-		req.Header.Set(router_http.VcapTraceHeader, "my_trace_key")
-		conn.WriteRequest(req)
+		defer draining.Close()
 
-		resp, _ := conn.ReadResponse()
-		Expect(resp.StatusCode).To(Equal(http.StatusOK))
-		Expect(resp.Header.Get(router_http.VcapBackendHeader)).To(Equal(ln.Addr().String()))
-		Expect(resp.Header.Get(router_http.CfRouteEndpointHeader)).To(Equal(ln.Addr().String()))
-		Expect(resp.Header.Get(router_http.VcapRouterHeader)).To(Equal(conf.Ip))
-	})
+		running := dialProxy(proxyServer)
+		running.WriteRequest(test_util.NewRequest("GET", "drain", "/", nil))
+		Eventually(received).Should(BeClosed())
 
-	It("trace headers not added on incorrect TraceKey", func() {
-		ln := registerHandler(r, "trace-test", func(conn *test_util.HttpConn) {
+		other := registerHandler(r, "drain", func(conn *test_util.HttpConn) {
 			_, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			Expect(err).NotTo(HaveOccurred())
 
 			resp := test_util.NewResponse(http.StatusOK)
+			resp.Body = ioutil.NopCloser(strings.NewReader("other"))
 			conn.WriteResponse(resp)
 			conn.Close()
 		})
-		defer ln.Close()
+		defer other.Close()
 
-		conn := dialProxy(proxyServer)
+		host, portStr, err := net.SplitHostPort(draining.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		port, err := strconv.Atoi(portStr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.DrainBackend(host, uint16(port))).To(BeTrue())
 
-		req := test_util.NewRequest("GET", "trace-test", "/", nil)
-		req.Header.Set(router_http.VcapTraceHeader, "a_bad_trace_key")
-		conn.WriteRequest(req)
+		for i := 0; i < 5; i++ {
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "drain", "/", nil))
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("other"))
+		}
 
-		resp, _ := conn.ReadResponse()
-		Expect(resp.Header.Get(router_http.VcapBackendHeader)).To(Equal(""))
-		Expect(resp.Header.Get(router_http.CfRouteEndpointHeader)).To(Equal(""))
-		Expect(resp.Header.Get(router_http.VcapRouterHeader)).To(Equal(""))
+		close(release)
+		resp, body := running.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("draining"))
 	})
 
-	It("X-Forwarded-For is added", func() {
-		done := make(chan bool)
-
-		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+	It("responds to HEAD with the headers of the backend and no body", func() {
+		ln := registerHandler(r, "head", func(conn *test_util.HttpConn) {
 			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			Expect(err).NotTo(HaveOccurred())
 
-			resp := test_util.NewResponse(http.StatusOK)
-			conn.WriteResponse(resp)
+			if req.Method == "HEAD" {
+				conn.WriteLines([]string{
+					"HTTP/1.1 200 OK",
+					"Content-Length: 5",
+					"Connection: close",
+				})
+				// a misbehaving backend sending a body anyway
+				conn.Conn.Write([]byte("stray"))
+			} else {
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Body = ioutil.NopCloser(strings.NewReader("body"))
+				resp.ContentLength = 4
+				conn.WriteResponse(resp)
+			}
 			conn.Close()
-
-			done <- req.Header.Get("X-Forwarded-For") == "127.0.0.1"
 		})
 		defer ln.Close()
 
 		conn := dialProxy(proxyServer)
-		req := test_util.NewRequest("GET", "app", "/", nil)
-		conn.WriteRequest(req)
 
-		var answer bool
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(BeTrue())
+		req := test_util.NewRequest("HEAD", "head", "/", nil)
+		conn.WriteRequest(req)
 
-		conn.ReadResponse()
-	})
+		resp, err := http.ReadResponse(conn.Reader, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Length")).To(Equal("5"))
+		Expect(resp.ContentLength).To(Equal(int64(5)))
 
-	It("X-Forwarded-For is appended", func() {
-		done := make(chan bool)
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(body).To(BeEmpty())
 
-		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+		// nothing of the stray body is left on the connection
+		conn.WriteRequest(test_util.NewRequest("GET", "head", "/", nil))
 
-			resp := test_util.NewResponse(http.StatusOK)
-			conn.WriteResponse(resp)
-			conn.Close()
+		resp, body2 := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(body2).To(Equal("body"))
+	})
 
-			done <- req.Header.Get("X-Forwarded-For") == "1.2.3.4, 127.0.0.1"
+	Context("when the client expects 100 Continue", func() {
+		BeforeEach(func() {
+			// leave time to wait for the backend's interim response
+			conf.EndpointTimeout = 2 * time.Second
 		})
-		defer ln.Close()
 
-		conn := dialProxy(proxyServer)
+		It("relays the backend's interim response before the body is sent", func() {
+			ln := registerHandler(r, "continue", func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(req.Header.Get("Expect")).To(Equal("100-continue"))
 
-		req := test_util.NewRequest("GET", "app", "/", nil)
-		req.Header.Add("X-Forwarded-For", "1.2.3.4")
-		conn.WriteRequest(req)
+				conn.WriteLines([]string{"HTTP/1.1 100 Continue"})
 
-		var answer bool
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(BeTrue())
+				body, err := ioutil.ReadAll(req.Body)
+				Expect(err).NotTo(HaveOccurred())
 
-		conn.ReadResponse()
-	})
+				resp := test_util.NewResponse(http.StatusCreated)
+				resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+				resp.ContentLength = int64(len(body))
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
 
-	It("X-Request-Start is appended", func() {
-		done := make(chan string)
+			conn := dialProxy(proxyServer)
+			conn.WriteLines([]string{
+				"POST / HTTP/1.1",
+				"Host: continue",
+				"Expect: 100-continue",
+				"Content-Length: 5",
+			})
 
-		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			// the body is held back until the interim response arrives
+			resp, err := http.ReadResponse(conn.Reader, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusContinue))
 
-			resp := test_util.NewResponse(http.StatusOK)
-			conn.WriteResponse(resp)
-			conn.Close()
+			conn.Writer.WriteString("hello")
+			conn.Writer.Flush()
 
-			done <- req.Header.Get("X-Request-Start")
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			Expect(body).To(Equal("hello"))
 		})
-		defer ln.Close()
-
-		conn := dialProxy(proxyServer)
-
-		req := test_util.NewRequest("GET", "app", "/", nil)
-		conn.WriteRequest(req)
 
-		var answer string
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(MatchRegexp("^\\d{10}\\d{3}$")) // unix timestamp millis
+		It("sends the body anyway to a backend that does not answer with 100 Continue", func() {
+			ln := registerHandler(r, "no-continue", func(conn *test_util.HttpConn) {
+				req, body := conn.ReadRequest()
+				Expect(req.Header.Get("Expect")).To(Equal("100-continue"))
 
-		conn.ReadResponse()
-	})
+				resp := test_util.NewResponse(http.StatusCreated)
+				resp.Body = ioutil.NopCloser(strings.NewReader(body))
+				resp.ContentLength = int64(len(body))
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
 
-	It("X-Request-Start is not overwritten", func() {
-		done := make(chan []string)
+			conn := dialProxy(proxyServer)
+			conn.WriteLines([]string{
+				"POST / HTTP/1.1",
+				"Host: no-continue",
+				"Expect: 100-continue",
+				"Content-Length: 5",
+			})
 
-		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			resp, err := http.ReadResponse(conn.Reader, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusContinue))
 
-			resp := test_util.NewResponse(http.StatusOK)
-			conn.WriteResponse(resp)
-			conn.Close()
+			conn.Writer.WriteString("hello")
+			conn.Writer.Flush()
 
-			done <- req.Header[http.CanonicalHeaderKey("X-Request-Start")]
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			Expect(body).To(Equal("hello"))
 		})
-		defer ln.Close()
 
-		conn := dialProxy(proxyServer)
+		It("relays a final response sent instead of 100 Continue without asking for the body", func() {
+			ln := registerHandler(r, "expectation-failed", func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(req.Header.Get("Expect")).To(Equal("100-continue"))
 
-		req := test_util.NewRequest("GET", "app", "/", nil)
-		req.Header.Add("X-Request-Start", "") // impl cannot just check for empty string
-		req.Header.Add("X-Request-Start", "user-set2")
-		conn.WriteRequest(req)
+				// closing the connection, so that the transport does not send
+				// the body after all to keep it
+				resp := test_util.NewResponse(http.StatusExpectationFailed)
+				resp.Close = true
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
 
-		var answer []string
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(Equal([]string{"", "user-set2"}))
+			conn := dialProxy(proxyServer)
+			conn.WriteLines([]string{
+				"POST / HTTP/1.1",
+				"Host: expectation-failed",
+				"Expect: 100-continue",
+				"Content-Length: 5",
+			})
 
-		conn.ReadResponse()
+			resp, err := http.ReadResponse(conn.Reader, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusExpectationFailed))
+		})
 	})
 
-	It("X-VcapRequest-Id header is added", func() {
-		done := make(chan string)
-
-		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
-
-			resp := test_util.NewResponse(http.StatusOK)
-			conn.WriteResponse(resp)
-			conn.Close()
+	It("does not respond to unsupported HTTP versions", func() {
+		conn := dialProxy(proxyServer)
 
-			done <- req.Header.Get(router_http.VcapRequestIdHeader)
+		conn.WriteLines([]string{
+			"GET / HTTP/0.9",
+			"Host: test",
 		})
-		defer ln.Close()
 
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	It("responds to load balancer check", func() {
 		conn := dialProxy(proxyServer)
 
-		req := test_util.NewRequest("GET", "app", "/", nil)
+		req := test_util.NewRequest("GET", "", "/", nil)
+		req.Header.Set("User-Agent", "HTTP-Monitor/1.1")
 		conn.WriteRequest(req)
 
-		var answer string
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(MatchRegexp(uuid_regex))
-
-		conn.ReadResponse()
+		resp, body := conn.ReadResponse()
+		Expect(resp.Header.Get("Cache-Control")).To(Equal("private, max-age=0"))
+		Expect(resp.Header.Get("Expires")).To(Equal("0"))
+		Expect(body).To(Equal("ok\n"))
 	})
 
-	It("X-Vcap-Request-Id header is overwritten", func() {
-		done := make(chan string)
+	Context("with a load balancer health check path", func() {
+		BeforeEach(func() {
+			conf.LoadBalancerHealthCheckPath = "/lb-healthz"
+		})
 
-		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+		It("responds ok to the path without a backend while other paths are routed", func() {
+			var served int32
+			ln := registerHandler(r, "lb-check", func(conn *test_util.HttpConn) {
+				req, _ := conn.ReadRequest()
+				atomic.AddInt32(&served, 1)
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Body = ioutil.NopCloser(strings.NewReader("backend " + req.URL.Path))
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
 
-			resp := test_util.NewResponse(http.StatusOK)
-			conn.WriteResponse(resp)
-			conn.Close()
+			for _, host := range []string{"", "lb-check"} {
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", host, "/lb-healthz", nil))
 
-			done <- req.Header.Get(router_http.VcapRequestIdHeader)
-		})
-		defer ln.Close()
+				resp, body := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Cache-Control")).To(Equal("private, max-age=0"))
+				Expect(body).To(Equal("ok\n"))
+			}
+			Expect(atomic.LoadInt32(&served)).To(BeZero())
 
-		conn := dialProxy(proxyServer)
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "lb-check", "/lb-healthz/app", nil))
 
-		req := test_util.NewRequest("GET", "app", "/", nil)
-		req.Header.Add(router_http.VcapRequestIdHeader, "A-BOGUS-REQUEST-ID")
-		conn.WriteRequest(req)
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("backend /lb-healthz/app"))
+		})
+	})
 
-		var answer string
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).ToNot(Equal("A-BOGUS-REQUEST-ID"))
-		Expect(answer).To(MatchRegexp(uuid_regex))
+	Context("with a configured load balancer check response", func() {
+		BeforeEach(func() {
+			conf.LoadBalancerHealthCheckPath = "/lb-healthz"
+			conf.HealthCheck.Status = http.StatusNoContent
+			conf.HealthCheck.Body = ""
+		})
 
-		conn.ReadResponse()
-	})
+		It("answers checks with the configured status and body", func() {
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "", "/", nil)
+			req.Header.Set("User-Agent", "HTTP-Monitor/1.1")
+			conn.WriteRequest(req)
 
-	It("X-CF-InstanceID header is added literally if present in the routing endpoint", func() {
-		done := make(chan string)
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(body).To(BeEmpty())
 
-		ln := registerHandlerWithInstanceId(r, "app", "", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			conn = dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "", "/lb-healthz", nil))
 
-			resp := test_util.NewResponse(http.StatusOK)
-			conn.WriteResponse(resp)
-			conn.Close()
+			resp, body = conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(body).To(BeEmpty())
+		})
 
-			done <- req.Header.Get(router_http.CfInstanceIdHeader)
-		}, "fake-instance-id")
-		defer ln.Close()
+		Context("with a body", func() {
+			BeforeEach(func() {
+				conf.HealthCheck.Status = http.StatusAccepted
+				conf.HealthCheck.Body = "healthy"
+			})
 
-		conn := dialProxy(proxyServer)
+			It("answers checks with the configured body", func() {
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", "", "/lb-healthz", nil))
 
-		req := test_util.NewRequest("GET", "app", "/", nil)
-		conn.WriteRequest(req)
+				resp, body := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(body).To(Equal("healthy"))
+			})
+		})
 
-		var answer string
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(Equal("fake-instance-id"))
+		It("answers checks with a 503 while draining", func() {
+			Expect(p.Drain(time.Second)).To(Succeed())
 
-		conn.ReadResponse()
-	})
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "", "/lb-healthz", nil))
 
-	It("emits HTTP start events", func() {
-		ln := registerHandlerWithInstanceId(r, "app", "", func(conn *test_util.HttpConn) {
-		}, "fake-instance-id")
-		defer ln.Close()
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("draining"))
+		})
+	})
 
+	It("responds to unknown host with 404", func() {
 		conn := dialProxy(proxyServer)
 
-		fakeEmitter := fake.NewFakeEventEmitter("fake")
-		dropsonde.InitializeWithEmitter(fakeEmitter)
-
-		req := test_util.NewRequest("GET", "app", "/", nil)
+		req := test_util.NewRequest("GET", "unknown", "/", nil)
 		conn.WriteRequest(req)
 
-		findStartEvent := func() *events.HttpStart {
-			for _, event := range fakeEmitter.GetEvents() {
-				startEvent, ok := event.(*events.HttpStart)
-				if ok {
-					return startEvent
-				}
-			}
-
-			return nil
-		}
+		resp, body := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("unknown_route"))
+		Expect(body).To(Equal("404 Not Found: Requested route ('unknown') does not exist.\n"))
+	})
 
-		Eventually(findStartEvent).ShouldNot(BeNil())
-		Expect(findStartEvent().GetInstanceId()).To(Equal("fake-instance-id"))
+	It("routes hosts differing in case, port or trailing dot to the registered route", func() {
+		ln := registerHandler(r, "test", func(conn *test_util.HttpConn) {
+			conn.ReadRequest()
+			conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+			conn.Close()
+		})
+		defer ln.Close()
 
-		conn.ReadResponse()
-	})
+		for _, host := range []string{"Test:80", "TEST", "test.", "test.:8080"} {
+			conn := dialProxy(proxyServer)
 
-	It("X-CF-InstanceID header is added with host:port information if NOT present in the routing endpoint", func() {
-		done := make(chan string)
+			req := test_util.NewRequest("GET", host, "/", nil)
+			conn.WriteRequest(req)
 
-		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK), host)
+		}
+	})
 
+	It("responds to a pruned host with 404", func() {
+		ln := registerHandler(r, "stale", func(conn *test_util.HttpConn) {
+			conn.CheckLine("GET / HTTP/1.1")
 			resp := test_util.NewResponse(http.StatusOK)
 			conn.WriteResponse(resp)
 			conn.Close()
-
-			done <- req.Header.Get(router_http.CfInstanceIdHeader)
 		})
 		defer ln.Close()
 
+		r.Lookup(route.Uri("stale")).MarkUpdated(time.Now().Add(-conf.DropletStaleThreshold - time.Second))
+		r.Prune()
+
 		conn := dialProxy(proxyServer)
 
-		req := test_util.NewRequest("GET", "app", "/", nil)
+		req := test_util.NewRequest("GET", "stale", "/", nil)
 		conn.WriteRequest(req)
 
-		var answer string
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(MatchRegexp(`^\d+(\.\d+){3}:\d+$`))
-
-		conn.ReadResponse()
+		resp, body := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("unknown_route"))
+		Expect(body).To(Equal("404 Not Found: Requested route ('stale') does not exist.\n"))
 	})
 
-	It("upgrades for a WebSocket request", func() {
-		done := make(chan bool)
-
-		ln := registerHandler(r, "ws", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
-
-			done <- req.Header.Get("Upgrade") == "WebsockeT" &&
-				req.Header.Get("Connection") == "UpgradE"
-
-			resp := test_util.NewResponse(http.StatusSwitchingProtocols)
-			resp.Header.Set("Upgrade", "WebsockeT")
-			resp.Header.Set("Connection", "UpgradE")
-
+	It("responds to an unregistered host with 404", func() {
+		ln := registerHandler(r, "gone", func(conn *test_util.HttpConn) {
+			conn.CheckLine("GET / HTTP/1.1")
+			resp := test_util.NewResponse(http.StatusOK)
 			conn.WriteResponse(resp)
-
-			conn.CheckLine("hello from client")
-			conn.WriteLine("hello from server")
 			conn.Close()
 		})
 		defer ln.Close()
 
-		conn := dialProxy(proxyServer)
+		var endpoint *route.Endpoint
+		r.Lookup(route.Uri("gone")).Each(func(e *route.Endpoint) {
+			endpoint = e
+		})
+		r.Unregister(route.Uri("gone"), endpoint)
 
-		req := test_util.NewRequest("GET", "ws", "/chat", nil)
-		req.Header.Set("Upgrade", "WebsockeT")
-		req.Header.Set("Connection", "UpgradE")
+		conn := dialProxy(proxyServer)
 
+		req := test_util.NewRequest("GET", "gone", "/", nil)
 		conn.WriteRequest(req)
 
-		var answer bool
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(BeTrue())
-
 		resp, _ := conn.ReadResponse()
-		Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
-		Expect(resp.Header.Get("Upgrade")).To(Equal("WebsockeT"))
-		Expect(resp.Header.Get("Connection")).To(Equal("UpgradE"))
-
-		conn.WriteLine("hello from client")
-		conn.CheckLine("hello from server")
-
-		conn.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("unknown_route"))
 	})
 
-	It("upgrades for a WebSocket request with comma-separated Connection header", func() {
-		done := make(chan bool)
+	It("responds with 503 and Retry-After when no endpoint of a route is healthy", func() {
+		ln := registerHandler(r, "sick-app", func(conn *test_util.HttpConn) {
+			conn.CheckLine("GET / HTTP/1.1")
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+		})
+		defer ln.Close()
 
-		ln := registerHandler(r, "ws-cs-header", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+		pool := r.Lookup(route.Uri("sick-app"))
+		var endpoint *route.Endpoint
+		pool.Each(func(e *route.Endpoint) {
+			endpoint = e
+		})
+		pool.MarkUnhealthy(endpoint)
 
-			done <- req.Header.Get("Upgrade") == "Websocket" &&
-				req.Header.Get("Connection") == "keep-alive, Upgrade"
+		conn := dialProxy(proxyServer)
 
-			resp := test_util.NewResponse(http.StatusSwitchingProtocols)
-			resp.Header.Set("Upgrade", "Websocket")
-			resp.Header.Set("Connection", "Upgrade")
+		req := test_util.NewRequest("GET", "sick-app", "/", nil)
+		conn.WriteRequest(req)
 
-			conn.WriteResponse(resp)
+		resp, body := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(resp.Header.Get("Retry-After")).To(Equal("5"))
+		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("no_endpoints"))
+		Expect(body).To(Equal("503 Service Unavailable: Registered endpoints are unavailable.\n"))
+	})
 
-			conn.CheckLine("hello from client")
-			conn.WriteLine("hello from server")
+	It("responds to misbehaving host with 502", func() {
+		ln := registerHandler(r, "enfant-terrible", func(conn *test_util.HttpConn) {
 			conn.Close()
 		})
 		defer ln.Close()
 
 		conn := dialProxy(proxyServer)
 
-		req := test_util.NewRequest("GET", "ws-cs-header", "/chat", nil)
-		req.Header.Add("Upgrade", "Websocket")
-		req.Header.Add("Connection", "keep-alive, Upgrade")
-
+		req := test_util.NewRequest("GET", "enfant-terrible", "/", nil)
 		conn.WriteRequest(req)
 
-		var answer bool
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(BeTrue())
+		resp, body := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("endpoint_failure"))
+		Expect(body).To(Equal("502 Bad Gateway: Registered endpoint failed to handle the request.\n"))
+	})
 
-		resp, _ := conn.ReadResponse()
-		Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+	It("responds with 502 to a backend sending something other than HTTP", func() {
+		ln := registerHandler(r, "garbage", func(conn *test_util.HttpConn) {
+			conn.ReadRequest()
+			conn.WriteLine("\x00\x01 this is not HTTP")
+			conn.Close()
+		})
+		defer ln.Close()
 
-		Expect(resp.Header.Get("Upgrade")).To(Equal("Websocket"))
-		Expect(resp.Header.Get("Connection")).To(Equal("Upgrade"))
+		conn := dialProxy(proxyServer)
 
-		conn.WriteLine("hello from client")
-		conn.CheckLine("hello from server")
+		req := test_util.NewRequest("GET", "garbage", "/", nil)
+		conn.WriteRequest(req)
 
-		conn.Close()
+		resp, body := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("endpoint_failure"))
+		Expect(body).To(Equal("502 Bad Gateway: Registered endpoint failed to handle the request.\n"))
 	})
 
-	It("upgrades for a WebSocket request with multiple Connection headers", func() {
-		done := make(chan bool)
+	Context("with a dial timeout", func() {
+		BeforeEach(func() {
+			conf.DialTimeout = 200 * time.Millisecond
+		})
 
-		ln := registerHandler(r, "ws-cs-header", func(conn *test_util.HttpConn) {
-			req, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+		It("responds with 502 quickly when the backend cannot be connected to", func() {
+			// a non-routable address, connection attempts are never answered
+			r.Register(route.Uri("black-hole"), route.NewEndpoint("", "10.255.255.1", 80, "", nil, -1, ""))
 
-			done <- req.Header.Get("Upgrade") == "Websocket" &&
-				req.Header[http.CanonicalHeaderKey("Connection")][0] == "keep-alive" &&
-				req.Header[http.CanonicalHeaderKey("Connection")][1] == "Upgrade"
+			conn := dialProxy(proxyServer)
 
-			resp := test_util.NewResponse(http.StatusSwitchingProtocols)
-			resp.Header.Set("Upgrade", "Websocket")
-			resp.Header.Set("Connection", "Upgrade")
+			req := test_util.NewRequest("GET", "black-hole", "/", nil)
+			started := time.Now()
+			conn.WriteRequest(req)
 
-			conn.WriteResponse(resp)
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("endpoint_failure"))
+			Expect(time.Since(started)).To(BeNumerically("<", 2*time.Second))
+		})
+	})
 
-			conn.CheckLine("hello from client")
-			conn.WriteLine("hello from server")
-			conn.Close()
+	Context("with a backend selector", func() {
+		BeforeEach(func() {
+			backendSelector = headerSelector{}
 		})
-		defer ln.Close()
 
-		conn := dialProxy(proxyServer)
+		It("routes to the backend chosen by the selector", func() {
+			var listeners []net.Listener
+			hits := make([]int32, 3)
+			for i := range hits {
+				i := i
+				ln := registerHandler(r, "selected", func(conn *test_util.HttpConn) {
+					_, err := http.ReadRequest(conn.Reader)
+					if err != nil {
+						conn.Close()
+						return
+					}
+
+					atomic.AddInt32(&hits[i], 1)
+					conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+					conn.Close()
+				})
+				defer ln.Close()
+				listeners = append(listeners, ln)
+			}
 
-		req := test_util.NewRequest("GET", "ws-cs-header", "/chat", nil)
-		req.Header.Add("Upgrade", "Websocket")
-		req.Header.Add("Connection", "keep-alive")
+			for j := 0; j < 5; j++ {
+				conn := dialProxy(proxyServer)
+				req := test_util.NewRequest("GET", "selected", "/", nil)
+				req.Header.Set("X-Backend", listeners[1].Addr().String())
+				conn.WriteRequest(req)
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				conn.Close()
+			}
+
+			Expect(atomic.LoadInt32(&hits[0])).To(BeZero())
+			Expect(atomic.LoadInt32(&hits[1])).To(Equal(int32(5)))
+			Expect(atomic.LoadInt32(&hits[2])).To(BeZero())
+		})
+
+		It("responds with 503 when the selector declines every backend", func() {
+			ln := registerHandler(r, "selected", func(conn *test_util.HttpConn) {
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "selected", "/", nil))
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+
+	Context("with route groups", func() {
+		respondWith := func(name string) connHandler {
+			return func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Body = ioutil.NopCloser(strings.NewReader(name))
+				conn.WriteResponse(resp)
+				conn.Close()
+			}
+		}
+
+		registerGroup := func(path string, match route.Match, handler connHandler) net.Listener {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			go runBackendInstance(ln, handler)
+
+			host, portStr, err := net.SplitHostPort(ln.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+			endpoint.Match = &match
+			r.Register(route.Uri(path), endpoint)
+
+			return ln
+		}
+
+		send := func(req *http.Request) string {
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+
+			conn.WriteRequest(req)
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			return body
+		}
+
+		It("routes requests matching a group to its backends", func() {
+			ln := registerHandler(r, "api", respondWith("default"))
+			defer ln.Close()
+			canary := registerGroup("api", route.Match{Header: "X-Canary", Value: "true"}, respondWith("canary"))
+			defer canary.Close()
+			writes := registerGroup("api", route.Match{Method: "POST"}, respondWith("writes"))
+			defer writes.Close()
+
+			req := test_util.NewRequest("GET", "api", "/", nil)
+			req.Header.Set("X-Canary", "true")
+			Expect(send(req)).To(Equal("canary"))
+
+			req = test_util.NewRequest("POST", "api", "/", nil)
+			req.Header.Set("X-Canary", "true")
+			Expect(send(req)).To(Equal("canary"))
+
+			Expect(send(test_util.NewRequest("POST", "api", "/", nil))).To(Equal("writes"))
+
+			req = test_util.NewRequest("GET", "api", "/", nil)
+			req.Header.Set("X-Canary", "false")
+			Expect(send(req)).To(Equal("default"))
+		})
+
+		It("mirrors requests to a shadow backend and discards its response", func() {
+			ln := registerHandler(r, "mirrored", respondWith("primary"))
+			defer ln.Close()
+
+			mirrored := make(chan string, 1)
+			shadow := registerGroup("mirrored", route.Match{MirrorPercent: 100}, func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				body, _ := ioutil.ReadAll(req.Body)
+				mirrored <- req.Method + " " + req.URL.Path + " " + string(body)
+
+				resp := test_util.NewResponse(http.StatusInternalServerError)
+				resp.Body = ioutil.NopCloser(strings.NewReader("shadow"))
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer shadow.Close()
+
+			Expect(send(test_util.NewRequest("POST", "mirrored", "/orders", strings.NewReader("order")))).To(Equal("primary"))
+			Eventually(mirrored).Should(Receive(Equal("POST /orders order")))
+		})
+
+		It("drops the mirrors of requests while too many are in flight", func() {
+			ln := registerHandler(r, "mirrored", respondWith("primary"))
+			defer ln.Close()
+
+			var received int32
+			stalled := make(chan struct{})
+			defer close(stalled)
+			shadow := registerGroup("mirrored", route.Match{MirrorPercent: 100}, func(conn *test_util.HttpConn) {
+				defer conn.Close()
+
+				if _, err := http.ReadRequest(conn.Reader); err == nil {
+					atomic.AddInt32(&received, 1)
+				}
+				<-stalled
+			})
+			defer shadow.Close()
+
+			for i := 0; i < 110; i++ {
+				Expect(send(test_util.NewRequest("GET", "mirrored", "/", nil))).To(Equal("primary"))
+			}
+
+			Eventually(func() int32 { return atomic.LoadInt32(&received) }).Should(Equal(int32(100)))
+			Consistently(func() int32 { return atomic.LoadInt32(&received) }, 200*time.Millisecond).Should(Equal(int32(100)))
+		})
+
+		It("answers without waiting for a shadow backend that does not read the body", func() {
+			ln := registerHandler(r, "mirrored", func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				body, _ := ioutil.ReadAll(req.Body)
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
+
+			stalled := make(chan struct{})
+			defer close(stalled)
+			shadow := registerGroup("mirrored", route.Match{MirrorPercent: 100}, func(conn *test_util.HttpConn) {
+				defer conn.Close()
+				<-stalled
+			})
+			defer shadow.Close()
+
+			body := strings.Repeat("x", 32*1024)
+			Expect(send(test_util.NewRequest("POST", "mirrored", "/", strings.NewReader(body)))).To(Equal(body))
+		})
+
+		It("answers from the primary when the shadow backend fails", func() {
+			ln := registerHandler(r, "mirrored", respondWith("primary"))
+			defer ln.Close()
+
+			shadow := registerGroup("mirrored", route.Match{MirrorPercent: 100}, func(conn *test_util.HttpConn) {
+				conn.Close()
+			})
+			defer shadow.Close()
+
+			Expect(send(test_util.NewRequest("GET", "mirrored", "/", nil))).To(Equal("primary"))
+		})
+	})
+
+	Context("with response headers configured", func() {
+		BeforeEach(func() {
+			conf.ResponseHeaders = config.ResponseHeadersConfig{
+				Add:    []config.HeaderConfig{{Name: "X-Frame-Options", Value: "DENY"}},
+				Remove: []string{"Server"},
+			}
+		})
+
+		It("adds and strips headers of the backend's response", func() {
+			ln := registerHandler(r, "headers", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+
+				conn.WriteLines([]string{
+					"HTTP/1.1 200 OK",
+					"server: leaky/1.0",
+					"X-Frame-Options: SAMEORIGIN",
+					"X-Backend: kept",
+					"Content-Length: 0",
+				})
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "headers", "/", nil))
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header).NotTo(HaveKey("Server"))
+			Expect(resp.Header["X-Frame-Options"]).To(Equal([]string{"DENY"}))
+			Expect(resp.Header.Get("X-Backend")).To(Equal("kept"))
+		})
+
+		It("sets the headers registered with a route on its responses only", func() {
+			handler := func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			}
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer ln.Close()
+			go runBackendInstance(ln, handler)
+
+			host, portStr, err := net.SplitHostPort(ln.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+			endpoint.ResponseHeaders = map[string]string{
+				"Content-Security-Policy": "default-src 'self'",
+				"X-Frame-Options":         "SAMEORIGIN",
+			}
+			r.Register("csp", endpoint)
+
+			plain := registerHandler(r, "plain", handler)
+			defer plain.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "csp", "/", nil))
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Security-Policy")).To(Equal("default-src 'self'"))
+			Expect(resp.Header["X-Frame-Options"]).To(Equal([]string{"SAMEORIGIN"}))
+
+			conn = dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "plain", "/", nil))
+
+			resp, _ = conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header).NotTo(HaveKey("Content-Security-Policy"))
+			Expect(resp.Header["X-Frame-Options"]).To(Equal([]string{"DENY"}))
+		})
+	})
+
+	Context("hop-by-hop headers", func() {
+		BeforeEach(func() {
+			conf.HopByHopHeaders = []string{"X-Edge-Session"}
+		})
+
+		It("strips them from the request and the response", func() {
+			ln := registerHandler(r, "hops", func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(req.Header).NotTo(HaveKey("X-Client-Hop"))
+				Expect(req.Header).NotTo(HaveKey("Keep-Alive"))
+				Expect(req.Header).NotTo(HaveKey("Proxy-Authorization"))
+				Expect(req.Header).NotTo(HaveKey("X-Edge-Session"))
+				Expect(req.Header.Get("X-End-To-End")).To(Equal("kept"))
+
+				conn.WriteLines([]string{
+					"HTTP/1.1 200 OK",
+					"Connection: X-Backend-Hop",
+					"X-Backend-Hop: internal",
+					"X-Edge-Session: internal",
+					"X-Backend: kept",
+					"Content-Length: 0",
+				})
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "hops", "/", nil)
+			req.Header.Set("Connection", "keep-alive, X-Client-Hop")
+			req.Header.Set("X-Client-Hop", "secret")
+			req.Header.Set("Keep-Alive", "timeout=5")
+			req.Header.Set("Proxy-Authorization", "Basic c2VjcmV0")
+			req.Header.Set("X-Edge-Session", "secret")
+			req.Header.Set("X-End-To-End", "kept")
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header).NotTo(HaveKey("X-Backend-Hop"))
+			Expect(resp.Header).NotTo(HaveKey("X-Edge-Session"))
+			Expect(resp.Header.Get("X-Backend")).To(Equal("kept"))
+		})
+	})
+
+	Context("Via", func() {
+		It("appends the router to the Via header of the request and the response", func() {
+			ln := registerHandler(r, "via", func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(req.Header["Via"]).To(Equal([]string{"1.0 client-proxy", "1.1 gorouter"}))
+
+				conn.WriteLines([]string{
+					"HTTP/1.1 200 OK",
+					"Via: 1.1 backend-proxy",
+					"Content-Length: 0",
+				})
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "via", "/", nil)
+			req.Header.Set("Via", "1.0 client-proxy")
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header["Via"]).To(Equal([]string{"1.1 backend-proxy", "1.1 gorouter"}))
+		})
+
+		It("names the protocol version the request was received with", func() {
+			ln := registerHandler(r, "via", func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(req.Header.Get("Via")).To(Equal("1.0 gorouter"))
+
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteLines([]string{
+				"GET / HTTP/1.0",
+				"Host: via",
+			})
+
+			conn.CheckLine("HTTP/1.0 200 OK")
+		})
+
+		Context("with a pseudonym configured", func() {
+			BeforeEach(func() {
+				conf.ViaPseudonym = "edge-1"
+			})
+
+			It("identifies the router by the pseudonym", func() {
+				ln := registerHandler(r, "via", func(conn *test_util.HttpConn) {
+					req, err := http.ReadRequest(conn.Reader)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(req.Header.Get("Via")).To(Equal("1.1 edge-1"))
+
+					conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+					conn.Close()
+				})
+				defer ln.Close()
+
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", "via", "/", nil))
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.Header.Get("Via")).To(Equal("1.1 edge-1"))
+			})
+		})
+
+		Context("without a pseudonym", func() {
+			BeforeEach(func() {
+				conf.ViaPseudonym = ""
+			})
+
+			It("leaves the Via header alone", func() {
+				ln := registerHandler(r, "via", func(conn *test_util.HttpConn) {
+					req, err := http.ReadRequest(conn.Reader)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(req.Header).NotTo(HaveKey("Via"))
+
+					conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+					conn.Close()
+				})
+				defer ln.Close()
+
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", "via", "/", nil))
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.Header).NotTo(HaveKey("Via"))
+			})
+		})
+	})
+
+	Context("timing header", func() {
+		var ln net.Listener
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "timing", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+				time.Sleep(50 * time.Millisecond)
+
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		It("is not added by default", func() {
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "timing", "/", nil))
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header).NotTo(HaveKey("X-Gorouter-Time"))
+		})
+
+		Context("when exposed", func() {
+			BeforeEach(func() {
+				conf.ExposeTimingHeader = true
+			})
+
+			It("reports the milliseconds the router spent on the request", func() {
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", "timing", "/", nil))
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				ms, err := strconv.Atoi(resp.Header.Get("X-Gorouter-Time"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ms).To(BeNumerically(">=", 50))
+				Expect(ms).To(BeNumerically("<", 5000))
+			})
+		})
+	})
+
+	Context("with custom error pages", func() {
+		BeforeEach(func() {
+			conf.ErrorPages = map[int]config.ErrorPage{
+				http.StatusNotFound: {
+					ContentType: "application/json",
+					Body:        template.Must(template.New("404").Parse(`{"host":"{{.Host}}","request_id":"{{.RequestId}}"}`)),
+				},
+			}
+		})
+
+		It("responds with the configured page for an unknown host", func() {
+			conn := dialProxy(proxyServer)
+
+			conn.WriteRequest(test_util.NewRequest("GET", "unknown", "/", nil))
+
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("unknown_route"))
+
+			var page map[string]string
+			Expect(json.Unmarshal([]byte(body), &page)).To(Succeed())
+			Expect(page["host"]).To(Equal("unknown"))
+			Expect(page["request_id"]).To(MatchRegexp(`^[0-9a-f-]{36}$`))
+		})
+
+		It("keeps the default body for other status codes", func() {
+			ln := registerHandler(r, "enfant-terrible", func(conn *test_util.HttpConn) {
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "enfant-terrible", "/", nil))
+
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+			Expect(body).To(Equal("502 Bad Gateway: Registered endpoint failed to handle the request.\n"))
+		})
+	})
+
+	It("retries idempotent requests against another backend", func() {
+		ln := registerHandler(r, "flaky", func(conn *test_util.HttpConn) {
+			conn.Close()
+		})
+		defer ln.Close()
+
+		ln2 := registerHandler(r, "flaky", func(conn *test_util.HttpConn) {
+			conn.CheckLine("GET / HTTP/1.1")
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+		})
+		defer ln2.Close()
+
+		for i := 0; i < 5; i++ {
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("GET", "flaky", "/", nil)
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+	})
+
+	It("does not retry requests that have sent a body", func() {
+		attempts := make(chan struct{}, 10)
+
+		ln := registerHandler(r, "flaky", func(conn *test_util.HttpConn) {
+			attempts <- struct{}{}
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("POST", "flaky", "/", strings.NewReader("some body"))
+		conn.WriteRequest(req)
+
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+		Expect(attempts).To(HaveLen(1))
+	})
+
+	It("streams request bodies to the backend as they arrive", func() {
+		size := 1024 * 1024
+		received := make(chan int, 1)
+
+		ln := registerHandler(r, "upload", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = io.ReadFull(req.Body, make([]byte, 1024))
+			Expect(err).NotTo(HaveOccurred())
+			received <- 1024
+
+			rest, err := ioutil.ReadAll(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+			received <- 1024 + len(rest)
+
+			conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+		conn.WriteLines([]string{
+			"POST / HTTP/1.1",
+			"Host: upload",
+			fmt.Sprintf("Content-Length: %d", size),
+		})
+		conn.Writer.Write(bytes.Repeat([]byte("a"), 1024))
+		conn.Writer.Flush()
+
+		Eventually(received).Should(Receive(Equal(1024)))
+
+		conn.Writer.Write(bytes.Repeat([]byte("a"), size-1024))
+		conn.Writer.Flush()
+
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(received).To(Receive(Equal(size)))
+	})
+
+	Context("when a backend refuses the connection", func() {
+		var (
+			bodies chan string
+			ln     net.Listener
+		)
+
+		BeforeEach(func() {
+			conf.RetryBodyBufferSize = 16
+		})
+
+		JustBeforeEach(func() {
+			bodies = make(chan string, 2)
+
+			var err error
+			ln, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			go runBackendInstance(ln, func(conn *test_util.HttpConn) {
+				_, body := conn.ReadRequest()
+				bodies <- body
+
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+
+			dead, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			dead.Close()
+
+			registerAddr(r, "upload", "", ln.Addr(), "")
+			registerAddr(r, "upload", "", dead.Addr(), "")
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		It("retries a request with a body up to the retry body buffer size", func() {
+			for i := 0; i < 2; i++ {
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("POST", "upload", "/", strings.NewReader("some body")))
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(bodies).To(Receive(Equal("some body")))
+			}
+		})
+
+		It("does not retry a request with a larger body", func() {
+			statuses := []int{}
+			for i := 0; i < 2; i++ {
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("POST", "upload", "/", strings.NewReader("a body too large to be buffered")))
+
+				resp, _ := conn.ReadResponse()
+				statuses = append(statuses, resp.StatusCode)
+			}
+
+			Expect(statuses).To(ConsistOf(http.StatusOK, http.StatusBadGateway))
+			Expect(bodies).To(Receive(Equal("a body too large to be buffered")))
+		})
+	})
+
+	It("trace headers added on correct TraceKey", func() {
+		ln := registerHandler(r, "trace-test", func(conn *test_util.HttpConn) {
+			_, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "trace-test", "/", nil)
+		req.Header.Set(router_http.VcapTraceHeader, "my_trace_key")
+		conn.WriteRequest(req)
+
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get(router_http.VcapBackendHeader)).To(Equal(ln.Addr().String()))
+		Expect(resp.Header.Get(router_http.CfRouteEndpointHeader)).To(Equal(ln.Addr().String()))
+		Expect(resp.Header.Get(router_http.VcapRouterHeader)).To(Equal(conf.Ip))
+	})
+
+	It("trace headers not added on incorrect TraceKey", func() {
+		ln := registerHandler(r, "trace-test", func(conn *test_util.HttpConn) {
+			_, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "trace-test", "/", nil)
+		req.Header.Set(router_http.VcapTraceHeader, "a_bad_trace_key")
+		conn.WriteRequest(req)
+
+		resp, _ := conn.ReadResponse()
+		Expect(resp.Header.Get(router_http.VcapBackendHeader)).To(Equal(""))
+		Expect(resp.Header.Get(router_http.CfRouteEndpointHeader)).To(Equal(""))
+		Expect(resp.Header.Get(router_http.VcapRouterHeader)).To(Equal(""))
+	})
+
+	It("X-Forwarded-For is added", func() {
+		done := make(chan bool)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get("X-Forwarded-For") == "127.0.0.1"
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		conn.WriteRequest(req)
+
+		var answer bool
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(BeTrue())
+
+		conn.ReadResponse()
+	})
+
+	It("X-Forwarded-For is appended", func() {
+		done := make(chan bool)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get("X-Forwarded-For") == "1.2.3.4, 127.0.0.1"
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		req.Header.Add("X-Forwarded-For", "1.2.3.4")
+		conn.WriteRequest(req)
+
+		var answer bool
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(BeTrue())
+
+		conn.ReadResponse()
+	})
+
+	Context("with a concurrent request limit", func() {
+		BeforeEach(func() {
+			conf.MaxConcurrentRequests = 3
+		})
+
+		It("sheds requests over the limit", func() {
+			release := make(chan struct{})
+			received := make(chan struct{}, 3)
+			ln := registerHandler(r, "slow", func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				received <- struct{}{}
+				<-release
+
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+			defer ln.Close()
+
+			results := make(chan int, 3)
+			for i := 0; i < 3; i++ {
+				go func() {
+					defer GinkgoRecover()
+					conn := dialProxy(proxyServer)
+					defer conn.Close()
+
+					conn.WriteRequest(test_util.NewRequest("GET", "slow", "/", nil))
+					resp, _ := conn.ReadResponse()
+					results <- resp.StatusCode
+				}()
+			}
+
+			for i := 0; i < 3; i++ {
+				Eventually(received).Should(Receive())
+			}
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "slow", "/", nil))
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("router_at_capacity"))
+			conn.Close()
+
+			close(release)
+			for i := 0; i < 3; i++ {
+				Eventually(results).Should(Receive(Equal(http.StatusOK)))
+			}
+
+			conn = dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "slow", "/", nil))
+			resp, _ = conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		Context("when a single request is allowed", func() {
+			BeforeEach(func() {
+				conf.MaxConcurrentRequests = 1
+			})
+
+			It("frees the slot of a closed WebSocket connection", func() {
+				ln := registerHandler(r, "ws", func(conn *test_util.HttpConn) {
+					req, err := http.ReadRequest(conn.Reader)
+					if err != nil {
+						conn.Close()
+						return
+					}
+
+					if req.Header.Get("Upgrade") == "" {
+						conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+						conn.Close()
+						return
+					}
+
+					resp := test_util.NewResponse(http.StatusSwitchingProtocols)
+					resp.Header.Set("Upgrade", "websocket")
+					resp.Header.Set("Connection", "upgrade")
+					conn.WriteResponse(resp)
+
+					conn.CheckLine("hello from client")
+					conn.WriteLine("hello from server")
+					conn.Close()
+				})
+				defer ln.Close()
+
+				conn := dialProxy(proxyServer)
+				req := test_util.NewRequest("GET", "ws", "/chat", nil)
+				req.Header.Set("Upgrade", "websocket")
+				req.Header.Set("Connection", "upgrade")
+				conn.WriteRequest(req)
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+				other := dialProxy(proxyServer)
+				other.WriteRequest(test_util.NewRequest("GET", "ws", "/", nil))
+				resp, _ = other.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+				other.Close()
+
+				conn.WriteLine("hello from client")
+				conn.CheckLine("hello from server")
+				conn.Close()
+
+				Eventually(func() int {
+					conn := dialProxy(proxyServer)
+					defer conn.Close()
+
+					conn.WriteRequest(test_util.NewRequest("GET", "ws", "/", nil))
+					resp, _ := conn.ReadResponse()
+					return resp.StatusCode
+				}).Should(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Context("with rate limiting", func() {
+		BeforeEach(func() {
+			conf.RateLimit.RequestsPerSecond = 1
+			conf.RateLimit.Burst = 3
+
+			_, network, err := net.ParseCIDR("127.0.0.0/8")
+			Expect(err).NotTo(HaveOccurred())
+			conf.TrustedProxyNetworks = []*net.IPNet{network}
+		})
+
+		It("rejects the requests of a client over its limit", func() {
+			var served int32
+			ln := registerHandler(r, "limited", func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				atomic.AddInt32(&served, 1)
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+			defer ln.Close()
+
+			sendFrom := func(client string) *http.Response {
+				conn := dialProxy(proxyServer)
+				defer conn.Close()
+
+				req := test_util.NewRequest("GET", "limited", "/", nil)
+				req.Header.Set("X-Forwarded-For", client)
+				conn.WriteRequest(req)
+
+				resp, _ := conn.ReadResponse()
+				return resp
+			}
+
+			for i := 0; i < 3; i++ {
+				Expect(sendFrom("1.1.1.1").StatusCode).To(Equal(http.StatusOK))
+			}
+
+			for i := 0; i < 2; i++ {
+				resp := sendFrom("1.1.1.1")
+				Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+				Expect(resp.Header.Get("Retry-After")).To(Equal("1"))
+				Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("rate_limited"))
+			}
+
+			Expect(sendFrom("2.2.2.2").StatusCode).To(Equal(http.StatusOK))
+			Expect(atomic.LoadInt32(&served)).To(Equal(int32(4)))
+		})
+	})
+
+	Context("with a requests per connection limit", func() {
+		BeforeEach(func() {
+			conf.MaxRequestsPerConn = 3
+		})
+
+		It("closes the client connection after the response to the last request", func() {
+			ln := registerHandler(r, "max-requests", func(conn *test_util.HttpConn) {
+				for {
+					if _, err := http.ReadRequest(conn.Reader); err != nil {
+						conn.Close()
+						return
+					}
+					conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				}
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+
+			for i := 1; i <= 3; i++ {
+				conn.WriteRequest(test_util.NewRequest("GET", "max-requests", "/", nil))
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Close).To(Equal(i == 3))
+			}
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err := conn.Reader.ReadByte()
+			Expect(err).To(Equal(io.EOF))
+		})
+	})
+
+	Context("with outlier detection", func() {
+		BeforeEach(func() {
+			conf.OutlierDetection.Interval = 100 * time.Millisecond
+			conf.OutlierDetection.MinRequests = 5
+			conf.OutlierDetection.DeviationFactor = 2
+			conf.OutlierDetection.EjectionTime = time.Minute
+		})
+
+		It("ejects a backend answering with more errors than its peers while the others keep serving", func() {
+			var failing, healthy int32
+
+			ln := registerHandler(r, "outlier", func(conn *test_util.HttpConn) {
+				for {
+					if _, err := http.ReadRequest(conn.Reader); err != nil {
+						conn.Close()
+						return
+					}
+					atomic.AddInt32(&failing, 1)
+					conn.WriteResponse(test_util.NewResponse(http.StatusInternalServerError))
+				}
+			})
+			defer ln.Close()
+
+			for i := 0; i < 3; i++ {
+				ln := registerHandler(r, "outlier", func(conn *test_util.HttpConn) {
+					for {
+						if _, err := http.ReadRequest(conn.Reader); err != nil {
+							conn.Close()
+							return
+						}
+						atomic.AddInt32(&healthy, 1)
+						conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+					}
+				})
+				defer ln.Close()
+			}
+
+			get := func() int {
+				conn := dialProxy(proxyServer)
+				defer conn.Close()
+
+				conn.WriteRequest(test_util.NewRequest("GET", "outlier", "/", nil))
+				resp, _ := conn.ReadResponse()
+				return resp.StatusCode
+			}
+
+			for i := 0; i < 40; i++ {
+				get()
+			}
+			Expect(atomic.LoadInt32(&failing)).To(BeNumerically(">=", 5))
+
+			// the first request after the interval has the backends evaluated
+			time.Sleep(100 * time.Millisecond)
+			get()
+
+			failed := atomic.LoadInt32(&failing)
+			served := atomic.LoadInt32(&healthy)
+			for i := 0; i < 20; i++ {
+				Expect(get()).To(Equal(http.StatusOK))
+			}
+			Expect(atomic.LoadInt32(&failing)).To(Equal(failed))
+			Expect(atomic.LoadInt32(&healthy)).To(Equal(served + 20))
+		})
+	})
+
+	Context("with forwarded client certificates", func() {
+		var (
+			headers    chan http.Header
+			ln         net.Listener
+			clientCert tls.Certificate
+		)
+
+		BeforeEach(func() {
+			conf.ForwardedClientCertHeader = "X-Forwarded-Client-Cert"
+
+			var err error
+			clientCert, err = tls.LoadX509KeyPair("../test/assets/public.pem", "../test/assets/private.pem")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		JustBeforeEach(func() {
+			headers = make(chan http.Header, 1)
+			ln = registerHandler(r, "mtls", func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				headers <- req.Header
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		// sendWithClientCert sends a request spoofing the header over a TLS
+		// connection on which the client presents its certificate
+		sendWithClientCert := func() http.Header {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			tlsProxyServer := tls.NewListener(l, &tls.Config{
+				Certificates: []tls.Certificate{clientCert},
+				ClientAuth:   tls.RequireAnyClientCert,
+			})
+			defer tlsProxyServer.Close()
+
+			server := http.Server{Handler: p}
+			go server.Serve(tlsProxyServer)
+
+			tlsConn, err := tls.Dial("tcp", tlsProxyServer.Addr().String(), &tls.Config{
+				Certificates:       []tls.Certificate{clientCert},
+				InsecureSkipVerify: true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			conn := test_util.NewHttpConn(tlsConn)
+			defer conn.Close()
+
+			req := test_util.NewRequest("GET", "mtls", "/", nil)
+			req.Header.Set("X-Forwarded-Client-Cert", "spoofed")
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var header http.Header
+			Eventually(headers).Should(Receive(&header))
+			return header
+		}
+
+		It("forwards the certificate the client presented over mTLS", func() {
+			header := sendWithClientCert()
+
+			Expect(header["X-Forwarded-Client-Cert"]).To(Equal([]string{
+				base64.StdEncoding.EncodeToString(clientCert.Certificate[0]),
+			}))
+		})
+
+		Context("in the fingerprint format", func() {
+			BeforeEach(func() {
+				conf.ForwardedClientCertFormat = config.ClientCertFormatFingerprint
+			})
+
+			It("forwards the SHA-256 fingerprint of the certificate", func() {
+				header := sendWithClientCert()
+
+				sum := sha256.Sum256(clientCert.Certificate[0])
+				Expect(header["X-Forwarded-Client-Cert"]).To(Equal([]string{hex.EncodeToString(sum[:])}))
+			})
+		})
+
+		It("strips the header sent by a client without a certificate", func() {
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("GET", "mtls", "/", nil)
+			req.Header.Set("X-Forwarded-Client-Cert", "spoofed")
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var header http.Header
+			Eventually(headers).Should(Receive(&header))
+			Expect(header).NotTo(HaveKey("X-Forwarded-Client-Cert"))
+		})
+	})
+
+	Context("with https forced", func() {
+		var (
+			served int32
+			ln     net.Listener
+		)
+
+		BeforeEach(func() {
+			served = 0
+			conf.ForceHTTPS = true
+			conf.SSLPort = 4443
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "secure", func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				atomic.AddInt32(&served, 1)
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		It("redirects plain http requests to the matching https url", func() {
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("GET", "secure:80", "/path/to?q=1&r=two", nil)
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusMovedPermanently))
+			Expect(resp.Header.Get("Location")).To(Equal("https://secure:4443/path/to?q=1&r=two"))
+			Expect(atomic.LoadInt32(&served)).To(BeZero())
+		})
+
+		Context("on the default https port", func() {
+			BeforeEach(func() {
+				conf.SSLPort = 443
+			})
+
+			It("leaves the port out of the redirect", func() {
+				conn := dialProxy(proxyServer)
+
+				conn.WriteRequest(test_util.NewRequest("GET", "secure", "/", nil))
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusMovedPermanently))
+				Expect(resp.Header.Get("Location")).To(Equal("https://secure/"))
+			})
+		})
+
+		It("proxies requests received over tls", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			tlsProxyServer := newTlsListener(l)
+			defer tlsProxyServer.Close()
+
+			server := http.Server{Handler: p}
+			go server.Serve(tlsProxyServer)
+
+			tlsConn, err := tls.Dial("tcp", tlsProxyServer.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+			Expect(err).NotTo(HaveOccurred())
+			conn := test_util.NewHttpConn(tlsConn)
+
+			conn.WriteRequest(test_util.NewRequest("GET", "secure", "/", nil))
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(atomic.LoadInt32(&served)).To(Equal(int32(1)))
+		})
+	})
+
+	Context("with an IP policy", func() {
+		var served int32
+
+		parseNetworks := func(cidrs ...string) []*net.IPNet {
+			var networks []*net.IPNet
+			for _, cidr := range cidrs {
+				_, network, err := net.ParseCIDR(cidr)
+				Expect(err).NotTo(HaveOccurred())
+				networks = append(networks, network)
+			}
+			return networks
+		}
+
+		send := func() *http.Response {
+			ln := registerHandler(r, "guarded", func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				atomic.AddInt32(&served, 1)
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+
+			conn.WriteRequest(test_util.NewRequest("GET", "guarded", "/", nil))
+
+			resp, _ := conn.ReadResponse()
+			return resp
+		}
+
+		BeforeEach(func() {
+			atomic.StoreInt32(&served, 0)
+			conf.IPAllowNetworks = parseNetworks("127.0.0.0/8")
+		})
+
+		It("serves an allowed client", func() {
+			Expect(send().StatusCode).To(Equal(http.StatusOK))
+			Expect(atomic.LoadInt32(&served)).To(Equal(int32(1)))
+		})
+
+		Context("when the client is also denied", func() {
+			BeforeEach(func() {
+				conf.IPDenyNetworks = parseNetworks("127.0.0.1/32")
+			})
+
+			It("rejects the client before routing the request", func() {
+				resp := send()
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("client_blocked"))
+				Expect(atomic.LoadInt32(&served)).To(BeZero())
+			})
+		})
+
+		It("applies a replaced policy to new requests", func() {
+			p.SetIPPolicy(parseNetworks("10.0.0.0/8"), nil)
+			Expect(send().StatusCode).To(Equal(http.StatusForbidden))
+
+			p.SetIPPolicy(nil, nil)
+			Expect(send().StatusCode).To(Equal(http.StatusOK))
+			Expect(atomic.LoadInt32(&served)).To(Equal(int32(1)))
+		})
+	})
+
+	Context("with TLS backends", func() {
+		var serverNames chan string
+
+		registerTLSHandler := func(path string, serverName string, handler connHandler) net.Listener {
+			cert, err := tls.LoadX509KeyPair("../test/assets/public.pem", "../test/assets/private.pem")
+			Expect(err).ToNot(HaveOccurred())
+
+			serverNames = make(chan string, 1)
+			tlsConfig := &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					select {
+					case serverNames <- hello.ServerName:
+					default:
+					}
+					return nil, nil
+				},
+			}
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			go runBackendInstance(tls.NewListener(ln, tlsConfig), handler)
+
+			host, portStr, err := net.SplitHostPort(ln.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+			endpoint.TLS = true
+			endpoint.ServerName = serverName
+			r.Register(route.Uri(path), endpoint)
+
+			return ln
+		}
+
+		tlsBackend := func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			if err != nil {
+				conn.Close()
+				return
+			}
+
+			Expect(req.Host).To(Equal("tls-app"))
+			Expect(req.URL.Path).To(Equal("/secure"))
+
+			resp := test_util.NewResponse(http.StatusOK)
+			resp.Body = ioutil.NopCloser(strings.NewReader("over tls"))
+			conn.WriteResponse(resp)
+			conn.Close()
+		}
+
+		Context("when skipping certificate validation", func() {
+			BeforeEach(func() {
+				conf.SSLSkipValidation = true
+			})
+
+			It("dials the backend over TLS", func() {
+				ln := registerTLSHandler("tls-app", "", tlsBackend)
+				defer ln.Close()
+
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", "tls-app", "/secure", nil))
+
+				resp, body := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(body).To(Equal("over tls"))
+			})
+
+			It("sends the registered server name", func() {
+				ln := registerTLSHandler("tls-app", "backend.internal", tlsBackend)
+				defer ln.Close()
+
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", "tls-app", "/secure", nil))
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Eventually(serverNames).Should(Receive(Equal("backend.internal")))
+			})
+		})
+
+		It("rejects a backend whose certificate cannot be verified", func() {
+			ln := registerTLSHandler("tls-app", "backend.internal", tlsBackend)
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "tls-app", "/secure", nil))
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+		})
+	})
+
+	Context("with trusted proxies", func() {
+		var forwardedFor chan string
+
+		trustNetworks := func(cidrs ...string) {
+			conf.TrustedProxyNetworks = nil
+			for _, cidr := range cidrs {
+				_, network, err := net.ParseCIDR(cidr)
+				Expect(err).NotTo(HaveOccurred())
+				conf.TrustedProxyNetworks = append(conf.TrustedProxyNetworks, network)
+			}
+		}
+
+		sendWithForwardedFor := func(xff string) {
+			forwardedFor = make(chan string, 1)
+
+			ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				Ω(err).NotTo(HaveOccurred())
+
+				forwardedFor <- req.Header.Get("X-Forwarded-For")
+
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("GET", "app", "/", nil)
+			req.Header.Set("X-Forwarded-For", xff)
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		accessLogLine := func() string {
+			var payload []byte
+			Eventually(func() int {
+				accessLogFile.Read(&payload)
+				return len(payload)
+			}).ShouldNot(BeZero())
+			return string(payload)
+		}
+
+		Context("when the request comes from a trusted proxy", func() {
+			BeforeEach(func() {
+				trustNetworks("127.0.0.0/8", "10.0.0.0/8")
+			})
+
+			It("takes the rightmost untrusted entry as the client and drops the spoofed ones", func() {
+				sendWithForwardedFor("6.6.6.6, 1.2.3.4, 10.0.0.5")
+
+				Eventually(forwardedFor).Should(Receive(Equal("1.2.3.4, 10.0.0.5, 127.0.0.1")))
+				Expect(accessLogLine()).To(ContainSubstring(` 1.2.3.4 x_forwarded_for:"1.2.3.4, 10.0.0.5"`))
+			})
+		})
+
+		Context("when the request comes from an untrusted peer", func() {
+			BeforeEach(func() {
+				trustNetworks("10.0.0.0/8")
+			})
+
+			It("ignores X-Forwarded-For and uses the peer address", func() {
+				sendWithForwardedFor("1.2.3.4")
+
+				Eventually(forwardedFor).Should(Receive(Equal("127.0.0.1")))
+
+				line := accessLogLine()
+				Expect(line).To(MatchRegexp(` 127\.0\.0\.1:\d+ x_forwarded_for:"-"`))
+				Expect(line).NotTo(ContainSubstring("1.2.3.4"))
+			})
+		})
+	})
+
+	It("X-Forwarded-Proto is set to http", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get("X-Forwarded-Proto")
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		conn.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(Equal("http"))
+
+		conn.ReadResponse()
+	})
+
+	It("X-Forwarded-Proto from the client is not trusted", func() {
+		done := make(chan []string)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header["X-Forwarded-Proto"]
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		req.Header.Add("X-Forwarded-Proto", "https")
+		conn.WriteRequest(req)
+
+		var answer []string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(Equal([]string{"http"}))
+
+		conn.ReadResponse()
+	})
+
+	It("X-Forwarded-Proto is set to https for TLS connections", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get("X-Forwarded-Proto")
+		})
+		defer ln.Close()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+		tlsProxyServer := newTlsListener(l)
+		defer tlsProxyServer.Close()
+
+		server := http.Server{Handler: p}
+		go server.Serve(tlsProxyServer)
+
+		tlsConn, err := tls.Dial("tcp", tlsProxyServer.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		Ω(err).NotTo(HaveOccurred())
+		conn := test_util.NewHttpConn(tlsConn)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		req.Header.Add("X-Forwarded-Proto", "http")
+		conn.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(Equal("https"))
+
+		conn.ReadResponse()
+	})
+
+	It("X-Request-Start is appended", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get("X-Request-Start")
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		conn.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(MatchRegexp("^\\d{10}\\d{3}$")) // unix timestamp millis
+
+		conn.ReadResponse()
+	})
+
+	It("X-Request-Start is not overwritten", func() {
+		done := make(chan []string)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header[http.CanonicalHeaderKey("X-Request-Start")]
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		req.Header.Add("X-Request-Start", "") // impl cannot just check for empty string
+		req.Header.Add("X-Request-Start", "user-set2")
+		conn.WriteRequest(req)
+
+		var answer []string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(Equal([]string{"", "user-set2"}))
+
+		conn.ReadResponse()
+	})
+
+	It("X-VcapRequest-Id header is added", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get(router_http.VcapRequestIdHeader)
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		conn.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(MatchRegexp(uuid_regex))
+
+		conn.ReadResponse()
+	})
+
+	It("X-Vcap-Request-Id header is overwritten", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get(router_http.VcapRequestIdHeader)
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		req.Header.Add(router_http.VcapRequestIdHeader, "A-BOGUS-REQUEST-ID")
+		conn.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).ToNot(Equal("A-BOGUS-REQUEST-ID"))
+		Expect(answer).To(MatchRegexp(uuid_regex))
+
+		conn.ReadResponse()
+	})
+
+	Context("with W3C trace context", func() {
+		const traceparent_regex = "^00-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$"
+
+		var headers chan http.Header
+
+		JustBeforeEach(func() {
+			headers = make(chan http.Header, 1)
+		})
+
+		traceHandler := func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			headers <- req.Header
+		}
+
+		It("starts a trace and records its ID in the access log", func() {
+			ln := registerHandler(r, "app", traceHandler)
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "app", "/", nil))
+
+			var header http.Header
+			Eventually(headers).Should(Receive(&header))
+			traceparent := header.Get(router_http.TraceparentHeader)
+			Expect(traceparent).To(MatchRegexp(traceparent_regex))
+
+			conn.ReadResponse()
+
+			var payload []byte
+			Eventually(func() string {
+				accessLogFile.Read(&payload)
+				return string(payload)
+			}).Should(ContainSubstring("trace_id:" + strings.Split(traceparent, "-")[1]))
+		})
+
+		It("keeps the trace ID of the client with a new span ID", func() {
+			ln := registerHandler(r, "app", traceHandler)
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "app", "/", nil)
+			req.Header.Set(router_http.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			req.Header.Set(router_http.TracestateHeader, "congo=t61rcWkgMzE")
+			conn.WriteRequest(req)
+
+			var header http.Header
+			Eventually(headers).Should(Receive(&header))
+			parts := strings.Split(header.Get(router_http.TraceparentHeader), "-")
+			Expect(parts).To(HaveLen(4))
+			Expect(parts[1]).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(parts[2]).To(MatchRegexp("^[0-9a-f]{16}$"))
+			Expect(parts[2]).NotTo(Equal("00f067aa0ba902b7"))
+			Expect(parts[3]).To(Equal("01"))
+			Expect(header.Get(router_http.TracestateHeader)).To(Equal("congo=t61rcWkgMzE"))
+
+			conn.ReadResponse()
+		})
+
+		It("replaces an invalid traceparent with a new trace", func() {
+			ln := registerHandler(r, "app", traceHandler)
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "app", "/", nil)
+			req.Header.Set(router_http.TraceparentHeader, "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+			req.Header.Set(router_http.TracestateHeader, "congo=t61rcWkgMzE")
+			conn.WriteRequest(req)
+
+			var header http.Header
+			Eventually(headers).Should(Receive(&header))
+			Expect(header.Get(router_http.TraceparentHeader)).To(MatchRegexp(traceparent_regex))
+			Expect(header.Get(router_http.TraceparentHeader)).NotTo(ContainSubstring("00000000000000000000000000000000"))
+			Expect(header).NotTo(HaveKey(router_http.TracestateHeader))
+
+			conn.ReadResponse()
+		})
+	})
+
+	It("X-Request-Id header is added alongside X-Request-Start", func() {
+		done := make(chan http.Header)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		conn.WriteRequest(req)
+
+		var header http.Header
+		Eventually(done).Should(Receive(&header))
+		Expect(header.Get(router_http.RequestIdHeader)).To(MatchRegexp(uuid_regex))
+		Expect(header.Get("X-Request-Start")).To(MatchRegexp("^\\d{13}$"))
+
+		conn.ReadResponse()
+
+		var payload []byte
+		Eventually(func() string {
+			accessLogFile.Read(&payload)
+			return string(payload)
+		}).Should(ContainSubstring("x_request_id:" + header.Get(router_http.RequestIdHeader)))
+		Expect(string(payload)).To(ContainSubstring("x_request_start:" + header.Get("X-Request-Start")))
+		Expect(string(payload)).To(ContainSubstring("vcap_request_id:" + header.Get(router_http.VcapRequestIdHeader)))
+	})
+
+	It("X-Request-Id header is not overwritten", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get(router_http.RequestIdHeader)
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		req.Header.Set(router_http.RequestIdHeader, "client-supplied-id")
+		conn.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(Equal("client-supplied-id"))
+
+		conn.ReadResponse()
+	})
+
+	It("X-CF-InstanceID header is added literally if present in the routing endpoint", func() {
+		done := make(chan string)
+
+		ln := registerHandlerWithInstanceId(r, "app", "", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get(router_http.CfInstanceIdHeader)
+		}, "fake-instance-id")
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		conn.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(Equal("fake-instance-id"))
+
+		conn.ReadResponse()
+	})
+
+	It("emits HTTP start events", func() {
+		ln := registerHandlerWithInstanceId(r, "app", "", func(conn *test_util.HttpConn) {
+		}, "fake-instance-id")
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		fakeEmitter := fake.NewFakeEventEmitter("fake")
+		dropsonde.InitializeWithEmitter(fakeEmitter)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		conn.WriteRequest(req)
+
+		findStartEvent := func() *events.HttpStart {
+			for _, event := range fakeEmitter.GetEvents() {
+				startEvent, ok := event.(*events.HttpStart)
+				if ok {
+					return startEvent
+				}
+			}
+
+			return nil
+		}
+
+		Eventually(findStartEvent).ShouldNot(BeNil())
+		Expect(findStartEvent().GetInstanceId()).To(Equal("fake-instance-id"))
+
+		conn.ReadResponse()
+	})
+
+	It("X-CF-InstanceID header is added with host:port information if NOT present in the routing endpoint", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+
+			done <- req.Header.Get(router_http.CfInstanceIdHeader)
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "app", "/", nil)
+		conn.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(MatchRegexp(`^\d+(\.\d+){3}:\d+$`))
+
+		conn.ReadResponse()
+	})
+
+	It("upgrades for a WebSocket request", func() {
+		done := make(chan bool)
+
+		ln := registerHandler(r, "ws", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			done <- req.Header.Get("Upgrade") == "WebsockeT" &&
+				req.Header.Get("Connection") == "UpgradE"
+
+			resp := test_util.NewResponse(http.StatusSwitchingProtocols)
+			resp.Header.Set("Upgrade", "WebsockeT")
+			resp.Header.Set("Connection", "UpgradE")
+
+			conn.WriteResponse(resp)
+
+			conn.CheckLine("hello from client")
+			conn.WriteLine("hello from server")
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "ws", "/chat", nil)
+		req.Header.Set("Upgrade", "WebsockeT")
+		req.Header.Set("Connection", "UpgradE")
+
+		conn.WriteRequest(req)
+
+		var answer bool
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(BeTrue())
+
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		Expect(resp.Header.Get("Upgrade")).To(Equal("WebsockeT"))
+		Expect(resp.Header.Get("Connection")).To(Equal("UpgradE"))
+
+		conn.WriteLine("hello from client")
+		conn.CheckLine("hello from server")
+
+		conn.Close()
+	})
+
+	Context("with varz", func() {
+		var v varz.Varz
+
+		BeforeEach(func() {
+			v = varz.NewVarz(registry.NewRouteRegistry(conf, fakeyagnats.Connect(), new(fakes.FakeRouteReporter)))
+			proxyReporter = v
+		})
+
+		proxyResponses := func(class string) float64 {
+			b, err := json.Marshal(v)
+			Expect(err).NotTo(HaveOccurred())
+
+			var d struct {
+				ProxyResponses map[string]float64 `json:"proxy_responses"`
+			}
+			Expect(json.Unmarshal(b, &d)).To(Succeed())
+			return d.ProxyResponses["responses_"+class]
+		}
+
+		It("counts the responses sent to clients by status class", func() {
+			ln := registerHandler(r, "app", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "app", "/", nil))
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			conn = dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "unknown", "/", nil))
+			resp, _ = conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+			Eventually(func() float64 { return proxyResponses("2xx") }).Should(Equal(float64(1)))
+			Eventually(func() float64 { return proxyResponses("4xx") }).Should(Equal(float64(1)))
+			Expect(proxyResponses("5xx")).To(BeZero())
+		})
+
+		It("counts errors against the backend that failed", func() {
+			healthy := registerHandler(r, "healthy", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+			defer healthy.Close()
+
+			// nothing listens on the address of the failing backend
+			failing, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			failing.Close()
+			registerAddr(r, "failing", "", failing.Addr(), "")
+
+			for _, host := range []string{"healthy", "failing", "healthy", "failing"} {
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", host, "/", nil))
+				conn.ReadResponse()
+			}
+
+			b, err := json.Marshal(v)
+			Expect(err).NotTo(HaveOccurred())
+
+			var d struct {
+				BackendErrors map[string]map[string]float64 `json:"backend_errors"`
+			}
+			Expect(json.Unmarshal(b, &d)).To(Succeed())
+
+			Expect(d.BackendErrors[failing.Addr().String()]["connection_failures"]).To(BeNumerically(">=", 2))
+			Expect(d.BackendErrors).NotTo(HaveKey(healthy.Addr().String()))
+		})
+
+		It("counts the invalid responses of a backend", func() {
+			ln := registerHandler(r, "garbage", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+				conn.WriteLine("HTTP/1.1 two hundred OK")
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "garbage", "/", nil))
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+
+			b, err := json.Marshal(v)
+			Expect(err).NotTo(HaveOccurred())
+
+			var d struct {
+				BadGateways   float64                       `json:"bad_gateways"`
+				BackendErrors map[string]map[string]float64 `json:"backend_errors"`
+			}
+			Expect(json.Unmarshal(b, &d)).To(Succeed())
+
+			Expect(d.BadGateways).To(Equal(float64(1)))
+			Expect(d.BackendErrors[ln.Addr().String()]["invalid_responses"]).To(BeNumerically(">=", 1))
+			Expect(d.BackendErrors[ln.Addr().String()]["connection_failures"]).To(BeZero())
+		})
+
+		It("counts a backend that closes the connection without a response as a connection failure", func() {
+			ln := registerHandler(r, "hangup", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "hangup", "/", nil))
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+
+			b, err := json.Marshal(v)
+			Expect(err).NotTo(HaveOccurred())
+
+			var d struct {
+				BackendErrors map[string]map[string]float64 `json:"backend_errors"`
+			}
+			Expect(json.Unmarshal(b, &d)).To(Succeed())
+
+			Expect(d.BackendErrors[ln.Addr().String()]["connection_failures"]).To(BeNumerically(">=", 1))
+			Expect(d.BackendErrors[ln.Addr().String()]["invalid_responses"]).To(BeZero())
+		})
+	})
+
+	Context("when proxying a WebSocket", func() {
+		var reporter *fakes.FakeReporter
+
+		BeforeEach(func() {
+			reporter = new(fakes.FakeReporter)
+			proxyReporter = reporter
+		})
+
+		It("captures the routing request and splices frames both ways", func() {
+			ln := registerHandler(r, "ws-frames", func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				Ω(err).NotTo(HaveOccurred())
+
+				resp := test_util.NewResponse(http.StatusSwitchingProtocols)
+				resp.Header.Set("Upgrade", "websocket")
+				resp.Header.Set("Connection", "Upgrade")
+				conn.WriteResponse(resp)
+
+				for i := 0; i < 3; i++ {
+					conn.CheckLine(fmt.Sprintf("frame %d from client", i))
+					conn.WriteLine(fmt.Sprintf("frame %d from server", i))
+				}
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("GET", "ws-frames", "/chat", nil)
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Connection", "Upgrade")
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+			for i := 0; i < 3; i++ {
+				conn.WriteLine(fmt.Sprintf("frame %d from client", i))
+				conn.CheckLine(fmt.Sprintf("frame %d from server", i))
+			}
+
+			Expect(reporter.CaptureRoutingRequestCallCount()).To(Equal(1))
+			endpoint, _ := reporter.CaptureRoutingRequestArgsForCall(0)
+			Expect(endpoint.CanonicalAddr()).To(Equal(ln.Addr().String()))
+
+			conn.Close()
+		})
+
+		Context("with max_retries 0", func() {
+			BeforeEach(func() {
+				conf.MaxRetries = 0
+			})
+
+			It("does not try another backend when one refuses the connection", func() {
+				ln := registerHandler(r, "ws-retries", func(conn *test_util.HttpConn) {
+					_, err := http.ReadRequest(conn.Reader)
+					Ω(err).NotTo(HaveOccurred())
+
+					resp := test_util.NewResponse(http.StatusSwitchingProtocols)
+					resp.Header.Set("Upgrade", "websocket")
+					resp.Header.Set("Connection", "Upgrade")
+					conn.WriteResponse(resp)
+					conn.Close()
+				})
+				defer ln.Close()
+
+				dead, err := net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).NotTo(HaveOccurred())
+				dead.Close()
+				registerAddr(r, "ws-retries", "", dead.Addr(), "")
+
+				// the client connection is hijacked before the backend is
+				// dialed, a failed dial closes it without a response
+				upgraded := 0
+				for i := 0; i < 2; i++ {
+					conn := dialProxy(proxyServer)
+
+					req := test_util.NewRequest("GET", "ws-retries", "/chat", nil)
+					req.Header.Set("Upgrade", "websocket")
+					req.Header.Set("Connection", "Upgrade")
+					conn.WriteRequest(req)
+
+					resp, err := http.ReadResponse(conn.Reader, nil)
+					if err == nil && resp.StatusCode == http.StatusSwitchingProtocols {
+						upgraded++
+					}
+					conn.Close()
+				}
+
+				Expect(upgraded).To(Equal(1))
+			})
+		})
+	})
+
+	Context("when counting bytes", func() {
+		var reporter *fakes.FakeReporter
+
+		BeforeEach(func() {
+			reporter = new(fakes.FakeReporter)
+			proxyReporter = reporter
+		})
+
+		// the headers of the test requests and responses stay well below
+		// this, whatever the router adds for itself
+		const headerOverhead = 512
+
+		requestBody := strings.Repeat("q", 4000)
+		responseBody := strings.Repeat("r", 10000)
+
+		sendRequest := func() {
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("POST", "bytes", "/", strings.NewReader(requestBody))
+			conn.WriteRequest(req)
+
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal(responseBody))
+
+			Eventually(reporter.CaptureRouteBytesCallCount).Should(Equal(1))
+			uri, received, sent := reporter.CaptureRouteBytesArgsForCall(0)
+			Expect(uri).To(Equal(route.Uri("bytes")))
+			Expect(received).To(BeNumerically(">", len(requestBody)))
+			Expect(received).To(BeNumerically("<", len(requestBody)+headerOverhead))
+			Expect(sent).To(BeNumerically(">", len(responseBody)))
+			Expect(sent).To(BeNumerically("<", len(responseBody)+headerOverhead))
+		}
+
+		It("reports the bytes of the request and response of a route", func() {
+			ln := registerHandler(r, "bytes", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Body = ioutil.NopCloser(strings.NewReader(responseBody))
+				resp.ContentLength = int64(len(responseBody))
+				resp.Write(conn)
+				conn.Close()
+			})
+			defer ln.Close()
+
+			sendRequest()
+		})
+
+		It("reports the bytes of chunked responses", func() {
+			ln := registerHandler(r, "bytes", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.TransferEncoding = []string{"chunked"}
+				resp.Body = ioutil.NopCloser(strings.NewReader(responseBody))
+				resp.Write(conn)
+				conn.Close()
+			})
+			defer ln.Close()
+
+			sendRequest()
+		})
+	})
+
+	It("upgrades for a WebSocket request with comma-separated Connection header", func() {
+		done := make(chan bool)
+
+		ln := registerHandler(r, "ws-cs-header", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			done <- req.Header.Get("Upgrade") == "Websocket" &&
+				req.Header.Get("Connection") == "keep-alive, Upgrade"
+
+			resp := test_util.NewResponse(http.StatusSwitchingProtocols)
+			resp.Header.Set("Upgrade", "Websocket")
+			resp.Header.Set("Connection", "Upgrade")
+
+			conn.WriteResponse(resp)
+
+			conn.CheckLine("hello from client")
+			conn.WriteLine("hello from server")
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "ws-cs-header", "/chat", nil)
+		req.Header.Add("Upgrade", "Websocket")
+		req.Header.Add("Connection", "keep-alive, Upgrade")
+
+		conn.WriteRequest(req)
+
+		var answer bool
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(BeTrue())
+
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+		Expect(resp.Header.Get("Upgrade")).To(Equal("Websocket"))
+		Expect(resp.Header.Get("Connection")).To(Equal("Upgrade"))
+
+		conn.WriteLine("hello from client")
+		conn.CheckLine("hello from server")
+
+		conn.Close()
+	})
+
+	It("upgrades for a WebSocket request with multiple Connection headers", func() {
+		done := make(chan bool)
+
+		ln := registerHandler(r, "ws-cs-header", func(conn *test_util.HttpConn) {
+			req, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			done <- req.Header.Get("Upgrade") == "Websocket" &&
+				req.Header[http.CanonicalHeaderKey("Connection")][0] == "keep-alive" &&
+				req.Header[http.CanonicalHeaderKey("Connection")][1] == "Upgrade"
+
+			resp := test_util.NewResponse(http.StatusSwitchingProtocols)
+			resp.Header.Set("Upgrade", "Websocket")
+			resp.Header.Set("Connection", "Upgrade")
+
+			conn.WriteResponse(resp)
+
+			conn.CheckLine("hello from client")
+			conn.WriteLine("hello from server")
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "ws-cs-header", "/chat", nil)
+		req.Header.Add("Upgrade", "Websocket")
+		req.Header.Add("Connection", "keep-alive")
 		req.Header.Add("Connection", "Upgrade")
 
-		conn.WriteRequest(req)
+		conn.WriteRequest(req)
+
+		var answer bool
+		Eventually(done).Should(Receive(&answer))
+		Expect(answer).To(BeTrue())
+
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+		Expect(resp.Header.Get("Upgrade")).To(Equal("Websocket"))
+		Expect(resp.Header.Get("Connection")).To(Equal("Upgrade"))
+
+		conn.WriteLine("hello from client")
+		conn.CheckLine("hello from server")
+
+		conn.Close()
+	})
+
+	It("upgrades a Tcp request", func() {
+		ln := registerHandler(r, "tcp-handler", func(conn *test_util.HttpConn) {
+			conn.WriteLine("hello")
+			conn.CheckLine("hello from client")
+			conn.WriteLine("hello from server")
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "tcp-handler", "/chat", nil)
+		req.Header.Set("Upgrade", "tcp")
+
+		req.Header.Set("Connection", "UpgradE")
+
+		conn.WriteRequest(req)
+
+		conn.CheckLine("hello")
+		conn.WriteLine("hello from client")
+		conn.CheckLine("hello from server")
+
+		conn.Close()
+	})
+
+	Context("CONNECT", func() {
+		It("tunnels raw bytes to a registered backend in both directions", func() {
+			ln := registerHandler(r, "tunnel", func(conn *test_util.HttpConn) {
+				conn.CheckLine("hello from client")
+				conn.WriteLine("hello from server")
+				conn.CheckLine("bye from client")
+				conn.WriteLine("bye from server")
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			conn.WriteLines([]string{
+				"CONNECT tunnel:443 HTTP/1.1",
+				"Host: tunnel:443",
+			})
+
+			resp, err := http.ReadResponse(conn.Reader, &http.Request{Method: "CONNECT"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Status).To(Equal("200 Connection Established"))
+
+			conn.WriteLine("hello from client")
+			conn.CheckLine("hello from server")
+			conn.WriteLine("bye from client")
+			conn.CheckLine("bye from server")
+
+			conn.Close()
+		})
+
+		It("responds with a 404 for hosts that are not registered", func() {
+			conn := dialProxy(proxyServer)
+
+			conn.WriteLines([]string{
+				"CONNECT unknown:443 HTTP/1.1",
+				"Host: unknown:443",
+			})
+
+			resp, err := http.ReadResponse(conn.Reader, &http.Request{Method: "CONNECT"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("responds with a 502 when the backend cannot be reached", func() {
+			ln := registerHandler(r, "tunnel", func(conn *test_util.HttpConn) {
+				conn.Close()
+			})
+			ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			conn.WriteLines([]string{
+				"CONNECT tunnel:443 HTTP/1.1",
+				"Host: tunnel:443",
+			})
+
+			resp, err := http.ReadResponse(conn.Reader, &http.Request{Method: "CONNECT"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+		})
+	})
+
+	It("transfers chunked encodings", func() {
+		ln := registerHandler(r, "chunk", func(conn *test_util.HttpConn) {
+			r, w := io.Pipe()
+
+			// Write 3 times on a 100ms interval
+			go func() {
+				t := time.NewTicker(100 * time.Millisecond)
+				defer t.Stop()
+				defer w.Close()
+
+				for i := 0; i < 3; i++ {
+					<-t.C
+					_, err := w.Write([]byte("hello"))
+					Ω(err).NotTo(HaveOccurred())
+				}
+			}()
+
+			_, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			resp.TransferEncoding = []string{"chunked"}
+			resp.Body = r
+			resp.Write(conn)
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "chunk", "/", nil)
+
+		err := req.Write(conn)
+		Ω(err).NotTo(HaveOccurred())
+
+		resp, err := http.ReadResponse(conn.Reader, &http.Request{})
+		Ω(err).NotTo(HaveOccurred())
+
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.TransferEncoding).To(Equal([]string{"chunked"}))
+
+		// Expect 3 individual reads to complete
+		b := make([]byte, 16)
+		for i := 0; i < 3; i++ {
+			n, err := resp.Body.Read(b[0:])
+			if err != nil {
+				Expect(err).To(Equal(io.EOF))
+			}
+			Expect(n).To(Equal(5))
+			Expect(string(b[0:n])).To(Equal("hello"))
+		}
+	})
+
+	It("forwards each chunk before the backend sends the next one", func() {
+		received := make(chan struct{})
+
+		ln := registerHandler(r, "stream", func(conn *test_util.HttpConn) {
+			r, w := io.Pipe()
+
+			// only send the next event once the client has seen this one
+			go func() {
+				defer GinkgoRecover()
+				defer w.Close()
+
+				for i := 0; i < 3; i++ {
+					_, err := fmt.Fprintf(w, "data: %d\n\n", i)
+					Ω(err).NotTo(HaveOccurred())
+
+					select {
+					case <-received:
+					case <-time.After(time.Second):
+						return
+					}
+				}
+			}()
+
+			_, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			resp.Header.Set("Content-Type", "text/event-stream")
+			resp.TransferEncoding = []string{"chunked"}
+			resp.Body = r
+			resp.Write(conn)
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "stream", "/", nil)
+		err := req.Write(conn)
+		Ω(err).NotTo(HaveOccurred())
+
+		resp, err := http.ReadResponse(conn.Reader, &http.Request{})
+		Ω(err).NotTo(HaveOccurred())
+
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.TransferEncoding).To(Equal([]string{"chunked"}))
+		Expect(resp.ContentLength).To(Equal(int64(-1)))
+		Expect(resp.Header.Get("Content-Length")).To(BeEmpty())
+
+		b := make([]byte, 16)
+		for i := 0; i < 3; i++ {
+			n, err := resp.Body.Read(b)
+			if err != nil {
+				Expect(err).To(Equal(io.EOF))
+			}
+			Expect(string(b[0:n])).To(Equal(fmt.Sprintf("data: %d\n\n", i)))
+
+			received <- struct{}{}
+		}
+	})
+
+	It("forwards the trailers of chunked responses", func() {
+		ln := registerHandler(r, "trailers", func(conn *test_util.HttpConn) {
+			conn.ReadRequest()
+			conn.WriteLines([]string{
+				"HTTP/1.1 200 OK",
+				"Trailer: Grpc-Status, Grpc-Message",
+				"Transfer-Encoding: chunked",
+			})
+			conn.WriteLine("5")
+			conn.WriteLine("hello")
+			conn.WriteLine("0")
+			conn.WriteLines([]string{
+				"Grpc-Status: 0",
+				"Grpc-Message: ok",
+			})
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "trailers", "/", nil)
+		conn.WriteRequest(req)
+
+		resp, body := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.TransferEncoding).To(Equal([]string{"chunked"}))
+		Expect(body).To(Equal("hello"))
+		Expect(resp.Trailer.Get("Grpc-Status")).To(Equal("0"))
+		Expect(resp.Trailer.Get("Grpc-Message")).To(Equal("ok"))
+	})
+
+	It("status no content was no Transfer Encoding response header", func() {
+		ln := registerHandler(r, "not-modified", func(conn *test_util.HttpConn) {
+			_, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusNoContent)
+			resp.Header.Set("Connection", "close")
+			conn.WriteResponse(resp)
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "not-modified", "/", nil)
+
+		req.Header.Set("Connection", "close")
+		conn.WriteRequest(req)
+
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+		Expect(resp.TransferEncoding).To(BeNil())
+	})
+
+	It("maintains percent-encoded values in URLs", func() {
+		shouldEcho("/abc%2b%2f%25%20%22%3F%5Edef", "/abc%2b%2f%25%20%22%3F%5Edef") // +, /, %, <space>, ", £, ^
+	})
+
+	It("does not encode reserved characters in URLs", func() {
+		rfc3986_reserved_characters := "!*'();:@&=+$,/?#[]"
+		shouldEcho("/"+rfc3986_reserved_characters, "/"+rfc3986_reserved_characters)
+	})
+
+	It("maintains encoding of percent-encoded reserved characters", func() {
+		encoded_reserved_characters := "%21%27%28%29%3B%3A%40%26%3D%2B%24%2C%2F%3F%23%5B%5D"
+		shouldEcho("/"+encoded_reserved_characters, "/"+encoded_reserved_characters)
+	})
+
+	It("does not encode unreserved characters in URLs", func() {
+		shouldEcho("/abc123_.~def", "/abc123_.~def")
+	})
+
+	It("does not percent-encode special characters in URLs (they came in like this, they go out like this)", func() {
+		shouldEcho("/abc\"£^def", "/abc\"£^def")
+	})
+
+	It("handles requests with encoded query strings", func() {
+		queryString := strings.Join([]string{"a=b", url.QueryEscape("b= bc "), url.QueryEscape("c=d&e")}, "&")
+		shouldEcho("/test?a=b&b%3D+bc+&c%3Dd%26e", "/test?"+queryString)
+	})
+
+	It("request terminates with slow response", func() {
+		ln := registerHandler(r, "slow-app", func(conn *test_util.HttpConn) {
+			_, err := http.ReadRequest(conn.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			time.Sleep(1 * time.Second)
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "slow-app", "/", nil)
+
+		started := time.Now()
+		conn.WriteRequest(req)
+
+		resp, _ := readResponse(conn)
+
+		Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+		Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("endpoint_timeout"))
+		Expect(time.Since(started)).To(BeNumerically("<", time.Duration(800*time.Millisecond)))
+	})
+
+	Context("with endpoint timeouts registered per route", func() {
+		registerSlowHandler := func(path string, delay, timeout time.Duration) net.Listener {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			go runBackendInstance(ln, func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					return
+				}
+
+				time.Sleep(delay)
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+
+			host, portStr, err := net.SplitHostPort(ln.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+			endpoint.Timeout = timeout
+			r.Register(route.Uri(path), endpoint)
+
+			return ln
+		}
+
+		It("lets a route with a long timeout respond after the global timeout", func() {
+			ln := registerSlowHandler("reports", time.Second, 3*time.Second)
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "reports", "/", nil))
+
+			resp, _ := readResponse(conn)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("times out a route with a short timeout before the global timeout", func() {
+			ln := registerSlowHandler("api", 400*time.Millisecond, 100*time.Millisecond)
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			started := time.Now()
+			conn.WriteRequest(test_util.NewRequest("GET", "api", "/", nil))
+
+			resp, _ := readResponse(conn)
+			Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("endpoint_timeout"))
+			Expect(time.Since(started)).To(BeNumerically("<", 350*time.Millisecond))
+		})
+	})
+
+	It("applies a reloaded endpoint timeout to the next request but not to one in flight", func() {
+		received := make(chan struct{}, 2)
+		ln := registerHandler(r, "reload", func(conn *test_util.HttpConn) {
+			_, err := http.ReadRequest(conn.Reader)
+			if err != nil {
+				return
+			}
+			received <- struct{}{}
+
+			time.Sleep(300 * time.Millisecond)
+			conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+			conn.Close()
+		})
+		defer ln.Close()
+
+		inFlight := dialProxy(proxyServer)
+		inFlight.WriteRequest(test_util.NewRequest("GET", "reload", "/", nil))
+		Eventually(received).Should(Receive())
+
+		reloaded := *conf
+		reloaded.EndpointTimeout = 100 * time.Millisecond
+		p.Reload(&reloaded)
+
+		resp, _ := readResponse(inFlight)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		conn := dialProxy(proxyServer)
+		started := time.Now()
+		conn.WriteRequest(test_util.NewRequest("GET", "reload", "/", nil))
+
+		resp, _ = readResponse(conn)
+		Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+		Expect(time.Since(started)).To(BeNumerically("<", 250*time.Millisecond))
+	})
+
+	Context("with a response cache", func() {
+		var hits int32
+
+		BeforeEach(func() {
+			conf.ResponseCacheSize = 1024 * 1024
+			atomic.StoreInt32(&hits, 0)
+		})
+
+		registerCachingHandler := func(path string, cacheControl string) net.Listener {
+			return registerHandler(r, path, func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				count := atomic.AddInt32(&hits, 1)
+				body := fmt.Sprintf("response %d", count)
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Header.Set("Cache-Control", cacheControl)
+				resp.Body = ioutil.NopCloser(strings.NewReader(body))
+				resp.ContentLength = int64(len(body))
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+		}
+
+		get := func(path string) (*http.Response, string) {
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+
+			conn.WriteRequest(test_util.NewRequest("GET", path, "/items?page=1", nil))
+			return conn.ReadResponse()
+		}
+
+		It("serves a cacheable response from the cache", func() {
+			ln := registerCachingHandler("cached", "public, max-age=60")
+			defer ln.Close()
+
+			resp, body := get("cached")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("response 1"))
+
+			resp, body = get("cached")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("response 1"))
+			Expect(resp.Header.Get("Cache-Control")).To(Equal("public, max-age=60"))
+			Expect(resp.Header.Get("Age")).To(Equal("0"))
+
+			Expect(atomic.LoadInt32(&hits)).To(Equal(int32(1)))
+		})
+
+		It("does not cache a response the backend marked private", func() {
+			ln := registerCachingHandler("private", "private, max-age=60")
+			defer ln.Close()
+
+			_, body := get("private")
+			Expect(body).To(Equal("response 1"))
+
+			_, body = get("private")
+			Expect(body).To(Equal("response 2"))
+
+			Expect(atomic.LoadInt32(&hits)).To(Equal(int32(2)))
+		})
+
+		It("sends requests with Cache-Control: no-cache to the backend and caches the response", func() {
+			ln := registerCachingHandler("no-cache", "public, max-age=60")
+			defer ln.Close()
+
+			_, body := get("no-cache")
+			Expect(body).To(Equal("response 1"))
+
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+			req := test_util.NewRequest("GET", "no-cache", "/items?page=1", nil)
+			req.Header.Set("Cache-Control", "no-cache")
+			conn.WriteRequest(req)
+			_, body = conn.ReadResponse()
+			Expect(body).To(Equal("response 2"))
+
+			_, body = get("no-cache")
+			Expect(body).To(Equal("response 2"))
+
+			Expect(atomic.LoadInt32(&hits)).To(Equal(int32(2)))
+		})
+
+		It("keeps the responses of a route group apart from the route's", func() {
+			ln := registerCachingHandler("grouped", "public, max-age=60")
+			defer ln.Close()
+
+			canary, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer canary.Close()
+			go runBackendInstance(canary, func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Header.Set("Cache-Control", "public, max-age=60")
+				resp.Body = ioutil.NopCloser(strings.NewReader("canary"))
+				resp.ContentLength = int64(len("canary"))
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+
+			host, portStr, err := net.SplitHostPort(canary.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).NotTo(HaveOccurred())
+			endpoint := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+			endpoint.Match = &route.Match{Header: "X-Canary", Value: "true"}
+			r.Register("grouped", endpoint)
+
+			_, body := get("grouped")
+			Expect(body).To(Equal("response 1"))
+
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+			req := test_util.NewRequest("GET", "grouped", "/items?page=1", nil)
+			req.Header.Set("X-Canary", "true")
+			conn.WriteRequest(req)
+			_, body = conn.ReadResponse()
+			Expect(body).To(Equal("canary"))
+
+			_, body = get("grouped")
+			Expect(body).To(Equal("response 1"))
+		})
+	})
+
+	Context("with an idempotency cache", func() {
+		var (
+			hits    int32
+			arrived chan struct{}
+			respond chan struct{}
+		)
+
+		BeforeEach(func() {
+			conf.IdempotencyCacheSize = 1024 * 1024
+			conf.IdempotencyKeyTTL = time.Minute
+			atomic.StoreInt32(&hits, 0)
+			arrived = make(chan struct{}, 2)
+			respond = make(chan struct{})
+		})
+
+		registerPaymentHandler := func(path string) net.Listener {
+			return registerHandler(r, path, func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				count := atomic.AddInt32(&hits, 1)
+				arrived <- struct{}{}
+				<-respond
+
+				body := fmt.Sprintf("payment %d", count)
+				resp := test_util.NewResponse(http.StatusCreated)
+				resp.Body = ioutil.NopCloser(strings.NewReader(body))
+				resp.ContentLength = int64(len(body))
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+		}
+
+		paymentRequest := func(path string, key string) *http.Request {
+			req := test_util.NewRequest("POST", path, "/payments", strings.NewReader("amount=10"))
+			req.Header.Set("Idempotency-Key", key)
+			return req
+		}
+
+		send := func(req *http.Request) chan *http.Response {
+			responses := make(chan *http.Response, 1)
+			go func() {
+				defer GinkgoRecover()
+
+				conn := dialProxy(proxyServer)
+				defer conn.Close()
+
+				conn.WriteRequest(req)
+
+				resp, body := conn.ReadResponse()
+				resp.Body = ioutil.NopCloser(strings.NewReader(body))
+				responses <- resp
+			}()
+			return responses
+		}
+
+		pay := func(path string, key string) chan *http.Response {
+			return send(paymentRequest(path, key))
+		}
+
+		bodyOf := func(resp *http.Response) string {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return string(body)
+		}
+
+		It("sends concurrent requests with the same key to the backend once", func() {
+			ln := registerPaymentHandler("payments")
+			defer ln.Close()
+
+			first := pay("payments", "key-1")
+			Eventually(arrived).Should(Receive())
+			second := pay("payments", "key-1")
+			Consistently(arrived).ShouldNot(Receive())
+			close(respond)
+
+			var resp *http.Response
+			Eventually(first).Should(Receive(&resp))
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			Expect(bodyOf(resp)).To(Equal("payment 1"))
+
+			Eventually(second).Should(Receive(&resp))
+			Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			Expect(bodyOf(resp)).To(Equal("payment 1"))
+			Expect(resp.Header.Get("Idempotent-Replayed")).To(Equal("true"))
+			Expect(resp.Header).NotTo(HaveKey("Age"))
+
+			Eventually(pay("payments", "key-1")).Should(Receive(&resp))
+			Expect(bodyOf(resp)).To(Equal("payment 1"))
+
+			Expect(atomic.LoadInt32(&hits)).To(Equal(int32(1)))
+		})
+
+		It("sends requests with different keys to the backend", func() {
+			ln := registerPaymentHandler("payments")
+			defer ln.Close()
+			close(respond)
+
+			var resp *http.Response
+			Eventually(pay("payments", "key-1")).Should(Receive(&resp))
+			Expect(bodyOf(resp)).To(Equal("payment 1"))
+			Eventually(pay("payments", "key-2")).Should(Receive(&resp))
+			Expect(bodyOf(resp)).To(Equal("payment 2"))
+			Expect(resp.Header.Get("Idempotent-Replayed")).To(BeEmpty())
+		})
+
+		It("keeps the keys of different callers apart", func() {
+			ln := registerPaymentHandler("payments")
+			defer ln.Close()
+			close(respond)
+
+			alice := paymentRequest("payments", "key-1")
+			alice.Header.Set("Authorization", "Bearer alice")
+			bob := paymentRequest("payments", "key-1")
+			bob.Header.Set("Authorization", "Bearer bob")
+
+			var resp *http.Response
+			Eventually(send(alice)).Should(Receive(&resp))
+			Expect(bodyOf(resp)).To(Equal("payment 1"))
+			Eventually(send(bob)).Should(Receive(&resp))
+			Expect(bodyOf(resp)).To(Equal("payment 2"))
+			Expect(resp.Header.Get("Idempotent-Replayed")).To(BeEmpty())
+		})
+
+		It("rejects a key reused for another request with a 422", func() {
+			ln := registerPaymentHandler("payments")
+			defer ln.Close()
+			close(respond)
+
+			var resp *http.Response
+			Eventually(pay("payments", "key-1")).Should(Receive(&resp))
+			Expect(bodyOf(resp)).To(Equal("payment 1"))
+
+			refund := test_util.NewRequest("POST", "payments", "/refunds", strings.NewReader("amount=10"))
+			refund.Header.Set("Idempotency-Key", "key-1")
+			Eventually(send(refund)).Should(Receive(&resp))
+			Expect(resp.StatusCode).To(Equal(http.StatusUnprocessableEntity))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("idempotency_key_reused"))
+
+			Expect(atomic.LoadInt32(&hits)).To(Equal(int32(1)))
+		})
+	})
+
+	Context("with a proxy buffer size", func() {
+		body := make([]byte, 4*1024*1024)
+		for i := range body {
+			body[i] = byte(i % 251)
+		}
+
+		registerEchoHandler := func() net.Listener {
+			return registerHandler(r, "transfer", func(conn *test_util.HttpConn) {
+				_, received := conn.ReadRequest()
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Body = ioutil.NopCloser(strings.NewReader(received))
+				resp.ContentLength = int64(len(received))
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+		}
+
+		transfer := func() string {
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+
+			conn.WriteRequest(test_util.NewRequest("POST", "transfer", "/", bytes.NewReader(body)))
+
+			resp, received := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			return received
+		}
+
+		for _, size := range []int{0, 1024, 32 * 1024, 1024 * 1024} {
+			size := size
+
+			Context(fmt.Sprintf("of %d bytes", size), func() {
+				BeforeEach(func() {
+					conf.ProxyBufferSize = size
+					conf.EndpointTimeout = 5 * time.Second
+				})
+
+				It("transfers large bodies intact in both directions", func() {
+					ln := registerEchoHandler()
+					defer ln.Close()
+
+					Expect(transfer() == string(body)).To(BeTrue())
+				})
+
+				Measure("the throughput", func(b Benchmarker) {
+					ln := registerEchoHandler()
+					defer ln.Close()
+
+					elapsed := b.Time("round trip", func() {
+						transfer()
+					})
+					b.RecordValue("MB/s", 2*float64(len(body))/(1024*1024)/elapsed.Seconds())
+				}, 5)
+			})
+		}
+	})
+
+	Context("with a request rate registered for a backend", func() {
+		It("sends the requests over the backend's rate to the other backends", func() {
+			served := make(chan string, 10)
+			respondAs := func(name string) connHandler {
+				return func(conn *test_util.HttpConn) {
+					conn.ReadRequest()
+					served <- name
+					conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+					conn.Close()
+				}
+			}
+
+			limitedLn, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer limitedLn.Close()
+			go runBackendInstance(limitedLn, respondAs("limited"))
+
+			host, portStr, err := net.SplitHostPort(limitedLn.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).NotTo(HaveOccurred())
+
+			limited := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+			limited.MaxRequestsPerSecond = 2
+			r.Register("rated", limited)
+
+			ln := registerHandler(r, "rated", respondAs("unlimited"))
+			defer ln.Close()
+
+			for i := 0; i < 10; i++ {
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", "rated", "/", nil))
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				conn.Close()
+			}
+
+			counts := map[string]int{}
+			for i := 0; i < 10; i++ {
+				counts[<-served]++
+			}
+			Expect(counts["limited"]).To(BeNumerically("<=", 3))
+			Expect(counts["unlimited"]).To(BeNumerically(">=", 7))
+		})
+	})
+
+	Context("with Location rewriting registered for a route", func() {
+		redirect := func(location func(addr string) string) connHandler {
+			return func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+
+				resp := test_util.NewResponse(http.StatusFound)
+				resp.Header.Set("Location", location(conn.LocalAddr().String()))
+				resp.Header.Set("Content-Location", "http://"+conn.LocalAddr().String()+"/page")
+				conn.WriteResponse(resp)
+				conn.Close()
+			}
+		}
+
+		register := func(path string, rewrite bool, handler connHandler) net.Listener {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			go runBackendInstance(ln, handler)
+
+			host, portStr, err := net.SplitHostPort(ln.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+			endpoint.RewriteLocation = rewrite
+			r.Register(route.Uri(path), endpoint)
+
+			return ln
+		}
+
+		get := func(host string) *http.Response {
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+
+			conn.WriteRequest(test_util.NewRequest("GET", host, "/", nil))
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusFound))
+			return resp
+		}
+
+		It("points redirects to the backend's address at the public host", func() {
+			ln := register("public.example.com", true, redirect(func(addr string) string {
+				return "http://" + addr + "/login?next=%2F"
+			}))
+			defer ln.Close()
+
+			resp := get("public.example.com")
+			Expect(resp.Header.Get("Location")).To(Equal("http://public.example.com/login?next=%2F"))
+			Expect(resp.Header.Get("Content-Location")).To(Equal("http://public.example.com/page"))
+		})
+
+		It("points redirects at the scheme the request was forwarded with", func() {
+			ln := register("public.example.com", true, redirect(func(addr string) string {
+				return "http://" + addr + "/login"
+			}))
+			defer ln.Close()
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			tlsProxyServer := newTlsListener(l)
+			defer tlsProxyServer.Close()
+
+			server := http.Server{Handler: p}
+			go server.Serve(tlsProxyServer)
+
+			tlsConn, err := tls.Dial("tcp", tlsProxyServer.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+			Expect(err).NotTo(HaveOccurred())
+			conn := test_util.NewHttpConn(tlsConn)
+			defer conn.Close()
+
+			req := test_util.NewRequest("GET", "public.example.com", "/", nil)
+			req.Header.Set("X-Forwarded-Proto", "http")
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusFound))
+			Expect(resp.Header.Get("Location")).To(Equal("https://public.example.com/login"))
+		})
+
+		It("leaves redirects to other hosts and relative ones alone", func() {
+			ln := register("external", true, redirect(func(string) string {
+				return "https://login.example.com/"
+			}))
+			defer ln.Close()
+			relative := register("relative", true, redirect(func(string) string {
+				return "/login"
+			}))
+			defer relative.Close()
+
+			Expect(get("external").Header.Get("Location")).To(Equal("https://login.example.com/"))
+			Expect(get("relative").Header.Get("Location")).To(Equal("/login"))
+		})
+
+		It("does not rewrite the redirects of other routes", func() {
+			var internal string
+			ln := register("internal", false, redirect(func(addr string) string {
+				internal = "http://" + addr + "/login"
+				return internal
+			}))
+			defer ln.Close()
+
+			Expect(get("internal").Header.Get("Location")).To(Equal(internal))
+		})
+	})
+
+	Context("with CORS configured for a route", func() {
+		var (
+			methods chan string
+			ln      net.Listener
+		)
+
+		JustBeforeEach(func() {
+			methods = make(chan string, 2)
+
+			var err error
+			ln, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			go runBackendInstance(ln, func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					return
+				}
+
+				methods <- req.Method
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+			host, portStr, err := net.SplitHostPort(ln.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(port), "", nil, -1, "")
+			endpoint.CORS = &route.CORS{
+				AllowedOrigins:   []string{"https://app.example.com"},
+				AllowedMethods:   []string{"GET", "PUT"},
+				AllowCredentials: true,
+				MaxAgeInSeconds:  600,
+			}
+			r.Register("cors-api", endpoint)
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		preflight := func(origin string) *http.Response {
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+
+			req := test_util.NewRequest("OPTIONS", "cors-api", "/items", nil)
+			req.Header.Set("Origin", origin)
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+			req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			return resp
+		}
+
+		It("answers a preflight request without reaching the backend", func() {
+			resp := preflight("https://app.example.com")
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+			Expect(resp.Header.Get("Access-Control-Allow-Methods")).To(Equal("GET, PUT"))
+			Expect(resp.Header.Get("Access-Control-Allow-Headers")).To(Equal("Content-Type"))
+			Expect(resp.Header.Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+			Expect(resp.Header.Get("Access-Control-Max-Age")).To(Equal("600"))
+			Expect(resp.Header.Get("Vary")).To(Equal("Origin"))
+			Consistently(methods).ShouldNot(Receive())
+		})
+
+		It("allows nothing to an origin that is not configured", func() {
+			resp := preflight("https://evil.example.com")
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(BeEmpty())
+			Expect(resp.Header.Get("Access-Control-Allow-Methods")).To(BeEmpty())
+			Consistently(methods).ShouldNot(Receive())
+		})
+
+		It("proxies requests that are not preflights", func() {
+			conn := dialProxy(proxyServer)
+			defer conn.Close()
+
+			req := test_util.NewRequest("OPTIONS", "cors-api", "/items", nil)
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Eventually(methods).Should(Receive(Equal("OPTIONS")))
+		})
+	})
+
+	Context("with a prometheus reporter", func() {
+		var prometheusReporter *metrics.PrometheusReporter
+
+		BeforeEach(func() {
+			prometheusReporter = metrics.NewPrometheusReporter()
+			proxyReporter = metrics.NewCompositeReporter(nullVarz{}, prometheusReporter)
+		})
+
+		It("exports the requests that went through the proxy", func() {
+			ln := registerHandler(r, "prometheus-app", func(conn *test_util.HttpConn) {
+				conn.CheckLine("GET / HTTP/1.1")
+				resp := test_util.NewResponse(http.StatusOK)
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
+
+			for i := 0; i < 2; i++ {
+				conn := dialProxy(proxyServer)
+				req := test_util.NewRequest("GET", "prometheus-app", "/", nil)
+				conn.WriteRequest(req)
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			}
+
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "unknown-app", "/", nil)
+			conn.WriteRequest(req)
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+			recorder := httptest.NewRecorder()
+			prometheusReporter.ServeHTTP(recorder, req)
+
+			body := recorder.Body.String()
+			Expect(body).To(ContainSubstring("gorouter_bad_requests_total 1\n"))
+			Expect(body).To(ContainSubstring("gorouter_backend_requests_total 2\n"))
+			Expect(body).To(ContainSubstring(`gorouter_backend_responses_total{status_class="2xx"} 2`))
+			Expect(body).To(ContainSubstring("gorouter_backend_response_latency_seconds_count 2\n"))
+		})
+	})
+
+	Context("when the endpoint never responds", func() {
+		var reporter *fakes.FakeReporter
+
+		BeforeEach(func() {
+			reporter = new(fakes.FakeReporter)
+			proxyReporter = reporter
+		})
+
+		It("responds with 504 and records a failed response", func() {
+			ln := registerHandler(r, "silent-app", func(conn *test_util.HttpConn) {
+				_, err := http.ReadRequest(conn.Reader)
+				Ω(err).NotTo(HaveOccurred())
+
+				ioutil.ReadAll(conn.Reader)
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("GET", "silent-app", "/", nil)
+
+			started := time.Now()
+			conn.WriteRequest(req)
+
+			resp, body := readResponse(conn)
+
+			Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+			Expect(body).To(Equal("504 Gateway Timeout: Registered endpoint failed to respond in time.\n"))
+			Expect(time.Since(started)).To(BeNumerically("~", conf.EndpointTimeout, 250*time.Millisecond))
+
+			Expect(reporter.CaptureRoutingResponseCallCount()).To(Equal(1))
+			_, uri, res, _, _ := reporter.CaptureRoutingResponseArgsForCall(0)
+			Expect(uri).To(Equal(route.Uri("silent-app")))
+			Expect(res).To(BeNil())
+		})
+	})
+
+	It("proxy detects closed client connection", func() {
+		serverResult := make(chan error)
+		ln := registerHandler(r, "slow-app", func(conn *test_util.HttpConn) {
+			conn.CheckLine("GET / HTTP/1.1")
+
+			timesToTick := 10
+
+			conn.WriteLines([]string{
+				"HTTP/1.1 200 OK",
+				fmt.Sprintf("Content-Length: %d", timesToTick),
+			})
+
+			for i := 0; i < timesToTick; i++ {
+				_, err := conn.Conn.Write([]byte("x"))
+				if err != nil {
+					serverResult <- err
+					return
+				}
+
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			serverResult <- nil
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "slow-app", "/", nil)
+		conn.WriteRequest(req)
+
+		conn.Conn.Close()
+
+		var err error
+		Eventually(serverResult).Should(Receive(&err))
+		Ω(err).NotTo(BeNil())
+	})
+
+	Context("respect client keepalives", func() {
+		It("closes the connection when told to close", func() {
+			ln := registerHandler(r, "remote", func(conn *test_util.HttpConn) {
+				http.ReadRequest(conn.Reader)
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Close = true
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("GET", "remote", "/", nil)
+			req.Close = true
+			conn.WriteRequest(req)
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			conn.WriteRequest(req)
+			_, err := http.ReadResponse(conn.Reader, &http.Request{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("keeps the connection alive", func() {
+			ln := registerHandler(r, "remote", func(conn *test_util.HttpConn) {
+				http.ReadRequest(conn.Reader)
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Close = true
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("GET", "remote", "/", nil)
+			req.Close = false
+			conn.WriteRequest(req)
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			conn.WriteRequest(req)
+			_, err := http.ReadResponse(conn.Reader, &http.Request{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+	})
+
+	It("disables compression", func() {
+		ln := registerHandler(r, "remote", func(conn *test_util.HttpConn) {
+			request, _ := http.ReadRequest(conn.Reader)
+			encoding := request.Header["Accept-Encoding"]
+			var resp *http.Response
+			if len(encoding) != 0 {
+				resp = test_util.NewResponse(http.StatusInternalServerError)
+			} else {
+				resp = test_util.NewResponse(http.StatusOK)
+			}
+			conn.WriteResponse(resp)
+			conn.Close()
+		})
+		defer ln.Close()
+
+		conn := dialProxy(proxyServer)
+
+		req := test_util.NewRequest("GET", "remote", "/", nil)
+		conn.WriteRequest(req)
+		resp, _ := conn.ReadResponse()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("retries when failed endpoints exist", func() {
+		ln := registerHandler(r, "retries", func(conn *test_util.HttpConn) {
+			conn.CheckLine("GET / HTTP/1.1")
+			resp := test_util.NewResponse(http.StatusOK)
+			conn.WriteResponse(resp)
+			conn.Close()
+		})
+		defer ln.Close()
+
+		ip, err := net.ResolveTCPAddr("tcp", "localhost:81")
+		Expect(err).To(BeNil())
+		registerAddr(r, "retries", "", ip, "instanceId")
+
+		for i := 0; i < 5; i++ {
+			conn := dialProxy(proxyServer)
+
+			req := test_util.NewRequest("GET", "retries", "/", nil)
+			conn.WriteRequest(req)
+			resp, _ := conn.ReadResponse()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+	})
+
+	Context("with routes registered under path prefixes", func() {
+		It("routes to the backend with the longest matching prefix", func() {
+			backend := func(name string) connHandler {
+				return func(conn *test_util.HttpConn) {
+					http.ReadRequest(conn.Reader)
+					resp := test_util.NewResponse(http.StatusOK)
+					resp.Header.Set("X-Backend", name)
+					conn.WriteResponse(resp)
+					conn.Close()
+				}
+			}
+
+			rootLn := registerHandler(r, "prefix.vcap.me", backend("root"))
+			defer rootLn.Close()
+			v2Ln := registerHandler(r, "prefix.vcap.me/v2", backend("v2"))
+			defer v2Ln.Close()
+			usersLn := registerHandler(r, "prefix.vcap.me/v2/users/admin", backend("admin"))
+			defer usersLn.Close()
+
+			expectations := map[string]string{
+				"/":                     "root",
+				"/v1/users":             "root",
+				"/v2":                   "v2",
+				"/v2/users":             "v2",
+				"/v2/users/admin/1?x=y": "admin",
+				"/v2users":              "root",
+			}
+
+			for path, name := range expectations {
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", "prefix.vcap.me", path, nil))
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("X-Backend")).To(Equal(name), path)
+			}
+		})
+	})
+
+	Context("Access log", func() {
+		It("Logs a request", func() {
+			ln := registerHandler(r, "test", func(conn *test_util.HttpConn) {
+				req, body := conn.ReadRequest()
+				Expect(req.Method).To(Equal("POST"))
+				Expect(req.URL.Path).To(Equal("/"))
+				Expect(req.ProtoMajor).To(Equal(1))
+				Expect(req.ProtoMinor).To(Equal(1))
+
+				Expect(body).To(Equal("ABCD"))
+
+				rsp := test_util.NewResponse(200)
+				out := &bytes.Buffer{}
+				out.WriteString("DEFG")
+				rsp.Body = ioutil.NopCloser(out)
+				conn.WriteResponse(rsp)
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+
+			body := &bytes.Buffer{}
+			body.WriteString("ABCD")
+			req := test_util.NewRequest("POST", "test", "/", ioutil.NopCloser(body))
+			conn.WriteRequest(req)
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var payload []byte
+			Eventually(func() int {
+				accessLogFile.Read(&payload)
+				return len(payload)
+			}).ShouldNot(BeZero())
+
+			//make sure the record includes all the data
+			//since the building of the log record happens throughout the life of the request
+			Expect(strings.HasPrefix(string(payload), "test - [")).To(BeTrue())
+			Expect(string(payload)).To(ContainSubstring(`"POST / HTTP/1.1" 200 4 4 "-"`))
+			Expect(string(payload)).To(ContainSubstring(`x_forwarded_for:"127.0.0.1" x_forwarded_proto:"-" vcap_request_id:`))
+			Expect(string(payload)).To(ContainSubstring(`response_time:`))
+			Expect(string(payload)).To(ContainSubstring(`app_id:`))
+			Expect(payload[len(payload)-1]).To(Equal(byte('\n')))
+		})
+
+		It("Logs the backend that served the request", func() {
+			ln := registerHandler(r, "logged-app", func(conn *test_util.HttpConn) {
+				conn.CheckLine("GET / HTTP/1.1")
+				resp := test_util.NewResponse(http.StatusOK)
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "logged-app", "/", nil)
+			conn.WriteRequest(req)
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var payload []byte
+			Eventually(func() string {
+				accessLogFile.Read(&payload)
+				return string(payload)
+			}).Should(HavePrefix("logged-app - ["))
+
+			Expect(string(payload)).To(ContainSubstring(`"GET / HTTP/1.1" 200 0 0 "-"`))
+			Expect(string(payload)).To(ContainSubstring("backend:" + ln.Addr().String()))
+		})
+
+		It("Logs requests for unknown routes", func() {
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "unlogged-app", "/", nil)
+			conn.WriteRequest(req)
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+			var payload []byte
+			Eventually(func() string {
+				accessLogFile.Read(&payload)
+				return string(payload)
+			}).Should(HavePrefix("unlogged-app - ["))
+
+			Expect(string(payload)).To(ContainSubstring(`"GET / HTTP/1.1" 404`))
+			Expect(string(payload)).To(ContainSubstring("backend:-"))
+		})
+
+		It("Logs a request when it exits early", func() {
+			conn := dialProxy(proxyServer)
+
+			conn.WriteLines([]string{
+				"GET / HTTP/0.9",
+				"Host: test",
+			})
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+			var payload []byte
+			Eventually(func() int {
+				n, e := accessLogFile.Read(&payload)
+				Expect(e).ToNot(HaveOccurred())
+				return n
+			}).ShouldNot(BeZero())
+
+			Expect(string(payload)).To(MatchRegexp("^test.*\n"))
+		})
+
+		Context("when the request is a TCP Upgrade", func() {
+			It("Logs the response time", func() {
+
+				ln := registerHandler(r, "tcp-handler", func(conn *test_util.HttpConn) {
+					conn.WriteLine("hello")
+					conn.CheckLine("hello from client")
+					conn.WriteLine("hello from server")
+					conn.Close()
+				})
+				defer ln.Close()
+
+				conn := dialProxy(proxyServer)
+
+				req := test_util.NewRequest("GET", "tcp-handler", "/chat", nil)
+				req.Header.Set("Upgrade", "tcp")
+
+				req.Header.Set("Connection", "Upgrade")
+
+				conn.WriteRequest(req)
+
+				conn.CheckLine("hello")
+				conn.WriteLine("hello from client")
+				conn.CheckLine("hello from server")
+
+				var payload []byte
+				Eventually(func() int {
+					accessLogFile.Read(&payload)
+					return len(payload)
+				}).ShouldNot(BeZero())
+
+				Expect(string(payload)).To(ContainSubstring(`response_time:`))
+				responseTime := parseResponseTimeFromLog(string(payload))
+				Expect(responseTime).To(BeNumerically(">", 0))
+
+				conn.Close()
+			})
+		})
+
+		Context("when the request is a web connection", func() {
+			It("Logs the response time", func() {
+				done := make(chan bool)
+				ln := registerHandler(r, "ws", func(conn *test_util.HttpConn) {
+					req, err := http.ReadRequest(conn.Reader)
+					Ω(err).NotTo(HaveOccurred())
+
+					done <- req.Header.Get("Upgrade") == "Websocket" &&
+						req.Header.Get("Connection") == "Upgrade"
+
+					resp := test_util.NewResponse(http.StatusSwitchingProtocols)
+					resp.Header.Set("Upgrade", "Websocket")
+					resp.Header.Set("Connection", "Upgrade")
+
+					conn.WriteResponse(resp)
+
+					conn.CheckLine("hello from client")
+					conn.WriteLine("hello from server")
+					conn.Close()
+				})
+				defer ln.Close()
+
+				conn := dialProxy(proxyServer)
+
+				req := test_util.NewRequest("GET", "ws", "/chat", nil)
+				req.Header.Set("Upgrade", "Websocket")
+				req.Header.Set("Connection", "Upgrade")
+
+				conn.WriteRequest(req)
+
+				var answer bool
+				Eventually(done).Should(Receive(&answer))
+				Expect(answer).To(BeTrue())
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+				Expect(resp.Header.Get("Upgrade")).To(Equal("Websocket"))
+				Expect(resp.Header.Get("Connection")).To(Equal("Upgrade"))
+
+				conn.WriteLine("hello from client")
+				conn.CheckLine("hello from server")
+
+				var payload []byte
+				Eventually(func() int {
+					accessLogFile.Read(&payload)
+					return len(payload)
+				}).ShouldNot(BeZero())
+
+				Expect(string(payload)).To(ContainSubstring(`response_time:`))
+				responseTime := parseResponseTimeFromLog(string(payload))
+				Expect(responseTime).To(BeNumerically(">", 0))
+
+				conn.Close()
+			})
+		})
+	})
+
+	Context("with a maximum number of connections per backend", func() {
+		var active, maxActive int32
+
+		BeforeEach(func() {
+			conf.MaxConnsPerBackend = 2
+			atomic.StoreInt32(&active, 0)
+			atomic.StoreInt32(&maxActive, 0)
+		})
+
+		slowHandler := func(release <-chan struct{}) connHandler {
+			return func(conn *test_util.HttpConn) {
+				defer conn.Close()
+
+				conn.ReadRequest()
+
+				n := atomic.AddInt32(&active, 1)
+				for {
+					max := atomic.LoadInt32(&maxActive)
+					if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+						break
+					}
+				}
+
+				<-release
+				atomic.AddInt32(&active, -1)
+
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+			}
+		}
+
+		get := func(statusCodes chan<- int) {
+			defer GinkgoRecover()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "limited", "/", nil))
+
+			resp, _ := conn.ReadResponse()
+			statusCodes <- resp.StatusCode
+		}
+
+		It("rejects requests over the limit with a 503", func() {
+			release := make(chan struct{})
+			ln := registerHandler(r, "limited", slowHandler(release))
+			defer ln.Close()
+
+			statusCodes := make(chan int, 3)
+			go get(statusCodes)
+			go get(statusCodes)
+			Eventually(func() int32 { return atomic.LoadInt32(&active) }).Should(Equal(int32(2)))
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "limited", "/", nil))
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("backend_at_capacity"))
+
+			close(release)
+			Eventually(statusCodes).Should(Receive(Equal(http.StatusOK)))
+			Eventually(statusCodes).Should(Receive(Equal(http.StatusOK)))
+			Expect(atomic.LoadInt32(&maxActive)).To(Equal(int32(2)))
+		})
+
+		It("admits new requests once a slot is released", func() {
+			release := make(chan struct{})
+			close(release)
+
+			ln := registerHandler(r, "limited", slowHandler(release))
+			defer ln.Close()
+
+			statusCodes := make(chan int, 3)
+			for i := 0; i < 3; i++ {
+				get(statusCodes)
+				Expect(<-statusCodes).To(Equal(http.StatusOK))
+			}
+		})
+
+		Context("when queueing", func() {
+			BeforeEach(func() {
+				conf.MaxConnsPerBackend = 1
+				conf.MaxConnsPolicy = config.MaxConnsPolicyQueue
+				conf.MaxConnsQueueTimeout = 2 * time.Second
+			})
+
+			It("holds requests over the limit until a slot is released", func() {
+				release := make(chan struct{})
+				ln := registerHandler(r, "limited", slowHandler(release))
+				defer ln.Close()
+
+				statusCodes := make(chan int, 2)
+				go get(statusCodes)
+				Eventually(func() int32 { return atomic.LoadInt32(&active) }).Should(Equal(int32(1)))
+
+				go get(statusCodes)
+				Consistently(statusCodes, 200*time.Millisecond).ShouldNot(Receive())
+
+				close(release)
+				Eventually(statusCodes).Should(Receive(Equal(http.StatusOK)))
+				Eventually(statusCodes).Should(Receive(Equal(http.StatusOK)))
+				Expect(atomic.LoadInt32(&maxActive)).To(Equal(int32(1)))
+			})
+
+			Context("with a reporter", func() {
+				var reporter *fakes.FakeReporter
+
+				BeforeEach(func() {
+					reporter = new(fakes.FakeReporter)
+					proxyReporter = reporter
+				})
+
+				It("reports the time requests spent queued", func() {
+					release := make(chan struct{})
+					ln := registerHandler(r, "limited", slowHandler(release))
+					defer ln.Close()
+
+					statusCodes := make(chan int, 2)
+					go get(statusCodes)
+					Eventually(func() int32 { return atomic.LoadInt32(&active) }).Should(Equal(int32(1)))
+					Expect(reporter.CaptureQueueTimeCallCount()).To(Equal(0))
+
+					go get(statusCodes)
+					time.Sleep(150 * time.Millisecond)
+					close(release)
+					Eventually(statusCodes).Should(Receive(Equal(http.StatusOK)))
+					Eventually(statusCodes).Should(Receive(Equal(http.StatusOK)))
+
+					Expect(reporter.CaptureQueueTimeCallCount()).To(Equal(1))
+					Expect(reporter.CaptureQueueTimeArgsForCall(0)).To(BeNumerically(">=", 150*time.Millisecond))
+					Expect(reporter.CaptureSelectionTimeCallCount()).To(BeNumerically(">=", 2))
+				})
+			})
+
+			Context("when the queue timeout expires", func() {
+				BeforeEach(func() {
+					conf.MaxConnsQueueTimeout = 100 * time.Millisecond
+				})
+
+				It("rejects the waiting request with a 503", func() {
+					release := make(chan struct{})
+					defer close(release)
+
+					ln := registerHandler(r, "limited", slowHandler(release))
+					defer ln.Close()
+
+					statusCodes := make(chan int, 1)
+					go get(statusCodes)
+					Eventually(func() int32 { return atomic.LoadInt32(&active) }).Should(Equal(int32(1)))
+
+					conn := dialProxy(proxyServer)
+					conn.WriteRequest(test_util.NewRequest("GET", "limited", "/", nil))
+
+					started := time.Now()
+					resp, _ := conn.ReadResponse()
+					Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+					Expect(time.Since(started)).To(BeNumerically(">=", 100*time.Millisecond))
+				})
+			})
+		})
+
+		Context("when queueing fairly", func() {
+			BeforeEach(func() {
+				conf.LoadBalancing = config.LoadBalancingLeastConnections
+				conf.MaxConnsPerBackend = 1
+				conf.MaxConnsPolicy = config.MaxConnsPolicyFairQueue
+				conf.MaxConnsQueueTimeout = 5 * time.Second
+			})
+
+			timedHandler := func(name string, delay time.Duration) connHandler {
+				return func(conn *test_util.HttpConn) {
+					defer conn.Close()
+
+					conn.ReadRequest()
+					time.Sleep(delay)
+
+					resp := test_util.NewResponse(http.StatusOK)
+					resp.Header.Set("X-Backend", name)
+					conn.WriteResponse(resp)
+				}
+			}
+
+			It("dispatches queued requests to the backend that frees up first", func() {
+				fast := registerHandler(r, "limited", timedHandler("fast", 20*time.Millisecond))
+				defer fast.Close()
+				slow := registerHandler(r, "limited", timedHandler("slow", 300*time.Millisecond))
+				defer slow.Close()
+
+				const requests = 10
+				backends := make(chan string, requests)
+				for i := 0; i < requests; i++ {
+					go func() {
+						defer GinkgoRecover()
+
+						conn := dialProxy(proxyServer)
+						conn.WriteRequest(test_util.NewRequest("GET", "limited", "/", nil))
+
+						resp, _ := conn.ReadResponse()
+						Expect(resp.StatusCode).To(Equal(http.StatusOK))
+						backends <- resp.Header.Get("X-Backend")
+					}()
+				}
+
+				served := map[string]int{}
+				for i := 0; i < requests; i++ {
+					var backend string
+					Eventually(backends, 5*time.Second).Should(Receive(&backend))
+					served[backend]++
+				}
+
+				Expect(served["slow"]).To(BeNumerically(">=", 1))
+				Expect(served["fast"]).To(BeNumerically(">=", requests-2))
+			})
+
+			Context("with a reporter", func() {
+				var reporter *fakes.FakeReporter
+
+				BeforeEach(func() {
+					reporter = new(fakes.FakeReporter)
+					proxyReporter = reporter
+				})
+
+				It("reports only the endpoint each queued request is sent to", func() {
+					fast := registerHandler(r, "limited", timedHandler("fast", 20*time.Millisecond))
+					defer fast.Close()
+					slow := registerHandler(r, "limited", timedHandler("slow", 300*time.Millisecond))
+					defer slow.Close()
+
+					const requests = 6
+					statusCodes := make(chan int, requests)
+					for i := 0; i < requests; i++ {
+						go func() {
+							defer GinkgoRecover()
+
+							conn := dialProxy(proxyServer)
+							conn.WriteRequest(test_util.NewRequest("GET", "limited", "/", nil))
+
+							resp, _ := conn.ReadResponse()
+							statusCodes <- resp.StatusCode
+						}()
+					}
+
+					for i := 0; i < requests; i++ {
+						Eventually(statusCodes, 5*time.Second).Should(Receive(Equal(http.StatusOK)))
+					}
+
+					Expect(reporter.CaptureRoutingRequestCallCount()).To(Equal(requests))
+					Expect(reporter.CaptureSelectionTimeCallCount()).To(Equal(requests))
+				})
+			})
+		})
+	})
+
+	Context("with response buffering", func() {
+		// more than the socket buffers between the proxy and the client
+		// can take in
+		const size = 16 * 1024 * 1024
+
+		BeforeEach(func() {
+			conf.MaxConnsPerBackend = 1
+			conf.ResponseBufferSize = 2 * size
+		})
+
+		largeHandler := func(conn *test_util.HttpConn) {
+			defer conn.Close()
+
+			conn.ReadRequest()
+
+			resp := test_util.NewResponse(http.StatusOK)
+			resp.ContentLength = size
+			resp.Body = ioutil.NopCloser(bytes.NewReader(make([]byte, size)))
+			// the proxy gives up on the response when a streaming test
+			// closes its slow client
+			resp.Write(conn)
+		}
+
+		// slowGet receives the headers of a response and leaves the body
+		// unread
+		slowGet := func() *test_util.HttpConn {
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "buffered", "/", nil))
+
+			resp, err := http.ReadResponse(conn.Reader, &http.Request{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			return conn
+		}
+
+		It("releases the backend before a slow client has read the response", func() {
+			ln := registerHandler(r, "buffered", largeHandler)
+			defer ln.Close()
+
+			slow := slowGet()
+			defer slow.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "buffered", "/", nil))
+
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(HaveLen(size))
+
+			_, err := io.CopyN(ioutil.Discard, slow.Reader, size)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when the response is over the buffer size", func() {
+			BeforeEach(func() {
+				conf.ResponseBufferSize = size - 1
+			})
+
+			It("streams it while holding on to the backend", func() {
+				ln := registerHandler(r, "buffered", largeHandler)
+				defer ln.Close()
+
+				slow := slowGet()
+				defer slow.Close()
+
+				conn := dialProxy(proxyServer)
+				conn.WriteRequest(test_util.NewRequest("GET", "buffered", "/", nil))
+
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			})
+		})
+	})
+
+	Context("with server-sent events", func() {
+		const events = 4
+		const pause = 200 * time.Millisecond
+
+		var accepted int32
+
+		BeforeEach(func() {
+			conf.MaxIdleConnsPerBackend = 1
+			atomic.StoreInt32(&accepted, 0)
+		})
+
+		JustBeforeEach(func() {
+			// the stream outlasts the endpoint timeout
+			Expect(events * pause).To(BeNumerically(">", conf.EndpointTimeout))
+		})
+
+		eventHandler := func(conn *test_util.HttpConn) {
+			atomic.AddInt32(&accepted, 1)
+			defer conn.Close()
+
+			for {
+				req, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					return
+				}
+
+				resp := test_util.NewResponse(http.StatusOK)
+				if req.URL.Path == "/events" {
+					r, w := io.Pipe()
+					go func() {
+						defer w.Close()
+						for i := 0; i < events; i++ {
+							time.Sleep(pause)
+							fmt.Fprintf(w, "data: %d\n\n", i)
+						}
+					}()
+
+					resp.Header.Set("Content-Type", "text/event-stream")
+					resp.TransferEncoding = []string{"chunked"}
+					resp.Body = r
+				}
+				resp.Write(conn)
+			}
+		}
+
+		It("delivers every event as it is sent, past the endpoint timeout", func() {
+			ln := registerHandler(r, "sse", eventHandler)
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "sse", "/events", nil))
+
+			resp, err := http.ReadResponse(conn.Reader, &http.Request{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			reader := bufio.NewReader(resp.Body)
+			var arrivals []time.Time
+			for i := 0; i < events; i++ {
+				line, err := reader.ReadString('\n')
+				Expect(err).NotTo(HaveOccurred())
+				Expect(line).To(Equal(fmt.Sprintf("data: %d\n", i)))
+				reader.ReadString('\n')
+
+				arrivals = append(arrivals, time.Now())
+			}
 
-		var answer bool
-		Eventually(done).Should(Receive(&answer))
-		Expect(answer).To(BeTrue())
+			for i := 1; i < events; i++ {
+				Expect(arrivals[i].Sub(arrivals[i-1])).To(BeNumerically(">", pause/2))
+			}
+		})
 
-		resp, _ := conn.ReadResponse()
-		Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		It("keeps the backend connection usable for later requests", func() {
+			ln := registerHandler(r, "sse", eventHandler)
+			defer ln.Close()
 
-		Expect(resp.Header.Get("Upgrade")).To(Equal("Websocket"))
-		Expect(resp.Header.Get("Connection")).To(Equal("Upgrade"))
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "sse", "/events", nil))
 
-		conn.WriteLine("hello from client")
-		conn.CheckLine("hello from server")
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(ContainSubstring(fmt.Sprintf("data: %d", events-1)))
 
-		conn.Close()
+			conn = dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "sse", "/", nil))
+
+			resp, _ = conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(atomic.LoadInt32(&accepted)).To(Equal(int32(1)))
+		})
 	})
 
-	It("upgrades a Tcp request", func() {
-		ln := registerHandler(r, "tcp-handler", func(conn *test_util.HttpConn) {
-			conn.WriteLine("hello")
-			conn.CheckLine("hello from client")
-			conn.WriteLine("hello from server")
-			conn.Close()
+	Context("with backend keep-alive", func() {
+		var accepted int32
+
+		BeforeEach(func() {
+			conf.MaxIdleConnsPerBackend = 2
+			conf.BackendIdleTimeout = time.Minute
+			atomic.StoreInt32(&accepted, 0)
 		})
-		defer ln.Close()
 
-		conn := dialProxy(proxyServer)
+		keepAliveHandler := func(closeAfterResponse bool) connHandler {
+			return func(conn *test_util.HttpConn) {
+				atomic.AddInt32(&accepted, 1)
+				defer conn.Close()
 
-		req := test_util.NewRequest("GET", "tcp-handler", "/chat", nil)
-		req.Header.Set("Upgrade", "tcp")
+				for {
+					_, err := http.ReadRequest(conn.Reader)
+					if err != nil {
+						return
+					}
 
-		req.Header.Set("Connection", "UpgradE")
+					resp := test_util.NewResponse(http.StatusOK)
+					resp.Close = closeAfterResponse
+					conn.WriteResponse(resp)
+				}
+			}
+		}
 
-		conn.WriteRequest(req)
+		get := func() {
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "keepalive", "/", nil))
 
-		conn.CheckLine("hello")
-		conn.WriteLine("hello from client")
-		conn.CheckLine("hello from server")
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
 
-		conn.Close()
-	})
+		It("reuses the connection to the backend", func() {
+			ln := registerHandler(r, "keepalive", keepAliveHandler(false))
+			defer ln.Close()
 
-	It("transfers chunked encodings", func() {
-		ln := registerHandler(r, "chunk", func(conn *test_util.HttpConn) {
-			r, w := io.Pipe()
+			get()
+			get()
 
-			// Write 3 times on a 100ms interval
-			go func() {
-				t := time.NewTicker(100 * time.Millisecond)
-				defer t.Stop()
-				defer w.Close()
+			Expect(atomic.LoadInt32(&accepted)).To(Equal(int32(1)))
+		})
 
-				for i := 0; i < 3; i++ {
-					<-t.C
-					_, err := w.Write([]byte("hello"))
-					Ω(err).NotTo(HaveOccurred())
-				}
-			}()
+		It("does not reuse a connection the backend asked to close", func() {
+			ln := registerHandler(r, "keepalive", keepAliveHandler(true))
+			defer ln.Close()
 
-			_, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			get()
+			get()
 
-			resp := test_util.NewResponse(http.StatusOK)
-			resp.TransferEncoding = []string{"chunked"}
-			resp.Body = r
-			resp.Write(conn)
+			Expect(atomic.LoadInt32(&accepted)).To(Equal(int32(2)))
 		})
-		defer ln.Close()
 
-		conn := dialProxy(proxyServer)
+		Context("when disabled", func() {
+			BeforeEach(func() {
+				conf.MaxIdleConnsPerBackend = 0
+			})
 
-		req := test_util.NewRequest("GET", "chunk", "/", nil)
+			It("opens a new connection per request", func() {
+				ln := registerHandler(r, "keepalive", keepAliveHandler(false))
+				defer ln.Close()
 
-		err := req.Write(conn)
-		Ω(err).NotTo(HaveOccurred())
+				get()
+				get()
 
-		resp, err := http.ReadResponse(conn.Reader, &http.Request{})
-		Ω(err).NotTo(HaveOccurred())
+				Expect(atomic.LoadInt32(&accepted)).To(Equal(int32(2)))
+			})
+		})
+	})
 
-		Expect(resp.StatusCode).To(Equal(http.StatusOK))
-		Expect(resp.TransferEncoding).To(Equal([]string{"chunked"}))
+	Context("with response compression", func() {
+		var plainBody string
 
-		// Expect 3 individual reads to complete
-		b := make([]byte, 16)
-		for i := 0; i < 3; i++ {
-			n, err := resp.Body.Read(b[0:])
-			if err != nil {
-				Expect(err).To(Equal(io.EOF))
+		BeforeEach(func() {
+			conf.CompressResponses = true
+			conf.CompressionMinSize = 1024
+			plainBody = strings.Repeat(`{"name":"some-app","state":"STARTED"}`, 100)
+		})
+
+		respondWith := func(body string, encoding string) connHandler {
+			return func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Header.Set("Content-Type", "application/json")
+				if encoding != "" {
+					resp.Header.Set("Content-Encoding", encoding)
+				}
+				resp.ContentLength = int64(len(body))
+				resp.Body = ioutil.NopCloser(strings.NewReader(body))
+				conn.WriteResponse(resp)
+				conn.Close()
 			}
-			Expect(n).To(Equal(5))
-			Expect(string(b[0:n])).To(Equal("hello"))
 		}
-	})
 
-	It("status no content was no Transfer Encoding response header", func() {
-		ln := registerHandler(r, "not-modified", func(conn *test_util.HttpConn) {
-			_, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+		get := func(acceptEncoding string) (*http.Response, string) {
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "compressed", "/", nil)
+			if acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", acceptEncoding)
+			}
+			conn.WriteRequest(req)
 
-			resp := test_util.NewResponse(http.StatusNoContent)
-			resp.Header.Set("Connection", "close")
-			conn.WriteResponse(resp)
-			conn.Close()
+			return conn.ReadResponse()
+		}
+
+		It("forwards the trailers of compressed responses", func() {
+			ln := registerHandler(r, "compressed", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.TransferEncoding = []string{"chunked"}
+				resp.Body = ioutil.NopCloser(strings.NewReader(plainBody))
+				resp.Trailer = http.Header{"X-Checksum": {"abc"}}
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
+
+			resp, body := get("gzip")
+			Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+			Expect(len(body)).To(BeNumerically("<", len(plainBody)))
+			Expect(resp.Trailer.Get("X-Checksum")).To(Equal("abc"))
 		})
-		defer ln.Close()
 
-		conn := dialProxy(proxyServer)
+		It("gzips responses for clients that accept it", func() {
+			ln := registerHandler(r, "compressed", respondWith(plainBody, ""))
+			defer ln.Close()
 
-		req := test_util.NewRequest("GET", "not-modified", "/", nil)
+			resp, body := get("deflate, gzip")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+			Expect(resp.Header.Get("Content-Length")).To(BeEmpty())
+			Expect(resp.Header.Get("Vary")).To(Equal("Accept-Encoding"))
+			Expect(len(body)).To(BeNumerically("<", len(plainBody)))
+
+			gz, err := gzip.NewReader(strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			decompressed, err := ioutil.ReadAll(gz)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(decompressed)).To(Equal(plainBody))
+		})
 
-		req.Header.Set("Connection", "close")
-		conn.WriteRequest(req)
+		It("does not compress responses below the minimum size", func() {
+			ln := registerHandler(r, "compressed", respondWith("small", ""))
+			defer ln.Close()
 
-		resp, _ := conn.ReadResponse()
-		Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
-		Expect(resp.TransferEncoding).To(BeNil())
-	})
+			resp, body := get("gzip")
+			Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+			Expect(body).To(Equal("small"))
+		})
 
-	It("maintains percent-encoded values in URLs", func() {
-		shouldEcho("/abc%2b%2f%25%20%22%3F%5Edef", "/abc%2b%2f%25%20%22%3F%5Edef") // +, /, %, <space>, ", £, ^
-	})
+		It("does not compress for clients that do not accept gzip", func() {
+			ln := registerHandler(r, "compressed", respondWith(plainBody, ""))
+			defer ln.Close()
 
-	It("does not encode reserved characters in URLs", func() {
-		rfc3986_reserved_characters := "!*'();:@&=+$,/?#[]"
-		shouldEcho("/"+rfc3986_reserved_characters, "/"+rfc3986_reserved_characters)
-	})
+			resp, body := get("gzip;q=0, deflate")
+			Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+			Expect(body).To(Equal(plainBody))
 
-	It("maintains encoding of percent-encoded reserved characters", func() {
-		encoded_reserved_characters := "%21%27%28%29%3B%3A%40%26%3D%2B%24%2C%2F%3F%23%5B%5D"
-		shouldEcho("/"+encoded_reserved_characters, "/"+encoded_reserved_characters)
-	})
+			resp, body = get("")
+			Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+			Expect(body).To(Equal(plainBody))
+		})
 
-	It("does not encode unreserved characters in URLs", func() {
-		shouldEcho("/abc123_.~def", "/abc123_.~def")
-	})
+		It("does not compress responses that are already encoded", func() {
+			ln := registerHandler(r, "compressed", respondWith(plainBody, "br"))
+			defer ln.Close()
 
-	It("does not percent-encode special characters in URLs (they came in like this, they go out like this)", func() {
-		shouldEcho("/abc\"£^def", "/abc\"£^def")
-	})
+			resp, body := get("gzip, br")
+			Expect(resp.Header.Get("Content-Encoding")).To(Equal("br"))
+			Expect(body).To(Equal(plainBody))
+		})
 
-	It("handles requests with encoded query strings", func() {
-		queryString := strings.Join([]string{"a=b", url.QueryEscape("b= bc "), url.QueryEscape("c=d&e")}, "&")
-		shouldEcho("/test?a=b&b%3D+bc+&c%3Dd%26e", "/test?"+queryString)
-	})
+		Context("when disabled", func() {
+			BeforeEach(func() {
+				conf.CompressResponses = false
+			})
 
-	It("request terminates with slow response", func() {
-		ln := registerHandler(r, "slow-app", func(conn *test_util.HttpConn) {
-			_, err := http.ReadRequest(conn.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			It("passes responses through unchanged", func() {
+				ln := registerHandler(r, "compressed", respondWith(plainBody, ""))
+				defer ln.Close()
 
-			time.Sleep(1 * time.Second)
-			resp := test_util.NewResponse(http.StatusOK)
-			conn.WriteResponse(resp)
-			conn.Close()
+				resp, body := get("gzip")
+				Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+				Expect(body).To(Equal(plainBody))
+			})
 		})
-		defer ln.Close()
+	})
 
-		conn := dialProxy(proxyServer)
+	Context("with a URI length limit", func() {
+		BeforeEach(func() {
+			conf.MaxURILength = 32
+		})
 
-		req := test_util.NewRequest("GET", "slow-app", "/", nil)
+		It("routes requests within the limit", func() {
+			ln := registerHandler(r, "limited", func(conn *test_util.HttpConn) {
+				req, _ := conn.ReadRequest()
+				Expect(req.URL.RequestURI()).To(Equal("/short?q=1"))
 
-		started := time.Now()
-		conn.WriteRequest(req)
+				conn.WriteResponse(test_util.NewResponse(http.StatusOK))
+				conn.Close()
+			})
+			defer ln.Close()
 
-		resp, _ := readResponse(conn)
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "limited", "/short?q=1", nil))
 
-		Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
-		Expect(time.Since(started)).To(BeNumerically("<", time.Duration(800*time.Millisecond)))
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("rejects a longer URI with a 414 before routing it", func() {
+			contacted := make(chan struct{}, 1)
+			ln := registerHandler(r, "limited", func(conn *test_util.HttpConn) {
+				contacted <- struct{}{}
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "limited", "/"+strings.Repeat("a", 32), nil))
+
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestURITooLong))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("uri_too_long"))
+			Consistently(contacted).ShouldNot(Receive())
+		})
 	})
 
-	It("proxy detects closed client connection", func() {
-		serverResult := make(chan error)
-		ln := registerHandler(r, "slow-app", func(conn *test_util.HttpConn) {
-			conn.CheckLine("GET / HTTP/1.1")
+	Context("with a request body size limit", func() {
+		BeforeEach(func() {
+			conf.MaxRequestBodySize = 16
+		})
 
-			timesToTick := 10
+		It("forwards bodies within the limit", func() {
+			ln := registerHandler(r, "limited", func(conn *test_util.HttpConn) {
+				req, body := conn.ReadRequest()
+				Expect(req.Method).To(Equal("POST"))
+				Expect(body).To(Equal("0123456789abcdef"))
 
-			conn.WriteLines([]string{
-				"HTTP/1.1 200 OK",
-				fmt.Sprintf("Content-Length: %d", timesToTick),
+				resp := test_util.NewResponse(http.StatusOK)
+				conn.WriteResponse(resp)
+				conn.Close()
 			})
+			defer ln.Close()
 
-			for i := 0; i < timesToTick; i++ {
-				_, err := conn.Conn.Write([]byte("x"))
-				if err != nil {
-					serverResult <- err
-					return
-				}
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("POST", "limited", "/", ioutil.NopCloser(strings.NewReader("0123456789abcdef")))
+			conn.WriteRequest(req)
 
-				time.Sleep(100 * time.Millisecond)
-			}
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
 
-			serverResult <- nil
+		It("rejects a Content-Length over the limit without contacting the backend", func() {
+			contacted := make(chan struct{}, 1)
+			ln := registerHandler(r, "limited", func(conn *test_util.HttpConn) {
+				contacted <- struct{}{}
+				conn.Close()
+			})
+			defer ln.Close()
+
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("POST", "limited", "/", strings.NewReader("0123456789abcdef-too-long"))
+			conn.WriteRequest(req)
+
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("request_too_large"))
+			Expect(body).To(Equal("413 Request Entity Too Large: Request body exceeds the maximum allowed size.\n"))
+			Consistently(contacted).ShouldNot(Receive())
 		})
-		defer ln.Close()
 
-		conn := dialProxy(proxyServer)
+		It("stops forwarding a chunked body once it exceeds the limit", func() {
+			received := make(chan int, 1)
+			ln := registerHandler(r, "limited", func(conn *test_util.HttpConn) {
+				req, err := http.ReadRequest(conn.Reader)
+				if err != nil {
+					received <- -1
+					return
+				}
+				b, _ := ioutil.ReadAll(req.Body)
+				received <- len(b)
+				conn.Close()
+			})
+			defer ln.Close()
 
-		req := test_util.NewRequest("GET", "slow-app", "/", nil)
-		conn.WriteRequest(req)
+			payload := strings.Repeat("x", 1024)
 
-		conn.Conn.Close()
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("POST", "limited", "/", ioutil.NopCloser(strings.NewReader(payload)))
+			conn.WriteRequest(req)
 
-		var err error
-		Eventually(serverResult).Should(Receive(&err))
-		Ω(err).NotTo(BeNil())
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+
+			var n int
+			Eventually(received).Should(Receive(&n))
+			Expect(n).To(BeNumerically("<=", 16))
+		})
 	})
 
-	Context("respect client keepalives", func() {
-		It("closes the connection when told to close", func() {
-			ln := registerHandler(r, "remote", func(conn *test_util.HttpConn) {
-				http.ReadRequest(conn.Reader)
+	Context("with a response header size limit", func() {
+		BeforeEach(func() {
+			conf.MaxResponseHeaderBytes = 4096
+		})
+
+		It("forwards responses with headers within the limit", func() {
+			ln := registerHandler(r, "limited", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
 				resp := test_util.NewResponse(http.StatusOK)
-				resp.Close = true
+				resp.Header.Set("X-Small", "small")
 				conn.WriteResponse(resp)
 				conn.Close()
 			})
 			defer ln.Close()
 
 			conn := dialProxy(proxyServer)
-
-			req := test_util.NewRequest("GET", "remote", "/", nil)
-			req.Close = true
+			req := test_util.NewRequest("GET", "limited", "/", nil)
 			conn.WriteRequest(req)
+
 			resp, _ := conn.ReadResponse()
 			Expect(resp.StatusCode).To(Equal(http.StatusOK))
-
-			conn.WriteRequest(req)
-			_, err := http.ReadResponse(conn.Reader, &http.Request{})
-			Expect(err).To(HaveOccurred())
+			Expect(resp.Header.Get("X-Small")).To(Equal("small"))
 		})
 
-		It("keeps the connection alive", func() {
-			ln := registerHandler(r, "remote", func(conn *test_util.HttpConn) {
-				http.ReadRequest(conn.Reader)
-				resp := test_util.NewResponse(http.StatusOK)
-				resp.Close = true
-				conn.WriteResponse(resp)
+		It("responds with 502 when the backend sends an enormous header block", func() {
+			ln := registerHandler(r, "limited", func(conn *test_util.HttpConn) {
+				conn.ReadRequest()
+				conn.WriteLine("HTTP/1.1 200 OK")
+				for i := 0; i < 1024; i++ {
+					conn.WriteLine(fmt.Sprintf("X-Huge-%d: %s", i, strings.Repeat("x", 1024)))
+				}
+				conn.WriteLine("")
 				conn.Close()
 			})
 			defer ln.Close()
 
 			conn := dialProxy(proxyServer)
-
-			req := test_util.NewRequest("GET", "remote", "/", nil)
-			req.Close = false
+			req := test_util.NewRequest("GET", "limited", "/", nil)
 			conn.WriteRequest(req)
-			resp, _ := conn.ReadResponse()
-			Expect(resp.StatusCode).To(Equal(http.StatusOK))
 
-			conn.WriteRequest(req)
-			_, err := http.ReadResponse(conn.Reader, &http.Request{})
-			Expect(err).ToNot(HaveOccurred())
+			resp, body := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("endpoint_failure"))
+			Expect(body).To(Equal("502 Bad Gateway: Registered endpoint failed to handle the request.\n"))
 		})
-
 	})
 
-	It("disables compression", func() {
-		ln := registerHandler(r, "remote", func(conn *test_util.HttpConn) {
-			request, _ := http.ReadRequest(conn.Reader)
-			encoding := request.Header["Accept-Encoding"]
-			var resp *http.Response
-			if len(encoding) != 0 {
-				resp = test_util.NewResponse(http.StatusInternalServerError)
-			} else {
-				resp = test_util.NewResponse(http.StatusOK)
+	Context("with a health checker", func() {
+		It("stops routing to backends failing their health check", func() {
+			var failing int32
+
+			handler := func(name string, canFail bool) connHandler {
+				return func(conn *test_util.HttpConn) {
+					req, _ := conn.ReadRequest()
+					resp := test_util.NewResponse(http.StatusOK)
+					if req.URL.Path == "/health" && canFail && atomic.LoadInt32(&failing) == 1 {
+						resp = test_util.NewResponse(http.StatusServiceUnavailable)
+					}
+					resp.Header.Set("X-Backend", name)
+					conn.WriteResponse(resp)
+					conn.Close()
+				}
 			}
-			conn.WriteResponse(resp)
-			conn.Close()
+
+			ln1 := registerHandler(r, "health-app", handler("stable", false))
+			defer ln1.Close()
+			ln2 := registerHandler(r, "health-app", handler("flapping", true))
+			defer ln2.Close()
+
+			checkConf := config.DefaultConfig()
+			checkConf.HealthCheckPath = "/health"
+			checkConf.HealthCheckUnhealthyThreshold = 1
+			checker := healthcheck.NewHealthChecker(steno.NewLogger("test"), r, checkConf)
+
+			backends := func() map[string]bool {
+				seen := make(map[string]bool)
+				for i := 0; i < 10; i++ {
+					conn := dialProxy(proxyServer)
+					req := test_util.NewRequest("GET", "health-app", "/", nil)
+					conn.WriteRequest(req)
+					resp, _ := conn.ReadResponse()
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					seen[resp.Header.Get("X-Backend")] = true
+				}
+				return seen
+			}
+
+			atomic.StoreInt32(&failing, 1)
+			checker.Check()
+			Expect(backends()).To(Equal(map[string]bool{"stable": true}))
+
+			atomic.StoreInt32(&failing, 0)
+			checker.Check()
+			Expect(backends()).To(HaveKey("flapping"))
 		})
-		defer ln.Close()
+	})
 
-		conn := dialProxy(proxyServer)
+	Context("with header-hash load balancing", func() {
+		BeforeEach(func() {
+			conf.LoadBalancing = config.LoadBalancingHeaderHash
+			conf.LoadBalancingHashHeader = "X-Tenant-Id"
+		})
 
-		req := test_util.NewRequest("GET", "remote", "/", nil)
-		conn.WriteRequest(req)
-		resp, _ := conn.ReadResponse()
-		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		It("sends the requests of a tenant to the same backend", func() {
+			for i := 0; i < 3; i++ {
+				name := fmt.Sprintf("backend-%d", i)
+				ln := registerHandler(r, "header-hash", func(conn *test_util.HttpConn) {
+					conn.CheckLine("GET / HTTP/1.1")
+					resp := test_util.NewResponse(http.StatusOK)
+					resp.Header.Set("X-Backend", name)
+					conn.WriteResponse(resp)
+					conn.Close()
+				})
+				defer ln.Close()
+			}
+
+			backendFor := func(tenant string) string {
+				conn := dialProxy(proxyServer)
+				req := test_util.NewRequest("GET", "header-hash", "/", nil)
+				req.Header.Set("X-Tenant-Id", tenant)
+				conn.WriteRequest(req)
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				return resp.Header.Get("X-Backend")
+			}
+
+			seen := make(map[string]bool)
+			for t := 0; t < 10; t++ {
+				tenant := fmt.Sprintf("tenant-%d", t)
+				backend := backendFor(tenant)
+				for i := 0; i < 3; i++ {
+					Expect(backendFor(tenant)).To(Equal(backend))
+				}
+				seen[backend] = true
+			}
+			Expect(len(seen)).To(BeNumerically(">", 1))
+		})
 	})
 
-	It("retries when failed endpoints exist", func() {
-		ln := registerHandler(r, "retries", func(conn *test_util.HttpConn) {
-			conn.CheckLine("GET / HTTP/1.1")
-			resp := test_util.NewResponse(http.StatusOK)
-			conn.WriteResponse(resp)
-			conn.Close()
+	Context("with least-connections load balancing", func() {
+		BeforeEach(func() {
+			conf.LoadBalancing = config.LoadBalancingLeastConnections
 		})
-		defer ln.Close()
 
-		ip, err := net.ResolveTCPAddr("tcp", "localhost:81")
-		Expect(err).To(BeNil())
-		registerAddr(r, "retries", "", ip, "instanceId")
+		It("sends requests to the backend with the fewest requests in flight", func() {
+			arrived := make(chan string, 2)
+			release := make(chan struct{})
 
-		for i := 0; i < 5; i++ {
-			conn := dialProxy(proxyServer)
+			blockingHandler := func(name string) connHandler {
+				return func(conn *test_util.HttpConn) {
+					conn.CheckLine("GET / HTTP/1.1")
+					arrived <- name
+					<-release
+					resp := test_util.NewResponse(http.StatusOK)
+					conn.WriteResponse(resp)
+					conn.Close()
+				}
+			}
 
-			req := test_util.NewRequest("GET", "retries", "/", nil)
-			conn.WriteRequest(req)
-			resp, _ := conn.ReadResponse()
+			ln1 := registerHandler(r, "least-conn", blockingHandler("first"))
+			defer ln1.Close()
+			ln2 := registerHandler(r, "least-conn", blockingHandler("second"))
+			defer ln2.Close()
 
-			Expect(resp.StatusCode).To(Equal(http.StatusOK))
-		}
-	})
+			done := make(chan struct{}, 2)
+			send := func() {
+				defer GinkgoRecover()
+				conn := dialProxy(proxyServer)
+				req := test_util.NewRequest("GET", "least-conn", "/", nil)
+				conn.WriteRequest(req)
+				resp, _ := conn.ReadResponse()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				done <- struct{}{}
+			}
 
-	Context("Access log", func() {
-		It("Logs a request", func() {
-			ln := registerHandler(r, "test", func(conn *test_util.HttpConn) {
-				req, body := conn.ReadRequest()
-				Expect(req.Method).To(Equal("POST"))
-				Expect(req.URL.Path).To(Equal("/"))
-				Expect(req.ProtoMajor).To(Equal(1))
-				Expect(req.ProtoMinor).To(Equal(1))
+			go send()
+			var busy string
+			Eventually(arrived).Should(Receive(&busy))
 
-				Expect(body).To(Equal("ABCD"))
+			go send()
+			var next string
+			Eventually(arrived).Should(Receive(&next))
+			Expect(next).ToNot(Equal(busy))
 
-				rsp := test_util.NewResponse(200)
-				out := &bytes.Buffer{}
-				out.WriteString("DEFG")
-				rsp.Body = ioutil.NopCloser(out)
-				conn.WriteResponse(rsp)
+			close(release)
+			Eventually(done).Should(Receive())
+			Eventually(done).Should(Receive())
+		})
+
+		It("releases the in-flight slot once the response completes", func() {
+			ln := registerHandler(r, "least-conn", func(conn *test_util.HttpConn) {
+				conn.CheckLine("GET / HTTP/1.1")
+				resp := test_util.NewResponse(http.StatusOK)
+				conn.WriteResponse(resp)
+				conn.Close()
 			})
 			defer ln.Close()
 
 			conn := dialProxy(proxyServer)
-
-			body := &bytes.Buffer{}
-			body.WriteString("ABCD")
-			req := test_util.NewRequest("POST", "test", "/", ioutil.NopCloser(body))
+			req := test_util.NewRequest("GET", "least-conn", "/", nil)
 			conn.WriteRequest(req)
-
 			resp, _ := conn.ReadResponse()
 			Expect(resp.StatusCode).To(Equal(http.StatusOK))
 
-			var payload []byte
-			Eventually(func() int {
-				accessLogFile.Read(&payload)
-				return len(payload)
-			}).ShouldNot(BeZero())
-
-			//make sure the record includes all the data
-			//since the building of the log record happens throughout the life of the request
-			Expect(strings.HasPrefix(string(payload), "test - [")).To(BeTrue())
-			Expect(string(payload)).To(ContainSubstring(`"POST / HTTP/1.1" 200 4 4 "-"`))
-			Expect(string(payload)).To(ContainSubstring(`x_forwarded_for:"127.0.0.1" x_forwarded_proto:"-" vcap_request_id:`))
-			Expect(string(payload)).To(ContainSubstring(`response_time:`))
-			Expect(string(payload)).To(ContainSubstring(`app_id:`))
-			Expect(payload[len(payload)-1]).To(Equal(byte('\n')))
+			pool := r.Lookup(route.Uri("least-conn"))
+			var endpoint *route.Endpoint
+			pool.Each(func(e *route.Endpoint) {
+				endpoint = e
+			})
+			Eventually(func() int { return pool.InFlight(endpoint) }).Should(Equal(0))
 		})
 
-		It("Logs a request when it exits early", func() {
-			conn := dialProxy(proxyServer)
-
-			conn.WriteLines([]string{
-				"GET / HTTP/0.9",
-				"Host: test",
+		It("releases the in-flight slot when the backend fails", func() {
+			ln := registerHandler(r, "least-conn", func(conn *test_util.HttpConn) {
+				conn.Close()
 			})
+			defer ln.Close()
 
+			conn := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "least-conn", "/", nil)
+			conn.WriteRequest(req)
 			resp, _ := conn.ReadResponse()
-			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
-
-			var payload []byte
-			Eventually(func() int {
-				n, e := accessLogFile.Read(&payload)
-				Expect(e).ToNot(HaveOccurred())
-				return n
-			}).ShouldNot(BeZero())
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
 
-			Expect(string(payload)).To(MatchRegexp("^test.*\n"))
+			pool := r.Lookup(route.Uri("least-conn"))
+			var endpoint *route.Endpoint
+			pool.Each(func(e *route.Endpoint) {
+				endpoint = e
+			})
+			Expect(pool.InFlight(endpoint)).To(Equal(0))
 		})
+	})
 
-		Context("when the request is a TCP Upgrade", func() {
-			It("Logs the response time", func() {
-
-				ln := registerHandler(r, "tcp-handler", func(conn *test_util.HttpConn) {
-					conn.WriteLine("hello")
-					conn.CheckLine("hello from client")
-					conn.WriteLine("hello from server")
-					conn.Close()
-				})
-				defer ln.Close()
+	Context("when draining", func() {
+		It("completes in-flight requests and rejects new ones", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
 
-				conn := dialProxy(proxyServer)
+			ln := registerHandler(r, "drain", func(conn *test_util.HttpConn) {
+				conn.CheckLine("GET / HTTP/1.1")
+				close(started)
+				<-release
 
-				req := test_util.NewRequest("GET", "tcp-handler", "/chat", nil)
-				req.Header.Set("Upgrade", "tcp")
+				resp := test_util.NewResponse(http.StatusOK)
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
 
-				req.Header.Set("Connection", "Upgrade")
+			inFlight := dialProxy(proxyServer)
+			inFlight.WriteRequest(test_util.NewRequest("GET", "drain", "/", nil))
+			Eventually(started).Should(BeClosed())
 
-				conn.WriteRequest(req)
+			drainResult := make(chan error)
+			go func() {
+				drainResult <- p.Drain(time.Second)
+			}()
+			Consistently(drainResult, 100*time.Millisecond).ShouldNot(Receive())
 
-				conn.CheckLine("hello")
-				conn.WriteLine("hello from client")
-				conn.CheckLine("hello from server")
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("GET", "drain", "/", nil))
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Header.Get("X-Cf-RouterError")).To(Equal("draining"))
 
-				var payload []byte
-				Eventually(func() int {
-					accessLogFile.Read(&payload)
-					return len(payload)
-				}).ShouldNot(BeZero())
+			close(release)
 
-				Expect(string(payload)).To(ContainSubstring(`response_time:`))
-				responseTime := parseResponseTimeFromLog(string(payload))
-				Expect(responseTime).To(BeNumerically(">", 0))
+			resp, _ = inFlight.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
 
-				conn.Close()
-			})
+			Eventually(drainResult).Should(Receive(BeNil()))
 		})
 
-		Context("when the request is a web connection", func() {
-			It("Logs the response time", func() {
-				done := make(chan bool)
-				ln := registerHandler(r, "ws", func(conn *test_util.HttpConn) {
-					req, err := http.ReadRequest(conn.Reader)
-					Ω(err).NotTo(HaveOccurred())
-
-					done <- req.Header.Get("Upgrade") == "Websocket" &&
-						req.Header.Get("Connection") == "Upgrade"
+		It("returns from every drain once the in-flight requests complete", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
 
-					resp := test_util.NewResponse(http.StatusSwitchingProtocols)
-					resp.Header.Set("Upgrade", "Websocket")
-					resp.Header.Set("Connection", "Upgrade")
+			ln := registerHandler(r, "drain-twice", func(conn *test_util.HttpConn) {
+				conn.CheckLine("GET / HTTP/1.1")
+				close(started)
+				<-release
 
-					conn.WriteResponse(resp)
+				resp := test_util.NewResponse(http.StatusOK)
+				conn.WriteResponse(resp)
+				conn.Close()
+			})
+			defer ln.Close()
 
-					conn.CheckLine("hello from client")
-					conn.WriteLine("hello from server")
-					conn.Close()
-				})
-				defer ln.Close()
+			inFlight := dialProxy(proxyServer)
+			inFlight.WriteRequest(test_util.NewRequest("GET", "drain-twice", "/", nil))
+			Eventually(started).Should(BeClosed())
 
-				conn := dialProxy(proxyServer)
+			drainResults := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					drainResults <- p.Drain(time.Second)
+				}()
+			}
+			Consistently(drainResults, 100*time.Millisecond).ShouldNot(Receive())
 
-				req := test_util.NewRequest("GET", "ws", "/chat", nil)
-				req.Header.Set("Upgrade", "Websocket")
-				req.Header.Set("Connection", "Upgrade")
+			close(release)
 
-				conn.WriteRequest(req)
+			resp, _ := inFlight.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
 
-				var answer bool
-				Eventually(done).Should(Receive(&answer))
-				Expect(answer).To(BeTrue())
+			Eventually(drainResults).Should(Receive(BeNil()))
+			Eventually(drainResults).Should(Receive(BeNil()))
+		})
 
-				resp, _ := conn.ReadResponse()
-				Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
-				Expect(resp.Header.Get("Upgrade")).To(Equal("Websocket"))
-				Expect(resp.Header.Get("Connection")).To(Equal("Upgrade"))
+		It("closes backend connections when the timeout expires", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			defer close(release)
 
-				conn.WriteLine("hello from client")
-				conn.CheckLine("hello from server")
+			ln := registerHandler(r, "drain-timeout", func(conn *test_util.HttpConn) {
+				close(started)
+				<-release
+				conn.Close()
+			})
+			defer ln.Close()
 
-				var payload []byte
-				Eventually(func() int {
-					accessLogFile.Read(&payload)
-					return len(payload)
-				}).ShouldNot(BeZero())
+			conn := dialProxy(proxyServer)
+			conn.WriteRequest(test_util.NewRequest("POST", "drain-timeout", "/", nil))
+			Eventually(started).Should(BeClosed())
 
-				Expect(string(payload)).To(ContainSubstring(`response_time:`))
-				responseTime := parseResponseTimeFromLog(string(payload))
-				Expect(responseTime).To(BeNumerically(">", 0))
+			err := p.Drain(100 * time.Millisecond)
+			Expect(err).To(Equal(proxy.DrainTimeout))
 
-				conn.Close()
-			})
+			resp, _ := conn.ReadResponse()
+			Expect(resp.StatusCode).To(Equal(http.StatusBadGateway))
 		})
 	})
 
 	Context("when the endpoint is nil", func() {
-		It("responds with a 502 BadGateway", func() {
+		It("responds with a 503 ServiceUnavailable", func() {
 			ln := registerHandler(r, "nil-endpoint", func(conn *test_util.HttpConn) {
 				conn.CheckLine("GET / HTTP/1.1")
 				resp := test_util.NewResponse(http.StatusOK)
@@ -1118,11 +5621,24 @@ var _ = Describe("Proxy", func() {
 			res, _ := conn.ReadResponse()
 			log.SetOutput(os.Stderr)
 			Expect(buf).NotTo(ContainSubstring("multiple response.WriteHeader calls"))
-			Expect(res.StatusCode).To(Equal(http.StatusBadGateway))
+			Expect(res.StatusCode).To(Equal(http.StatusServiceUnavailable))
 		})
 	})
 })
 
+// headerSelector sends each request to the backend named by its X-Backend
+// header.
+type headerSelector struct{}
+
+func (headerSelector) Select(candidates []route.Candidate, request *http.Request) (*route.Endpoint, bool) {
+	for _, c := range candidates {
+		if c.Endpoint.CanonicalAddr() == request.Header.Get("X-Backend") {
+			return c.Endpoint, true
+		}
+	}
+	return nil, false
+}
+
 // HACK: this is used to silence any http warnings in logs
 // that clutter stdout/stderr when running unit tests
 func readResponse(conn *test_util.HttpConn) (*http.Response, string) {
@@ -89,4 +89,19 @@ var _ = Describe("Proxy Unit tests", func() {
 			})
 		})
 	})
+
+	Context("NewBackendDialer", func() {
+		It("enables TCP keepalive with the configured period", func() {
+			dialer := proxy.NewBackendDialer(5*time.Second, 30*time.Second)
+
+			Expect(dialer.Timeout).To(Equal(5 * time.Second))
+			Expect(dialer.KeepAlive).To(Equal(30 * time.Second))
+		})
+
+		It("disables TCP keepalive without a period", func() {
+			dialer := proxy.NewBackendDialer(5*time.Second, 0)
+
+			Expect(dialer.KeepAlive).To(BeNumerically("<", 0))
+		})
+	})
 })
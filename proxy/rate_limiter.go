@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const rateLimiterSweepInterval = time.Minute
+
+// rateLimiter limits the requests of each client with a token bucket that
+// holds up to burst tokens and refills at rate tokens per second. Buckets
+// that have refilled completely are forgotten, so idle clients do not hold
+// on to memory. A nil limiter lets every request through.
+type rateLimiter struct {
+	lock      sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the client's bucket. When the bucket is empty it
+// returns how long the client has to wait for the next token.
+func (l *rateLimiter) allow(clientAddr string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	client, _, err := net.SplitHostPort(clientAddr)
+	if err != nil {
+		client = clientAddr
+	}
+
+	now := time.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	} else {
+		bucket.tokens = l.refill(bucket, now)
+		bucket.updated = now
+	}
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.updated).Seconds()*l.rate
+	return math.Min(tokens, l.burst)
+}
+
+// sweep drops the buckets that are full again, they are no different from
+// the bucket a new client starts with.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// settings are the parts of the proxy's configuration Reload can change. A
+// request keeps the settings in effect when it arrived until it completes.
+type settings struct {
+	endpointTimeout    time.Duration
+	maxAttempts        int
+	retryBackoff       RetryBackoff
+	maxURILength       int
+	maxRequestBodySize int64
+	maxRequestsPerConn int
+	compressResponses  bool
+	compressionMinSize int64
+	extraHeadersToLog  []string
+	errorPages         map[int]config.ErrorPage
+	responseHeaders    config.ResponseHeadersConfig
+	viaPseudonym       string
+	exposeTimingHeader bool
+	httpsRedirectPort  uint16
+
+	forwardedClientCertHeader string
+	forwardedClientCertFormat string
+}
+
+// NoRetries is the MaxRetries of a proxy that sends every request to a
+// single endpoint. ProxyArgs leaving MaxRetries at zero retry twice, as the
+// default configuration does.
+const NoRetries = -1
+
+// RetriesArg returns the MaxRetries for the max_retries of a configuration,
+// where 0 means no retries.
+func RetriesArg(maxRetries int) int {
+	if maxRetries <= 0 {
+		return NoRetries
+	}
+	return maxRetries
+}
+
+func newSettings(args ProxyArgs) *settings {
+	maxRetries := args.MaxRetries
+	switch {
+	case maxRetries == 0:
+		maxRetries = defaultMaxRetries
+	case maxRetries < 0:
+		maxRetries = 0
+	}
+
+	return &settings{
+		endpointTimeout:    args.EndpointTimeout,
+		maxAttempts:        maxRetries + 1,
+		retryBackoff:       args.RetryBackoff,
+		maxURILength:       args.MaxURILength,
+		maxRequestBodySize: args.MaxRequestBodySize,
+		maxRequestsPerConn: args.MaxRequestsPerConn,
+		compressResponses:  args.CompressResponses,
+		compressionMinSize: args.CompressionMinSize,
+		extraHeadersToLog:  args.ExtraHeadersToLog,
+		errorPages:         args.ErrorPages,
+		responseHeaders:    args.ResponseHeaders,
+		viaPseudonym:       args.ViaPseudonym,
+		exposeTimingHeader: args.ExposeTimingHeader,
+		httpsRedirectPort:  args.HTTPSRedirectPort,
+
+		forwardedClientCertHeader: args.ForwardedClientCertHeader,
+		forwardedClientCertFormat: args.ForwardedClientCertFormat,
+	}
+}
+
+func (p *proxy) settings() *settings {
+	return p.currentSettings.Load().(*settings)
+}
+
+// Reload applies the endpoint timeout, retries and their backoff, URI length,
+// request body, concurrent request and requests per connection limits,
+// compression, logged headers, error pages, response headers, Via pseudonym,
+// timing header, forwarded client certificate header, force_https and IP
+// lists of the configuration to the requests arriving from now on. Requests
+// in flight are not affected. The rest of the configuration, such as the
+// listeners, the connections to backends, the response cache and the rate
+// limit, keeps the values the proxy was created with.
+func (p *proxy) Reload(c *config.Config) {
+	args := ProxyArgs{
+		EndpointTimeout:    c.EndpointTimeout,
+		MaxRetries:         RetriesArg(c.MaxRetries),
+		MaxURILength:       c.MaxURILength,
+		MaxRequestBodySize: c.MaxRequestBodySize,
+		MaxRequestsPerConn: c.MaxRequestsPerConn,
+		CompressResponses:  c.CompressResponses,
+		CompressionMinSize: c.CompressionMinSize,
+		ExtraHeadersToLog:  c.ExtraHeadersToLog,
+		ErrorPages:         c.ErrorPages,
+		ResponseHeaders:    c.ResponseHeaders,
+		ViaPseudonym:       c.ViaPseudonym,
+		ExposeTimingHeader: c.ExposeTimingHeader,
+
+		RetryBackoff: RetryBackoff{
+			Delay:        c.RetryBackoff.Delay,
+			Jitter:       c.RetryBackoff.Jitter,
+			MaxRetryTime: c.RetryBackoff.MaxRetryTime,
+		},
+
+		ForwardedClientCertHeader: c.ForwardedClientCertHeader,
+		ForwardedClientCertFormat: c.ForwardedClientCertFormat,
+	}
+	if c.ForceHTTPS {
+		args.HTTPSRedirectPort = c.SSLPort
+	}
+	p.currentSettings.Store(newSettings(args))
+
+	p.SetIPPolicy(c.IPAllowNetworks, c.IPDenyNetworks)
+
+	p.drainLock.Lock()
+	p.maxRequests = c.MaxConcurrentRequests
+	p.drainLock.Unlock()
+
+	p.logger.Info("proxy.reloaded")
+}
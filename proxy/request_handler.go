@@ -2,8 +2,10 @@ package proxy
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"strconv"
@@ -13,6 +15,7 @@ import (
 	"github.com/cloudfoundry/gorouter/access_log"
 	"github.com/cloudfoundry/gorouter/common"
 	router_http "github.com/cloudfoundry/gorouter/common/http"
+	"github.com/cloudfoundry/gorouter/config"
 	"github.com/cloudfoundry/gorouter/route"
 	steno "github.com/cloudfoundry/gosteno"
 "github.com/cloudfoundry/gorouter/metrics"
@@ -22,17 +25,24 @@ type RequestHandler struct {
 	StenoLogger *steno.Logger
 	reporter    metrics.ProxyReporter
 	logrecord   *access_log.AccessLogRecord
+	errorPages  map[int]config.ErrorPage
+	dialer      *net.Dialer
+	maxAttempts int
 
 	request  *http.Request
 	response ProxyResponseWriter
 }
 
 func NewRequestHandler(request *http.Request, response ProxyResponseWriter, r metrics.ProxyReporter,
-	alr *access_log.AccessLogRecord) RequestHandler {
+	alr *access_log.AccessLogRecord, errorPages map[int]config.ErrorPage, dialer *net.Dialer,
+	maxAttempts int) RequestHandler {
 	return RequestHandler{
 		StenoLogger: createLogger(request),
 		reporter:    r,
 		logrecord:   alr,
+		errorPages:  errorPages,
+		dialer:      dialer,
+		maxAttempts: maxAttempts,
 
 		request:  request,
 		response: response,
@@ -55,15 +65,63 @@ func (h *RequestHandler) Logger() *steno.Logger {
 	return h.StenoLogger
 }
 
-func (h *RequestHandler) HandleHeartbeat() {
+func (h *RequestHandler) HandleHeartbeat(status int, body string) {
 	h.response.Header().Set("Cache-Control", "private, max-age=0")
 	h.response.Header().Set("Expires", "0")
-	h.logrecord.StatusCode = http.StatusOK
-	h.response.WriteHeader(http.StatusOK)
-	h.response.Write([]byte("ok\n"))
+	h.logrecord.StatusCode = status
+	h.response.WriteHeader(status)
+	h.response.Write([]byte(body))
 	h.request.Close = true
 }
 
+func (h *RequestHandler) HandleCorsPreflight(cors *route.CORS) {
+	cors.PreflightHeaders(h.request, h.response.Header())
+	h.logrecord.StatusCode = http.StatusNoContent
+	h.response.WriteHeader(http.StatusNoContent)
+	h.response.Done()
+}
+
+func (h *RequestHandler) HandleHTTPSRedirect(location string) {
+	h.response.Header().Set("Location", location)
+	h.logrecord.StatusCode = http.StatusMovedPermanently
+	h.response.WriteHeader(http.StatusMovedPermanently)
+	h.response.Done()
+}
+
+func (h *RequestHandler) HandleCachedResponse(cached *cachedResponse) {
+	h.response.Header().Set("Age", strconv.Itoa(int(time.Since(cached.stored).Seconds())))
+	h.writeCachedResponse(cached)
+}
+
+// HandleReplayedResponse answers a repeat of an idempotent request with the
+// response to the first one. Unlike a cached response it is not a copy of a
+// resource that ages, so it gets no Age header.
+func (h *RequestHandler) HandleReplayedResponse(cached *cachedResponse) {
+	h.response.Header().Set("Idempotent-Replayed", "true")
+	h.writeCachedResponse(cached)
+}
+
+func (h *RequestHandler) HandleIdempotencyKeyReused(err error) {
+	h.StenoLogger.Set("Error", err.Error())
+	h.StenoLogger.Warnf("proxy.idempotency-key.reused")
+
+	h.response.Header().Set("X-Cf-RouterError", "idempotency_key_reused")
+	h.writeStatus(http.StatusUnprocessableEntity, "Idempotency-Key was already used for another request.")
+	h.response.Done()
+}
+
+func (h *RequestHandler) writeCachedResponse(cached *cachedResponse) {
+	header := h.response.Header()
+	for name, values := range cached.header {
+		header[name] = append([]string(nil), values...)
+	}
+
+	h.logrecord.StatusCode = cached.statusCode
+	h.response.WriteHeader(cached.statusCode)
+	h.response.Write(cached.body)
+	h.response.Done()
+}
+
 func (h *RequestHandler) HandleUnsupportedProtocol() {
 	// must be hijacked, otherwise no response is sent back
 	conn, buf, err := h.hijack()
@@ -86,6 +144,48 @@ func (h *RequestHandler) HandleMissingRoute() {
 	h.writeStatus(http.StatusNotFound, message)
 }
 
+func (h *RequestHandler) HandleMissingHost() {
+	h.StenoLogger.Warnf("proxy.request.missing-host")
+
+	h.response.Header().Set("X-Cf-RouterError", "missing_host")
+	h.writeStatus(http.StatusBadRequest, "Request has no Host header.")
+}
+
+func (h *RequestHandler) HandleURITooLong() {
+	h.StenoLogger.Warnf("proxy.request.uri-too-long")
+
+	h.response.Header().Set("X-Cf-RouterError", "uri_too_long")
+	h.writeStatus(http.StatusRequestURITooLong, "Request URI exceeds the maximum allowed length.")
+	h.response.Done()
+}
+
+func (h *RequestHandler) HandleRequestEntityTooLarge() {
+	h.StenoLogger.Warnf("proxy.request.too-large")
+
+	h.response.Header().Set("X-Cf-RouterError", "request_too_large")
+	h.writeStatus(http.StatusRequestEntityTooLarge, "Request body exceeds the maximum allowed size.")
+	h.response.Done()
+}
+
+func (h *RequestHandler) HandleDraining() {
+	h.StenoLogger.Warnf("proxy.draining")
+
+	h.response.Header().Set("X-Cf-RouterError", "draining")
+	h.writeStatus(http.StatusServiceUnavailable, "Router is shutting down.")
+	h.request.Close = true
+	h.response.Done()
+}
+
+func (h *RequestHandler) HandleRouterAtCapacity(err error) {
+	h.StenoLogger.Set("Error", err.Error())
+	h.StenoLogger.Warnf("proxy.at-capacity")
+
+	h.response.Header().Set("X-Cf-RouterError", "router_at_capacity")
+	h.response.Header().Set("Retry-After", strconv.Itoa(retryAfterNoEndpoints))
+	h.writeStatus(http.StatusServiceUnavailable, "Router is handling too many requests.")
+	h.response.Done()
+}
+
 func (h *RequestHandler) HandleBadGateway(err error) {
 	h.StenoLogger.Set("Error", err.Error())
 	h.StenoLogger.Warnf("proxy.endpoint.failed")
@@ -95,6 +195,57 @@ func (h *RequestHandler) HandleBadGateway(err error) {
 	h.response.Done()
 }
 
+func (h *RequestHandler) HandleServiceUnavailable(err error) {
+	h.StenoLogger.Set("Error", err.Error())
+	h.StenoLogger.Warnf("proxy.endpoint.unavailable")
+
+	h.response.Header().Set("X-Cf-RouterError", "no_endpoints")
+	h.response.Header().Set("Retry-After", strconv.Itoa(retryAfterNoEndpoints))
+	h.writeStatus(http.StatusServiceUnavailable, "Registered endpoints are unavailable.")
+	h.response.Done()
+}
+
+func (h *RequestHandler) HandleBackendAtCapacity(err error) {
+	h.StenoLogger.Set("Error", err.Error())
+	h.StenoLogger.Warnf("proxy.endpoint.at-capacity")
+
+	h.response.Header().Set("X-Cf-RouterError", "backend_at_capacity")
+	h.response.Header().Set("Retry-After", strconv.Itoa(retryAfterNoEndpoints))
+	h.writeStatus(http.StatusServiceUnavailable, "Registered endpoint is handling too many requests.")
+	h.response.Done()
+}
+
+func (h *RequestHandler) HandleClientBlocked() {
+	h.StenoLogger.Warnf("proxy.client.blocked")
+
+	h.response.Header().Set("X-Cf-RouterError", "client_blocked")
+	h.writeStatus(http.StatusForbidden, "Requests from this client are not allowed.")
+	h.response.Done()
+}
+
+func (h *RequestHandler) HandleRateLimited(retryAfter time.Duration) {
+	h.StenoLogger.Warnf("proxy.client.rate-limited")
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	h.response.Header().Set("X-Cf-RouterError", "rate_limited")
+	h.response.Header().Set("Retry-After", strconv.Itoa(seconds))
+	h.writeStatus(http.StatusTooManyRequests, "Too many requests from this client.")
+	h.response.Done()
+}
+
+func (h *RequestHandler) HandleGatewayTimeout(err error) {
+	h.StenoLogger.Set("Error", err.Error())
+	h.StenoLogger.Warnf("proxy.endpoint.timeout")
+
+	h.response.Header().Set("X-Cf-RouterError", "endpoint_timeout")
+	h.writeStatus(http.StatusGatewayTimeout, "Registered endpoint failed to respond in time.")
+	h.response.Done()
+}
+
 func (h *RequestHandler) HandleBadSignature(err error) {
 	h.StenoLogger.Set("Error", err.Error())
 	h.StenoLogger.Warnf("proxy.signature.validation.failed")
@@ -141,21 +292,58 @@ func (h *RequestHandler) HandleWebSocketRequest(iter route.EndpointIterator) {
 	}
 }
 
+func (h *RequestHandler) HandleConnectRequest(iter route.EndpointIterator) {
+	h.StenoLogger.Set("Method", "CONNECT")
+
+	h.logrecord.StatusCode = http.StatusOK
+
+	err := h.serveConnect(iter)
+	if err != nil && err != noEndpointsAvailable {
+		h.writeStatus(http.StatusBadGateway, "Tunnel to endpoint failed.")
+	}
+}
+
 func (h *RequestHandler) writeStatus(code int, message string) {
 	body := fmt.Sprintf("%d %s: %s", code, http.StatusText(code), message)
 
 	h.StenoLogger.Warn(body)
 	h.logrecord.StatusCode = code
 
-	http.Error(h.response, body, code)
+	if page, ok := h.errorPages[code]; !ok || !h.writeErrorPage(code, page) {
+		http.Error(h.response, body, code)
+	}
 	if code > 299 {
 		h.response.Header().Del("Connection")
 	}
 }
 
+func (h *RequestHandler) writeErrorPage(code int, page config.ErrorPage) bool {
+	data := struct {
+		Host      string
+		RequestId string
+	}{
+		Host:      h.request.Host,
+		RequestId: h.request.Header.Get(router_http.VcapRequestIdHeader),
+	}
+
+	var buf bytes.Buffer
+	if err := page.Body.Execute(&buf, data); err != nil {
+		h.StenoLogger.Set("Error", err.Error())
+		h.StenoLogger.Warnf("proxy.error-page.failed")
+		return false
+	}
+
+	h.response.Header().Set("Content-Type", page.ContentType)
+	h.response.Header().Set("X-Content-Type-Options", "nosniff")
+	h.response.WriteHeader(code)
+	h.response.Write(buf.Bytes())
+	return true
+}
+
 func (h *RequestHandler) serveTcp(iter route.EndpointIterator) error {
 	var err error
 	var connection net.Conn
+	var endpoint *route.Endpoint
 
 	client, _, err := h.hijack()
 	if err != nil {
@@ -171,40 +359,112 @@ func (h *RequestHandler) serveTcp(iter route.EndpointIterator) error {
 
 	retry := 0
 	for {
-		endpoint := iter.Next()
+		endpoint = iter.Next()
 		if endpoint == nil {
 			h.reporter.CaptureBadGateway(h.request)
 			err = noEndpointsAvailable
-			h.HandleBadGateway(err)
+			h.HandleServiceUnavailable(err)
 			return err
 		}
 
-		connection, err = net.DialTimeout("tcp", endpoint.CanonicalAddr(), 5*time.Second)
+		connection, err = h.dialer.Dial("tcp", endpoint.CanonicalAddr())
 		if err == nil {
+			iter.RecordSuccess(endpoint)
 			break
 		}
 
+		h.reporter.CaptureBackendFailure(endpoint, err)
 		iter.EndpointFailed()
+		iter.RecordFailure(endpoint)
 
 		h.StenoLogger.Set("Error", err.Error())
 		h.StenoLogger.Warn("proxy.tcp.failed")
 
 		retry++
-		if retry == maxRetries {
+		if retry >= h.maxAttempts {
 			return err
 		}
 	}
 
 	if connection != nil {
+		iter.PreRequest(endpoint)
+		defer iter.PostRequest(endpoint)
+
 		forwardIO(client, connection)
 	}
 
 	return nil
 }
 
+// serveConnect dials the backend before taking over the client connection,
+// so that a failure can still be answered with a regular response.
+func (h *RequestHandler) serveConnect(iter route.EndpointIterator) error {
+	var err error
+	var connection net.Conn
+	var endpoint *route.Endpoint
+
+	retry := 0
+	for {
+		endpoint = iter.Next()
+		if endpoint == nil {
+			h.reporter.CaptureBadGateway(h.request)
+			err = noEndpointsAvailable
+			h.HandleServiceUnavailable(err)
+			return err
+		}
+
+		connection, err = h.dialer.Dial("tcp", endpoint.CanonicalAddr())
+		if err == nil {
+			iter.RecordSuccess(endpoint)
+			break
+		}
+
+		h.reporter.CaptureBackendFailure(endpoint, err)
+		iter.EndpointFailed()
+		iter.RecordFailure(endpoint)
+
+		h.StenoLogger.Set("Error", err.Error())
+		h.StenoLogger.Warn("proxy.connect.failed")
+
+		retry++
+		if retry >= h.maxAttempts {
+			h.reporter.CaptureBadGateway(h.request)
+			return err
+		}
+	}
+	defer connection.Close()
+
+	client, rw, err := h.hijack()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	iter.PreRequest(endpoint)
+	defer iter.PostRequest(endpoint)
+
+	_, err = client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if err != nil {
+		return nil
+	}
+
+	// the client may have started sending before seeing the response
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered, _ := rw.Reader.Peek(n)
+		if _, err = connection.Write(buffered); err != nil {
+			return nil
+		}
+	}
+
+	forwardIO(client, connection)
+
+	return nil
+}
+
 func (h *RequestHandler) serveWebSocket(iter route.EndpointIterator) error {
 	var err error
 	var connection net.Conn
+	var endpoint *route.Endpoint
 
 	client, _, err := h.hijack()
 	if err != nil {
@@ -220,32 +480,38 @@ func (h *RequestHandler) serveWebSocket(iter route.EndpointIterator) error {
 
 	retry := 0
 	for {
-		endpoint := iter.Next()
+		endpoint = iter.Next()
 		if endpoint == nil {
 			h.reporter.CaptureBadGateway(h.request)
 			err = noEndpointsAvailable
-			h.HandleBadGateway(err)
+			h.HandleServiceUnavailable(err)
 			return err
 		}
 
-		connection, err = net.DialTimeout("tcp", endpoint.CanonicalAddr(), 5*time.Second)
+		connection, err = h.dialer.Dial("tcp", endpoint.CanonicalAddr())
 		if err == nil {
+			iter.RecordSuccess(endpoint)
 			h.setupRequest(endpoint)
 			break
 		}
 
+		h.reporter.CaptureBackendFailure(endpoint, err)
 		iter.EndpointFailed()
+		iter.RecordFailure(endpoint)
 
 		h.StenoLogger.Set("Error", err.Error())
 		h.StenoLogger.Warn("proxy.websocket.failed")
 
 		retry++
-		if retry == maxRetries {
+		if retry >= h.maxAttempts {
 			return err
 		}
 	}
 
 	if connection != nil {
+		iter.PreRequest(endpoint)
+		defer iter.PostRequest(endpoint)
+
 		err = h.request.Write(connection)
 		if err != nil {
 			return err
@@ -258,9 +524,11 @@ func (h *RequestHandler) serveWebSocket(iter route.EndpointIterator) error {
 
 func (h *RequestHandler) setupRequest(endpoint *route.Endpoint) {
 	h.setRequestURL(endpoint.CanonicalAddr())
+	if endpoint.PathRewrite != nil {
+		endpoint.PathRewrite.Rewrite(h.request.URL)
+	}
 	h.setRequestXForwardedFor()
-	setRequestXRequestStart(h.request)
-	setRequestXVcapRequestId(h.request, h.StenoLogger)
+	setRequestXForwardedProto(h.request)
 }
 
 func (h *RequestHandler) setRequestURL(addr string) {
@@ -280,12 +548,36 @@ func (h *RequestHandler) setRequestXForwardedFor() {
 	}
 }
 
+func setRequestXForwardedProto(request *http.Request) {
+	// The client's value is replaced, not trusted, so that a backend can
+	// rely on it to tell whether the router received the request over TLS.
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	request.Header.Set("X-Forwarded-Proto", scheme)
+}
+
 func setRequestXRequestStart(request *http.Request) {
 	if _, ok := request.Header[http.CanonicalHeaderKey("X-Request-Start")]; !ok {
 		request.Header.Set("X-Request-Start", strconv.FormatInt(time.Now().UnixNano()/1e6, 10))
 	}
 }
 
+func setRequestXRequestId(request *http.Request, logger *steno.Logger) {
+	if request.Header.Get(router_http.RequestIdHeader) == "" {
+		uuid, err := common.GenerateUUID()
+		if err != nil {
+			return
+		}
+		request.Header.Set(router_http.RequestIdHeader, uuid)
+	}
+
+	if logger != nil {
+		logger.Set(router_http.RequestIdHeader, request.Header.Get(router_http.RequestIdHeader))
+	}
+}
+
 func setRequestXVcapRequestId(request *http.Request, logger *steno.Logger) {
 	uuid, err := common.GenerateUUID()
 	if err == nil {
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// shouldBuffer tells whether the body of the response is small enough to be
+// read in full before it is sent to the client. Bodies of unknown length may
+// be streamed and are never buffered.
+func shouldBuffer(response *http.Response, maxSize int64) bool {
+	if maxSize <= 0 || response.Body == nil || response.Body == http.NoBody {
+		return false
+	}
+
+	return response.ContentLength > 0 && response.ContentLength <= maxSize
+}
+
+// bufferResponse reads the body of the response from the backend and closes
+// it, which hands the backend connection back for other requests while the
+// client is still reading. A read error is kept for the client's copy to run
+// into once the bytes read before it are sent.
+func bufferResponse(response *http.Response) {
+	body := make([]byte, 0, response.ContentLength)
+	buf := bytes.NewBuffer(body)
+
+	_, err := io.CopyN(buf, response.Body, response.ContentLength)
+	response.Body.Close()
+
+	if err != nil {
+		response.Body = ioutil.NopCloser(io.MultiReader(buf, errorReader{err}))
+		return
+	}
+
+	response.Body = ioutil.NopCloser(buf)
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
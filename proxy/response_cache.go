@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// responseCache keeps the cacheable responses of backends in memory, keyed by
+// method, host, request URI and route group, until they expire. Once the bodies of the
+// cached responses add up to more than maxSize bytes the least recently used
+// ones are evicted. A nil cache stores nothing.
+type responseCache struct {
+	lock    sync.Mutex
+	maxSize int64
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cachedResponse struct {
+	key        string
+	statusCode int
+	header     http.Header
+	body       []byte
+	stored     time.Time
+	expires    time.Time
+
+	// request identifies the request the response answered, for the
+	// idempotency cache
+	request string
+}
+
+func newResponseCache(maxSize int64) *responseCache {
+	if maxSize <= 0 {
+		return nil
+	}
+
+	return &responseCache{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// cacheKey returns the key of the response to the request from the backends
+// of the pool, which is a route group when the request matches one.
+func cacheKey(request *http.Request, pool *route.Pool) string {
+	key := request.Method + " " + strings.ToLower(request.Host) + request.RequestURI
+	if match := pool.Match(); match != nil {
+		key += fmt.Sprintf(" %+v", *match)
+	}
+	return key
+}
+
+// isCacheableRequest tells whether the response to the request may come from
+// the cache. Requests carrying credentials are always sent to the backend.
+func isCacheableRequest(request *http.Request) bool {
+	return request.Method == "GET" &&
+		request.Header.Get("Authorization") == "" &&
+		request.Header.Get("Cookie") == ""
+}
+
+// wantsFreshResponse tells whether the client asked for the response of the
+// backend rather than a cached one, with Cache-Control: no-cache or the
+// Pragma: no-cache of HTTP/1.0. That response is still cached for the
+// requests after it.
+func wantsFreshResponse(request *http.Request) bool {
+	for _, value := range request.Header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+				return true
+			}
+		}
+	}
+
+	return len(request.Header["Cache-Control"]) == 0 &&
+		strings.EqualFold(strings.TrimSpace(request.Header.Get("Pragma")), "no-cache")
+}
+
+// cacheLifetime returns how long the response may be cached for, or zero when
+// it must not be cached. s-maxage takes precedence over max-age as the router
+// is a shared cache.
+func cacheLifetime(response *http.Response) time.Duration {
+	if response.StatusCode != http.StatusOK ||
+		response.Header.Get("Set-Cookie") != "" ||
+		response.Header.Get("Vary") != "" ||
+		len(response.Trailer) > 0 {
+		return 0
+	}
+
+	maxAge, sharedMaxAge := -1, -1
+	for _, value := range response.Header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg := strings.TrimSpace(directive), ""
+			if i := strings.Index(name, "="); i >= 0 {
+				name, arg = name[:i], strings.Trim(name[i+1:], `"`)
+			}
+
+			switch strings.ToLower(name) {
+			case "private", "no-store", "no-cache":
+				return 0
+			case "max-age":
+				maxAge, _ = strconv.Atoi(arg)
+			case "s-maxage":
+				sharedMaxAge, _ = strconv.Atoi(arg)
+			}
+		}
+	}
+
+	if sharedMaxAge >= 0 {
+		maxAge = sharedMaxAge
+	}
+	if maxAge <= 0 {
+		return 0
+	}
+	return time.Duration(maxAge) * time.Second
+}
+
+// get returns the cached response for the key, unless it has expired.
+func (c *responseCache) get(key string) *cachedResponse {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	cached := element.Value.(*cachedResponse)
+	if !time.Now().Before(cached.expires) {
+		c.remove(element)
+		return nil
+	}
+
+	c.lru.MoveToFront(element)
+	return cached
+}
+
+func (c *responseCache) put(cached *cachedResponse) {
+	size := int64(len(cached.body))
+	if size > c.maxSize {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[cached.key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[cached.key] = c.lru.PushFront(cached)
+	c.size += size
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(element *list.Element) {
+	cached := c.lru.Remove(element).(*cachedResponse)
+	delete(c.entries, cached.key)
+	c.size -= int64(len(cached.body))
+}
+
+// store arranges for the response to be cached once its body has been read
+// to the end, as long as it fits in the cache.
+func (c *responseCache) store(key string, response *http.Response, lifetime time.Duration) *cachedResponse {
+	if c == nil || lifetime <= 0 || response.ContentLength > c.maxSize {
+		return nil
+	}
+
+	now := time.Now()
+	cached := &cachedResponse{
+		key:        key,
+		statusCode: response.StatusCode,
+		header:     response.Header.Clone(),
+		stored:     now,
+		expires:    now.Add(lifetime),
+	}
+
+	response.Body = &cachingReadCloser{
+		delegate: response.Body,
+		limit:    c.maxSize,
+		length:   response.ContentLength,
+		done: func(body []byte) {
+			cached.body = body
+			c.put(cached)
+		},
+	}
+	return cached
+}
+
+// cachingReadCloser copies the body read through it and hands it over once
+// the end is reached, or as soon as length bytes have been read when the
+// length is known. Bodies over the limit are not kept.
+type cachingReadCloser struct {
+	delegate io.ReadCloser
+	limit    int64
+	length   int64
+	buf      bytes.Buffer
+	exceeded bool
+	done     func(body []byte)
+}
+
+func (c *cachingReadCloser) Read(b []byte) (int, error) {
+	n, err := c.delegate.Read(b)
+	if !c.exceeded {
+		c.buf.Write(b[:n])
+		if int64(c.buf.Len()) > c.limit {
+			c.exceeded = true
+			c.buf = bytes.Buffer{}
+		}
+	}
+
+	complete := err == io.EOF || (c.length >= 0 && int64(c.buf.Len()) == c.length)
+	if complete && !c.exceeded && c.done != nil {
+		c.done(c.buf.Bytes())
+		c.done = nil
+	}
+	return n, err
+}
+
+func (c *cachingReadCloser) Close() error {
+	return c.delegate.Close()
+}
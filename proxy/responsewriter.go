@@ -14,6 +14,7 @@ type ProxyResponseWriter interface {
 	WriteHeader(s int)
 	Done()
 	Flush()
+	FlushOnWrite()
 	Status() int
 	Size() int
 }
@@ -23,8 +24,9 @@ type proxyResponseWriter struct {
 	status int
 	size   int
 
-	flusher http.Flusher
-	done    bool
+	flusher      http.Flusher
+	flushOnWrite bool
+	done         bool
 }
 
 func NewProxyResponseWriter(w http.ResponseWriter) *proxyResponseWriter {
@@ -58,6 +60,10 @@ func (p *proxyResponseWriter) Write(b []byte) (int, error) {
 	}
 	size, err := p.w.Write(b)
 	p.size += size
+
+	if p.flushOnWrite {
+		p.Flush()
+	}
 	return size, err
 }
 
@@ -66,9 +72,17 @@ func (p *proxyResponseWriter) WriteHeader(s int) {
 		return
 	}
 
+	// the server sends 100 Continue to the client itself once the body is
+	// read, which is when the backend asked for it
+	if s == http.StatusContinue {
+		return
+	}
+
 	p.w.WriteHeader(s)
 
-	if p.status == 0 {
+	// other interim responses precede the final status
+	informational := s >= 100 && s < 200 && s != http.StatusSwitchingProtocols
+	if p.status == 0 && !informational {
 		p.status = s
 	}
 }
@@ -83,6 +97,12 @@ func (p *proxyResponseWriter) Flush() {
 	}
 }
 
+// FlushOnWrite sends every write to the client right away instead of
+// leaving it buffered until the next periodic flush.
+func (p *proxyResponseWriter) FlushOnWrite() {
+	p.flushOnWrite = true
+}
+
 func (p *proxyResponseWriter) Status() int {
 	return p.status
 }
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryBackoff spaces out the attempts of a request, so that retries do not
+// pile onto a backend that is recovering. Delay is waited before every
+// retry; with Jitter a random time between half of Delay and all of it. No
+// retry is started once MaxRetryTime would have passed since the first
+// attempt, zero does not limit the time spent retrying.
+type RetryBackoff struct {
+	Delay        time.Duration
+	Jitter       bool
+	MaxRetryTime time.Duration
+}
+
+// wait sleeps before the next attempt of the request, the first of which
+// started at started. It tells false, without sleeping, when the retry would
+// start past MaxRetryTime, and as soon as the client goes away.
+func (b RetryBackoff) wait(request *http.Request, started time.Time) bool {
+	delay := b.Delay
+	if b.Jitter && delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+	}
+
+	if b.MaxRetryTime > 0 && time.Since(started)+delay > b.MaxRetryTime {
+		return false
+	}
+
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-request.Context().Done():
+		return false
+	}
+}
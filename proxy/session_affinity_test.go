@@ -217,6 +217,71 @@ var _ = Describe("Session Affinity", func() {
 		})
 	})
 
+	Context("with a custom sticky cookie name", func() {
+		BeforeEach(func() {
+			conf.StickyCookieName = "SESSIONID"
+		})
+
+		responseWithSession := func(id string) connHandler {
+			return func(x *test_util.HttpConn) {
+				_, err := http.ReadRequest(x.Reader)
+				Expect(err).ToNot(HaveOccurred())
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Header.Add("Set-Cookie", (&http.Cookie{Name: "SESSIONID", Value: "xxx"}).String())
+				resp.Header.Set("X-Backend", id)
+				x.WriteResponse(resp)
+				x.Close()
+				done <- true
+			}
+		}
+
+		It("pins repeated requests to the same backend", func() {
+			ln := registerHandlerWithInstanceId(r, "app", "", responseWithSession("id-1"), "id-1")
+			defer ln.Close()
+			ln2 := registerHandlerWithInstanceId(r, "app", "", responseWithSession("id-2"), "id-2")
+			defer ln2.Close()
+
+			x := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "app", "/", nil)
+			x.WriteRequest(req)
+
+			Eventually(done).Should(Receive())
+
+			resp, _ := x.ReadResponse()
+			backend := resp.Header.Get("X-Backend")
+			vcapId := getCookie(proxy.VcapCookieId, resp.Cookies())
+			Expect(vcapId).ToNot(BeNil())
+			Expect(vcapId.Value).To(Equal(backend))
+
+			for i := 0; i < 5; i++ {
+				req := test_util.NewRequest("GET", "app", "/", nil)
+				req.AddCookie(&http.Cookie{Name: "SESSIONID", Value: "xxx"})
+				req.AddCookie(vcapId)
+				x.WriteRequest(req)
+
+				Eventually(done).Should(Receive())
+
+				resp, _ := x.ReadResponse()
+				Expect(resp.Header.Get("X-Backend")).To(Equal(backend))
+			}
+		})
+
+		It("ignores the default JSESSIONID cookie", func() {
+			ln := registerHandlerWithInstanceId(r, "app", "", responseWithJSessionID, "my-id")
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+			req := test_util.NewRequest("GET", "app", "/", nil)
+			x.WriteRequest(req)
+
+			Eventually(done).Should(Receive())
+
+			resp, _ := x.ReadResponse()
+			Expect(getCookie(proxy.VcapCookieId, resp.Cookies())).To(BeNil())
+		})
+	})
+
 	Context("subsequent requests", func() {
 		const host = "app"
 		var req *http.Request
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	router_http "github.com/cloudfoundry/gorouter/common/http"
+)
+
+// setRequestTraceparent makes the router a hop of the W3C Trace Context of
+// the request. A valid traceparent keeps its trace ID and flags and gets a
+// new parent ID for the router's span; otherwise a new trace is started and
+// the tracestate of the old one dropped. It returns the trace ID.
+func setRequestTraceparent(request *http.Request) string {
+	traceId, flags, ok := parseTraceparent(request.Header.Get(router_http.TraceparentHeader))
+	if !ok {
+		traceId, flags = randomHex(16), "00"
+		request.Header.Del(router_http.TracestateHeader)
+	}
+
+	request.Header.Set(router_http.TraceparentHeader, "00-"+traceId+"-"+randomHex(8)+"-"+flags)
+	return traceId
+}
+
+// parseTraceparent returns the trace ID and flags of a traceparent value,
+// which is version-trace_id-parent_id-flags in lower case hex. Versions
+// later than 00 may append fields.
+func parseTraceparent(value string) (traceId string, flags string, ok bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 {
+		return "", "", false
+	}
+
+	version, traceId, parentId, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return "", "", false
+	}
+
+	if !isHex(traceId, 32) || traceId == strings.Repeat("0", 32) {
+		return "", "", false
+	}
+
+	if !isHex(parentId, 16) || parentId == strings.Repeat("0", 16) {
+		return "", "", false
+	}
+
+	if !isHex(flags, 2) {
+		return "", "", false
+	}
+
+	return traceId, flags, true
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -9,12 +9,26 @@ import (
 )
 
 type FakeRegistryInterface struct {
-	RegisterStub        func(uri route.Uri, endpoint *route.Endpoint)
+	RegisterStub        func(uri route.Uri, endpoint *route.Endpoint) (registry.Registration, error)
 	registerMutex       sync.RWMutex
 	registerArgsForCall []struct {
 		uri      route.Uri
 		endpoint *route.Endpoint
 	}
+	registerReturns struct {
+		result1 registry.Registration
+		result2 error
+	}
+	RegisterUrisStub        func(uris []route.Uri, endpoint *route.Endpoint) (registry.Registration, error)
+	registerUrisMutex       sync.RWMutex
+	registerUrisArgsForCall []struct {
+		uris     []route.Uri
+		endpoint *route.Endpoint
+	}
+	registerUrisReturns struct {
+		result1 registry.Registration
+		result2 error
+	}
 	UnregisterStub        func(uri route.Uri, endpoint *route.Endpoint)
 	unregisterMutex       sync.RWMutex
 	unregisterArgsForCall []struct {
@@ -29,6 +43,15 @@ type FakeRegistryInterface struct {
 	lookupReturns struct {
 		result1 *route.Pool
 	}
+	PoolsStub        func() []*route.Pool
+	poolsMutex       sync.RWMutex
+	poolsArgsForCall []struct{}
+	poolsReturns     struct {
+		result1 []*route.Pool
+	}
+	PruneStub                    func()
+	pruneMutex                   sync.RWMutex
+	pruneArgsForCall             []struct{}
 	StartPruningCycleStub        func()
 	startPruningCycleMutex       sync.RWMutex
 	startPruningCycleArgsForCall []struct{}
@@ -56,7 +79,7 @@ type FakeRegistryInterface struct {
 	}
 }
 
-func (fake *FakeRegistryInterface) Register(uri route.Uri, endpoint *route.Endpoint) {
+func (fake *FakeRegistryInterface) Register(uri route.Uri, endpoint *route.Endpoint) (registry.Registration, error) {
 	fake.registerMutex.Lock()
 	fake.registerArgsForCall = append(fake.registerArgsForCall, struct {
 		uri      route.Uri
@@ -64,7 +87,9 @@ func (fake *FakeRegistryInterface) Register(uri route.Uri, endpoint *route.Endpo
 	}{uri, endpoint})
 	fake.registerMutex.Unlock()
 	if fake.RegisterStub != nil {
-		fake.RegisterStub(uri, endpoint)
+		return fake.RegisterStub(uri, endpoint)
+	} else {
+		return fake.registerReturns.result1, fake.registerReturns.result2
 	}
 }
 
@@ -80,6 +105,48 @@ func (fake *FakeRegistryInterface) RegisterArgsForCall(i int) (route.Uri, *route
 	return fake.registerArgsForCall[i].uri, fake.registerArgsForCall[i].endpoint
 }
 
+func (fake *FakeRegistryInterface) RegisterReturns(result1 registry.Registration, result2 error) {
+	fake.RegisterStub = nil
+	fake.registerReturns = struct {
+		result1 registry.Registration
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeRegistryInterface) RegisterUris(uris []route.Uri, endpoint *route.Endpoint) (registry.Registration, error) {
+	fake.registerUrisMutex.Lock()
+	fake.registerUrisArgsForCall = append(fake.registerUrisArgsForCall, struct {
+		uris     []route.Uri
+		endpoint *route.Endpoint
+	}{uris, endpoint})
+	fake.registerUrisMutex.Unlock()
+	if fake.RegisterUrisStub != nil {
+		return fake.RegisterUrisStub(uris, endpoint)
+	} else {
+		return fake.registerUrisReturns.result1, fake.registerUrisReturns.result2
+	}
+}
+
+func (fake *FakeRegistryInterface) RegisterUrisCallCount() int {
+	fake.registerUrisMutex.RLock()
+	defer fake.registerUrisMutex.RUnlock()
+	return len(fake.registerUrisArgsForCall)
+}
+
+func (fake *FakeRegistryInterface) RegisterUrisArgsForCall(i int) ([]route.Uri, *route.Endpoint) {
+	fake.registerUrisMutex.RLock()
+	defer fake.registerUrisMutex.RUnlock()
+	return fake.registerUrisArgsForCall[i].uris, fake.registerUrisArgsForCall[i].endpoint
+}
+
+func (fake *FakeRegistryInterface) RegisterUrisReturns(result1 registry.Registration, result2 error) {
+	fake.RegisterUrisStub = nil
+	fake.registerUrisReturns = struct {
+		result1 registry.Registration
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeRegistryInterface) Unregister(uri route.Uri, endpoint *route.Endpoint) {
 	fake.unregisterMutex.Lock()
 	fake.unregisterArgsForCall = append(fake.unregisterArgsForCall, struct {
@@ -136,6 +203,45 @@ func (fake *FakeRegistryInterface) LookupReturns(result1 *route.Pool) {
 	}{result1}
 }
 
+func (fake *FakeRegistryInterface) Pools() []*route.Pool {
+	fake.poolsMutex.Lock()
+	fake.poolsArgsForCall = append(fake.poolsArgsForCall, struct{}{})
+	fake.poolsMutex.Unlock()
+	if fake.PoolsStub != nil {
+		return fake.PoolsStub()
+	} else {
+		return fake.poolsReturns.result1
+	}
+}
+
+func (fake *FakeRegistryInterface) PoolsCallCount() int {
+	fake.poolsMutex.RLock()
+	defer fake.poolsMutex.RUnlock()
+	return len(fake.poolsArgsForCall)
+}
+
+func (fake *FakeRegistryInterface) PoolsReturns(result1 []*route.Pool) {
+	fake.PoolsStub = nil
+	fake.poolsReturns = struct {
+		result1 []*route.Pool
+	}{result1}
+}
+
+func (fake *FakeRegistryInterface) Prune() {
+	fake.pruneMutex.Lock()
+	fake.pruneArgsForCall = append(fake.pruneArgsForCall, struct{}{})
+	fake.pruneMutex.Unlock()
+	if fake.PruneStub != nil {
+		fake.PruneStub()
+	}
+}
+
+func (fake *FakeRegistryInterface) PruneCallCount() int {
+	fake.pruneMutex.RLock()
+	defer fake.pruneMutex.RUnlock()
+	return len(fake.pruneArgsForCall)
+}
+
 func (fake *FakeRegistryInterface) StartPruningCycle() {
 	fake.startPruningCycleMutex.Lock()
 	fake.startPruningCycleArgsForCall = append(fake.startPruningCycleArgsForCall, struct{}{})
@@ -2,9 +2,13 @@ package registry
 
 import (
 	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	steno "github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/yagnats"
@@ -15,9 +19,12 @@ import (
 )
 
 type RegistryInterface interface {
-	Register(uri route.Uri, endpoint *route.Endpoint)
+	Register(uri route.Uri, endpoint *route.Endpoint) (Registration, error)
+	RegisterUris(uris []route.Uri, endpoint *route.Endpoint) (Registration, error)
 	Unregister(uri route.Uri, endpoint *route.Endpoint)
 	Lookup(uri route.Uri) *route.Pool
+	Pools() []*route.Pool
+	Prune()
 	StartPruningCycle()
 	StopPruningCycle()
 	NumUris() int
@@ -25,6 +32,30 @@ type RegistryInterface interface {
 	MarshalJSON() ([]byte, error)
 }
 
+// Registration tells which of the registered URIs did not have the endpoint
+// yet and which only had it refreshed.
+type Registration struct {
+	Added     []route.Uri
+	Refreshed []route.Uri
+}
+
+// RouteEventType tells how the routing table changed.
+type RouteEventType int
+
+const (
+	EndpointRegistered RouteEventType = iota
+	EndpointUnregistered
+	EndpointPruned
+)
+
+// RouteEvent is a change of the endpoint's routes: the URIs it was newly
+// registered under, or those it was unregistered or pruned from.
+type RouteEvent struct {
+	Type     RouteEventType
+	Uris     []route.Uri
+	Endpoint *route.Endpoint
+}
+
 type RouteRegistry struct {
 	sync.RWMutex
 
@@ -35,12 +66,19 @@ type RouteRegistry struct {
 	pruneStaleDropletsInterval time.Duration
 	dropletStaleThreshold      time.Duration
 
+	circuitBreakerThreshold int
+	circuitBreakerCooldown  time.Duration
+	slowStartDuration       time.Duration
+	outlierDetection        route.OutlierDetection
+
 	messageBus yagnats.NATSConn
 
 	reporter metrics.RouteReporter
 
 	ticker           *time.Ticker
 	timeOfLastUpdate time.Time
+
+	callbacks []func(RouteEvent)
 }
 
 func NewRouteRegistry(c *config.Config, mbus yagnats.NATSConn, reporter metrics.RouteReporter) *RouteRegistry {
@@ -52,29 +90,87 @@ func NewRouteRegistry(c *config.Config, mbus yagnats.NATSConn, reporter metrics.
 
 	r.pruneStaleDropletsInterval = c.PruneStaleDropletsInterval
 	r.dropletStaleThreshold = c.DropletStaleThreshold
+	r.circuitBreakerThreshold = c.CircuitBreakerThreshold
+	r.circuitBreakerCooldown = c.CircuitBreakerCooldown
+	r.slowStartDuration = c.SlowStartDuration
+	r.outlierDetection = route.OutlierDetection{
+		Interval:        c.OutlierDetection.Interval,
+		MinRequests:     c.OutlierDetection.MinRequests,
+		DeviationFactor: c.OutlierDetection.DeviationFactor,
+		EjectionTime:    c.OutlierDetection.EjectionTime,
+	}
 
 	r.messageBus = mbus
 	r.reporter = reporter
 	return r
 }
 
-func (r *RouteRegistry) Register(uri route.Uri, endpoint *route.Endpoint) {
-	t := time.Now()
+// OnChange adds a callback invoked with every endpoint registered under new
+// URIs, unregistered or pruned. Callbacks are invoked one after the other,
+// without the registry locked, so they may use the registry themselves;
+// refreshes of existing registrations are not reported. UnregisterPattern
+// reports every endpoint of the URIs it removes as unregistered from them.
+func (r *RouteRegistry) OnChange(callback func(RouteEvent)) {
 	r.Lock()
+	r.callbacks = append(r.callbacks, callback)
+	r.Unlock()
+}
 
-	uri = uri.RouteKey()
+// notify must be called without the lock held, with the callbacks taken
+// while it was.
+func notify(callbacks []func(RouteEvent), event RouteEvent) {
+	for _, callback := range callbacks {
+		callback(event)
+	}
+}
 
-	pool, found := r.byUri.Find(uri)
-	if !found {
-		contextPath := parseContextPath(uri)
-		pool = route.NewPool(r.dropletStaleThreshold/4, contextPath)
-		r.byUri.Insert(uri, pool)
+func (r *RouteRegistry) Register(uri route.Uri, endpoint *route.Endpoint) (Registration, error) {
+	return r.RegisterUris([]route.Uri{uri}, endpoint)
+}
+
+// RegisterUris registers the endpoint under all the URIs at once. When one
+// of them is malformed none of them is registered.
+func (r *RouteRegistry) RegisterUris(uris []route.Uri, endpoint *route.Endpoint) (Registration, error) {
+	var registration Registration
+
+	for _, uri := range uris {
+		if !validUri(uri) {
+			return registration, fmt.Errorf("invalid uri %q", string(uri))
+		}
 	}
 
-	pool.Put(endpoint)
+	t := time.Now()
+	r.Lock()
+
+	for _, uri := range uris {
+		key := uri.RouteKey()
+
+		pool, found := r.byUri.Find(key)
+		if !found {
+			pool = r.newPool(key)
+			r.byUri.Insert(key, pool)
+		}
+
+		if pool.Put(endpoint) {
+			registration.Added = append(registration.Added, uri)
+		} else {
+			registration.Refreshed = append(registration.Refreshed, uri)
+		}
+	}
 
 	r.timeOfLastUpdate = t
+	callbacks := r.callbacks
 	r.Unlock()
+
+	for _, uri := range registration.Added {
+		r.logger.Infod(endpointLogData(uri, endpoint), "registry.endpoint.registered")
+	}
+
+	if len(registration.Added) > 0 {
+		notify(callbacks, RouteEvent{Type: EndpointRegistered, Uris: registration.Added, Endpoint: endpoint})
+	}
+
+	return registration, nil
 }
 
 func (r *RouteRegistry) Unregister(uri route.Uri, endpoint *route.Endpoint) {
@@ -82,16 +178,76 @@ func (r *RouteRegistry) Unregister(uri route.Uri, endpoint *route.Endpoint) {
 
 	uri = uri.RouteKey()
 
+	removed := false
 	pool, found := r.byUri.Find(uri)
 	if found {
-		pool.Remove(endpoint)
+		removed = pool.Remove(endpoint)
 
 		if pool.IsEmpty() {
 			r.byUri.Delete(uri)
 		}
 	}
 
+	callbacks := r.callbacks
 	r.Unlock()
+
+	if removed {
+		r.logger.Infod(endpointLogData(uri, endpoint), "registry.endpoint.unregistered")
+		notify(callbacks, RouteEvent{Type: EndpointUnregistered, Uris: []route.Uri{uri}, Endpoint: endpoint})
+	}
+}
+
+// UnregisterPattern removes every URI matching the glob pattern, with all of
+// its endpoints, and returns how many were removed. The pattern is matched
+// as with path.Match, so "*" does not cross a "/". A pattern without a path
+// is matched against the host of the URIs, removing the routes with a path
+// under a matching host along with it.
+func (r *RouteRegistry) UnregisterPattern(pattern string) (int, error) {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+	hostOnly := !strings.Contains(pattern, "/")
+
+	r.Lock()
+
+	var removed []route.Uri
+	var unregistered []RouteEvent
+	for uri, pool := range r.byUri.ToMap() {
+		subject := uri.String()
+		if hostOnly {
+			subject = strings.SplitN(subject, "/", 2)[0]
+		}
+
+		if matched, _ := path.Match(pattern, subject); matched {
+			r.byUri.Delete(uri)
+			removed = append(removed, uri)
+
+			pool.Each(func(endpoint *route.Endpoint) {
+				unregistered = append(unregistered, RouteEvent{Type: EndpointUnregistered, Uris: []route.Uri{uri}, Endpoint: endpoint})
+			})
+		}
+	}
+
+	if len(removed) > 0 {
+		r.timeOfLastUpdate = time.Now()
+	}
+
+	callbacks := r.callbacks
+	r.Unlock()
+
+	if len(removed) > 0 {
+		r.logger.Infod(map[string]interface{}{
+			"pattern": pattern,
+			"uris":    removed,
+		}, "registry.pattern.unregistered")
+	}
+
+	for _, event := range unregistered {
+		notify(callbacks, event)
+	}
+
+	return len(removed), nil
 }
 
 func (r *RouteRegistry) Lookup(uri route.Uri) *route.Pool {
@@ -110,6 +266,49 @@ func (r *RouteRegistry) Lookup(uri route.Uri) *route.Pool {
 	return pool
 }
 
+// IsRegistered tells whether the URI itself has a route, unlike Lookup, which
+// also finds the wildcard or shorter path routes matching it.
+func (r *RouteRegistry) IsRegistered(uri route.Uri) bool {
+	r.RLock()
+	_, found := r.byUri.Find(uri.RouteKey())
+	r.RUnlock()
+
+	return found
+}
+
+// HasBackend tells whether a backend at addr is registered for any route.
+func (r *RouteRegistry) HasBackend(addr string) bool {
+	found := false
+
+	r.RLock()
+	r.byUri.EachNodeWithPool(func(t *Trie) {
+		if found || t.Pool.Has(addr) {
+			found = true
+			return
+		}
+		for _, group := range t.Pool.RouteGroups() {
+			found = found || group.Has(addr)
+		}
+	})
+	r.RUnlock()
+
+	return found
+}
+
+func (r *RouteRegistry) Pools() []*route.Pool {
+	r.RLock()
+
+	pools := make([]*route.Pool, 0, r.byUri.PoolCount())
+	r.byUri.EachNodeWithPool(func(t *Trie) {
+		pools = append(pools, t.Pool)
+		pools = append(pools, t.Pool.RouteGroups()...)
+	})
+
+	r.RUnlock()
+
+	return pools
+}
+
 func (r *RouteRegistry) StartPruningCycle() {
 	if r.pruneStaleDropletsInterval > 0 {
 		r.Lock()
@@ -121,7 +320,7 @@ func (r *RouteRegistry) StartPruningCycle() {
 				select {
 				case <-r.ticker.C:
 					r.logger.Debug("Start to check and prune stale droplets")
-					r.pruneStaleDroplets()
+					r.Prune()
 					msSinceLastUpdate := uint64(time.Since(r.TimeOfLastUpdate())/time.Millisecond)
 					r.reporter.CaptureRouteStats(r.NumUris(), msSinceLastUpdate)
 				}
@@ -169,13 +368,217 @@ func (r *RouteRegistry) MarshalJSON() ([]byte, error) {
 	return json.Marshal(r.byUri.ToMap())
 }
 
-func (r *RouteRegistry) pruneStaleDroplets() {
+// MarshalHostJSON is like MarshalJSON but only includes the routes that can
+// match requests for host, wildcard routes included.
+func (r *RouteRegistry) MarshalHostJSON(host string) ([]byte, error) {
+	host = strings.ToLower(host)
+
+	r.RLock()
+	defer r.RUnlock()
+
+	routes := make(map[route.Uri]*route.Pool)
+	for uri, pool := range r.byUri.ToMap() {
+		if matchesHost(uri, host) {
+			routes[uri] = pool
+		}
+	}
+
+	return json.Marshal(routes)
+}
+
+func matchesHost(uri route.Uri, host string) bool {
+	uriHost := strings.SplitN(uri.String(), "/", 2)[0]
+	if uriHost == host {
+		return true
+	}
+
+	return strings.HasPrefix(uriHost, "*.") && strings.HasSuffix(host, uriHost[1:])
+}
+
+func (r *RouteRegistry) Prune() {
 	r.Lock()
+	pruned := r.prune()
+	callbacks := r.callbacks
+	r.Unlock()
+
+	for _, event := range pruned {
+		notify(callbacks, event)
+	}
+}
+
+// prune must be called with the lock held
+func (r *RouteRegistry) prune() []RouteEvent {
+	var pruned []RouteEvent
 	r.byUri.EachNodeWithPool(func(t *Trie) {
-		t.Pool.PruneEndpoints(r.dropletStaleThreshold)
+		for _, endpoint := range t.Pool.PruneEndpoints(r.dropletStaleThreshold) {
+			r.logger.Infod(endpointLogData(t.Pool.Uri(), endpoint), "registry.endpoint.pruned")
+			pruned = append(pruned, RouteEvent{Type: EndpointPruned, Uris: []route.Uri{t.Pool.Uri()}, Endpoint: endpoint})
+		}
 		t.Snip()
 	})
-	r.Unlock()
+	return pruned
+}
+
+// DrainBackend stops sending new requests to the backend on every route it
+// is registered for, letting its requests in flight complete. It is pruned
+// once it has none left.
+func (r *RouteRegistry) DrainBackend(host string, port uint16) bool {
+	addr := fmt.Sprintf("%s:%d", host, port)
+	drained := false
+
+	r.RLock()
+	r.byUri.EachNodeWithPool(func(t *Trie) {
+		drained = t.Pool.Drain(addr) || drained
+	})
+	r.RUnlock()
+
+	return drained
+}
+
+// Backend is a registered backend along with its state across all the routes
+// it is registered for. It is healthy when it passes the health checks of
+// every route, and draining when it is being drained from any of them.
+type Backend struct {
+	Address           string
+	ApplicationId     string
+	PrivateInstanceId string
+	Uris              []route.Uri
+	Healthy           bool
+	Draining          bool
+	InFlight          int
+}
+
+// Backends returns the registered backends ordered by address, each with its
+// URIs sorted. The routing table does not change while they are collected.
+func (r *RouteRegistry) Backends() []Backend {
+	byAddr := make(map[string]*Backend)
+
+	r.RLock()
+	r.byUri.EachNodeWithPool(func(t *Trie) {
+		pools := append([]*route.Pool{t.Pool}, t.Pool.RouteGroups()...)
+		for _, pool := range pools {
+			var endpoints []*route.Endpoint
+			pool.Each(func(e *route.Endpoint) {
+				endpoints = append(endpoints, e)
+			})
+
+			for _, e := range endpoints {
+				b := byAddr[e.CanonicalAddr()]
+				if b == nil {
+					b = &Backend{
+						Address:           e.CanonicalAddr(),
+						ApplicationId:     e.ApplicationId,
+						PrivateInstanceId: e.PrivateInstanceId,
+						Healthy:           true,
+					}
+					byAddr[e.CanonicalAddr()] = b
+				}
+
+				// a backend in a route group may also be in the route's
+				// default pool
+				if n := len(b.Uris); n == 0 || b.Uris[n-1] != pool.Uri() {
+					b.Uris = append(b.Uris, pool.Uri())
+				}
+				b.Healthy = b.Healthy && pool.IsHealthy(e)
+				b.Draining = b.Draining || pool.IsDraining(e)
+				b.InFlight += pool.InFlight(e)
+			}
+		}
+	})
+	r.RUnlock()
+
+	backends := make([]Backend, 0, len(byAddr))
+	for _, b := range byAddr {
+		// the routing table is not walked in any particular order
+		sort.Slice(b.Uris, func(i, j int) bool {
+			return b.Uris[i] < b.Uris[j]
+		})
+		backends = append(backends, *b)
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Address < backends[j].Address
+	})
+
+	return backends
+}
+
+type routeSnapshot struct {
+	Uri       route.Uri                `json:"uri"`
+	Endpoints []route.EndpointSnapshot `json:"endpoints"`
+}
+
+// Snapshot serializes the routing table, with the time every endpoint was
+// last registered, so that it can be restored after a restart.
+func (r *RouteRegistry) Snapshot() ([]byte, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	routes := make([]routeSnapshot, 0, r.byUri.PoolCount())
+	r.byUri.EachNodeWithPool(func(t *Trie) {
+		routes = append(routes, routeSnapshot{
+			Uri:       t.Pool.Uri(),
+			Endpoints: t.Pool.Snapshot(),
+		})
+	})
+
+	return json.Marshal(routes)
+}
+
+// Restore adds the routes of a snapshot to the routing table. Endpoints that
+// have gone stale since the snapshot was taken are dropped.
+func (r *RouteRegistry) Restore(data []byte) error {
+	var routes []routeSnapshot
+	err := json.Unmarshal(data, &routes)
+	if err != nil {
+		return err
+	}
+
+	for _, rs := range routes {
+		if !validUri(rs.Uri) {
+			return fmt.Errorf("invalid uri %q", string(rs.Uri))
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, rs := range routes {
+		key := rs.Uri.RouteKey()
+
+		pool, found := r.byUri.Find(key)
+		if !found {
+			pool = r.newPool(key)
+			r.byUri.Insert(key, pool)
+		}
+
+		for _, s := range rs.Endpoints {
+			pool.Restore(s)
+		}
+	}
+
+	// the restored endpoints were not reported as registered, nor are
+	// those pruned right away
+	r.prune()
+
+	return nil
+}
+
+func endpointLogData(uri route.Uri, endpoint *route.Endpoint) map[string]interface{} {
+	return map[string]interface{}{
+		"uri":                 uri,
+		"address":             endpoint.CanonicalAddr(),
+		"app":                 endpoint.ApplicationId,
+		"private_instance_id": endpoint.PrivateInstanceId,
+	}
+}
+
+func (r *RouteRegistry) newPool(key route.Uri) *route.Pool {
+	pool := route.NewPool(r.dropletStaleThreshold/4, parseContextPath(key))
+	pool.SetUri(key)
+	pool.SetCircuitBreaker(r.circuitBreakerThreshold, r.circuitBreakerCooldown)
+	pool.SetSlowStart(r.slowStartDuration)
+	pool.SetOutlierDetection(r.outlierDetection)
+	return pool
 }
 
 func parseContextPath(uri route.Uri) string {
@@ -187,3 +590,7 @@ func parseContextPath(uri route.Uri) string {
 	}
 	return contextPath
 }
+
+func validUri(uri route.Uri) bool {
+	return uri.String() != "" && strings.IndexFunc(string(uri), unicode.IsSpace) < 0
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/cloudfoundry/gorouter/route"
 	"github.com/cloudfoundry/yagnats/fakeyagnats"
 	"github.com/cloudfoundry/gorouter/metrics/fakes"
+	steno "github.com/cloudfoundry/gosteno"
 
 	"encoding/json"
 	"time"
@@ -135,6 +136,93 @@ var _ = Describe("RouteRegistry", func() {
 				Expect(r.NumEndpoints()).To(Equal(1))
 			})
 		})
+
+		Context("several uris", func() {
+			It("tells the added uris from the refreshed ones", func() {
+				r.Register("foo", fooEndpoint)
+
+				registration, err := r.RegisterUris([]route.Uri{"foo", "bar", "baz"}, fooEndpoint)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(registration.Added).To(Equal([]route.Uri{"bar", "baz"}))
+				Expect(registration.Refreshed).To(Equal([]route.Uri{"foo"}))
+
+				Expect(r.NumUris()).To(Equal(3))
+				Expect(r.NumEndpoints()).To(Equal(1))
+			})
+
+			It("reports a single uri from Register", func() {
+				registration, err := r.Register("foo", fooEndpoint)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(registration.Added).To(Equal([]route.Uri{"foo"}))
+				Expect(registration.Refreshed).To(BeEmpty())
+
+				registration, err = r.Register("foo", fooEndpoint)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(registration.Added).To(BeEmpty())
+				Expect(registration.Refreshed).To(Equal([]route.Uri{"foo"}))
+			})
+
+			It("registers none of the uris when one is malformed", func() {
+				_, err := r.RegisterUris([]route.Uri{"foo", "b ar"}, fooEndpoint)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(`"b ar"`))
+
+				Expect(r.NumUris()).To(Equal(0))
+			})
+
+			It("rejects an empty uri", func() {
+				_, err := r.Register("", fooEndpoint)
+				Expect(err).To(HaveOccurred())
+
+				Expect(r.NumUris()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("UnregisterPattern", func() {
+		BeforeEach(func() {
+			r.Register("api.staging.example.com", fooEndpoint)
+			r.Register("WWW.staging.example.com/docs", fooEndpoint)
+			r.Register("*.staging.example.com", barEndpoint)
+			r.Register("staging.example.com", barEndpoint)
+			r.Register("api.example.com", barEndpoint)
+			r.Register("api.staging.example.org", bar2Endpoint)
+		})
+
+		It("removes only the uris matching the pattern", func() {
+			removed, err := r.UnregisterPattern("*.staging.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(3))
+
+			Expect(r.NumUris()).To(Equal(3))
+			Expect(r.Lookup("api.staging.example.com")).To(BeNil())
+			Expect(r.Lookup("www.staging.example.com/docs")).To(BeNil())
+			Expect(r.Lookup("staging.example.com")).NotTo(BeNil())
+			Expect(r.Lookup("api.example.com")).NotTo(BeNil())
+			Expect(r.Lookup("api.staging.example.org")).NotTo(BeNil())
+		})
+
+		It("matches the path when the pattern has one", func() {
+			removed, err := r.UnregisterPattern("*.staging.example.com/*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(1))
+
+			Expect(r.NumUris()).To(Equal(5))
+			Expect(r.Lookup("api.staging.example.com")).NotTo(BeNil())
+		})
+
+		It("removes nothing when no uri matches", func() {
+			removed, err := r.UnregisterPattern("*.production.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(BeZero())
+			Expect(r.NumUris()).To(Equal(6))
+		})
+
+		It("rejects a malformed pattern", func() {
+			_, err := r.UnregisterPattern("[api.example.com")
+			Expect(err).To(HaveOccurred())
+			Expect(r.NumUris()).To(Equal(6))
+		})
 	})
 
 	Context("Unregister", func() {
@@ -166,6 +254,30 @@ var _ = Describe("RouteRegistry", func() {
 			Expect(r.NumEndpoints()).To(Equal(0))
 		})
 
+		It("ignores a host:port that was never registered", func() {
+			r.Register("bar", barEndpoint)
+
+			unknown := route.NewEndpoint("", "192.168.1.9", 9999, "", nil, -1, "")
+			r.Unregister("bar", unknown)
+
+			Expect(r.NumUris()).To(Equal(1))
+			Expect(r.NumEndpoints()).To(Equal(1))
+			Expect(r.Lookup("bar").Endpoints("").Next()).To(Equal(barEndpoint))
+		})
+
+		It("leaves the other uris of the endpoint routable", func() {
+			r.Register("bar", barEndpoint)
+			r.Register("baar", barEndpoint)
+			r.Register("baaar", barEndpoint)
+
+			r.Unregister("bar", barEndpoint)
+			r.Unregister("baar", barEndpoint)
+
+			Expect(r.Lookup("bar")).To(BeNil())
+			Expect(r.Lookup("baar")).To(BeNil())
+			Expect(r.Lookup("baaar").Endpoints("").Next()).To(Equal(barEndpoint))
+		})
+
 		It("ignores uri case and matches endpoint", func() {
 			m1 := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1, "")
 			m2 := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1, "")
@@ -285,6 +397,16 @@ var _ = Describe("RouteRegistry", func() {
 			Expect(e.CanonicalAddr()).To(MatchRegexp("192.168.1.1:123[4|5]"))
 		})
 
+		It("returns the pool registered for the matched uri", func() {
+			m := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1, "")
+
+			r.Register("*.wild.card", m)
+			r.Register("foo.com/bar", m)
+
+			Expect(r.Lookup("foo.wild.card").Uri()).To(Equal(route.Uri("*.wild.card")))
+			Expect(r.Lookup("FOO.com/bar/baz?q=1").Uri()).To(Equal(route.Uri("foo.com/bar")))
+		})
+
 		It("selects the outer most wild card route if one exists", func() {
 			app1 := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1, "")
 			app2 := route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1, "")
@@ -319,6 +441,34 @@ var _ = Describe("RouteRegistry", func() {
 			Expect(e.CanonicalAddr()).To(Equal("192.168.1.1:1234"))
 		})
 
+		It("prefers more specific wildcard routes", func() {
+			app1 := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1, "")
+			app2 := route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1, "")
+
+			r.Register("*.card", app1)
+			r.Register("*.wild.card", app2)
+
+			p := r.Lookup("foo.wild.card")
+			Expect(p).ToNot(BeNil())
+			Expect(p.Endpoints("").Next().CanonicalAddr()).To(Equal("192.168.1.2:1234"))
+
+			p = r.Lookup("foo.tame.card")
+			Expect(p).ToNot(BeNil())
+			Expect(p.Endpoints("").Next().CanonicalAddr()).To(Equal("192.168.1.1:1234"))
+		})
+
+		It("matches wildcard routes across multiple levels", func() {
+			app1 := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1, "")
+
+			r.Register("*.wild.card", app1)
+
+			p := r.Lookup("a.b.c.wild.card")
+			Expect(p).ToNot(BeNil())
+			Expect(p.Endpoints("").Next().CanonicalAddr()).To(Equal("192.168.1.1:1234"))
+
+			Expect(r.Lookup("wild.card")).To(BeNil())
+		})
+
 		Context("has context path", func() {
 
 			var m *route.Endpoint
@@ -336,6 +486,33 @@ var _ = Describe("RouteRegistry", func() {
 				Expect(iter.Next().CanonicalAddr()).To(Equal("192.168.1.1:1234"))
 			})
 
+			It("matches the longest registered path prefix", func() {
+				root := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1, "")
+				v2 := route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1, "")
+				users := route.NewEndpoint("", "192.168.1.3", 1234, "", nil, -1, "")
+
+				r.Register("api.app.com/v2/users", users)
+				r.Register("api.app.com", root)
+				r.Register("api.app.com/v2", v2)
+
+				Expect(r.Lookup("api.app.com/v2/users/1").Endpoints("").Next()).To(Equal(users))
+				Expect(r.Lookup("api.app.com/v2/apps").Endpoints("").Next()).To(Equal(v2))
+				Expect(r.Lookup("api.app.com/v2").Endpoints("").Next()).To(Equal(v2))
+				Expect(r.Lookup("api.app.com/v2users").Endpoints("").Next()).To(Equal(root))
+				Expect(r.Lookup("api.app.com/").Endpoints("").Next()).To(Equal(root))
+			})
+
+			It("prefers an exact host over a wildcard host with a longer path", func() {
+				exact := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1, "")
+				wildcard := route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1, "")
+
+				r.Register("api.app.com", exact)
+				r.Register("*.app.com/v2", wildcard)
+
+				Expect(r.Lookup("api.app.com/v2").Endpoints("").Next()).To(Equal(exact))
+				Expect(r.Lookup("web.app.com/v2").Endpoints("").Next()).To(Equal(wildcard))
+			})
+
 			It("using nested context path and query string", func() {
 				r.Register("dora.app.com/env/abc", m)
 				p := r.Lookup("dora.app.com/env/abc?foo=bar&baz=bing")
@@ -431,6 +608,422 @@ var _ = Describe("RouteRegistry", func() {
 		})
 	})
 
+	Context("Pools", func() {
+		It("returns every registered pool", func() {
+			m := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1, "")
+
+			r.Register("foo", m)
+			r.Register("bar", m)
+			r.Register("bar/baz", m)
+
+			uris := []route.Uri{}
+			for _, p := range r.Pools() {
+				uris = append(uris, p.Uri())
+			}
+			Expect(uris).To(ConsistOf(route.Uri("foo"), route.Uri("bar"), route.Uri("bar/baz")))
+		})
+
+		It("returns nothing for an empty registry", func() {
+			Expect(r.Pools()).To(BeEmpty())
+		})
+
+		It("includes route groups", func() {
+			canary := route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1, "")
+			canary.Match = &route.Match{Header: "X-Canary", Value: "true"}
+
+			r.Register("foo", fooEndpoint)
+			r.Register("foo", canary)
+
+			Expect(r.Pools()).To(HaveLen(2))
+			Expect(r.NumEndpoints()).To(Equal(2))
+		})
+	})
+
+	Context("route groups", func() {
+		var canary *route.Endpoint
+
+		BeforeEach(func() {
+			canary = route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1, "")
+			canary.Match = &route.Match{Header: "X-Canary", Value: "true"}
+		})
+
+		It("keeps a route with only grouped endpoints", func() {
+			r.Register("foo", canary)
+
+			Expect(r.NumUris()).To(Equal(1))
+			Expect(r.Lookup("foo").RouteGroups()).To(HaveLen(1))
+		})
+
+		It("removes the route with its last grouped endpoint", func() {
+			r.Register("foo", canary)
+			r.Unregister("foo", canary)
+
+			Expect(r.NumUris()).To(Equal(0))
+			Expect(r.Lookup("foo")).To(BeNil())
+		})
+	})
+
+	Context("Prune", func() {
+		BeforeEach(func() {
+			configObj.DropletStaleThreshold = time.Minute
+			r = NewRouteRegistry(configObj, messageBus, reporter)
+		})
+
+		It("removes droplets that have not been refreshed within the threshold", func() {
+			r.Register("foo", fooEndpoint)
+			r.Register("bar", barEndpoint)
+
+			r.Lookup("foo").MarkUpdated(time.Now().Add(-2 * time.Minute))
+
+			r.Prune()
+
+			Expect(r.Lookup("foo")).To(BeNil())
+			Expect(r.Lookup("bar")).ToNot(BeNil())
+			Expect(r.NumUris()).To(Equal(1))
+			Expect(r.NumEndpoints()).To(Equal(1))
+		})
+
+		It("keeps droplets that are registered again", func() {
+			r.Register("foo", fooEndpoint)
+
+			r.Lookup("foo").MarkUpdated(time.Now().Add(-2 * time.Minute))
+			r.Register("foo", fooEndpoint)
+
+			r.Prune()
+
+			Expect(r.Lookup("foo")).ToNot(BeNil())
+		})
+
+		It("keeps a refreshed endpoint when its address is re-registered", func() {
+			r.Register("foo", fooEndpoint)
+
+			r.Lookup("foo").MarkUpdated(time.Now().Add(-2 * time.Minute))
+			refreshed := route.NewEndpoint("12345", "192.168.1.1", 1234, "id1", nil, -1, "")
+			r.Register("foo", refreshed)
+
+			r.Prune()
+
+			p := r.Lookup("foo")
+			Expect(p).ToNot(BeNil())
+			Expect(p.Endpoints("").Next()).To(Equal(refreshed))
+		})
+	})
+
+	Context("change callbacks", func() {
+		var events []RouteEvent
+
+		BeforeEach(func() {
+			configObj.DropletStaleThreshold = time.Minute
+			r = NewRouteRegistry(configObj, messageBus, reporter)
+
+			events = nil
+			r.OnChange(func(event RouteEvent) {
+				// the registry must not be locked
+				r.NumUris()
+				events = append(events, event)
+			})
+		})
+
+		It("reports the URIs an endpoint is registered under and unregistered from", func() {
+			r.RegisterUris([]route.Uri{"foo", "bar"}, fooEndpoint)
+			r.Register("foo", fooEndpoint)
+			r.Unregister("bar", fooEndpoint)
+			r.Unregister("bar", fooEndpoint)
+
+			Expect(events).To(Equal([]RouteEvent{
+				{Type: EndpointRegistered, Uris: []route.Uri{"foo", "bar"}, Endpoint: fooEndpoint},
+				{Type: EndpointUnregistered, Uris: []route.Uri{"bar"}, Endpoint: fooEndpoint},
+			}))
+		})
+
+		It("reports the endpoints of the URIs unregistered by pattern", func() {
+			r.Register("api.staging.example.com", fooEndpoint)
+			r.Register("api.staging.example.com", barEndpoint)
+			r.Register("api.example.com", barEndpoint)
+			events = nil
+
+			_, err := r.UnregisterPattern("*.staging.example.com")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(events).To(ConsistOf(
+				RouteEvent{Type: EndpointUnregistered, Uris: []route.Uri{"api.staging.example.com"}, Endpoint: fooEndpoint},
+				RouteEvent{Type: EndpointUnregistered, Uris: []route.Uri{"api.staging.example.com"}, Endpoint: barEndpoint},
+			))
+		})
+
+		It("reports pruned endpoints", func() {
+			r.Register("foo", fooEndpoint)
+			r.Lookup("foo").MarkUpdated(time.Now().Add(-2 * time.Minute))
+
+			r.Prune()
+
+			Expect(events).To(HaveLen(2))
+			Expect(events[1]).To(Equal(RouteEvent{Type: EndpointPruned, Uris: []route.Uri{"foo"}, Endpoint: fooEndpoint}))
+		})
+	})
+
+	Context("logging", func() {
+		var sink *steno.TestingSink
+
+		recordsFor := func(message string) []*steno.Record {
+			var records []*steno.Record
+			for _, record := range sink.Records() {
+				if record.Message == message {
+					records = append(records, record)
+				}
+			}
+			return records
+		}
+
+		BeforeEach(func() {
+			sink = steno.NewTestingSink()
+			steno.Init(&steno.Config{
+				Sinks: []steno.Sink{sink},
+				Level: steno.LOG_INFO,
+			})
+
+			configObj.DropletStaleThreshold = time.Minute
+			r = NewRouteRegistry(configObj, messageBus, reporter)
+		})
+
+		AfterEach(func() {
+			steno.Init(&steno.Config{})
+		})
+
+		It("logs registered and pruned endpoints", func() {
+			r.Register("foo", fooEndpoint)
+			r.Register("foo", fooEndpoint)
+
+			registered := recordsFor("registry.endpoint.registered")
+			Expect(registered).To(HaveLen(1))
+			Expect(registered[0].Level).To(Equal(steno.LOG_INFO))
+			Expect(registered[0].Data).To(HaveKeyWithValue("uri", BeEquivalentTo("foo")))
+			Expect(registered[0].Data).To(HaveKeyWithValue("address", "192.168.1.1:1234"))
+			Expect(registered[0].Data).To(HaveKeyWithValue("app", "12345"))
+			Expect(registered[0].Data).To(HaveKeyWithValue("private_instance_id", "id1"))
+
+			r.Lookup("foo").MarkUpdated(time.Now().Add(-2 * time.Minute))
+			r.Prune()
+
+			pruned := recordsFor("registry.endpoint.pruned")
+			Expect(pruned).To(HaveLen(1))
+			Expect(pruned[0].Data).To(HaveKeyWithValue("uri", BeEquivalentTo("foo")))
+			Expect(pruned[0].Data).To(HaveKeyWithValue("address", "192.168.1.1:1234"))
+		})
+
+		It("logs unregistered endpoints", func() {
+			r.Register("foo", fooEndpoint)
+			r.Unregister("foo", fooEndpoint)
+			r.Unregister("foo", fooEndpoint)
+
+			unregistered := recordsFor("registry.endpoint.unregistered")
+			Expect(unregistered).To(HaveLen(1))
+			Expect(unregistered[0].Data).To(HaveKeyWithValue("address", "192.168.1.1:1234"))
+		})
+	})
+
+	Context("DrainBackend", func() {
+		It("drains the backend on every route it is registered for", func() {
+			r.Register("foo", fooEndpoint)
+			r.Register("fooo", fooEndpoint)
+			r.Register("foo", bar2Endpoint)
+
+			Expect(r.DrainBackend("192.168.1.1", 1234)).To(BeTrue())
+
+			Expect(r.Lookup("foo").IsDraining(fooEndpoint)).To(BeTrue())
+			Expect(r.Lookup("fooo").IsDraining(fooEndpoint)).To(BeTrue())
+			Expect(r.Lookup("foo").IsDraining(bar2Endpoint)).To(BeFalse())
+			Expect(r.Lookup("foo").Endpoints("").Next()).To(Equal(bar2Endpoint))
+		})
+
+		It("prunes the backend once it is idle", func() {
+			configObj.DropletStaleThreshold = time.Minute
+			r = NewRouteRegistry(configObj, messageBus, reporter)
+
+			r.Register("foo", fooEndpoint)
+			r.Register("foo", bar2Endpoint)
+			r.DrainBackend("192.168.1.1", 1234)
+
+			r.Prune()
+
+			Expect(r.NumUris()).To(Equal(1))
+			Expect(r.NumEndpoints()).To(Equal(1))
+		})
+
+		It("reports unknown backends", func() {
+			r.Register("foo", fooEndpoint)
+
+			Expect(r.DrainBackend("192.168.1.1", 4321)).To(BeFalse())
+		})
+	})
+
+	Context("Backends", func() {
+		It("lists every backend with its routes and state", func() {
+			canary := route.NewEndpoint("", "192.168.1.4", 1234, "", nil, -1, "")
+			canary.Match = &route.Match{Header: "X-Canary", Value: "true"}
+
+			r.Register("foo", fooEndpoint)
+			r.Register("fooo", fooEndpoint)
+			r.Register("bar", barEndpoint)
+			r.Register("bar", bar2Endpoint)
+			r.Register("foo", canary)
+
+			r.Lookup("bar").MarkUnhealthy(barEndpoint)
+			r.DrainBackend("192.168.1.3", 1234)
+			r.Lookup("foo").Endpoints("").PreRequest(fooEndpoint)
+			r.Lookup("fooo").Endpoints("").PreRequest(fooEndpoint)
+
+			Expect(r.Backends()).To(Equal([]Backend{
+				{
+					Address:           "192.168.1.1:1234",
+					ApplicationId:     "12345",
+					PrivateInstanceId: "id1",
+					Uris:              []route.Uri{"foo", "fooo"},
+					Healthy:           true,
+					InFlight:          2,
+				},
+				{
+					Address:           "192.168.1.2:4321",
+					ApplicationId:     "54321",
+					PrivateInstanceId: "id2",
+					Uris:              []route.Uri{"bar"},
+				},
+				{
+					Address:           "192.168.1.3:1234",
+					ApplicationId:     "54321",
+					PrivateInstanceId: "id3",
+					Uris:              []route.Uri{"bar"},
+					Healthy:           true,
+					Draining:          true,
+				},
+				{
+					Address: "192.168.1.4:1234",
+					Uris:    []route.Uri{"foo"},
+					Healthy: true,
+				},
+			}))
+		})
+
+		It("is empty without routes", func() {
+			Expect(r.Backends()).To(BeEmpty())
+		})
+	})
+
+	Context("IsRegistered", func() {
+		It("tells routes apart from the wildcards and paths matching them", func() {
+			r.Register("*.example.com", fooEndpoint)
+			r.Register("bar", barEndpoint)
+
+			Expect(r.IsRegistered("*.example.com")).To(BeTrue())
+			Expect(r.IsRegistered("BAR")).To(BeTrue())
+			Expect(r.IsRegistered("foo.example.com")).To(BeFalse())
+			Expect(r.IsRegistered("bar/path")).To(BeFalse())
+		})
+	})
+
+	Context("HasBackend", func() {
+		It("finds backends in route groups and default pools", func() {
+			canary := route.NewEndpoint("", "192.168.1.4", 1234, "", nil, -1, "")
+			canary.Match = &route.Match{Header: "X-Canary", Value: "true"}
+
+			r.Register("foo", fooEndpoint)
+			r.Register("foo", canary)
+
+			Expect(r.HasBackend("192.168.1.1:1234")).To(BeTrue())
+			Expect(r.HasBackend("192.168.1.4:1234")).To(BeTrue())
+			Expect(r.HasBackend("192.168.1.2:4321")).To(BeFalse())
+
+			r.Unregister("foo", canary)
+			Expect(r.HasBackend("192.168.1.4:1234")).To(BeFalse())
+		})
+	})
+
+	Context("Snapshot", func() {
+		var restored *RouteRegistry
+
+		BeforeEach(func() {
+			configObj.DropletStaleThreshold = time.Minute
+			r = NewRouteRegistry(configObj, messageBus, reporter)
+			restored = NewRouteRegistry(configObj, messageBus, reporter)
+		})
+
+		It("restores the routes into a fresh registry", func() {
+			canary := route.NewEndpoint("", "192.168.1.4", 1234, "", nil, -1, "")
+			canary.Match = &route.Match{Header: "X-Canary", Value: "true"}
+			canary.Timeout = 5 * time.Minute
+
+			r.Register("foo", fooEndpoint)
+			r.Register("bar.com/v2", barEndpoint)
+			r.Register("bar.com/v2", bar2Endpoint)
+			r.Register("*.wild.com", fooEndpoint)
+			r.Register("foo", canary)
+
+			data, err := r.Snapshot()
+			Expect(err).NotTo(HaveOccurred())
+
+			err = restored.Restore(data)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(restored.NumUris()).To(Equal(3))
+			Expect(restored.NumEndpoints()).To(Equal(4))
+
+			foo := restored.Lookup("foo")
+			Expect(foo).ToNot(BeNil())
+			Expect(foo.Endpoints("").Next().CanonicalAddr()).To(Equal("192.168.1.1:1234"))
+			Expect(foo.RouteGroups()).To(HaveLen(1))
+			Expect(foo.RouteGroups()[0].Endpoints("").Next().CanonicalAddr()).To(Equal("192.168.1.4:1234"))
+			Expect(foo.RouteGroups()[0].Endpoints("").Next().Timeout).To(Equal(5 * time.Minute))
+
+			bar := restored.Lookup("bar.com/v2/users")
+			Expect(bar).ToNot(BeNil())
+			Expect(bar.ContextPath()).To(Equal("/v2"))
+			Expect(bar.RouteServiceUrl()).To(Equal("https://my-rs.com"))
+
+			Expect(restored.Lookup("app.wild.com")).ToNot(BeNil())
+
+			original, err := json.Marshal(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Marshal(restored)).To(MatchJSON(original))
+		})
+
+		It("drops the endpoints that went stale since the snapshot", func() {
+			r.Register("foo", fooEndpoint)
+			r.Register("bar", barEndpoint)
+			r.Lookup("foo").MarkUpdated(time.Now().Add(-2 * time.Minute))
+
+			data, err := r.Snapshot()
+			Expect(err).NotTo(HaveOccurred())
+
+			err = restored.Restore(data)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(restored.Lookup("foo")).To(BeNil())
+			Expect(restored.Lookup("bar")).ToNot(BeNil())
+			Expect(restored.NumUris()).To(Equal(1))
+		})
+
+		It("keeps the time the endpoints were last registered", func() {
+			r.Register("foo", fooEndpoint)
+			r.Lookup("foo").MarkUpdated(time.Now().Add(-50 * time.Second))
+
+			data, err := r.Snapshot()
+			Expect(err).NotTo(HaveOccurred())
+
+			err = restored.Restore(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored.Lookup("foo")).ToNot(BeNil())
+
+			restored.Lookup("foo").PruneEndpoints(30 * time.Second)
+			Expect(restored.Lookup("foo").IsEmpty()).To(BeTrue())
+		})
+
+		It("rejects malformed snapshots", func() {
+			Expect(restored.Restore([]byte("not json"))).To(HaveOccurred())
+			Expect(restored.Restore([]byte(`[{"uri":"","endpoints":[]}]`))).To(HaveOccurred())
+			Expect(restored.NumUris()).To(Equal(0))
+		})
+	})
+
 	Context("Varz data", func() {
 		It("NumUris", func() {
 			r.Register("bar", barEndpoint)
@@ -477,4 +1070,31 @@ var _ = Describe("RouteRegistry", func() {
 		Ω(err).NotTo(HaveOccurred())
 		Expect(string(marshalled)).To(Equal(`{}`))
 	})
+
+	It("marshals the routes for a host", func() {
+		r.Register("foo.com", fooEndpoint)
+		r.Register("foo.com/v2", barEndpoint)
+		r.Register("*.foo.com", bar2Endpoint)
+		r.Register("bar.com", barEndpoint)
+
+		marshalled, err := r.MarshalHostJSON("FOO.com")
+		Ω(err).NotTo(HaveOccurred())
+
+		var routes map[string][]map[string]interface{}
+		Ω(json.Unmarshal(marshalled, &routes)).To(Succeed())
+		Expect(routes).To(HaveLen(2))
+		Expect(routes["foo.com"][0]["address"]).To(Equal("192.168.1.1:1234"))
+		Expect(routes["foo.com/v2"][0]["address"]).To(Equal("192.168.1.2:4321"))
+
+		marshalled, err = r.MarshalHostJSON("api.foo.com")
+		Ω(err).NotTo(HaveOccurred())
+		routes = nil
+		Ω(json.Unmarshal(marshalled, &routes)).To(Succeed())
+		Expect(routes).To(HaveKey("*.foo.com"))
+		Expect(routes).To(HaveLen(1))
+
+		marshalled, err = r.MarshalHostJSON("unknown.com")
+		Ω(err).NotTo(HaveOccurred())
+		Expect(string(marshalled)).To(Equal(`{}`))
+	})
 })
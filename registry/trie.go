@@ -205,6 +205,9 @@ func (r *Trie) endpointCount(m map[string]struct{}) map[string]struct{} {
 			m[e.CanonicalAddr()] = struct{}{}
 		}
 		r.Pool.Each(f)
+		for _, group := range r.Pool.RouteGroups() {
+			group.Each(f)
+		}
 	}
 
 	for _, child := range r.ChildNodes {
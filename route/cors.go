@@ -0,0 +1,75 @@
+package route
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORS is the cross-origin policy the router answers the preflight requests
+// of a route with, instead of passing them on to its endpoints. An origin of
+// "*" allows every origin. Without AllowedMethods or AllowedHeaders the
+// method and headers asked for by the preflight are allowed.
+type CORS struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	MaxAgeInSeconds  int      `json:"max_age,omitempty"`
+}
+
+// IsPreflight tells whether the request is a CORS preflight request.
+func IsPreflight(request *http.Request) bool {
+	return request.Method == "OPTIONS" &&
+		request.Header.Get("Origin") != "" &&
+		request.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// PreflightHeaders sets the Access-Control headers answering the preflight
+// request. Nothing but Vary is set for an origin that is not allowed, which
+// makes the browser refuse the actual request.
+func (c *CORS) PreflightHeaders(request *http.Request, header http.Header) {
+	header.Add("Vary", "Origin")
+
+	origin := request.Header.Get("Origin")
+	wildcard := false
+	allowed := false
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			wildcard = true
+			allowed = true
+		} else if o == origin {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return
+	}
+
+	// credentials are never sent to a wildcard origin
+	if wildcard && !c.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if len(c.AllowedMethods) > 0 {
+		header.Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+	} else {
+		header.Set("Access-Control-Allow-Methods", request.Header.Get("Access-Control-Request-Method"))
+	}
+
+	if len(c.AllowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	} else if requested := request.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		header.Set("Access-Control-Allow-Headers", requested)
+	}
+
+	if c.MaxAgeInSeconds > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAgeInSeconds))
+	}
+}
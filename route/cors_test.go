@@ -0,0 +1,72 @@
+package route_test
+
+import (
+	"net/http"
+
+	. "github.com/cloudfoundry/gorouter/route"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CORS", func() {
+	var request *http.Request
+
+	BeforeEach(func() {
+		var err error
+		request, err = http.NewRequest("OPTIONS", "http://api.example.com/items", nil)
+		Expect(err).NotTo(HaveOccurred())
+		request.Header.Set("Origin", "https://app.example.com")
+		request.Header.Set("Access-Control-Request-Method", "DELETE")
+		request.Header.Set("Access-Control-Request-Headers", "X-Token")
+	})
+
+	Context("IsPreflight", func() {
+		It("recognizes a preflight request", func() {
+			Expect(IsPreflight(request)).To(BeTrue())
+		})
+
+		It("requires the requested method", func() {
+			request.Header.Del("Access-Control-Request-Method")
+			Expect(IsPreflight(request)).To(BeFalse())
+		})
+
+		It("requires the OPTIONS method", func() {
+			request.Method = "GET"
+			Expect(IsPreflight(request)).To(BeFalse())
+		})
+	})
+
+	Context("PreflightHeaders", func() {
+		It("allows any origin and what the browser asks for with a wildcard", func() {
+			cors := &CORS{AllowedOrigins: []string{"*"}}
+
+			header := http.Header{}
+			cors.PreflightHeaders(request, header)
+
+			Expect(header.Get("Access-Control-Allow-Origin")).To(Equal("*"))
+			Expect(header.Get("Access-Control-Allow-Methods")).To(Equal("DELETE"))
+			Expect(header.Get("Access-Control-Allow-Headers")).To(Equal("X-Token"))
+			Expect(header.Get("Access-Control-Allow-Credentials")).To(BeEmpty())
+			Expect(header.Get("Access-Control-Max-Age")).To(BeEmpty())
+		})
+
+		It("names the origin when credentials are allowed", func() {
+			cors := &CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+
+			header := http.Header{}
+			cors.PreflightHeaders(request, header)
+
+			Expect(header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+			Expect(header.Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+		})
+
+		It("allows nothing to another origin", func() {
+			cors := &CORS{AllowedOrigins: []string{"https://other.example.com"}}
+
+			header := http.Header{}
+			cors.PreflightHeaders(request, header)
+
+			Expect(header).To(Equal(http.Header{"Vary": []string{"Origin"}}))
+		})
+	})
+})
@@ -6,6 +6,10 @@ import (
 	"time"
 )
 
+// DefaultWeight is the weight given to endpoints that do not specify one.
+// An endpoint with a weight of zero never receives traffic.
+const DefaultWeight = 1
+
 func NewEndpoint(appId, host string, port uint16, privateInstanceId string,
 	tags map[string]string, staleThresholdInSeconds int, routeServiceUrl string) *Endpoint {
 	return &Endpoint{
@@ -15,6 +19,7 @@ func NewEndpoint(appId, host string, port uint16, privateInstanceId string,
 		PrivateInstanceId: privateInstanceId,
 		staleThreshold:    time.Duration(staleThresholdInSeconds) * time.Second,
 		RouteServiceUrl:   routeServiceUrl,
+		Weight:            DefaultWeight,
 	}
 }
 
@@ -25,6 +30,49 @@ type Endpoint struct {
 	PrivateInstanceId string
 	staleThreshold    time.Duration
 	RouteServiceUrl   string
+	Weight            uint16
+
+	// TLS backends are dialed over TLS, verifying their certificate against
+	// ServerName, or their host when it is empty.
+	TLS        bool
+	ServerName string
+
+	// HostHeader replaces the Host of the requests proxied to the endpoint
+	// when it is not empty.
+	HostHeader string
+
+	// PathRewrite changes the path of the requests proxied to the endpoint
+	// when it is not nil.
+	PathRewrite *PathRewrite
+
+	// Timeout replaces the proxy's endpoint timeout for the requests
+	// proxied to the endpoint when it is not zero.
+	Timeout time.Duration
+
+	// CORS makes the router answer the preflight requests of the route
+	// itself when it is not nil.
+	CORS *CORS
+
+	// ResponseHeaders are set on the responses of the endpoint, replacing
+	// the backend's values and those of the router's response headers.
+	ResponseHeaders map[string]string
+
+	// RewriteLocation points the Location and Content-Location headers
+	// naming the endpoint itself at the host the client sent the request to.
+	RewriteLocation bool
+
+	// MaxRequestsPerSecond keeps requests away from the endpoint once it
+	// has been sent that many in the last second, when it is not zero. The
+	// limit holds for each route the endpoint is registered under.
+	MaxRequestsPerSecond int
+
+	// Match puts the endpoint in the route group receiving the requests
+	// it matches, instead of the route's default pool.
+	Match *Match
+
+	// TLSPassthrough gives the endpoint the TLS connections for the route's
+	// host name as they are, instead of requests. Its Match is ignored.
+	TLSPassthrough bool
 }
 
 func (e *Endpoint) MarshalJSON() ([]byte, error) {
@@ -32,10 +80,14 @@ func (e *Endpoint) MarshalJSON() ([]byte, error) {
 		Address         string `json:"address"`
 		TTL             int    `json:"ttl"`
 		RouteServiceUrl string `json:"route_service_url,omitempty"`
+		Match           *Match `json:"match,omitempty"`
+		TLSPassthrough  bool   `json:"tls_passthrough,omitempty"`
 	}
 
 	jsonObj.Address = e.addr
 	jsonObj.RouteServiceUrl = e.RouteServiceUrl
+	jsonObj.Match = e.Match
+	jsonObj.TLSPassthrough = e.TLSPassthrough
 	jsonObj.TTL = int(e.staleThreshold.Seconds())
 	return json.Marshal(jsonObj)
 }
@@ -1,6 +1,9 @@
 package route_test
 
 import (
+	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	. "github.com/cloudfoundry/gorouter/route"
@@ -131,6 +134,649 @@ var _ = Describe("EndpointIterator", func() {
 		})
 	})
 
+	Describe("Weight", func() {
+		It("distributes requests in proportion to endpoint weights", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e2 := NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			e3 := NewEndpoint("", "1.2.7.8", 1234, "", nil, -1, "")
+			e2.Weight = 3
+			e3.Weight = 6
+			endpoints := []*Endpoint{e1, e2, e3}
+
+			for _, e := range endpoints {
+				pool.Put(e)
+			}
+
+			counts := make(map[*Endpoint]int)
+
+			iter := pool.Endpoints("")
+
+			picks := 5000
+			for i := 0; i < picks; i++ {
+				counts[iter.Next()]++
+			}
+
+			Expect(counts[e1]).To(BeNumerically("~", picks*1/10, picks/100))
+			Expect(counts[e2]).To(BeNumerically("~", picks*3/10, picks/100))
+			Expect(counts[e3]).To(BeNumerically("~", picks*6/10, picks/100))
+		})
+
+		It("never returns endpoints with a weight of zero", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e2 := NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			e2.Weight = 0
+			pool.Put(e1)
+			pool.Put(e2)
+
+			iter := pool.Endpoints("")
+			for i := 0; i < 100; i++ {
+				Expect(iter.Next()).To(Equal(e1))
+			}
+		})
+
+		It("returns nil when all endpoints have a weight of zero", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e1.Weight = 0
+			pool.Put(e1)
+
+			iter := pool.Endpoints("")
+			Expect(iter.Next()).To(BeNil())
+		})
+
+		It("does not honor a sticky session to an endpoint with a weight of zero", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "a", nil, -1, "")
+			e2 := NewEndpoint("", "5.6.7.8", 1234, "b", nil, -1, "")
+			e2.Weight = 0
+			pool.Put(e1)
+			pool.Put(e2)
+
+			iter := pool.Endpoints(e2.PrivateInstanceId)
+			Expect(iter.Next()).To(Equal(e1))
+		})
+
+		It("skips failed endpoints regardless of their weight", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e2 := NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			e2.Weight = 10
+			pool.Put(e1)
+			pool.Put(e2)
+
+			iter := pool.Endpoints("")
+			for iter.Next() != e2 {
+			}
+			iter.EndpointFailed()
+
+			for i := 0; i < 10; i++ {
+				Expect(iter.Next()).To(Equal(e1))
+			}
+		})
+	})
+
+	Describe("SlowStart", func() {
+		newEndpointShare := func(age time.Duration, slowStart time.Duration) int {
+			pool := NewPool(2*time.Minute, "")
+			pool.SetSlowStart(slowStart)
+
+			// restored endpoints count as added at their snapshot time
+			for i := 0; i < 3; i++ {
+				pool.Restore(EndpointSnapshot{
+					Address:           fmt.Sprintf("10.0.0.%d:8080", i),
+					PrivateInstanceId: fmt.Sprintf("established-%d", i),
+					Weight:            1,
+					Updated:           time.Now().Add(-time.Hour),
+				})
+			}
+			pool.Restore(EndpointSnapshot{
+				Address:           "10.0.1.1:8080",
+				PrivateInstanceId: "new",
+				Weight:            1,
+				Updated:           time.Now().Add(-age),
+			})
+
+			share := 0
+			iter := pool.Endpoints("")
+			for i := 0; i < 4000; i++ {
+				if iter.Next().CanonicalAddr() == "10.0.1.1:8080" {
+					share++
+				}
+			}
+			return share
+		}
+
+		It("ramps up the share of a new endpoint over the slow start duration", func() {
+			var shares []int
+			for _, age := range []time.Duration{0, 15 * time.Second, 30 * time.Second, 45 * time.Second, time.Minute} {
+				shares = append(shares, newEndpointShare(age, time.Minute))
+			}
+
+			Expect(shares[0]).To(BeNumerically("<", 40))
+			for i := 1; i < len(shares); i++ {
+				Expect(shares[i]).To(BeNumerically(">", shares[i-1]))
+			}
+			Expect(shares[2]).To(BeNumerically("~", 4000*50/350, 40))
+			Expect(shares[4]).To(Equal(1000))
+		})
+
+		It("gives a new endpoint its full share without slow start", func() {
+			Expect(newEndpointShare(0, 0)).To(Equal(1000))
+		})
+
+		It("holds back a new endpoint with least connections", func() {
+			pool.SetSlowStart(time.Minute)
+			pool.Restore(EndpointSnapshot{
+				Address:           "10.0.0.1:8080",
+				PrivateInstanceId: "established",
+				Weight:            1,
+				Updated:           time.Now().Add(-time.Hour),
+			})
+			added := NewEndpoint("", "10.0.1.1", 8080, "added", nil, -1, "")
+			pool.Put(added)
+
+			var established *Endpoint
+			pool.Each(func(e *Endpoint) {
+				if e != added {
+					established = e
+				}
+			})
+
+			iter := pool.LeastConnectionEndpoints("")
+			iter.PreRequest(added)
+			for i := 0; i < 5; i++ {
+				iter.PreRequest(established)
+			}
+
+			// five requests in flight on the established endpoint weigh
+			// less than one on the endpoint just added
+			Expect(pool.LeastConnectionEndpoints("").Next()).To(Equal(established))
+		})
+	})
+
+	Describe("LeastConnection", func() {
+		var e1, e2, e3 *Endpoint
+
+		BeforeEach(func() {
+			e1 = NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e2 = NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			e3 = NewEndpoint("", "1.2.7.8", 1234, "", nil, -1, "")
+			pool.Put(e1)
+			pool.Put(e2)
+			pool.Put(e3)
+		})
+
+		It("selects the endpoint with the fewest in-flight requests", func() {
+			iter := pool.LeastConnectionEndpoints("")
+			iter.PreRequest(e1)
+			iter.PreRequest(e1)
+			iter.PreRequest(e2)
+			iter.PreRequest(e3)
+			iter.PreRequest(e3)
+
+			for i := 0; i < 10; i++ {
+				Expect(iter.Next()).To(Equal(e2))
+			}
+
+			iter.PostRequest(e1)
+			iter.PostRequest(e1)
+
+			Expect(iter.Next()).To(Equal(e1))
+		})
+
+		It("spreads requests across idle endpoints", func() {
+			iter := pool.LeastConnectionEndpoints("")
+
+			seen := map[*Endpoint]bool{}
+			for i := 0; i < 3; i++ {
+				seen[iter.Next()] = true
+			}
+
+			Expect(seen).To(HaveLen(3))
+		})
+
+		It("takes endpoint weight into account", func() {
+			e1.Weight = 4
+
+			iter := pool.LeastConnectionEndpoints("")
+			iter.PreRequest(e1)
+			iter.PreRequest(e1)
+			iter.PreRequest(e1)
+			iter.PreRequest(e2)
+			iter.PreRequest(e3)
+
+			Expect(iter.Next()).To(Equal(e1))
+		})
+
+		It("skips failed endpoints", func() {
+			pool.Remove(e3)
+
+			iter := pool.LeastConnectionEndpoints("")
+			iter.PreRequest(e1)
+
+			Expect(iter.Next()).To(Equal(e2))
+			iter.EndpointFailed()
+
+			Expect(iter.Next()).To(Equal(e1))
+		})
+
+		It("honors sticky sessions", func() {
+			iter := pool.LeastConnectionEndpoints(e1.CanonicalAddr())
+			iter.PreRequest(e1)
+
+			Expect(iter.Next()).To(Equal(e1))
+		})
+
+		It("shares in-flight counts between iterators over the same pool", func() {
+			pool.Remove(e3)
+
+			pool.LeastConnectionEndpoints("").PreRequest(e1)
+
+			iter := pool.LeastConnectionEndpoints("")
+			Expect(iter.Next()).To(Equal(e2))
+		})
+	})
+
+	Describe("SelectorEndpoints", func() {
+		var e1, e2, e3 *Endpoint
+
+		BeforeEach(func() {
+			e1 = NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e2 = NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			e3 = NewEndpoint("", "1.2.7.8", 1234, "", nil, -1, "")
+			for _, e := range []*Endpoint{e1, e2, e3} {
+				pool.Put(e)
+			}
+		})
+
+		It("takes turns with the round-robin selector", func() {
+			selector := NewRoundRobinSelector()
+
+			counts := make(map[*Endpoint]int)
+			for i := 0; i < 30; i++ {
+				counts[pool.SelectorEndpoints("", selector, nil).Next()]++
+			}
+
+			Expect(counts).To(Equal(map[*Endpoint]int{e1: 10, e2: 10, e3: 10}))
+		})
+
+		It("picks among all endpoints with the random selector", func() {
+			selector := NewRandomSelector()
+
+			counts := make(map[*Endpoint]int)
+			for i := 0; i < 300; i++ {
+				counts[pool.SelectorEndpoints("", selector, nil).Next()]++
+			}
+
+			Expect(counts).To(HaveLen(3))
+		})
+
+		It("takes turns in proportion to the weights with the round-robin selector", func() {
+			e2.Weight = 3
+			e3.Weight = 6
+			selector := NewRoundRobinSelector()
+
+			counts := make(map[*Endpoint]int)
+			for i := 0; i < 10; i++ {
+				counts[pool.SelectorEndpoints("", selector, nil).Next()]++
+			}
+
+			Expect(counts).To(Equal(map[*Endpoint]int{e1: 1, e2: 3, e3: 6}))
+		})
+
+		It("takes turns in every pool it is shared by with the round-robin selector", func() {
+			other := NewPool(2*time.Minute, "")
+			o1 := NewEndpoint("", "10.0.0.1", 8080, "", nil, -1, "")
+			o2 := NewEndpoint("", "10.0.0.2", 8080, "", nil, -1, "")
+			other.Put(o1)
+			other.Put(o2)
+			selector := NewRoundRobinSelector()
+
+			counts := make(map[*Endpoint]int)
+			for i := 0; i < 6; i++ {
+				counts[pool.SelectorEndpoints("", selector, nil).Next()]++
+				counts[other.SelectorEndpoints("", selector, nil).Next()]++
+			}
+
+			Expect(counts).To(Equal(map[*Endpoint]int{e1: 2, e2: 2, e3: 2, o1: 3, o2: 3}))
+		})
+
+		It("picks in proportion to the weights with the random selector", func() {
+			e3.Weight = 8
+			selector := NewRandomSelector()
+
+			counts := make(map[*Endpoint]int)
+			for i := 0; i < 5000; i++ {
+				counts[pool.SelectorEndpoints("", selector, nil).Next()]++
+			}
+
+			Expect(counts[e1]).To(BeNumerically("~", 500, 100))
+			Expect(counts[e2]).To(BeNumerically("~", 500, 100))
+			Expect(counts[e3]).To(BeNumerically("~", 4000, 150))
+		})
+
+		It("picks the least loaded endpoint with the least connection selector", func() {
+			iter := pool.SelectorEndpoints("", NewLeastConnectionSelector(), nil)
+			iter.PreRequest(e1)
+			iter.PreRequest(e3)
+
+			Expect(iter.Next()).To(Equal(e2))
+		})
+
+		It("spreads the load more evenly with the two choices selector than at random", func() {
+			pool = NewPool(2*time.Minute, "")
+			for i := 0; i < 10; i++ {
+				pool.Put(NewEndpoint("", "10.0.0.1", uint16(8000+i), "", nil, -1, ""))
+			}
+
+			// the difference between the most and the least loaded endpoint
+			// once 200 requests are in flight
+			skew := func(selector BackendSelector) int {
+				loads := make(map[*Endpoint]int)
+				for i := 0; i < 200; i++ {
+					iter := pool.SelectorEndpoints("", selector, nil)
+					e := iter.Next()
+					iter.PreRequest(e)
+					loads[e]++
+				}
+
+				var endpoints []*Endpoint
+				pool.Each(func(e *Endpoint) {
+					endpoints = append(endpoints, e)
+				})
+
+				min, max := 200, 0
+				for _, e := range endpoints {
+					if loads[e] < min {
+						min = loads[e]
+					}
+					if loads[e] > max {
+						max = loads[e]
+					}
+					for i := 0; i < loads[e]; i++ {
+						pool.Endpoints("").PostRequest(e)
+					}
+				}
+				return max - min
+			}
+
+			twoChoices, random := 0, 0
+			for i := 0; i < 10; i++ {
+				twoChoices += skew(NewTwoChoicesSelector())
+				random += skew(NewRandomSelector())
+			}
+
+			Expect(twoChoices).To(BeNumerically("<", random/2))
+			Expect(twoChoices).To(BeNumerically("<=", 10*4))
+		})
+
+		It("picks for many pools at once with the random and two choices selectors", func() {
+			for _, selector := range []BackendSelector{NewRandomSelector(), NewTwoChoicesSelector()} {
+				var wg sync.WaitGroup
+				for i := 0; i < 8; i++ {
+					other := NewPool(2*time.Minute, "")
+					other.Put(NewEndpoint("", "10.0.0.1", uint16(8080+i), "", nil, -1, ""))
+					other.Put(NewEndpoint("", "10.0.0.2", uint16(8080+i), "", nil, -1, ""))
+
+					wg.Add(1)
+					go func(pool *Pool, selector BackendSelector) {
+						defer GinkgoRecover()
+						defer wg.Done()
+
+						for j := 0; j < 100; j++ {
+							Expect(pool.SelectorEndpoints("", selector, nil).Next()).NotTo(BeNil())
+						}
+					}(other, selector)
+				}
+				wg.Wait()
+			}
+		})
+
+		It("only offers endpoints that can take requests", func() {
+			e2.Weight = 0
+			pool.MarkUnhealthy(e3)
+
+			selector := NewRoundRobinSelector()
+			for i := 0; i < 5; i++ {
+				Expect(pool.SelectorEndpoints("", selector, nil).Next()).To(Equal(e1))
+			}
+		})
+
+		Describe("the header hash selector", func() {
+			var selector BackendSelector
+
+			requestFor := func(tenant string) *http.Request {
+				request, err := http.NewRequest("GET", "http://example.com/", nil)
+				Expect(err).NotTo(HaveOccurred())
+				request.Header.Set("X-Tenant-Id", tenant)
+				return request
+			}
+
+			assignments := func() map[string]*Endpoint {
+				assigned := make(map[string]*Endpoint)
+				for i := 0; i < 1000; i++ {
+					tenant := fmt.Sprintf("tenant-%d", i)
+					assigned[tenant] = pool.SelectorEndpoints("", selector, requestFor(tenant)).Next()
+				}
+				return assigned
+			}
+
+			BeforeEach(func() {
+				selector = NewHeaderHashSelector("X-Tenant-Id")
+			})
+
+			It("sends requests with the same header value to the same endpoint", func() {
+				assigned := assignments()
+
+				counts := make(map[*Endpoint]int)
+				for tenant, e := range assigned {
+					for i := 0; i < 3; i++ {
+						Expect(pool.SelectorEndpoints("", selector, requestFor(tenant)).Next()).To(Equal(e))
+					}
+					counts[e]++
+				}
+				for _, e := range []*Endpoint{e1, e2, e3} {
+					Expect(counts[e]).To(BeNumerically("~", 333, 100))
+				}
+			})
+
+			It("only reassigns the values the added endpoint takes over", func() {
+				before := assignments()
+
+				e4 := NewEndpoint("", "10.0.0.4", 8080, "", nil, -1, "")
+				pool.Put(e4)
+				after := assignments()
+
+				moved := 0
+				for tenant, e := range after {
+					if e != before[tenant] {
+						Expect(e).To(Equal(e4))
+						moved++
+					}
+				}
+				Expect(moved).To(BeNumerically("~", 250, 100))
+			})
+
+			It("takes turns for requests without the header", func() {
+				counts := make(map[*Endpoint]int)
+				for i := 0; i < 30; i++ {
+					counts[pool.SelectorEndpoints("", selector, nil).Next()]++
+				}
+
+				Expect(counts).To(Equal(map[*Endpoint]int{e1: 10, e2: 10, e3: 10}))
+			})
+		})
+
+		It("prefers the sticky endpoint", func() {
+			sticky := NewEndpoint("", "1.2.7.8", 1234, "sticky", nil, -1, "")
+			pool.Put(sticky)
+
+			iter := pool.SelectorEndpoints("sticky", NewRoundRobinSelector(), nil)
+			Expect(iter.Next()).To(Equal(sticky))
+		})
+	})
+
+	Describe("Preview", func() {
+		It("returns the endpoint the iterator would pick without taking its turn", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e2 := NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			pool.Put(e1)
+			pool.Put(e2)
+
+			for i := 0; i < 4; i++ {
+				preview := pool.Preview("", false, nil, nil)
+				Expect(pool.Preview("", false, nil, nil)).To(Equal(preview))
+				Expect(pool.Endpoints("").Next()).To(Equal(preview))
+			}
+		})
+
+		It("does not take the turn of a header hash selector falling back to round robin", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e2 := NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			pool.Put(e1)
+			pool.Put(e2)
+
+			selector := NewHeaderHashSelector("X-Session")
+			request, _ := http.NewRequest("GET", "/", nil)
+
+			for i := 0; i < 4; i++ {
+				preview := pool.Preview("", false, selector, request)
+				Expect(pool.Preview("", false, selector, request)).To(Equal(preview))
+				Expect(pool.SelectorEndpoints("", selector, request).Next()).To(Equal(preview))
+			}
+		})
+
+		It("leaves the probe of a half-open circuit to a request", func() {
+			pool.SetCircuitBreaker(1, time.Millisecond)
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			pool.Put(e1)
+
+			pool.Endpoints("").RecordFailure(e1)
+			time.Sleep(time.Millisecond)
+
+			Expect(pool.Preview("", false, nil, nil)).To(Equal(e1))
+			Expect(pool.Endpoints("").Next()).To(Equal(e1))
+			Expect(pool.Endpoints("").Next()).To(BeNil())
+		})
+	})
+
+	Describe("InFlight", func() {
+		It("tracks requests between PreRequest and PostRequest", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			pool.Put(e1)
+
+			iter := pool.Endpoints("")
+			iter.PreRequest(e1)
+			iter.PreRequest(e1)
+			Expect(pool.InFlight(e1)).To(Equal(2))
+
+			iter.PostRequest(e1)
+			Expect(pool.InFlight(e1)).To(Equal(1))
+		})
+
+		It("survives re-registration of the endpoint", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			pool.Put(e1)
+
+			pool.Endpoints("").PreRequest(e1)
+
+			refreshed := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			pool.Put(refreshed)
+
+			Expect(pool.InFlight(refreshed)).To(Equal(1))
+		})
+
+		It("never drops below zero", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			pool.Put(e1)
+
+			pool.Endpoints("").PostRequest(e1)
+			Expect(pool.InFlight(e1)).To(Equal(0))
+		})
+	})
+
+	Describe("Unhealthy", func() {
+		It("skips unhealthy endpoints", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e2 := NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			pool.Put(e1)
+			pool.Put(e2)
+			pool.MarkUnhealthy(e1)
+
+			for i := 0; i < 10; i++ {
+				Expect(pool.Endpoints("").Next()).To(Equal(e2))
+				Expect(pool.LeastConnectionEndpoints("").Next()).To(Equal(e2))
+			}
+		})
+
+		It("returns no endpoint when all endpoints are unhealthy", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			pool.Put(e1)
+			pool.MarkUnhealthy(e1)
+
+			Expect(pool.Endpoints("").Next()).To(BeNil())
+			Expect(pool.LeastConnectionEndpoints("").Next()).To(BeNil())
+		})
+
+		It("does not stick to an unhealthy endpoint", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "a", nil, -1, "")
+			e2 := NewEndpoint("", "5.6.7.8", 1234, "b", nil, -1, "")
+			pool.Put(e1)
+			pool.Put(e2)
+			pool.MarkUnhealthy(e1)
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(e2))
+		})
+
+		It("uses endpoints again once they are healthy", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			pool.Put(e1)
+			pool.MarkUnhealthy(e1)
+			Expect(pool.IsHealthy(e1)).To(BeFalse())
+
+			pool.MarkHealthy(e1)
+			Expect(pool.IsHealthy(e1)).To(BeTrue())
+			Expect(pool.Endpoints("").Next()).To(Equal(e1))
+		})
+	})
+
+	Describe("MaxRequestsPerSecond", func() {
+		It("sends the requests over an endpoint's rate to the others", func() {
+			limited := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			limited.MaxRequestsPerSecond = 2
+			unlimited := NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			pool.Put(limited)
+			pool.Put(unlimited)
+
+			seen := map[*Endpoint]int{}
+			for i := 0; i < 10; i++ {
+				seen[pool.Endpoints("").Next()]++
+			}
+			Expect(seen[limited]).To(Equal(2))
+			Expect(seen[unlimited]).To(Equal(8))
+		})
+
+		It("returns no endpoint once all of them are over their rate", func() {
+			limited := NewEndpoint("", "1.2.3.4", 5678, "a", nil, -1, "")
+			limited.MaxRequestsPerSecond = 1
+			pool.Put(limited)
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(limited))
+			Expect(pool.Endpoints("a").Next()).To(BeNil())
+			Expect(pool.LeastConnectionEndpoints("").Next()).To(BeNil())
+		})
+
+		It("takes requests again as its budget refills", func() {
+			limited := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			limited.MaxRequestsPerSecond = 20
+			pool.Put(limited)
+
+			for i := 0; i < 20; i++ {
+				Expect(pool.Endpoints("").Next()).To(Equal(limited))
+			}
+			Expect(pool.Endpoints("").Next()).To(BeNil())
+
+			Eventually(func() *Endpoint { return pool.Endpoints("").Next() }).Should(Equal(limited))
+		})
+	})
+
 	Describe("Failed", func() {
 		It("skips failed endpoints", func() {
 			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
@@ -196,4 +842,198 @@ var _ = Describe("EndpointIterator", func() {
 			Expect(n1).ToNot(Equal(n2))
 		})
 	})
+
+	Describe("CircuitBreaker", func() {
+		var e1, e2 *Endpoint
+
+		BeforeEach(func() {
+			pool.SetCircuitBreaker(3, 50*time.Millisecond)
+
+			e1 = NewEndpoint("", "1.2.3.4", 5678, "a", nil, -1, "")
+			e2 = NewEndpoint("", "5.6.7.8", 1234, "b", nil, -1, "")
+			pool.Put(e1)
+			pool.Put(e2)
+		})
+
+		It("skips an endpoint after consecutive failures until the cooldown elapses", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 2; i++ {
+				iter.RecordFailure(e1)
+			}
+
+			seen := map[*Endpoint]bool{}
+			for i := 0; i < 4; i++ {
+				seen[iter.Next()] = true
+			}
+			Expect(seen).To(HaveKey(e1))
+
+			iter.RecordFailure(e1)
+
+			for i := 0; i < 10; i++ {
+				Expect(pool.Endpoints("").Next()).To(Equal(e2))
+				Expect(pool.LeastConnectionEndpoints("").Next()).To(Equal(e2))
+				Expect(pool.Endpoints("a").Next()).To(Equal(e2))
+			}
+
+			time.Sleep(50 * time.Millisecond)
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(e1))
+		})
+
+		It("reports whether the circuit is open", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 2; i++ {
+				iter.RecordFailure(e1)
+			}
+			Expect(pool.IsCircuitOpen(e1)).To(BeFalse())
+
+			iter.RecordFailure(e1)
+			Expect(pool.IsCircuitOpen(e1)).To(BeTrue())
+			Expect(pool.IsCircuitOpen(e2)).To(BeFalse())
+
+			iter.RecordSuccess(e1)
+			Expect(pool.IsCircuitOpen(e1)).To(BeFalse())
+		})
+
+		It("resets the failure count after a success", func() {
+			iter := pool.Endpoints("")
+			iter.RecordFailure(e1)
+			iter.RecordFailure(e1)
+			iter.RecordSuccess(e1)
+			iter.RecordFailure(e1)
+			iter.RecordFailure(e1)
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(e1))
+		})
+
+		It("lets a single probe through once the cooldown elapses", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 3; i++ {
+				iter.RecordFailure(e1)
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(e1))
+			Expect(pool.Endpoints("a").Next()).To(Equal(e2))
+		})
+
+		It("closes the circuit when the probe succeeds", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 3; i++ {
+				iter.RecordFailure(e1)
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(e1))
+			iter.RecordSuccess(e1)
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(e1))
+			Expect(pool.Endpoints("a").Next()).To(Equal(e1))
+		})
+
+		It("reopens the circuit when the probe fails", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 3; i++ {
+				iter.RecordFailure(e1)
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(e1))
+			iter.RecordFailure(e1)
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(e2))
+		})
+
+		It("returns no endpoint when all circuits are open", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 3; i++ {
+				iter.RecordFailure(e1)
+				iter.RecordFailure(e2)
+			}
+
+			Expect(pool.Endpoints("").Next()).To(BeNil())
+		})
+
+		It("is disabled with a threshold of zero", func() {
+			pool.SetCircuitBreaker(0, time.Minute)
+
+			iter := pool.Endpoints("")
+			for i := 0; i < 10; i++ {
+				iter.RecordFailure(e1)
+			}
+
+			Expect(pool.Endpoints("a").Next()).To(Equal(e1))
+		})
+	})
+
+	Describe("OutlierDetection", func() {
+		var endpoints []*Endpoint
+
+		BeforeEach(func() {
+			pool.SetOutlierDetection(OutlierDetection{
+				Interval:        50 * time.Millisecond,
+				MinRequests:     10,
+				DeviationFactor: 2,
+				EjectionTime:    100 * time.Millisecond,
+			})
+
+			endpoints = nil
+			for i := 0; i < 4; i++ {
+				e := NewEndpoint("", fmt.Sprintf("10.0.0.%d", i), 8080, fmt.Sprintf("id-%d", i), nil, -1, "")
+				pool.Put(e)
+				endpoints = append(endpoints, e)
+			}
+		})
+
+		// record sends requests to every endpoint, every other one failing on
+		// the first endpoint, then ends the interval
+		record := func(requests int) {
+			iter := pool.Endpoints("")
+			for i := 0; i < requests; i++ {
+				for j, e := range endpoints {
+					if j == 0 && i%2 == 0 {
+						iter.RecordFailure(e)
+					} else {
+						iter.RecordSuccess(e)
+					}
+				}
+			}
+
+			time.Sleep(50 * time.Millisecond)
+			iter.RecordSuccess(endpoints[1])
+		}
+
+		It("ejects an endpoint failing more often than its peers until the ejection time elapses", func() {
+			record(20)
+
+			Expect(pool.IsEjected(endpoints[0])).To(BeTrue())
+			seen := map[*Endpoint]int{}
+			for i := 0; i < 30; i++ {
+				seen[pool.Endpoints("").Next()]++
+				seen[pool.LeastConnectionEndpoints("").Next()]++
+			}
+			Expect(seen).NotTo(HaveKey(endpoints[0]))
+			Expect(seen).To(HaveLen(3))
+			Expect(pool.Endpoints("id-0").Next()).NotTo(Equal(endpoints[0]))
+
+			time.Sleep(100 * time.Millisecond)
+
+			Expect(pool.IsEjected(endpoints[0])).To(BeFalse())
+			Expect(pool.Endpoints("id-0").Next()).To(Equal(endpoints[0]))
+		})
+
+		It("does not judge endpoints with fewer requests than the minimum", func() {
+			record(5)
+
+			Expect(pool.IsEjected(endpoints[0])).To(BeFalse())
+		})
+
+		It("is disabled with a deviation factor of zero", func() {
+			pool.SetOutlierDetection(OutlierDetection{})
+
+			record(20)
+
+			Expect(pool.IsEjected(endpoints[0])).To(BeFalse())
+		})
+	})
 })
@@ -11,12 +11,32 @@ type FakeEndpointIterator struct {
 	NextStub        func() *route.Endpoint
 	nextMutex       sync.RWMutex
 	nextArgsForCall []struct{}
-	nextReturns struct {
+	nextReturns     struct {
 		result1 *route.Endpoint
 	}
 	EndpointFailedStub        func()
 	endpointFailedMutex       sync.RWMutex
 	endpointFailedArgsForCall []struct{}
+	PreRequestStub            func(e *route.Endpoint)
+	preRequestMutex           sync.RWMutex
+	preRequestArgsForCall     []struct {
+		e *route.Endpoint
+	}
+	PostRequestStub        func(e *route.Endpoint)
+	postRequestMutex       sync.RWMutex
+	postRequestArgsForCall []struct {
+		e *route.Endpoint
+	}
+	RecordSuccessStub        func(e *route.Endpoint)
+	recordSuccessMutex       sync.RWMutex
+	recordSuccessArgsForCall []struct {
+		e *route.Endpoint
+	}
+	RecordFailureStub        func(e *route.Endpoint)
+	recordFailureMutex       sync.RWMutex
+	recordFailureArgsForCall []struct {
+		e *route.Endpoint
+	}
 }
 
 func (fake *FakeEndpointIterator) Next() *route.Endpoint {
@@ -58,4 +78,96 @@ func (fake *FakeEndpointIterator) EndpointFailedCallCount() int {
 	return len(fake.endpointFailedArgsForCall)
 }
 
+func (fake *FakeEndpointIterator) PreRequest(e *route.Endpoint) {
+	fake.preRequestMutex.Lock()
+	fake.preRequestArgsForCall = append(fake.preRequestArgsForCall, struct {
+		e *route.Endpoint
+	}{e})
+	fake.preRequestMutex.Unlock()
+	if fake.PreRequestStub != nil {
+		fake.PreRequestStub(e)
+	}
+}
+
+func (fake *FakeEndpointIterator) PreRequestCallCount() int {
+	fake.preRequestMutex.RLock()
+	defer fake.preRequestMutex.RUnlock()
+	return len(fake.preRequestArgsForCall)
+}
+
+func (fake *FakeEndpointIterator) PreRequestArgsForCall(i int) *route.Endpoint {
+	fake.preRequestMutex.RLock()
+	defer fake.preRequestMutex.RUnlock()
+	return fake.preRequestArgsForCall[i].e
+}
+
+func (fake *FakeEndpointIterator) PostRequest(e *route.Endpoint) {
+	fake.postRequestMutex.Lock()
+	fake.postRequestArgsForCall = append(fake.postRequestArgsForCall, struct {
+		e *route.Endpoint
+	}{e})
+	fake.postRequestMutex.Unlock()
+	if fake.PostRequestStub != nil {
+		fake.PostRequestStub(e)
+	}
+}
+
+func (fake *FakeEndpointIterator) PostRequestCallCount() int {
+	fake.postRequestMutex.RLock()
+	defer fake.postRequestMutex.RUnlock()
+	return len(fake.postRequestArgsForCall)
+}
+
+func (fake *FakeEndpointIterator) PostRequestArgsForCall(i int) *route.Endpoint {
+	fake.postRequestMutex.RLock()
+	defer fake.postRequestMutex.RUnlock()
+	return fake.postRequestArgsForCall[i].e
+}
+
+func (fake *FakeEndpointIterator) RecordSuccess(e *route.Endpoint) {
+	fake.recordSuccessMutex.Lock()
+	fake.recordSuccessArgsForCall = append(fake.recordSuccessArgsForCall, struct {
+		e *route.Endpoint
+	}{e})
+	fake.recordSuccessMutex.Unlock()
+	if fake.RecordSuccessStub != nil {
+		fake.RecordSuccessStub(e)
+	}
+}
+
+func (fake *FakeEndpointIterator) RecordSuccessCallCount() int {
+	fake.recordSuccessMutex.RLock()
+	defer fake.recordSuccessMutex.RUnlock()
+	return len(fake.recordSuccessArgsForCall)
+}
+
+func (fake *FakeEndpointIterator) RecordSuccessArgsForCall(i int) *route.Endpoint {
+	fake.recordSuccessMutex.RLock()
+	defer fake.recordSuccessMutex.RUnlock()
+	return fake.recordSuccessArgsForCall[i].e
+}
+
+func (fake *FakeEndpointIterator) RecordFailure(e *route.Endpoint) {
+	fake.recordFailureMutex.Lock()
+	fake.recordFailureArgsForCall = append(fake.recordFailureArgsForCall, struct {
+		e *route.Endpoint
+	}{e})
+	fake.recordFailureMutex.Unlock()
+	if fake.RecordFailureStub != nil {
+		fake.RecordFailureStub(e)
+	}
+}
+
+func (fake *FakeEndpointIterator) RecordFailureCallCount() int {
+	fake.recordFailureMutex.RLock()
+	defer fake.recordFailureMutex.RUnlock()
+	return len(fake.recordFailureArgsForCall)
+}
+
+func (fake *FakeEndpointIterator) RecordFailureArgsForCall(i int) *route.Endpoint {
+	fake.recordFailureMutex.RLock()
+	defer fake.recordFailureMutex.RUnlock()
+	return fake.recordFailureArgsForCall[i].e
+}
+
 var _ route.EndpointIterator = new(FakeEndpointIterator)
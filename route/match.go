@@ -0,0 +1,68 @@
+package route
+
+import (
+	"hash/fnv"
+	"net"
+	"net/http"
+)
+
+// Match selects the requests a route group receives. An empty Method or
+// Header matches every request; a Header without a Value matches requests
+// that carry the header with any value. A CanaryPercent between 1 and 100
+// additionally limits the group to that share of clients, chosen by a hash
+// of the client's IP so that every client stays on the same side.
+//
+// A MirrorPercent group receives no requests either; that share of the
+// requests of the route is copied to its endpoints, whose responses are
+// discarded, see Pool.MirrorGroup.
+type Match struct {
+	Method        string `json:"method,omitempty"`
+	Header        string `json:"header,omitempty"`
+	Value         string `json:"value,omitempty"`
+	CanaryPercent int    `json:"canary_percent,omitempty"`
+	MirrorPercent int    `json:"mirror_percent,omitempty"`
+}
+
+// Matches tells whether the request, sent by the client at clientAddr,
+// belongs to the group.
+func (m Match) Matches(request *http.Request, clientAddr string) bool {
+	if m.MirrorPercent > 0 {
+		return false
+	}
+
+	if m.Method != "" && m.Method != request.Method {
+		return false
+	}
+
+	if m.CanaryPercent > 0 && m.CanaryPercent < 100 && clientBucket(clientAddr) >= m.CanaryPercent {
+		return false
+	}
+
+	if m.Header == "" {
+		return true
+	}
+
+	values := request.Header[http.CanonicalHeaderKey(m.Header)]
+	if m.Value == "" {
+		return len(values) > 0
+	}
+
+	for _, value := range values {
+		if value == m.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// clientBucket maps the client's IP to a number between 0 and 99. The port
+// is ignored as it changes with every connection.
+func clientBucket(clientAddr string) int {
+	if host, _, err := net.SplitHostPort(clientAddr); err == nil {
+		clientAddr = host
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(clientAddr))
+	return int(h.Sum32() % 100)
+}
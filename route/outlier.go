@@ -0,0 +1,109 @@
+package route
+
+import (
+	"time"
+)
+
+// OutlierDetection ejects the endpoints of a pool whose failure rate stands
+// out from the rest. Every Interval the failure rate of each endpoint that
+// received at least MinRequests requests is compared with the rate of all
+// those endpoints together; an endpoint failing more than DeviationFactor
+// times as often receives no traffic for EjectionTime. A DeviationFactor of
+// 0 disables it.
+type OutlierDetection struct {
+	Interval        time.Duration
+	MinRequests     int
+	DeviationFactor float64
+	EjectionTime    time.Duration
+}
+
+func (o OutlierDetection) enabled() bool {
+	return o.DeviationFactor > 0 && o.Interval > 0
+}
+
+// SetOutlierDetection enables outlier detection for the endpoints of the
+// pool, or disables it for a zero OutlierDetection.
+func (p *Pool) SetOutlierDetection(outlier OutlierDetection) {
+	p.lock.Lock()
+	p.outlier = outlier
+	p.lock.Unlock()
+}
+
+// IsEjected tells whether outlier detection keeps traffic away from the
+// endpoint.
+func (p *Pool) IsEjected(endpoint *Endpoint) bool {
+	ejected := false
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		ejected = p.isEjected(e, time.Now())
+	}
+	p.lock.Unlock()
+
+	return ejected
+}
+
+func (p *Pool) isEjected(e *endpointElem, now time.Time) bool {
+	return e.ejectedAt != nil && now.Sub(*e.ejectedAt) < p.outlier.EjectionTime
+}
+
+// countOutcome must be called with the lock held
+func (p *Pool) countOutcome(e *endpointElem, failed bool) {
+	if !p.outlier.enabled() {
+		return
+	}
+
+	e.requests++
+	if failed {
+		e.failures++
+	}
+
+	now := time.Now()
+	if p.windowStart.IsZero() {
+		p.windowStart = now
+	} else if now.Sub(p.windowStart) >= p.outlier.Interval {
+		p.ejectOutliers(now)
+		p.windowStart = now
+	}
+}
+
+// ejectOutliers must be called with the lock held. No more than half of the
+// endpoints are ever ejected at the same time, so that a failing backend
+// service does not end up without any endpoints.
+func (p *Pool) ejectOutliers(now time.Time) {
+	requests, failures, counted := 0, 0, 0
+	for _, e := range p.endpoints {
+		if e.requests >= p.outlier.MinRequests {
+			requests += e.requests
+			failures += e.failures
+			counted++
+		}
+	}
+
+	if counted > 1 && failures > 0 {
+		ejected := 0
+		for _, e := range p.endpoints {
+			if p.isEjected(e, now) {
+				ejected++
+			}
+		}
+
+		threshold := p.outlier.DeviationFactor * float64(failures) / float64(requests)
+		for _, e := range p.endpoints {
+			if (ejected+1)*2 > len(p.endpoints) {
+				break
+			}
+
+			if e.requests >= p.outlier.MinRequests && float64(e.failures)/float64(e.requests) > threshold {
+				t := now
+				e.ejectedAt = &t
+				ejected++
+			}
+		}
+	}
+
+	for _, e := range p.endpoints {
+		e.requests = 0
+		e.failures = 0
+	}
+}
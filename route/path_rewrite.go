@@ -0,0 +1,52 @@
+package route
+
+import (
+	"net/url"
+	"strings"
+)
+
+// PathRewrite changes the path of the requests proxied to an endpoint, for
+// backends serving under another prefix than the public URL. StripPrefix is
+// removed first, when the path starts with it, then AddPrefix is prepended.
+type PathRewrite struct {
+	StripPrefix string `json:"strip_prefix,omitempty"`
+	AddPrefix   string `json:"add_prefix,omitempty"`
+}
+
+// Rewrite applies the rule to the path of the URL. An opaque URL is taken as
+// a request URI, such as the one the proxy passes on as received.
+func (r *PathRewrite) Rewrite(u *url.URL) {
+	if u.Opaque != "" {
+		requestURI, err := url.ParseRequestURI(u.Opaque)
+		if err != nil {
+			return
+		}
+
+		r.Rewrite(requestURI)
+		u.Opaque = requestURI.RequestURI()
+		return
+	}
+
+	u.Path = r.rewrite(u.Path)
+	if u.RawPath != "" {
+		// dropped by url.URL when it no longer encodes the path
+		u.RawPath = r.rewrite(u.RawPath)
+	}
+}
+
+func (r *PathRewrite) rewrite(path string) string {
+	// the prefix only matches whole segments, so /api does not strip /apis
+	if prefix := strings.TrimSuffix(r.StripPrefix, "/"); prefix != "" {
+		if path == prefix {
+			path = "/"
+		} else if strings.HasPrefix(path, prefix+"/") {
+			path = path[len(prefix):]
+		}
+	}
+
+	if prefix := strings.TrimSuffix(r.AddPrefix, "/"); prefix != "" {
+		path = prefix + path
+	}
+
+	return path
+}
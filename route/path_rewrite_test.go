@@ -0,0 +1,55 @@
+package route_test
+
+import (
+	"net/url"
+
+	. "github.com/cloudfoundry/gorouter/route"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PathRewrite", func() {
+	rewrite := func(rule *PathRewrite, rawurl string) string {
+		u, err := url.Parse(rawurl)
+		Expect(err).NotTo(HaveOccurred())
+
+		rule.Rewrite(u)
+		return u.RequestURI()
+	}
+
+	It("strips a prefix", func() {
+		rule := &PathRewrite{StripPrefix: "/api/v2"}
+
+		Expect(rewrite(rule, "http://example.com/api/v2/x?q=1")).To(Equal("/x?q=1"))
+		Expect(rewrite(rule, "http://example.com/api/v2")).To(Equal("/"))
+	})
+
+	It("only strips whole path segments", func() {
+		rule := &PathRewrite{StripPrefix: "/api/"}
+
+		Expect(rewrite(rule, "http://example.com/apis/x")).To(Equal("/apis/x"))
+		Expect(rewrite(rule, "http://example.com/other")).To(Equal("/other"))
+	})
+
+	It("adds a prefix after stripping one", func() {
+		rule := &PathRewrite{StripPrefix: "/api/v2", AddPrefix: "/internal/"}
+
+		Expect(rewrite(rule, "http://example.com/api/v2/x")).To(Equal("/internal/x"))
+		Expect(rewrite(rule, "http://example.com/x")).To(Equal("/internal/x"))
+	})
+
+	It("rewrites the request URI of an opaque URL", func() {
+		rule := &PathRewrite{StripPrefix: "/api"}
+
+		u := &url.URL{Scheme: "http", Host: "10.0.0.1:8080", Opaque: "/api/a%2Fb?q=1"}
+		rule.Rewrite(u)
+
+		Expect(u.Opaque).To(Equal("/a%2Fb?q=1"))
+	})
+
+	It("keeps escaped characters of the path", func() {
+		rule := &PathRewrite{StripPrefix: "/api"}
+
+		Expect(rewrite(rule, "http://example.com/api/a%2Fb")).To(Equal("/a%2Fb"))
+	})
+})
@@ -2,20 +2,28 @@ package route
 
 import (
 	"encoding/json"
-	"math/rand"
+	"net/http"
 	"sync"
 	"time"
 )
 
-var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+// slowStartScale is what weights are multiplied by when selecting an
+// endpoint, so that endpoints in slow start can get a fraction of theirs.
+const slowStartScale = 100
 
 type EndpointIterator interface {
 	Next() *Endpoint
 	EndpointFailed()
+	PreRequest(e *Endpoint)
+	PostRequest(e *Endpoint)
+	RecordSuccess(e *Endpoint)
+	RecordFailure(e *Endpoint)
 }
 
 type endpointIterator struct {
-	pool *Pool
+	pool     *Pool
+	selector BackendSelector
+	request  *http.Request
 
 	initialEndpoint string
 	lastEndpoint    *Endpoint
@@ -25,7 +33,27 @@ type endpointElem struct {
 	endpoint *Endpoint
 	index    int
 	updated  time.Time
+	added    time.Time
 	failedAt *time.Time
+
+	unhealthy bool
+	draining  bool
+
+	inFlight int
+
+	consecutiveFailures int
+	circuitOpenedAt     *time.Time
+	probing             bool
+
+	// outcomes of the requests of the current outlier detection interval
+	requests  int
+	failures  int
+	ejectedAt *time.Time
+
+	// requests the endpoint can still take under its MaxRequestsPerSecond,
+	// in this pool only
+	rateBudget     float64
+	rateRefilledAt time.Time
 }
 
 type Pool struct {
@@ -33,11 +61,30 @@ type Pool struct {
 	endpoints []*endpointElem
 	index     map[string]*endpointElem
 
+	uri             Uri
 	contextPath     string
 	routeServiceUrl string
 
 	retryAfterFailure time.Duration
-	nextIdx           int
+
+	// the selectors of Endpoints and LeastConnectionEndpoints, which are
+	// kept per pool as they go by the choices made before
+	roundRobin      BackendSelector
+	leastConnection BackendSelector
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	slowStart time.Duration
+
+	outlier     OutlierDetection
+	windowStart time.Time
+
+	// route groups in registration order, a group has a match, or is the
+	// group of the TLS passthrough endpoints, and no groups of its own
+	groups      []*Pool
+	match       *Match
+	passthrough bool
 }
 
 func NewPool(retryAfterFailure time.Duration, contextPath string) *Pool {
@@ -45,7 +92,8 @@ func NewPool(retryAfterFailure time.Duration, contextPath string) *Pool {
 		endpoints:         make([]*endpointElem, 0, 1),
 		index:             make(map[string]*endpointElem),
 		retryAfterFailure: retryAfterFailure,
-		nextIdx:           -1,
+		roundRobin:        NewRoundRobinSelector(),
+		leastConnection:   NewLeastConnectionSelector(),
 		contextPath:       contextPath,
 	}
 }
@@ -54,13 +102,49 @@ func (p *Pool) ContextPath() string {
 	return p.contextPath
 }
 
+// Uri is the registered route the pool is stored under. It must be set
+// before the pool is shared.
+func (p *Pool) Uri() Uri {
+	return p.uri
+}
+
+func (p *Pool) SetUri(uri Uri) {
+	p.uri = uri
+}
+
+// SetCircuitBreaker makes the pool skip an endpoint for cooldown after
+// threshold consecutive failed requests. A threshold of 0 disables it.
+func (p *Pool) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	p.lock.Lock()
+	p.breakerThreshold = threshold
+	p.breakerCooldown = cooldown
+	p.lock.Unlock()
+}
+
+// SetSlowStart makes endpoints added to the pool ramp up to their weight
+// over the duration. A duration of 0 disables it.
+func (p *Pool) SetSlowStart(duration time.Duration) {
+	p.lock.Lock()
+	p.slowStart = duration
+	p.lock.Unlock()
+}
+
 func (p *Pool) Put(endpoint *Endpoint) bool {
+	return p.put(endpoint, time.Now())
+}
+
+func (p *Pool) put(endpoint *Endpoint, updated time.Time) bool {
+	if p.grouped(endpoint) {
+		return p.group(endpoint).put(endpoint, updated)
+	}
+
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
 	e, found := p.index[endpoint.CanonicalAddr()]
 	if found {
 		if e.endpoint == endpoint {
+			e.updated = updated
 			return false
 		}
 
@@ -75,6 +159,7 @@ func (p *Pool) Put(endpoint *Endpoint) bool {
 		e = &endpointElem{
 			endpoint: endpoint,
 			index:    len(p.endpoints),
+			added:    updated,
 		}
 
 		p.endpoints = append(p.endpoints, e)
@@ -83,11 +168,141 @@ func (p *Pool) Put(endpoint *Endpoint) bool {
 		p.index[endpoint.PrivateInstanceId] = e
 	}
 
-	e.updated = time.Now()
+	e.updated = updated
 
 	return !found
 }
 
+// grouped tells whether the endpoint belongs in a route group of the pool.
+func (p *Pool) grouped(endpoint *Endpoint) bool {
+	return p.match == nil && !p.passthrough && (endpoint.Match != nil || endpoint.TLSPassthrough)
+}
+
+// inGroup tells whether the endpoint belongs in the route group g.
+func (g *Pool) inGroup(endpoint *Endpoint) bool {
+	if g.passthrough || endpoint.TLSPassthrough {
+		return g.passthrough && endpoint.TLSPassthrough
+	}
+	return *g.match == *endpoint.Match
+}
+
+// group returns the route group of the endpoint, creating it after the
+// existing ones when there is none yet.
+func (p *Pool) group(endpoint *Endpoint) *Pool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, g := range p.groups {
+		if g.inGroup(endpoint) {
+			return g
+		}
+	}
+
+	g := NewPool(p.retryAfterFailure, p.contextPath)
+	g.uri = p.uri
+	g.breakerThreshold = p.breakerThreshold
+	g.breakerCooldown = p.breakerCooldown
+	g.slowStart = p.slowStart
+	g.outlier = p.outlier
+	if endpoint.TLSPassthrough {
+		g.passthrough = true
+	} else {
+		match := *endpoint.Match
+		g.match = &match
+	}
+
+	p.groups = append(p.groups, g)
+	return g
+}
+
+// Match returns what the requests of a route group match, or nil for the
+// pool of a route and its TLS passthrough group.
+func (p *Pool) Match() *Match {
+	return p.match
+}
+
+// RouteGroup returns the first route group, in registration order, whose
+// match the request from clientAddr satisfies, or the pool itself when there
+// is none.
+func (p *Pool) RouteGroup(request *http.Request, clientAddr string) *Pool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, g := range p.groups {
+		if g.match != nil && g.match.Matches(request, clientAddr) {
+			return g
+		}
+	}
+	return p
+}
+
+// PassthroughGroup returns the route group of the endpoints registered for
+// TLS passthrough, or nil when there are none.
+func (p *Pool) PassthroughGroup() *Pool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, g := range p.groups {
+		if g.passthrough {
+			return g
+		}
+	}
+	return nil
+}
+
+// PassthroughOnly tells whether all the endpoints of the pool are registered
+// for TLS passthrough, so that the route takes no requests.
+func (p *Pool) PassthroughOnly() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.endpoints) > 0 {
+		return false
+	}
+	for _, g := range p.groups {
+		if !g.passthrough {
+			return false
+		}
+	}
+	return len(p.groups) > 0
+}
+
+// MirrorGroup returns the route group of the endpoints registered as a
+// shadow of the route along with the percentage of requests mirrored to
+// them, or nil when there are none.
+func (p *Pool) MirrorGroup() (*Pool, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, g := range p.groups {
+		if g.match != nil && g.match.MirrorPercent > 0 {
+			return g, g.match.MirrorPercent
+		}
+	}
+	return nil, 0
+}
+
+func (p *Pool) RouteGroups() []*Pool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return append([]*Pool(nil), p.groups...)
+}
+
+// removeEmptyGroups must be called with the lock held
+func (p *Pool) removeEmptyGroups() {
+	groups := p.groups[:0]
+	for _, g := range p.groups {
+		if !g.IsEmpty() {
+			groups = append(groups, g)
+		}
+	}
+	for i := len(groups); i < len(p.groups); i++ {
+		p.groups[i] = nil
+	}
+	p.groups = groups
+}
+
 func (p *Pool) RouteServiceUrl() string {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -100,8 +315,56 @@ func (p *Pool) RouteServiceUrl() string {
 	}
 }
 
-func (p *Pool) PruneEndpoints(defaultThreshold time.Duration) {
+// CORS returns the cross-origin policy of the route, if it has one. The
+// endpoints of a route, including those of its route groups, are expected to
+// register the same policy; while they do not, during a rolling deploy say,
+// the policy of the endpoint added first holds.
+func (p *Pool) CORS() *CORS {
+	first, added := p.first()
+	for _, g := range p.RouteGroups() {
+		e, groupAdded := g.first()
+		if e != nil && (first == nil || groupAdded.Before(added) ||
+			groupAdded.Equal(added) && e.CanonicalAddr() < first.CanonicalAddr()) {
+			first, added = e, groupAdded
+		}
+	}
+
+	if first == nil {
+		return nil
+	}
+	return first.CORS
+}
+
+// first returns the endpoint added to the pool first, and when it was added.
+// Endpoints added at the same time are ordered by address.
+func (p *Pool) first() (*Endpoint, time.Time) {
 	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var first *endpointElem
+	for _, e := range p.endpoints {
+		if first == nil || e.added.Before(first.added) ||
+			e.added.Equal(first.added) && e.endpoint.CanonicalAddr() < first.endpoint.CanonicalAddr() {
+			first = e
+		}
+	}
+
+	if first == nil {
+		return nil, time.Time{}
+	}
+	return first.endpoint, first.added
+}
+
+// PruneEndpoints removes the stale endpoints, and the draining ones without
+// requests in flight, from the pool and its route groups and returns them.
+func (p *Pool) PruneEndpoints(defaultThreshold time.Duration) []*Endpoint {
+	var pruned []*Endpoint
+	for _, g := range p.RouteGroups() {
+		pruned = append(pruned, g.PruneEndpoints(defaultThreshold)...)
+	}
+
+	p.lock.Lock()
+	p.removeEmptyGroups()
 
 	last := len(p.endpoints)
 	now := time.Now()
@@ -114,8 +377,9 @@ func (p *Pool) PruneEndpoints(defaultThreshold time.Duration) {
 			staleTime = now.Add(-e.endpoint.staleThreshold)
 		}
 
-		if e.updated.Before(staleTime) {
+		if e.updated.Before(staleTime) || (e.draining && e.inFlight == 0) {
 			p.removeEndpoint(e)
+			pruned = append(pruned, e.endpoint)
 			last--
 		} else {
 			i++
@@ -123,9 +387,15 @@ func (p *Pool) PruneEndpoints(defaultThreshold time.Duration) {
 	}
 
 	p.lock.Unlock()
+
+	return pruned
 }
 
 func (p *Pool) Remove(endpoint *Endpoint) bool {
+	if p.grouped(endpoint) {
+		return p.removeFromGroup(endpoint)
+	}
+
 	var e *endpointElem
 
 	p.lock.Lock()
@@ -141,6 +411,20 @@ func (p *Pool) Remove(endpoint *Endpoint) bool {
 	return e != nil
 }
 
+func (p *Pool) removeFromGroup(endpoint *Endpoint) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, g := range p.groups {
+		if g.inGroup(endpoint) {
+			removed := g.Remove(endpoint)
+			p.removeEmptyGroups()
+			return removed
+		}
+	}
+	return false
+}
+
 func (p *Pool) removeEndpoint(e *endpointElem) {
 	i := e.index
 	es := p.endpoints
@@ -156,72 +440,201 @@ func (p *Pool) removeEndpoint(e *endpointElem) {
 	delete(p.index, e.endpoint.PrivateInstanceId)
 }
 
+// Endpoints takes turns between the endpoints in proportion to their
+// weights.
 func (p *Pool) Endpoints(initial string) EndpointIterator {
-	return newEndpointIterator(p, initial)
+	return p.SelectorEndpoints(initial, p.roundRobin, nil)
+}
+
+// LeastConnectionEndpoints picks the endpoint with the fewest requests in
+// flight relative to its weight.
+func (p *Pool) LeastConnectionEndpoints(initial string) EndpointIterator {
+	return p.SelectorEndpoints(initial, p.leastConnection, nil)
+}
+
+// SelectorEndpoints leaves the choice of endpoint for the request to the
+// selector.
+func (p *Pool) SelectorEndpoints(initial string, selector BackendSelector, request *http.Request) EndpointIterator {
+	return &endpointIterator{
+		pool:            p,
+		selector:        selector,
+		request:         request,
+		initialEndpoint: initial,
+	}
 }
 
-func (p *Pool) next() *Endpoint {
+// Preview returns the endpoint an iterator created with the same arguments
+// would return first, with a nil selector for Endpoints or
+// LeastConnectionEndpoints, without taking that endpoint's turn or using up
+// the probe request of a circuit breaker. The selector is called as it would
+// be for the request.
+func (p *Pool) Preview(initial string, leastConnection bool, selector BackendSelector, request *http.Request) *Endpoint {
+	if selector == nil {
+		selector = p.roundRobin
+		if leastConnection {
+			selector = p.leastConnection
+		}
+	}
+
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	last := len(p.endpoints)
-	if last == 0 {
-		return nil
+	if initial != "" {
+		if e := p.byId(initial, time.Now()); e != nil {
+			return e.endpoint
+		}
 	}
 
-	if p.nextIdx == -1 {
-		p.nextIdx = random.Intn(last)
-	} else if p.nextIdx >= last {
-		p.nextIdx = 0
-	}
+	return p.pick(selector, request, true)
+}
 
-	startIdx := p.nextIdx
-	curIdx := startIdx
-	for {
-		e := p.endpoints[curIdx]
+func (p *Pool) nextSelected(selector BackendSelector, request *http.Request) *Endpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 
-		curIdx++
-		if curIdx == last {
-			curIdx = 0
+	return p.pick(selector, request, false)
+}
+
+// pick returns the endpoint the selector chooses among the endpoints that
+// can take the request, and takes its turn unless peek is set. It must be
+// called with the lock held.
+func (p *Pool) pick(selector BackendSelector, request *http.Request, peek bool) *Endpoint {
+	candidates, failed := p.candidates(time.Now())
+	if len(candidates) == 0 {
+		if len(failed) == 0 {
+			// only endpoints with zero weight, failing health checks, an
+			// open circuit or no request rate left are registered
+			return nil
 		}
 
-		if e.failedAt != nil {
-			curTime := time.Now()
-			if curTime.Sub(*e.failedAt) > p.retryAfterFailure {
-				// exipired failure window
+		// all endpoints are marked failed so reset everything to available
+		if !peek {
+			for _, e := range p.endpoints {
 				e.failedAt = nil
 			}
 		}
+		candidates = failed
+	}
 
-		if e.failedAt == nil {
-			p.nextIdx = curIdx
-			return e.endpoint
+	var endpoint *Endpoint
+	var ok bool
+	if s, isPeeker := selector.(peeker); peek && isPeeker {
+		endpoint, ok = s.peek(candidates, request)
+	} else {
+		endpoint, ok = selector.Select(candidates, request)
+	}
+	if !ok {
+		return nil
+	}
+
+	if e := p.index[endpoint.CanonicalAddr()]; e != nil && !peek {
+		e.take()
+	}
+	return endpoint
+}
+
+// candidates returns the endpoints that can take requests, leaving out the
+// ones that failed recently, which are returned separately. It must be
+// called with the lock held.
+func (p *Pool) candidates(now time.Time) ([]Candidate, []Candidate) {
+	var candidates, failed []Candidate
+
+	for _, e := range p.endpoints {
+		if !p.available(e, now) {
+			continue
 		}
 
-		if curIdx == startIdx {
-			// all endpoints are marked failed so reset everything to available
-			for _, e2 := range p.endpoints {
-				e2.failedAt = nil
-			}
+		c := Candidate{
+			Endpoint: e.endpoint,
+			Weight:   p.effectiveWeight(e, now),
+			InFlight: e.inFlight,
+		}
+		if p.isFailed(e) {
+			failed = append(failed, c)
+		} else {
+			candidates = append(candidates, c)
 		}
 	}
+
+	return candidates, failed
+}
+
+// effectiveWeight is the scaled weight of the endpoint. During slow start it
+// grows linearly from a hundredth of the weight, right after the endpoint was
+// added, to all of it once the slow start duration has passed.
+func (p *Pool) effectiveWeight(e *endpointElem, now time.Time) int {
+	weight := int(e.endpoint.Weight) * slowStartScale
+
+	age := now.Sub(e.added)
+	if p.slowStart <= 0 || age >= p.slowStart {
+		return weight
+	}
+
+	ramped := int(int64(weight) * int64(age) / int64(p.slowStart))
+	if ramped < int(e.endpoint.Weight) {
+		ramped = int(e.endpoint.Weight)
+	}
+	return ramped
+}
+
+func (p *Pool) isFailed(e *endpointElem) bool {
+	if e.failedAt != nil {
+		curTime := time.Now()
+		if curTime.Sub(*e.failedAt) > p.retryAfterFailure {
+			// exipired failure window
+			e.failedAt = nil
+		}
+	}
+
+	return e.failedAt != nil
+}
+
+// isCircuitOpen reports whether the endpoint's circuit breaker holds back
+// requests. Once the cooldown has passed a single probe request is let
+// through; its outcome closes or reopens the circuit.
+func (p *Pool) isCircuitOpen(e *endpointElem) bool {
+	if e.circuitOpenedAt == nil {
+		return false
+	}
+
+	return e.probing || time.Since(*e.circuitOpenedAt) < p.breakerCooldown
 }
 
 func (p *Pool) findById(id string) *Endpoint {
-	var endpoint *Endpoint
 	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	e := p.byId(id, time.Now())
+	if e == nil {
+		return nil
+	}
+
+	e.take()
+	return e.endpoint
+}
+
+// byId returns the endpoint with the private instance id or address if it
+// can take requests. It must be called with the lock held.
+func (p *Pool) byId(id string, now time.Time) *endpointElem {
 	e := p.index[id]
-	if e != nil {
-		endpoint = e.endpoint
+	if e != nil && p.available(e, now) {
+		return e
 	}
-	p.lock.Unlock()
 
-	return endpoint
+	return nil
+}
+
+// available tells whether the endpoint can take requests: it has a weight, is
+// healthy, not draining and not held back by an open circuit, an ejection or
+// its request rate. It must be called with the lock held.
+func (p *Pool) available(e *endpointElem, now time.Time) bool {
+	return e.endpoint.Weight > 0 && !e.unhealthy && !e.draining &&
+		!p.isCircuitOpen(e) && !p.isEjected(e, now) && !e.overRate(now)
 }
 
 func (p *Pool) IsEmpty() bool {
 	p.lock.Lock()
-	l := len(p.endpoints)
+	l := len(p.endpoints) + len(p.groups)
 	p.lock.Unlock()
 
 	return l == 0
@@ -244,30 +657,169 @@ func (p *Pool) endpointFailed(endpoint *Endpoint) {
 	p.lock.Unlock()
 }
 
-func (p *Pool) Each(f func(endpoint *Endpoint)) {
+func (p *Pool) MarkHealthy(endpoint *Endpoint) {
+	p.setHealthy(endpoint, true)
+}
+
+func (p *Pool) MarkUnhealthy(endpoint *Endpoint) {
+	p.setHealthy(endpoint, false)
+}
+
+func (p *Pool) setHealthy(endpoint *Endpoint, healthy bool) {
 	p.lock.Lock()
-	for _, e := range p.endpoints {
-		f(e.endpoint)
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		e.unhealthy = !healthy
 	}
 	p.lock.Unlock()
 }
 
-func (p *Pool) MarshalJSON() ([]byte, error) {
+// Drain stops sending new requests to the endpoint at addr, in the pool or
+// in one of its route groups, while its requests in flight complete. The
+// endpoint is pruned once it has none left.
+func (p *Pool) Drain(addr string) bool {
+	drained := false
+	for _, g := range p.RouteGroups() {
+		drained = g.Drain(addr) || drained
+	}
+
 	p.lock.Lock()
-	endpoints := make([]Endpoint, 0, len(p.endpoints))
-	for _, e := range p.endpoints {
-		endpoints = append(endpoints, *e.endpoint)
+	e := p.index[addr]
+	if e != nil {
+		e.draining = true
+		drained = true
 	}
 	p.lock.Unlock()
 
-	return json.Marshal(endpoints)
+	return drained
 }
 
-func newEndpointIterator(p *Pool, initial string) EndpointIterator {
-	return &endpointIterator{
-		pool:            p,
-		initialEndpoint: initial,
+func (p *Pool) IsDraining(endpoint *Endpoint) bool {
+	draining := false
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		draining = e.draining
+	}
+	p.lock.Unlock()
+
+	return draining
+}
+
+// IsCircuitOpen tells whether the endpoint's circuit breaker has opened and
+// not closed again since, including while its probe request is in flight.
+func (p *Pool) IsCircuitOpen(endpoint *Endpoint) bool {
+	open := false
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		open = e.circuitOpenedAt != nil
 	}
+	p.lock.Unlock()
+
+	return open
+}
+
+func (p *Pool) IsHealthy(endpoint *Endpoint) bool {
+	healthy := false
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		healthy = !e.unhealthy
+	}
+	p.lock.Unlock()
+
+	return healthy
+}
+
+func (p *Pool) preRequest(endpoint *Endpoint) {
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		e.inFlight++
+	}
+	p.lock.Unlock()
+}
+
+func (p *Pool) postRequest(endpoint *Endpoint) {
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil && e.inFlight > 0 {
+		e.inFlight--
+	}
+	p.lock.Unlock()
+}
+
+func (p *Pool) recordSuccess(endpoint *Endpoint) {
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		e.consecutiveFailures = 0
+		e.circuitOpenedAt = nil
+		e.probing = false
+		p.countOutcome(e, false)
+	}
+	p.lock.Unlock()
+}
+
+func (p *Pool) recordFailure(endpoint *Endpoint) {
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		p.countOutcome(e, true)
+	}
+	if e != nil && p.breakerThreshold > 0 {
+		e.consecutiveFailures++
+		if e.probing || e.consecutiveFailures >= p.breakerThreshold {
+			t := time.Now()
+			e.circuitOpenedAt = &t
+			e.probing = false
+		}
+	}
+	p.lock.Unlock()
+}
+
+func (p *Pool) InFlight(endpoint *Endpoint) int {
+	var n int
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		n = e.inFlight
+	}
+	p.lock.Unlock()
+
+	return n
+}
+
+// Has tells whether an endpoint at addr is in the pool.
+func (p *Pool) Has(addr string) bool {
+	p.lock.Lock()
+	_, ok := p.index[addr]
+	p.lock.Unlock()
+
+	return ok
+}
+
+func (p *Pool) Each(f func(endpoint *Endpoint)) {
+	p.lock.Lock()
+	for _, e := range p.endpoints {
+		f(e.endpoint)
+	}
+	p.lock.Unlock()
+}
+
+func (p *Pool) MarshalJSON() ([]byte, error) {
+	endpoints := make([]Endpoint, 0)
+	p.Each(func(e *Endpoint) {
+		endpoints = append(endpoints, *e)
+	})
+	for _, g := range p.RouteGroups() {
+		g.Each(func(e *Endpoint) {
+			endpoints = append(endpoints, *e)
+		})
+	}
+
+	return json.Marshal(endpoints)
 }
 
 func (i *endpointIterator) Next() *Endpoint {
@@ -278,7 +830,7 @@ func (i *endpointIterator) Next() *Endpoint {
 	}
 
 	if e == nil {
-		e = i.pool.next()
+		e = i.pool.nextSelected(i.selector, i.request)
 	}
 
 	i.lastEndpoint = e
@@ -292,7 +844,37 @@ func (i *endpointIterator) EndpointFailed() {
 	}
 }
 
+func (i *endpointIterator) PreRequest(e *Endpoint) {
+	i.pool.preRequest(e)
+}
+
+func (i *endpointIterator) PostRequest(e *Endpoint) {
+	i.pool.postRequest(e)
+}
+
+func (i *endpointIterator) RecordSuccess(e *Endpoint) {
+	i.pool.recordSuccess(e)
+}
+
+func (i *endpointIterator) RecordFailure(e *Endpoint) {
+	i.pool.recordFailure(e)
+}
+
 func (e *endpointElem) failed() {
 	t := time.Now()
 	e.failedAt = &t
 }
+
+// take charges the endpoint for a request chosen to be sent to it.
+func (e *endpointElem) take() {
+	e.startProbe()
+	e.takeRequest()
+}
+
+// startProbe marks a half-open endpoint as having its probe request in
+// flight, so that no other request is sent until the probe completes.
+func (e *endpointElem) startProbe() {
+	if e.circuitOpenedAt != nil {
+		e.probing = true
+	}
+}
@@ -2,6 +2,7 @@ package route_test
 
 import (
 	"fmt"
+	"net/http"
 	"time"
 
 	. "github.com/cloudfoundry/gorouter/route"
@@ -39,6 +40,17 @@ var _ = Describe("Pool", func() {
 			pool.Put(endpoint1)
 			Expect(pool.Put(endpoint2)).To(BeFalse())
 		})
+
+		It("refreshes the update time of duplicate endpoints", func() {
+			endpoint := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+
+			pool.Put(endpoint)
+			pool.MarkUpdated(time.Now().Add(-2 * time.Minute))
+			pool.Put(endpoint)
+
+			pool.PruneEndpoints(time.Minute)
+			Expect(pool.IsEmpty()).To(BeFalse())
+		})
 	})
 
 	Context("RouteServiceUrl", func() {
@@ -199,6 +211,70 @@ var _ = Describe("Pool", func() {
 		})
 	})
 
+	Context("Drain", func() {
+		var e1, e2 *Endpoint
+
+		BeforeEach(func() {
+			e1 = NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			e2 = NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			pool.Put(e1)
+			pool.Put(e2)
+		})
+
+		It("stops selecting the endpoint", func() {
+			Expect(pool.Drain(e1.CanonicalAddr())).To(BeTrue())
+			Expect(pool.IsDraining(e1)).To(BeTrue())
+
+			iter := pool.Endpoints("")
+			for i := 0; i < 10; i++ {
+				Expect(iter.Next()).To(Equal(e2))
+			}
+			Expect(pool.LeastConnectionEndpoints("").Next()).To(Equal(e2))
+			Expect(pool.SelectorEndpoints("", NewRandomSelector(), nil).Next()).To(Equal(e2))
+		})
+
+		It("ignores a sticky session for the endpoint", func() {
+			e1.PrivateInstanceId = "id1"
+			pool.Put(e1)
+			pool.Drain(e1.CanonicalAddr())
+
+			Expect(pool.Endpoints("id1").Next()).To(Equal(e2))
+		})
+
+		It("prunes the endpoint once it has no requests in flight", func() {
+			iter := pool.Endpoints("")
+			iter.PreRequest(e1)
+			pool.Drain(e1.CanonicalAddr())
+
+			pool.PruneEndpoints(time.Minute)
+			Expect(pool.InFlight(e1)).To(Equal(1))
+			Expect(pool.IsDraining(e1)).To(BeTrue())
+
+			iter.PostRequest(e1)
+			pool.PruneEndpoints(time.Minute)
+			Expect(pool.IsDraining(e1)).To(BeFalse())
+
+			var addrs []string
+			pool.Each(func(e *Endpoint) {
+				addrs = append(addrs, e.CanonicalAddr())
+			})
+			Expect(addrs).To(Equal([]string{e2.CanonicalAddr()}))
+		})
+
+		It("drains an endpoint of a route group", func() {
+			canary := NewEndpoint("", "5.6.7.8", 4321, "", nil, -1, "")
+			canary.Match = &Match{Header: "X-Canary", Value: "true"}
+			pool.Put(canary)
+
+			Expect(pool.Drain(canary.CanonicalAddr())).To(BeTrue())
+			Expect(pool.RouteGroups()[0].Endpoints("").Next()).To(BeNil())
+		})
+
+		It("reports unknown endpoints", func() {
+			Expect(pool.Drain("9.9.9.9:80")).To(BeFalse())
+		})
+	})
+
 	Context("Each", func() {
 		It("applies a function to each endpoint", func() {
 			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
@@ -216,6 +292,178 @@ var _ = Describe("Pool", func() {
 		})
 	})
 
+	Context("CORS", func() {
+		restore := func(address string, cors *CORS, match *Match, added time.Time) {
+			pool.Restore(EndpointSnapshot{
+				Address: address,
+				Weight:  1,
+				CORS:    cors,
+				Match:   match,
+				Updated: added,
+			})
+		}
+
+		It("is the policy of the endpoint added first", func() {
+			now := time.Now()
+			restore("10.0.0.2:8080", &CORS{AllowedOrigins: []string{"https://new.example.com"}}, nil, now)
+			restore("10.0.0.1:8080", &CORS{AllowedOrigins: []string{"https://old.example.com"}}, nil, now.Add(-time.Minute))
+			restore("10.0.0.3:8080", nil, nil, now)
+
+			for i := 0; i < 10; i++ {
+				Expect(pool.CORS().AllowedOrigins).To(Equal([]string{"https://old.example.com"}))
+			}
+		})
+
+		It("is taken from route groups as well", func() {
+			restore("10.0.0.1:8080", &CORS{AllowedOrigins: []string{"https://app.example.com"}}, &Match{Method: "POST"}, time.Now())
+
+			Expect(pool.CORS()).NotTo(BeNil())
+			Expect(pool.CORS().AllowedOrigins).To(Equal([]string{"https://app.example.com"}))
+		})
+
+		It("is nil without endpoints", func() {
+			Expect(pool.CORS()).To(BeNil())
+		})
+	})
+
+	Context("RouteGroup", func() {
+		var canary, posts *Endpoint
+
+		newRequest := func(method string, header http.Header) *http.Request {
+			req, err := http.NewRequest(method, "http://example.com/", nil)
+			Expect(err).NotTo(HaveOccurred())
+			for name, values := range header {
+				req.Header[name] = values
+			}
+			return req
+		}
+
+		BeforeEach(func() {
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, ""))
+
+			canary = NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			canary.Match = &Match{Header: "X-Canary", Value: "true"}
+			pool.Put(canary)
+
+			posts = NewEndpoint("", "5.6.7.8", 4321, "", nil, -1, "")
+			posts.Match = &Match{Method: "POST"}
+			pool.Put(posts)
+		})
+
+		It("picks the first group matching the request", func() {
+			group := pool.RouteGroup(newRequest("POST", http.Header{"X-Canary": {"true"}}), "1.1.1.1:1234")
+			Expect(group).NotTo(Equal(pool))
+			Expect(group.Endpoints("").Next()).To(Equal(canary))
+
+			group = pool.RouteGroup(newRequest("POST", nil), "1.1.1.1:1234")
+			Expect(group.Endpoints("").Next()).To(Equal(posts))
+		})
+
+		It("falls back to the pool for requests no group matches", func() {
+			Expect(pool.RouteGroup(newRequest("GET", http.Header{"X-Canary": {"false"}}), "1.1.1.1:1234")).To(Equal(pool))
+		})
+
+		It("keeps grouped endpoints out of the default pool", func() {
+			var addrs []string
+			pool.Each(func(e *Endpoint) {
+				addrs = append(addrs, e.CanonicalAddr())
+			})
+			Expect(addrs).To(Equal([]string{"1.2.3.4:5678"}))
+			Expect(pool.RouteGroups()).To(HaveLen(2))
+		})
+
+		It("drops a group once its last endpoint is removed", func() {
+			Expect(pool.Remove(canary)).To(BeTrue())
+
+			Expect(pool.RouteGroups()).To(HaveLen(1))
+			Expect(pool.RouteGroup(newRequest("GET", http.Header{"X-Canary": {"true"}}), "1.1.1.1:1234")).To(Equal(pool))
+		})
+
+		It("keeps requests away from the TLS passthrough group", func() {
+			Expect(pool.PassthroughGroup()).To(BeNil())
+
+			passthrough := NewEndpoint("", "5.6.7.8", 8443, "", nil, -1, "")
+			passthrough.TLSPassthrough = true
+			passthrough.Match = &Match{Header: "X-Canary"}
+			pool.Put(passthrough)
+
+			Expect(pool.PassthroughGroup().Endpoints("").Next()).To(Equal(passthrough))
+			Expect(pool.RouteGroup(newRequest("GET", nil), "1.1.1.1:1234")).To(Equal(pool))
+			Expect(pool.RouteGroup(newRequest("GET", http.Header{"X-Canary": {"true"}}), "1.1.1.1:1234").Endpoints("").Next()).To(Equal(canary))
+
+			Expect(pool.Remove(passthrough)).To(BeTrue())
+			Expect(pool.PassthroughGroup()).To(BeNil())
+		})
+
+		It("tells a route with only TLS passthrough endpoints apart", func() {
+			passthroughOnly := NewPool(2*time.Minute, "")
+			Expect(passthroughOnly.PassthroughOnly()).To(BeFalse())
+
+			passthrough := NewEndpoint("", "5.6.7.8", 8443, "", nil, -1, "")
+			passthrough.TLSPassthrough = true
+			passthroughOnly.Put(passthrough)
+			Expect(passthroughOnly.PassthroughOnly()).To(BeTrue())
+
+			passthroughOnly.Put(NewEndpoint("", "1.2.3.4", 8080, "", nil, -1, ""))
+			Expect(passthroughOnly.PassthroughOnly()).To(BeFalse())
+
+			Expect(pool.PassthroughOnly()).To(BeFalse())
+		})
+
+		It("keeps requests away from the mirror group", func() {
+			group, percent := pool.MirrorGroup()
+			Expect(group).To(BeNil())
+			Expect(percent).To(BeZero())
+
+			shadow := NewEndpoint("", "5.6.7.8", 8080, "", nil, -1, "")
+			shadow.Match = &Match{MirrorPercent: 10}
+			pool.Put(shadow)
+
+			group, percent = pool.MirrorGroup()
+			Expect(group.Endpoints("").Next()).To(Equal(shadow))
+			Expect(percent).To(Equal(10))
+			Expect(pool.RouteGroup(newRequest("GET", nil), "1.1.1.1:1234")).To(Equal(pool))
+		})
+
+		It("prunes the endpoints of groups", func() {
+			pool.PruneEndpoints(0)
+
+			Expect(pool.RouteGroups()).To(BeEmpty())
+			Expect(pool.IsEmpty()).To(BeTrue())
+		})
+
+		It("splits clients between a canary group and the pool by percentage", func() {
+			pool = NewPool(2*time.Minute, "")
+			primary := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "")
+			pool.Put(primary)
+
+			canary = NewEndpoint("", "5.6.7.8", 1234, "", nil, -1, "")
+			canary.Match = &Match{CanaryPercent: 50}
+			pool.Put(canary)
+
+			onCanary := 0
+			for i := 0; i < 1000; i++ {
+				client := fmt.Sprintf("10.0.%d.%d:%d", i/256, i%256, 40000+i)
+				group := pool.RouteGroup(newRequest("GET", nil), client)
+				if group.Endpoints("").Next() == canary {
+					onCanary++
+				}
+
+				// another connection from the same client lands on the same side
+				again := fmt.Sprintf("10.0.%d.%d:%d", i/256, i%256, 50000+i)
+				Expect(pool.RouteGroup(newRequest("GET", nil), again)).To(Equal(group))
+			}
+
+			Expect(onCanary).To(BeNumerically("~", 500, 75))
+		})
+
+		It("is not empty while a group has endpoints", func() {
+			pool.Remove(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, ""))
+
+			Expect(pool.IsEmpty()).To(BeFalse())
+		})
+	})
+
 	It("marshals json", func() {
 		e := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1, "https://my-rs.com")
 		e2 := NewEndpoint("", "5.6.7.8", 5678, "", nil, -1, "")
@@ -0,0 +1,36 @@
+package route
+
+import (
+	"time"
+)
+
+// overRate tells whether the endpoint has used up its MaxRequestsPerSecond.
+// Its budget refills continuously at that rate and holds at most a second's
+// worth of requests, so that an idle endpoint can take a short burst. The
+// budget is kept per pool, so a backend registered under several routes
+// takes up to its rate from each of them.
+func (e *endpointElem) overRate(now time.Time) bool {
+	limit := float64(e.endpoint.MaxRequestsPerSecond)
+	if limit <= 0 {
+		return false
+	}
+
+	if e.rateRefilledAt.IsZero() {
+		e.rateBudget = limit
+	} else {
+		e.rateBudget += now.Sub(e.rateRefilledAt).Seconds() * limit
+		if e.rateBudget > limit {
+			e.rateBudget = limit
+		}
+	}
+	e.rateRefilledAt = now
+
+	return e.rateBudget < 1
+}
+
+// takeRequest charges the endpoint's budget for a request sent to it.
+func (e *endpointElem) takeRequest() {
+	if e.endpoint.MaxRequestsPerSecond > 0 {
+		e.rateBudget--
+	}
+}
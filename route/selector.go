@@ -0,0 +1,327 @@
+package route
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Candidate is an endpoint a BackendSelector can pick, with what the pool
+// knows about it.
+type Candidate struct {
+	Endpoint *Endpoint
+
+	// Weight is the endpoint's weight, scaled up so that it can be cut to a
+	// fraction during slow start. Only the ratios between weights matter.
+	Weight int
+
+	// InFlight is the number of requests in flight to the endpoint.
+	InFlight int
+}
+
+// BackendSelector picks the endpoint a request is sent to. It is given the
+// endpoints of a pool that can take requests, that is with a weight, healthy,
+// not draining and not held back by a failure or an open circuit, and is
+// called with the pool locked, so it must not call back into the pool. The
+// same selector may be given the endpoints of many pools.
+type BackendSelector interface {
+	Select(candidates []Candidate, request *http.Request) (*Endpoint, bool)
+}
+
+// peeker is a BackendSelector whose choice depends on the ones it made
+// before. peek returns the endpoint Select would, without making the choice.
+type peeker interface {
+	peek(candidates []Candidate, request *http.Request) (*Endpoint, bool)
+}
+
+// runningTotalsKept is the number of choices a round-robin selector keeps the
+// running total of an endpoint it was not given for, so that it forgets
+// endpoints that went away.
+const runningTotalsKept = 1 << 16
+
+type roundRobinSelector struct {
+	lock    sync.Mutex
+	totals  map[*Endpoint]*runningTotal
+	choices uint64
+}
+
+type runningTotal struct {
+	value   int
+	givenAt uint64
+}
+
+// NewRoundRobinSelector returns a selector that takes turns between the
+// endpoints in proportion to their weights, spreading the turns of every
+// endpoint evenly over the others'.
+func NewRoundRobinSelector() BackendSelector {
+	return &roundRobinSelector{
+		totals: make(map[*Endpoint]*runningTotal),
+	}
+}
+
+func (s *roundRobinSelector) Select(candidates []Candidate, request *http.Request) (*Endpoint, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.choices++
+
+	// smooth weighted round-robin: every candidate gains its weight, the one
+	// with the highest running total is chosen and pays back the sum of all
+	// weights
+	var best *runningTotal
+	var endpoint *Endpoint
+	totalWeight := 0
+	for _, c := range candidates {
+		t := s.totals[c.Endpoint]
+		if t == nil {
+			t = &runningTotal{}
+			s.totals[c.Endpoint] = t
+		}
+		t.value += c.Weight
+		t.givenAt = s.choices
+		totalWeight += c.Weight
+
+		if best == nil || t.value > best.value {
+			best, endpoint = t, c.Endpoint
+		}
+	}
+
+	if s.choices%runningTotalsKept == 0 {
+		for e, t := range s.totals {
+			if s.choices-t.givenAt >= runningTotalsKept {
+				delete(s.totals, e)
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, false
+	}
+
+	best.value -= totalWeight
+	return endpoint, true
+}
+
+func (s *roundRobinSelector) peek(candidates []Candidate, request *http.Request) (*Endpoint, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var endpoint *Endpoint
+	bestValue := 0
+	for _, c := range candidates {
+		value := c.Weight
+		if t := s.totals[c.Endpoint]; t != nil {
+			value += t.value
+		}
+
+		if endpoint == nil || value > bestValue {
+			endpoint, bestValue = c.Endpoint, value
+		}
+	}
+
+	return endpoint, endpoint != nil
+}
+
+type leastConnectionSelector struct {
+	next uint64
+}
+
+// NewLeastConnectionSelector returns a selector that picks the endpoint with
+// the fewest requests in flight relative to its weight, taking turns between
+// the endpoints that tie.
+func NewLeastConnectionSelector() BackendSelector {
+	return &leastConnectionSelector{}
+}
+
+func (s *leastConnectionSelector) Select(candidates []Candidate, request *http.Request) (*Endpoint, bool) {
+	i, ok := s.least(candidates)
+	if !ok {
+		return nil, false
+	}
+
+	atomic.StoreUint64(&s.next, uint64(i+1))
+	return candidates[i].Endpoint, true
+}
+
+func (s *leastConnectionSelector) peek(candidates []Candidate, request *http.Request) (*Endpoint, bool) {
+	i, ok := s.least(candidates)
+	if !ok {
+		return nil, false
+	}
+
+	return candidates[i].Endpoint, true
+}
+
+func (s *leastConnectionSelector) least(candidates []Candidate) (int, bool) {
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	// ties are broken by starting after the endpoint chosen last
+	start := int(atomic.LoadUint64(&s.next) % uint64(len(candidates)))
+	best := start
+	for n := 1; n < len(candidates); n++ {
+		i := (start + n) % len(candidates)
+		if candidates[i].InFlight*candidates[best].Weight < candidates[best].InFlight*candidates[i].Weight {
+			best = i
+		}
+	}
+
+	return best, true
+}
+
+type randomSelector struct{}
+
+// NewRandomSelector returns a selector that picks an endpoint at random, with
+// a chance in proportion to its weight.
+func NewRandomSelector() BackendSelector {
+	return randomSelector{}
+}
+
+func (randomSelector) Select(candidates []Candidate, request *http.Request) (*Endpoint, bool) {
+	i, ok := pickWeighted(candidates, -1)
+	if !ok {
+		return nil, false
+	}
+
+	return candidates[i].Endpoint, true
+}
+
+// pickWeighted returns the index of a candidate other than skip drawn at
+// random, with a chance in proportion to its weight.
+func pickWeighted(candidates []Candidate, skip int) (int, bool) {
+	totalWeight := 0
+	for i, c := range candidates {
+		if i != skip {
+			totalWeight += c.Weight
+		}
+	}
+	if totalWeight <= 0 {
+		return 0, false
+	}
+
+	// the functions of math/rand are safe for concurrent use, unlike a
+	// rand.Rand, and the same selector picks for many pools at once
+	n := rand.Intn(totalWeight)
+	for i, c := range candidates {
+		if i == skip {
+			continue
+		}
+		if n < c.Weight {
+			return i, true
+		}
+		n -= c.Weight
+	}
+
+	return 0, false
+}
+
+type twoChoicesSelector struct{}
+
+// NewTwoChoicesSelector returns a selector that applies the power of two
+// choices: it samples two endpoints at random, by weight, and picks the one
+// with fewer requests in flight relative to its weight. This comes close to
+// least connections without comparing all endpoints, and avoids sending a
+// burst of requests to the same least loaded endpoint.
+func NewTwoChoicesSelector() BackendSelector {
+	return twoChoicesSelector{}
+}
+
+func (twoChoicesSelector) Select(candidates []Candidate, request *http.Request) (*Endpoint, bool) {
+	i, ok := pickWeighted(candidates, -1)
+	if !ok {
+		return nil, false
+	}
+
+	j, ok := pickWeighted(candidates, i)
+	if !ok {
+		return candidates[i].Endpoint, true
+	}
+
+	a, b := candidates[i], candidates[j]
+	if b.InFlight*a.Weight < a.InFlight*b.Weight {
+		return b.Endpoint, true
+	}
+	return a.Endpoint, true
+}
+
+type headerHashSelector struct {
+	header   string
+	fallback *roundRobinSelector
+}
+
+// NewHeaderHashSelector returns a selector that sends all requests with the
+// same value of the header to the same endpoint, as long as it can take
+// requests, and takes turns between the endpoints for requests without it.
+//
+// Endpoints are chosen by rendezvous hashing: every endpoint gets a score for
+// the value, drawn from the hash of both and scaled by its weight, and the
+// highest score wins. Unlike a hash ring this needs no state per pool, and
+// it moves values just as little when endpoints change: an endpoint that is
+// added only takes over values from the others, and only the values of an
+// endpoint that is removed move elsewhere.
+func NewHeaderHashSelector(header string) BackendSelector {
+	return &headerHashSelector{
+		header:   header,
+		fallback: NewRoundRobinSelector().(*roundRobinSelector),
+	}
+}
+
+func (s *headerHashSelector) Select(candidates []Candidate, request *http.Request) (*Endpoint, bool) {
+	if value := s.value(request); value != "" {
+		return highestScore(value, candidates)
+	}
+	return s.fallback.Select(candidates, request)
+}
+
+func (s *headerHashSelector) peek(candidates []Candidate, request *http.Request) (*Endpoint, bool) {
+	if value := s.value(request); value != "" {
+		return highestScore(value, candidates)
+	}
+	return s.fallback.peek(candidates, request)
+}
+
+func (s *headerHashSelector) value(request *http.Request) string {
+	if request == nil {
+		return ""
+	}
+	return request.Header.Get(s.header)
+}
+
+func highestScore(value string, candidates []Candidate) (*Endpoint, bool) {
+	var best *Endpoint
+	bestScore := math.Inf(-1)
+	for _, c := range candidates {
+		if score := hashScore(value, c); score > bestScore {
+			best, bestScore = c.Endpoint, score
+		}
+	}
+
+	return best, best != nil
+}
+
+func hashScore(value string, c Candidate) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Endpoint.CanonicalAddr()))
+
+	// a uniform number in (0, 1), turned into a score that an endpoint with
+	// twice the weight wins twice as often
+	u := (float64(mix(h.Sum64())>>11) + 0.5) / (1 << 53)
+	return float64(c.Weight) / -math.Log(u)
+}
+
+// mix spreads the bits of an FNV hash, whose high bits barely change between
+// values that only differ at the end, over the whole number
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,96 @@
+package route
+
+import (
+	"time"
+)
+
+// EndpointSnapshot is the state of an endpoint of a pool, as saved across
+// restarts of the router.
+type EndpointSnapshot struct {
+	ApplicationId        string            `json:"app"`
+	Address              string            `json:"address"`
+	Tags                 map[string]string `json:"tags,omitempty"`
+	PrivateInstanceId    string            `json:"private_instance_id"`
+	StaleThreshold       time.Duration     `json:"stale_threshold"`
+	RouteServiceUrl      string            `json:"route_service_url,omitempty"`
+	Weight               uint16            `json:"weight"`
+	TLS                  bool              `json:"tls,omitempty"`
+	ServerName           string            `json:"server_name,omitempty"`
+	HostHeader           string            `json:"host_header,omitempty"`
+	PathRewrite          *PathRewrite      `json:"path_rewrite,omitempty"`
+	Timeout              time.Duration     `json:"timeout,omitempty"`
+	CORS                 *CORS             `json:"cors,omitempty"`
+	ResponseHeaders      map[string]string `json:"response_headers,omitempty"`
+	RewriteLocation      bool              `json:"rewrite_location,omitempty"`
+	MaxRequestsPerSecond int               `json:"max_requests_per_second,omitempty"`
+	Match                *Match            `json:"match,omitempty"`
+	TLSPassthrough       bool              `json:"tls_passthrough,omitempty"`
+	Updated              time.Time         `json:"updated"`
+}
+
+// Snapshot returns the endpoints of the pool and of its route groups along
+// with the time they were last registered.
+func (p *Pool) Snapshot() []EndpointSnapshot {
+	var snapshots []EndpointSnapshot
+
+	p.lock.Lock()
+	for _, e := range p.endpoints {
+		snapshots = append(snapshots, EndpointSnapshot{
+			ApplicationId:        e.endpoint.ApplicationId,
+			Address:              e.endpoint.addr,
+			Tags:                 e.endpoint.Tags,
+			PrivateInstanceId:    e.endpoint.PrivateInstanceId,
+			StaleThreshold:       e.endpoint.staleThreshold,
+			RouteServiceUrl:      e.endpoint.RouteServiceUrl,
+			Weight:               e.endpoint.Weight,
+			TLS:                  e.endpoint.TLS,
+			ServerName:           e.endpoint.ServerName,
+			HostHeader:           e.endpoint.HostHeader,
+			PathRewrite:          e.endpoint.PathRewrite,
+			Timeout:              e.endpoint.Timeout,
+			CORS:                 e.endpoint.CORS,
+			ResponseHeaders:      e.endpoint.ResponseHeaders,
+			RewriteLocation:      e.endpoint.RewriteLocation,
+			MaxRequestsPerSecond: e.endpoint.MaxRequestsPerSecond,
+			Match:                e.endpoint.Match,
+			TLSPassthrough:       e.endpoint.TLSPassthrough,
+			Updated:              e.updated,
+		})
+	}
+	groups := append([]*Pool(nil), p.groups...)
+	p.lock.Unlock()
+
+	for _, g := range groups {
+		snapshots = append(snapshots, g.Snapshot()...)
+	}
+
+	return snapshots
+}
+
+// Restore puts the endpoint of the snapshot back in the pool as if it had
+// last been registered at the time of the snapshot. An endpoint new to the
+// pool also counts as added then, so it does not slow start all over again.
+func (p *Pool) Restore(s EndpointSnapshot) bool {
+	endpoint := &Endpoint{
+		ApplicationId:        s.ApplicationId,
+		addr:                 s.Address,
+		Tags:                 s.Tags,
+		PrivateInstanceId:    s.PrivateInstanceId,
+		staleThreshold:       s.StaleThreshold,
+		RouteServiceUrl:      s.RouteServiceUrl,
+		Weight:               s.Weight,
+		TLS:                  s.TLS,
+		ServerName:           s.ServerName,
+		HostHeader:           s.HostHeader,
+		PathRewrite:          s.PathRewrite,
+		Timeout:              s.Timeout,
+		CORS:                 s.CORS,
+		ResponseHeaders:      s.ResponseHeaders,
+		RewriteLocation:      s.RewriteLocation,
+		MaxRequestsPerSecond: s.MaxRequestsPerSecond,
+		Match:                s.Match,
+		TLSPassthrough:       s.TLSPassthrough,
+	}
+
+	return p.put(endpoint, s.Updated)
+}
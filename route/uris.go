@@ -14,7 +14,13 @@ func (u Uri) ToLower() Uri {
 func (u Uri) NextWildcard() (Uri, error) {
 	uri := strings.TrimPrefix(u.String(), "*.")
 
-	i := strings.Index(uri, ".")
+	// only labels of the host can be replaced, dots in the path do not count
+	host := uri
+	if i := strings.Index(uri, "/"); i >= 0 {
+		host = uri[:i]
+	}
+
+	i := strings.Index(host, ".")
 	if i == -1 {
 		return u, errors.New("no next wildcard available")
 	}
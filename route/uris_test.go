@@ -73,4 +73,29 @@ var _ = Describe("URIs", func() {
 		})
 
 	})
+
+	Context("NextWildcard", func() {
+
+		It("replaces the leftmost label with a wildcard", func() {
+			next, err := Uri("foo.bar.example.com").NextWildcard()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(next).To(Equal(Uri("*.bar.example.com")))
+
+			next, err = next.NextWildcard()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(next).To(Equal(Uri("*.example.com")))
+		})
+
+		It("keeps the context path", func() {
+			next, err := Uri("foo.example.com/v1.2").NextWildcard()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(next).To(Equal(Uri("*.example.com/v1.2")))
+		})
+
+		It("fails when the host has a single label", func() {
+			_, err := Uri("localhost/file.txt").NextWildcard()
+			Expect(err).To(HaveOccurred())
+		})
+
+	})
 })
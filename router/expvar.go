@@ -0,0 +1,45 @@
+package router
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+)
+
+// serveExpvar serves the variables published with expvar, as
+// expvar.Handler does, along with the key counters of this router as
+// "gorouter". They are not published with expvar, since its names are global
+// to the process and a process may run more than one router.
+func (r *Router) serveExpvar(w http.ResponseWriter, req *http.Request) {
+	vars := make(map[string]json.RawMessage)
+	expvar.Do(func(kv expvar.KeyValue) {
+		vars[kv.Key] = json.RawMessage(kv.Value.String())
+	})
+
+	counters, err := json.Marshal(r.expvarCounters())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	vars["gorouter"] = counters
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(vars)
+}
+
+// expvarCounters mirrors the request, 5xx and backend counts of varz and adds
+// the number of client connections with a request in progress.
+func (r *Router) expvarCounters() map[string]interface{} {
+	counters := r.varz.Counters()
+
+	r.connLock.Lock()
+	activeConnections := len(r.activeConns)
+	r.connLock.Unlock()
+
+	return map[string]interface{}{
+		"requests":           counters.Requests,
+		"responses_5xx":      counters.Responses5xx,
+		"droplets":           r.registry.NumEndpoints(),
+		"active_connections": activeConnections,
+	}
+}
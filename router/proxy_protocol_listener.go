@@ -0,0 +1,161 @@
+package router
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a v1 header is at most 107 bytes long, CRLF included
+const maxProxyProtocolHeaderLength = 107
+
+var invalidProxyProtocolHeader = errors.New("invalid PROXY protocol header")
+
+// proxyProtocolListener hands out connections that start with a PROXY
+// protocol v1 header, as sent by load balancers in front of the router. The
+// source address in the header becomes the remote address of the connection.
+type proxyProtocolListener struct {
+	net.Listener
+	headerTimeout time.Duration
+}
+
+func newProxyProtocolListener(listener net.Listener, headerTimeout time.Duration) net.Listener {
+	return &proxyProtocolListener{
+		Listener:      listener,
+		headerTimeout: headerTimeout,
+	}
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyProtocolConn{
+		Conn:          conn,
+		reader:        bufio.NewReader(conn),
+		headerTimeout: l.headerTimeout,
+	}, nil
+}
+
+// proxyProtocolConn reads the header on the first Read or RemoteAddr, in the
+// goroutine serving the connection, so a slow client does not hold up Accept.
+type proxyProtocolConn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+
+	// readDeadline is the read deadline set by the user of the connection,
+	// put back once the header is read
+	deadlineLock sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *proxyProtocolConn) SetDeadline(t time.Time) error {
+	c.deadlineLock.Lock()
+	defer c.deadlineLock.Unlock()
+
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyProtocolConn) SetReadDeadline(t time.Time) error {
+	c.deadlineLock.Lock()
+	defer c.deadlineLock.Unlock()
+
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	if c.headerTimeout > 0 {
+		c.deadlineLock.Lock()
+		deadline := time.Now().Add(c.headerTimeout)
+		if c.readDeadline.IsZero() || deadline.Before(c.readDeadline) {
+			c.Conn.SetReadDeadline(deadline)
+			defer c.restoreReadDeadline()
+		}
+		c.deadlineLock.Unlock()
+	}
+
+	var line []byte
+	for !strings.HasSuffix(string(line), "\r\n") {
+		if len(line) == maxProxyProtocolHeaderLength {
+			c.err = invalidProxyProtocolHeader
+			return
+		}
+
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			c.err = err
+			return
+		}
+		line = append(line, b)
+	}
+
+	c.remoteAddr, c.err = parseProxyProtocolHeader(strings.TrimSuffix(string(line), "\r\n"))
+}
+
+// restoreReadDeadline puts back the read deadline the user of the connection
+// set, which the header timeout may have replaced.
+func (c *proxyProtocolConn) restoreReadDeadline() {
+	c.deadlineLock.Lock()
+	defer c.deadlineLock.Unlock()
+
+	c.Conn.SetReadDeadline(c.readDeadline)
+}
+
+func parseProxyProtocolHeader(header string) (net.Addr, error) {
+	fields := strings.Split(header, " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, invalidProxyProtocolHeader
+	}
+
+	// the load balancer could not tell the source, the peer is used instead
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if (fields[1] != "TCP4" && fields[1] != "TCP6") || len(fields) != 6 {
+		return nil, invalidProxyProtocolHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, invalidProxyProtocolHeader
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, invalidProxyProtocolHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -2,23 +2,54 @@ package router
 
 import (
 	"strings"
+	"time"
 
 	"github.com/cloudfoundry/gorouter/route"
 )
 
 type RegistryMessage struct {
-	Host                    string            `json:"host"`
-	Port                    uint16            `json:"port"`
-	Uris                    []route.Uri       `json:"uris"`
-	Tags                    map[string]string `json:"tags"`
-	App                     string            `json:"app"`
-	StaleThresholdInSeconds int               `json:"stale_threshold_in_seconds"`
-	RouteServiceUrl         string            `json:"route_service_url"`
-	PrivateInstanceId       string            `json:"private_instance_id"`
+	Host                     string             `json:"host"`
+	Port                     uint16             `json:"port"`
+	Uris                     []route.Uri        `json:"uris"`
+	Tags                     map[string]string  `json:"tags"`
+	App                      string             `json:"app"`
+	StaleThresholdInSeconds  int                `json:"stale_threshold_in_seconds"`
+	RouteServiceUrl          string             `json:"route_service_url"`
+	PrivateInstanceId        string             `json:"private_instance_id"`
+	Weight                   *uint16            `json:"weight"`
+	TLS                      bool               `json:"tls"`
+	ServerName               string             `json:"server_name"`
+	Match                    *route.Match       `json:"match"`
+	HostHeader               string             `json:"host_header"`
+	PathRewrite              *route.PathRewrite `json:"path_rewrite"`
+	EndpointTimeoutInSeconds int                `json:"endpoint_timeout"`
+	CORS                     *route.CORS        `json:"cors"`
+	TLSPassthrough           bool               `json:"tls_passthrough"`
+	ResponseHeaders          map[string]string  `json:"response_headers"`
+	RewriteLocation          bool               `json:"rewrite_location"`
+	MaxRequestsPerSecond     int                `json:"max_requests_per_second"`
 }
 
 func (rm *RegistryMessage) makeEndpoint() *route.Endpoint {
-	return route.NewEndpoint(rm.App, rm.Host, rm.Port, rm.PrivateInstanceId, rm.Tags, rm.StaleThresholdInSeconds, rm.RouteServiceUrl)
+	endpoint := route.NewEndpoint(rm.App, rm.Host, rm.Port, rm.PrivateInstanceId, rm.Tags, rm.StaleThresholdInSeconds, rm.RouteServiceUrl)
+	if rm.Weight != nil {
+		endpoint.Weight = *rm.Weight
+	}
+	endpoint.TLS = rm.TLS
+	endpoint.ServerName = rm.ServerName
+	endpoint.Match = rm.Match
+	endpoint.HostHeader = rm.HostHeader
+	endpoint.PathRewrite = rm.PathRewrite
+	endpoint.CORS = rm.CORS
+	endpoint.ResponseHeaders = rm.ResponseHeaders
+	endpoint.RewriteLocation = rm.RewriteLocation
+	endpoint.MaxRequestsPerSecond = rm.MaxRequestsPerSecond
+	endpoint.TLSPassthrough = rm.TLSPassthrough
+	if rm.EndpointTimeoutInSeconds > 0 {
+		endpoint.Timeout = time.Duration(rm.EndpointTimeoutInSeconds) * time.Second
+	}
+
+	return endpoint
 }
 
 func (rm *RegistryMessage) ValidateMessage() bool {
@@ -3,6 +3,7 @@ package router_test
 import (
 	"encoding/json"
 
+	"github.com/cloudfoundry/gorouter/route"
 	. "github.com/cloudfoundry/gorouter/router"
 
 	. "github.com/onsi/ginkgo"
@@ -10,6 +11,132 @@ import (
 )
 
 var _ = Describe("RegistryMessage", func() {
+	Describe("Weight", func() {
+		It("is absent when not sent", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.Weight).To(BeNil())
+		})
+
+		It("accepts an explicit weight of zero", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234,"weight":0}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.Weight).NotTo(BeNil())
+			Expect(*message.Weight).To(BeZero())
+		})
+	})
+
+	Describe("TLS", func() {
+		It("is disabled when not sent", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.TLS).To(BeFalse())
+			Expect(message.ServerName).To(BeEmpty())
+		})
+
+		It("accepts a server name", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234,"tls":true,"server_name":"app1.internal"}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.TLS).To(BeTrue())
+			Expect(message.ServerName).To(Equal("app1.internal"))
+		})
+	})
+
+	Describe("Match", func() {
+		It("is absent when not sent", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.Match).To(BeNil())
+		})
+
+		It("accepts a header match", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234,"match":{"header":"X-Canary","value":"true"}}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.Match).To(Equal(&route.Match{Header: "X-Canary", Value: "true"}))
+		})
+
+		It("accepts a canary percentage", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234,"match":{"canary_percent":5}}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.Match).To(Equal(&route.Match{CanaryPercent: 5}))
+		})
+	})
+
+	Describe("HostHeader", func() {
+		It("is empty when not sent", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.HostHeader).To(BeEmpty())
+		})
+
+		It("accepts a host header override", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234,"host_header":"app1.internal"}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.HostHeader).To(Equal("app1.internal"))
+		})
+	})
+
+	Describe("EndpointTimeoutInSeconds", func() {
+		It("is zero when not sent", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.EndpointTimeoutInSeconds).To(BeZero())
+		})
+
+		It("accepts an endpoint timeout", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234,"endpoint_timeout":300}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.EndpointTimeoutInSeconds).To(Equal(300))
+		})
+	})
+
+	Describe("CORS", func() {
+		It("is nil when not sent", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.CORS).To(BeNil())
+		})
+
+		It("accepts a CORS policy", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234,"cors":{"allowed_origins":["*"],"allowed_methods":["GET"],"max_age":60}}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.CORS).To(Equal(&route.CORS{
+				AllowedOrigins:  []string{"*"},
+				AllowedMethods:  []string{"GET"},
+				MaxAgeInSeconds: 60,
+			}))
+		})
+	})
+
+	Describe("PathRewrite", func() {
+		It("is nil when not sent", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.PathRewrite).To(BeNil())
+		})
+
+		It("accepts a path rewrite", func() {
+			message := new(RegistryMessage)
+			err := json.Unmarshal([]byte(`{"app":"app1","uris":["test.com/api/v2"],"host":"1.2.3.4","port":1234,"path_rewrite":{"strip_prefix":"/api/v2","add_prefix":"/v2"}}`), message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(message.PathRewrite).To(Equal(&route.PathRewrite{StripPrefix: "/api/v2", AddPrefix: "/v2"}))
+		})
+	})
+
 	Describe("ValidateMessage", func() {
 		var message *RegistryMessage
 		var payload []byte
@@ -39,24 +39,28 @@ type Router struct {
 	varz       varz.Varz
 	component  *vcap.VcapComponent
 
-	listener         net.Listener
-	tlsListener      net.Listener
-	closeConnections bool
-	connLock         sync.Mutex
-	idleConns        map[net.Conn]struct{}
-	activeConns      map[net.Conn]struct{}
-	drainDone        chan struct{}
-	serveDone        chan struct{}
-	tlsServeDone     chan struct{}
-	stopping         bool
-	stopLock         sync.Mutex
+	listener             net.Listener
+	tlsListener          net.Listener
+	passthroughListener  net.Listener
+	connThrottle         *connThrottle
+	closeConnections     bool
+	connLock             sync.Mutex
+	idleConns            map[net.Conn]struct{}
+	activeConns          map[net.Conn]struct{}
+	drainDone            chan struct{}
+	serveDone            chan struct{}
+	tlsServeDone         chan struct{}
+	passthroughServeDone chan struct{}
+	serving              bool
+	stopping             bool
+	stopLock             sync.Mutex
 
 	logger  *steno.Logger
 	errChan chan error
 }
 
 func NewRouter(cfg *config.Config, p proxy.Proxy, mbusClient yagnats.NATSConn, r *registry.RouteRegistry,
-	v varz.Varz, logCounter *vcap.LogCounter, errChan chan error) (*Router, error) {
+	v varz.Varz, logCounter *vcap.LogCounter, statusHandlers map[string]http.Handler, errChan chan error) (*Router, error) {
 
 	var host string
 	if cfg.Status.Port != 0 {
@@ -76,14 +80,21 @@ func NewRouter(cfg *config.Config, p proxy.Proxy, mbusClient yagnats.NATSConn, r
 
 	healthz := &vcap.Healthz{}
 
+	handlers := map[string]http.Handler{
+		"/routes":  routesHandler(r),
+		"/routing": routingHandler(p),
+	}
+	for path, handler := range statusHandlers {
+		handlers[path] = handler
+	}
+
 	component := &vcap.VcapComponent{
-		Config:  cfg,
-		Varz:    varz,
-		Healthz: healthz,
-		InfoRoutes: map[string]json.Marshaler{
-			"/routes": r,
-		},
-		Logger: steno.NewLogger("common.logger"),
+		Config:     cfg,
+		Varz:       varz,
+		Healthz:    healthz,
+		InfoRoutes: map[string]json.Marshaler{},
+		Handlers:   handlers,
+		Logger:     steno.NewLogger("common.logger"),
 	}
 
 	routerErrChan := errChan
@@ -92,20 +103,23 @@ func NewRouter(cfg *config.Config, p proxy.Proxy, mbusClient yagnats.NATSConn, r
 	}
 
 	router := &Router{
-		config:       cfg,
-		proxy:        p,
-		mbusClient:   mbusClient,
-		registry:     r,
-		varz:         v,
-		component:    component,
-		serveDone:    make(chan struct{}),
-		tlsServeDone: make(chan struct{}),
-		idleConns:    make(map[net.Conn]struct{}),
-		activeConns:  make(map[net.Conn]struct{}),
-		logger:       steno.NewLogger("router"),
-		errChan:      routerErrChan,
-		stopping:     false,
+		config:               cfg,
+		proxy:                p,
+		mbusClient:           mbusClient,
+		registry:             r,
+		varz:                 v,
+		component:            component,
+		serveDone:            make(chan struct{}),
+		tlsServeDone:         make(chan struct{}),
+		passthroughServeDone: make(chan struct{}),
+		idleConns:            make(map[net.Conn]struct{}),
+		activeConns:          make(map[net.Conn]struct{}),
+		logger:               steno.NewLogger("router"),
+		errChan:              routerErrChan,
+		stopping:             false,
 	}
+	healthz.Health = router.healthy
+	handlers["/debug/vars"] = http.HandlerFunc(router.serveExpvar)
 
 	if err := router.component.Start(); err != nil {
 		return nil, err
@@ -142,9 +156,15 @@ func (r *Router) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
 		time.Sleep(r.config.StartResponseDelayInterval)
 	}
 
+	r.connThrottle = newConnThrottle(r.config.MaxClientConns, r.config.ResumeClientConns)
+
 	server := &http.Server{
-		Handler:   dropsonde.InstrumentedHandler(r.proxy),
-		ConnState: r.HandleConnState,
+		Handler:           dropsonde.InstrumentedHandler(r.proxy),
+		ConnState:         r.HandleConnState,
+		ConnContext:       r.proxy.ConnContext,
+		ReadHeaderTimeout: r.config.ClientReadTimeout,
+		IdleTimeout:       r.config.IdleTimeout,
+		MaxHeaderBytes:    r.config.MaxRequestHeaderBytes,
 	}
 
 	err := r.serveHTTP(server, r.errChan)
@@ -157,6 +177,15 @@ func (r *Router) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
 		r.errChan <- err
 		return err
 	}
+	err = r.serveTLSPassthrough(r.errChan)
+	if err != nil {
+		r.errChan <- err
+		return err
+	}
+
+	r.stopLock.Lock()
+	r.serving = true
+	r.stopLock.Unlock()
 
 	r.logger.Info("gorouter.started")
 
@@ -213,14 +242,27 @@ func (r *Router) serveHTTPS(server *http.Server, errChan chan error) error {
 		tlsConfig := &tls.Config{
 			Certificates: []tls.Certificate{r.config.SSLCertificate},
 			CipherSuites: r.config.CipherSuites,
+			MinVersion:   r.config.MinTLSVersion,
+		}
+		if r.config.ClientCACerts != nil {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			tlsConfig.ClientCAs = r.config.ClientCACerts
 		}
 
-		tlsListener, err := tls.Listen("tcp", fmt.Sprintf(":%d", r.config.SSLPort), tlsConfig)
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", r.config.SSLPort))
 		if err != nil {
 			r.logger.Fatalf("tls.Listen: %s", err)
 			return err
 		}
 
+		listener = r.connThrottle.listener(listener)
+
+		if r.config.EnableProxyProtocol {
+			listener = newProxyProtocolListener(listener, r.config.ClientReadTimeout)
+		}
+
+		tlsListener := tls.NewListener(newWriteTimeoutListener(listener, r.config.ClientWriteTimeout), tlsConfig)
+
 		r.tlsListener = tlsListener
 		r.logger.Infof("Listening on %s", tlsListener.Addr())
 
@@ -244,6 +286,13 @@ func (r *Router) serveHTTP(server *http.Server, errChan chan error) error {
 		return err
 	}
 
+	listener = r.connThrottle.listener(listener)
+
+	if r.config.EnableProxyProtocol {
+		listener = newProxyProtocolListener(listener, r.config.ClientReadTimeout)
+	}
+
+	listener = newWriteTimeoutListener(listener, r.config.ClientWriteTimeout)
 	r.listener = listener
 	r.logger.Infof("Listening on %s", listener.Addr())
 
@@ -261,6 +310,8 @@ func (r *Router) serveHTTP(server *http.Server, errChan chan error) error {
 }
 
 func (r *Router) Drain(drainTimeout time.Duration) error {
+	deadline := time.Now().Add(drainTimeout)
+
 	r.stopListening()
 
 	drained := make(chan struct{})
@@ -279,9 +330,14 @@ func (r *Router) Drain(drainTimeout time.Duration) error {
 
 	r.connLock.Unlock()
 
+	if err := r.proxy.Drain(drainTimeout); err != nil {
+		r.logger.Warn("router.drain.timed-out")
+		return DrainTimeout
+	}
+
 	select {
 	case <-drained:
-	case <-time.After(drainTimeout):
+	case <-time.After(deadline.Sub(time.Now())):
 		r.logger.Warn("router.drain.timed-out")
 		return DrainTimeout
 	}
@@ -330,9 +386,108 @@ func (r *Router) stopListening() {
 		<-r.tlsServeDone
 	}
 
+	if r.passthroughListener != nil {
+		r.passthroughListener.Close()
+		<-r.passthroughServeDone
+	}
+
 	<-r.serveDone
 }
 
+// healthy tells whether the router is accepting traffic and has received at
+// least one route. It turns false as soon as the router drains or stops.
+func (r *Router) healthy() bool {
+	r.stopLock.Lock()
+	accepting := r.serving && !r.stopping
+	r.stopLock.Unlock()
+
+	return accepting && !r.registry.TimeOfLastUpdate().IsZero()
+}
+
+// routesHandler serves the routing table, limited to the routes matching the
+// host query parameter when one is given.
+func routesHandler(registry *registry.RouteRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var b []byte
+		var err error
+
+		if host := req.URL.Query().Get("host"); host != "" {
+			b, err = registry.MarshalHostJSON(host)
+		} else {
+			b, err = registry.MarshalJSON()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(append(b, '\n'))
+	})
+}
+
+// routingHandler answers where the proxy would send a request for the host
+// and path given as query parameters. The method defaults to GET and the
+// client IP to that of the caller; the caller's headers, other than its
+// credentials, are passed on so that route groups, sticky sessions and
+// header-hash load balancing can be tried.
+func routingHandler(p proxy.Proxy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+
+		host := query.Get("host")
+		if host == "" {
+			http.Error(w, "host is required", http.StatusBadRequest)
+			return
+		}
+
+		path := query.Get("path")
+		if path == "" {
+			path = "/"
+		}
+
+		method := query.Get("method")
+		if method == "" {
+			method = "GET"
+		}
+
+		request, err := http.NewRequest(method, "http://"+host+path, nil)
+		if err != nil || path[0] != '/' {
+			http.Error(w, "invalid host or path", http.StatusBadRequest)
+			return
+		}
+		request.RequestURI = path
+		request.RemoteAddr = req.RemoteAddr
+		if client := query.Get("client"); client != "" {
+			request.RemoteAddr = net.JoinHostPort(client, "0")
+		}
+		for name, values := range req.Header {
+			if name != "Authorization" {
+				request.Header[name] = values
+			}
+		}
+
+		decision := p.DryRun(request)
+		if decision == nil {
+			http.Error(w, "no route matches", http.StatusNotFound)
+			return
+		}
+
+		b, err := json.Marshal(decision)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(append(b, '\n'))
+	})
+}
+
 func (r *Router) RegisterComponent() {
 	r.component.Register(r.mbusClient)
 }
@@ -341,11 +496,9 @@ func (r *Router) SubscribeRegister() {
 	r.subscribeRegistry("router.register", func(registryMessage *RegistryMessage) {
 		r.logger.Debugf("Got router.register: %v", registryMessage)
 
-		for _, uri := range registryMessage.Uris {
-			r.registry.Register(
-				uri,
-				registryMessage.makeEndpoint(),
-			)
+		_, err := r.registry.RegisterUris(registryMessage.Uris, registryMessage.makeEndpoint())
+		if err != nil {
+			r.logger.Warnf("Rejected router.register: %s", err)
 		}
 	})
 }
@@ -127,11 +127,12 @@ var _ = Describe("Router", func() {
 			Registry:        registry,
 			Reporter:        varz,
 			AccessLogger:    &access_log.NullAccessLogger{},
+			MaxRetries:      proxy.RetriesArg(config.MaxRetries),
 		})
 
 		errChan := make(chan error, 2)
 		var err error
-		router, err = NewRouter(config, proxy, mbusClient, registry, varz, logcounter, errChan)
+		router, err = NewRouter(config, proxy, mbusClient, registry, varz, logcounter, nil, errChan)
 		Expect(err).ToNot(HaveOccurred())
 	})
 
@@ -267,11 +268,12 @@ var _ = Describe("Router", func() {
 					Registry:        registry,
 					Reporter:        varz,
 					AccessLogger:    &access_log.NullAccessLogger{},
+					MaxRetries:      proxy.RetriesArg(config.MaxRetries),
 				})
 
 				errChan = make(chan error, 2)
 				var err error
-				router, err = NewRouter(config, proxy, mbusClient, registry, varz, logcounter, errChan)
+				router, err = NewRouter(config, proxy, mbusClient, registry, varz, logcounter, nil, errChan)
 				Expect(err).ToNot(HaveOccurred())
 				runRouter(router)
 			})
@@ -7,7 +7,9 @@ import (
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/cloudfoundry/dropsonde/emitter/fake"
 	"github.com/cloudfoundry/gorouter/access_log"
+	accessfakes "github.com/cloudfoundry/gorouter/access_log/fakes"
 	vcap "github.com/cloudfoundry/gorouter/common"
+	router_http "github.com/cloudfoundry/gorouter/common/http"
 	cfg "github.com/cloudfoundry/gorouter/config"
 	"github.com/cloudfoundry/gorouter/proxy"
 	rregistry "github.com/cloudfoundry/gorouter/registry"
@@ -30,7 +32,9 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/http/httputil"
+	"strconv"
 	"strings"
 	"time"
 
@@ -47,6 +51,7 @@ var _ = Describe("Router", func() {
 		registry     *rregistry.RouteRegistry
 		varz         vvarz.Varz
 		router       *Router
+		accessLogger access_log.AccessLogger
 		signals      chan os.Signal
 		closeChannel chan struct{}
 		readyChan    chan struct{}
@@ -71,10 +76,16 @@ var _ = Describe("Router", func() {
 		config.SSLPort = 4443 + uint16(gConfig.GinkgoConfig.ParallelNode)
 		config.SSLCertificate = cert
 		config.CipherSuites = []uint16{tls.TLS_RSA_WITH_AES_256_CBC_SHA}
+		config.MinTLSVersion = tls.VersionTLS11
+		config.TLSPassthroughPort = test_util.NextAvailPort()
 
 		mbusClient = natsRunner.MessageBus
 		registry = rregistry.NewRouteRegistry(config, mbusClient, new(fakes.FakeRouteReporter))
 		varz = vvarz.NewVarz(registry)
+		accessLogger = &access_log.NullAccessLogger{}
+	})
+
+	JustBeforeEach(func() {
 		logcounter := vcap.NewLogCounter()
 		proxy := proxy.NewProxy(proxy.ProxyArgs{
 			EndpointTimeout: config.EndpointTimeout,
@@ -82,10 +93,12 @@ var _ = Describe("Router", func() {
 			TraceKey:        config.TraceKey,
 			Registry:        registry,
 			Reporter:        varz,
-			AccessLogger:    &access_log.NullAccessLogger{},
+			AccessLogger:    accessLogger,
+			MaxRetries:      proxy.RetriesArg(config.MaxRetries),
 		})
-		router, err = NewRouter(config, proxy, mbusClient, registry, varz, logcounter, nil)
 
+		var err error
+		router, err = NewRouter(config, proxy, mbusClient, registry, varz, logcounter, nil, nil)
 		Expect(err).ToNot(HaveOccurred())
 
 		readyChan = make(chan struct{})
@@ -110,6 +123,12 @@ var _ = Describe("Router", func() {
 		}
 	})
 
+	dialRouter := func() net.Conn {
+		conn, err := net.Dial("tcp", net.JoinHostPort(config.Ip, strconv.Itoa(int(config.Port))))
+		Expect(err).ToNot(HaveOccurred())
+		return conn
+	}
+
 	Context("NATS", func() {
 		Context("Router Greetings", func() {
 			It("RouterGreets", func() {
@@ -517,6 +536,287 @@ var _ = Describe("Router", func() {
 		Expect(string(body)).To(MatchRegexp(".*1\\.2\\.3\\.4:1234.*\n"))
 	})
 
+	Context("/healthz", func() {
+		healthzStatus := func() int {
+			resp, err := http.Get(fmt.Sprintf("http://%s:%d/healthz", config.Ip, config.Status.Port))
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		It("is unavailable before any route is registered", func() {
+			Expect(healthzStatus()).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("is ok once a route is registered", func() {
+			mbusClient.Publish("router.register", []byte(`{"dea":"dea1","app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234,"tags":{}}`))
+
+			Eventually(healthzStatus).Should(Equal(http.StatusOK))
+		})
+
+		It("turns unavailable when the router drains", func() {
+			mbusClient.Publish("router.register", []byte(`{"dea":"dea1","app":"app1","uris":["test.com"],"host":"1.2.3.4","port":1234,"tags":{}}`))
+			Eventually(healthzStatus).Should(Equal(http.StatusOK))
+
+			Expect(router.Drain(time.Second)).To(Succeed())
+
+			Expect(healthzStatus()).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+
+	It("filters a /routes request by host", func() {
+		mbusClient.Publish("router.register", []byte(`{"dea":"dea1","app":"app1","uris":["test.com","test.com/v2"],"host":"1.2.3.4","port":1234,"tags":{}}`))
+		mbusClient.Publish("router.register", []byte(`{"dea":"dea1","app":"app2","uris":["other.com"],"host":"5.6.7.8","port":5678,"tags":{}}`))
+		time.Sleep(250 * time.Millisecond)
+
+		host := fmt.Sprintf("http://%s:%d/routes?host=test.com", config.Ip, config.Status.Port)
+
+		req, err := http.NewRequest("GET", host, nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.SetBasicAuth("user", "pass")
+
+		var client http.Client
+		resp, err := client.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(200))
+		defer resp.Body.Close()
+
+		var routes map[string][]map[string]interface{}
+		Expect(json.NewDecoder(resp.Body).Decode(&routes)).To(Succeed())
+		Expect(routes).To(HaveLen(2))
+		Expect(routes["test.com"][0]["address"]).To(Equal("1.2.3.4:1234"))
+		Expect(routes["test.com/v2"][0]["address"]).To(Equal("1.2.3.4:1234"))
+	})
+
+	It("passes TLS connections through to the backend registered for their server name", func() {
+		backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("passed through"))
+		}))
+		defer backend.Close()
+
+		backendAddr := backend.Listener.Addr().(*net.TCPAddr)
+		mbusClient.Publish("router.register", []byte(fmt.Sprintf(
+			`{"app":"app1","uris":["passthrough.vcap.me"],"host":"127.0.0.1","port":%d,"tls_passthrough":true}`, backendAddr.Port)))
+		Eventually(func() *route.Pool {
+			pool := registry.Lookup("passthrough.vcap.me")
+			if pool == nil {
+				return nil
+			}
+			return pool.PassthroughGroup()
+		}).ShouldNot(BeNil())
+
+		passthroughAddr := fmt.Sprintf("%s:%d", config.Ip, config.TLSPassthroughPort)
+		conn, err := tls.Dial("tcp", passthroughAddr, &tls.Config{
+			ServerName:         "passthrough.vcap.me",
+			InsecureSkipVerify: true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		// the handshake was made with the backend, not the router
+		Expect(conn.ConnectionState().PeerCertificates[0].Raw).To(Equal(backend.Certificate().Raw))
+
+		req, err := http.NewRequest("GET", "https://passthrough.vcap.me/", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(req.Write(conn)).To(Succeed())
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		Expect(err).ToNot(HaveOccurred())
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal("passed through"))
+
+		_, err = tls.Dial("tcp", passthroughAddr, &tls.Config{
+			ServerName:         "unknown.vcap.me",
+			InsecureSkipVerify: true,
+		})
+		Expect(err).To(HaveOccurred())
+
+		// plain requests for the host are not sent to the backend
+		plain, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/", config.Ip, config.Port), nil)
+		Expect(err).ToNot(HaveOccurred())
+		plain.Host = "passthrough.vcap.me"
+		resp, err = http.DefaultClient.Do(plain)
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("waits for the connections being passed through when draining", func() {
+		backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("passed through"))
+		}))
+		defer backend.Close()
+
+		backendAddr := backend.Listener.Addr().(*net.TCPAddr)
+		mbusClient.Publish("router.register", []byte(fmt.Sprintf(
+			`{"app":"app1","uris":["passthrough.vcap.me"],"host":"127.0.0.1","port":%d,"tls_passthrough":true}`, backendAddr.Port)))
+		Eventually(func() *route.Pool {
+			pool := registry.Lookup("passthrough.vcap.me")
+			if pool == nil {
+				return nil
+			}
+			return pool.PassthroughGroup()
+		}).ShouldNot(BeNil())
+
+		conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", config.Ip, config.TLSPassthroughPort), &tls.Config{
+			ServerName:         "passthrough.vcap.me",
+			InsecureSkipVerify: true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		Expect(router.Drain(200 * time.Millisecond)).To(Equal(DrainTimeout))
+	})
+
+	It("answers a dry-run routing request with the backend the next request goes to", func() {
+		for i := 0; i < 2; i++ {
+			app := test.NewGreetApp([]route.Uri{"dryrun.vcap.me"}, config.Port, mbusClient, nil)
+			app.Listen()
+		}
+		Eventually(func() int {
+			pool := registry.Lookup("dryrun.vcap.me")
+			if pool == nil {
+				return 0
+			}
+			n := 0
+			pool.Each(func(*route.Endpoint) { n++ })
+			return n
+		}).Should(Equal(2))
+
+		dryRun := func(query string) (int, map[string]interface{}) {
+			uri := fmt.Sprintf("http://%s:%d/routing?%s", config.Ip, config.Status.Port, query)
+			req, err := http.NewRequest("GET", uri, nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.SetBasicAuth("user", "pass")
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return resp.StatusCode, nil
+			}
+
+			decision := make(map[string]interface{})
+			Expect(json.NewDecoder(resp.Body).Decode(&decision)).To(Succeed())
+			return resp.StatusCode, decision
+		}
+
+		send := func() string {
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/hello", config.Ip, config.Port), nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.Host = "dryrun.vcap.me"
+			req.Header.Set(router_http.VcapTraceHeader, config.TraceKey)
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			return resp.Header.Get(router_http.VcapBackendHeader)
+		}
+
+		backends := map[string]bool{}
+		for i := 0; i < 4; i++ {
+			status, decision := dryRun("host=dryrun.vcap.me&path=/hello")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(decision["uri"]).To(Equal("dryrun.vcap.me"))
+			Expect(decision["candidates"]).To(HaveLen(2))
+
+			selected := decision["selected"].(map[string]interface{})["address"]
+			_, again := dryRun("host=dryrun.vcap.me&path=/hello")
+			Expect(again["selected"].(map[string]interface{})["address"]).To(Equal(selected))
+
+			Expect(send()).To(Equal(selected))
+			backends[selected.(string)] = true
+		}
+		Expect(backends).To(HaveLen(2))
+
+		status, _ := dryRun("host=unknown.vcap.me")
+		Expect(status).To(Equal(http.StatusNotFound))
+		status, _ = dryRun("path=/hello")
+		Expect(status).To(Equal(http.StatusBadRequest))
+	})
+
+	It("publishes its counters in expvar", func() {
+		app := test.NewGreetApp([]route.Uri{"expvar.vcap.me"}, config.Port, mbusClient, nil)
+		app.Listen()
+		Eventually(func() bool {
+			return appRegistered(registry, app)
+		}).Should(BeTrue())
+
+		readCounters := func() map[string]interface{} {
+			uri := fmt.Sprintf("http://%s:%d/debug/vars", config.Ip, config.Status.Port)
+			req, err := http.NewRequest("GET", uri, nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.SetBasicAuth("user", "pass")
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			vars := make(map[string]interface{})
+			Expect(json.NewDecoder(resp.Body).Decode(&vars)).To(Succeed())
+			return vars["gorouter"].(map[string]interface{})
+		}
+
+		initial := readCounters()
+		for i := 0; i < 3; i++ {
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/", config.Ip, config.Port), nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.Host = "expvar.vcap.me"
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+		updated := readCounters()
+
+		Expect(updated["requests"].(float64) - initial["requests"].(float64)).To(Equal(float64(3)))
+		Expect(updated["responses_5xx"]).To(Equal(initial["responses_5xx"]))
+		Expect(updated["droplets"]).To(Equal(fetchRecursively(readVarz(varz), "droplets")))
+		Expect(updated).To(HaveKey("active_connections"))
+	})
+
+	It("requires credentials on the status port but not on the proxy port", func() {
+		app := test.NewGreetApp([]route.Uri{"unauthenticated.vcap.me"}, config.Port, mbusClient, nil)
+		app.Listen()
+		Eventually(func() bool {
+			return appRegistered(registry, app)
+		}).Should(BeTrue())
+
+		for _, path := range []string{"/varz", "/routes"} {
+			uri := fmt.Sprintf("http://%s:%d%s", config.Ip, config.Status.Port, path)
+
+			req, err := http.NewRequest("GET", uri, nil)
+			Expect(err).ToNot(HaveOccurred())
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized), path)
+			Expect(resp.Header.Get("WWW-Authenticate")).To(ContainSubstring("Basic"))
+
+			req.SetBasicAuth("user", "wrong")
+			resp, err = http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized), path)
+
+			req.SetBasicAuth("user", "pass")
+			resp, err = http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK), path)
+		}
+
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/", config.Ip, config.Port), nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Host = "unauthenticated.vcap.me"
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
 	Context("HTTP keep-alive", func() {
 		It("reuses the same connection on subsequent calls", func() {
 			app := test.NewGreetApp([]route.Uri{"keepalive.vcap.me"}, config.Port, mbusClient, nil)
@@ -634,9 +934,306 @@ var _ = Describe("Router", func() {
 		})
 	})
 
+	Context("client connection timeouts", func() {
+		BeforeEach(func() {
+			config.EndpointTimeout = 5 * time.Second
+			config.ClientReadTimeout = 500 * time.Millisecond
+			config.ClientWriteTimeout = 500 * time.Millisecond
+			config.IdleTimeout = 500 * time.Millisecond
+		})
+
+		JustBeforeEach(func() {
+			app := test.NewTestApp([]route.Uri{"timeout.vcap.me"}, config.Port, mbusClient, nil, "")
+			app.AddHandler("/", func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				defer r.Body.Close()
+				Expect(err).ToNot(HaveOccurred())
+
+				w.Write(body)
+			})
+			app.Listen()
+
+			Eventually(func() bool {
+				return appRegistered(registry, app)
+			}).Should(BeTrue())
+		})
+
+		It("closes connections that send their headers too slowly", func() {
+			conn := dialRouter()
+			defer conn.Close()
+
+			_, err := conn.Write([]byte("GET / HTTP/1.1\r\n"))
+			Expect(err).ToNot(HaveOccurred())
+
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				ioutil.ReadAll(conn)
+			}()
+
+			for _, b := range []byte("Host: timeout.vcap.me\r\n") {
+				select {
+				case <-closed:
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
+				conn.Write([]byte{b})
+			}
+
+			Fail("connection was not closed")
+		})
+
+		It("does not time out request bodies sent slowly", func() {
+			conn := dialRouter()
+			defer conn.Close()
+
+			_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: timeout.vcap.me\r\nContent-Length: 4\r\n\r\n"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, b := range []byte("ping") {
+				time.Sleep(300 * time.Millisecond)
+				_, err = conn.Write([]byte{b})
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("ping"))
+		})
+
+		It("closes keep-alive connections left idle between requests", func() {
+			conn := dialRouter()
+			defer conn.Close()
+
+			request := []byte("POST / HTTP/1.1\r\nHost: timeout.vcap.me\r\nContent-Length: 4\r\n\r\nping")
+			_, err := conn.Write(request)
+			Expect(err).ToNot(HaveOccurred())
+
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			time.Sleep(1 * time.Second)
+
+			// the first write after the server closed may still be buffered,
+			// the ones following it fail
+			Eventually(func() error {
+				_, err := conn.Write(request)
+				return err
+			}).Should(HaveOccurred())
+		})
+	})
+
+	Context("with a client connection limit", func() {
+		BeforeEach(func() {
+			config.MaxClientConns = 3
+			config.ResumeClientConns = 1
+		})
+
+		JustBeforeEach(func() {
+			app := test.NewTestApp([]route.Uri{"limited.vcap.me"}, config.Port, mbusClient, nil, "")
+			app.AddHandler("/", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			app.Listen()
+
+			Eventually(func() bool {
+				return appRegistered(registry, app)
+			}).Should(BeTrue())
+		})
+
+		It("stops accepting connections until capacity frees up", func() {
+			first := dialRouter()
+			defer first.Close()
+			second := dialRouter()
+			defer second.Close()
+			third := dialRouter()
+			defer third.Close()
+
+			waiting := dialRouter()
+			defer waiting.Close()
+
+			_, err := waiting.Write([]byte("GET / HTTP/1.1\r\nHost: limited.vcap.me\r\n\r\n"))
+			Expect(err).ToNot(HaveOccurred())
+
+			responses := make(chan *http.Response, 1)
+			go func() {
+				resp, err := http.ReadResponse(bufio.NewReader(waiting), nil)
+				if err == nil {
+					resp.Body.Close()
+					responses <- resp
+				}
+			}()
+
+			Consistently(responses, 500*time.Millisecond).ShouldNot(Receive())
+
+			first.Close()
+			Consistently(responses, 500*time.Millisecond).ShouldNot(Receive())
+
+			second.Close()
+			third.Close()
+			var resp *http.Response
+			Eventually(responses).Should(Receive(&resp))
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+		})
+	})
+
+	Context("with a request header size limit", func() {
+		var contacted chan struct{}
+
+		BeforeEach(func() {
+			config.MaxRequestHeaderBytes = 1024
+			contacted = make(chan struct{}, 1)
+		})
+
+		JustBeforeEach(func() {
+			app := test.NewTestApp([]route.Uri{"limited.vcap.me"}, config.Port, mbusClient, nil, "")
+			app.AddHandler("/", func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case contacted <- struct{}{}:
+				default:
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+			app.Listen()
+
+			Eventually(func() bool {
+				return appRegistered(registry, app)
+			}).Should(BeTrue())
+		})
+
+		sendRequest := func(conn net.Conn, header string) *http.Response {
+			defer conn.Close()
+
+			_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: limited.vcap.me\r\nX-Header: " + header + "\r\n\r\n"))
+			Expect(err).ToNot(HaveOccurred())
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+			return resp
+		}
+
+		dialRouterTLS := func() net.Conn {
+			conn, err := tls.Dial("tcp", net.JoinHostPort(config.Ip, strconv.Itoa(int(config.SSLPort))), &tls.Config{
+				InsecureSkipVerify: true,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return conn
+		}
+
+		It("routes requests within the limit", func() {
+			resp := sendRequest(dialRouter(), "value")
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(contacted).To(Receive())
+		})
+
+		It("answers larger headers with a 431 before routing the request", func() {
+			resp := sendRequest(dialRouter(), strings.Repeat("a", 16*1024))
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestHeaderFieldsTooLarge))
+			Consistently(contacted).ShouldNot(Receive())
+		})
+
+		It("applies the limit to requests over https", func() {
+			resp := sendRequest(dialRouterTLS(), "value")
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(contacted).To(Receive())
+
+			resp = sendRequest(dialRouterTLS(), strings.Repeat("a", 16*1024))
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestHeaderFieldsTooLarge))
+			Consistently(contacted).ShouldNot(Receive())
+		})
+	})
+
+	Context("with the PROXY protocol enabled", func() {
+		var fakeAccessLogger *accessfakes.FakeAccessLogger
+
+		BeforeEach(func() {
+			config.EnableProxyProtocol = true
+			fakeAccessLogger = new(accessfakes.FakeAccessLogger)
+			accessLogger = fakeAccessLogger
+		})
+
+		JustBeforeEach(func() {
+			app := test.NewTestApp([]route.Uri{"proxied.vcap.me"}, config.Port, mbusClient, nil, "")
+			app.AddHandler("/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Header.Get("X-Forwarded-For")))
+			})
+			app.Listen()
+
+			Eventually(func() bool {
+				return appRegistered(registry, app)
+			}).Should(BeTrue())
+		})
+
+		It("takes the client address from the PROXY header", func() {
+			conn := dialRouter()
+			defer conn.Close()
+
+			_, err := conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\nGET / HTTP/1.1\r\nHost: proxied.vcap.me\r\n\r\n"))
+			Expect(err).ToNot(HaveOccurred())
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("203.0.113.7"))
+
+			Eventually(fakeAccessLogger.LogCallCount).Should(Equal(1))
+			Expect(fakeAccessLogger.LogArgsForCall(0).ClientAddr).To(Equal("203.0.113.7:51234"))
+		})
+
+		It("rejects connections without a valid PROXY header", func() {
+			conn := dialRouter()
+			defer conn.Close()
+
+			_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: proxied.vcap.me\r\n\r\n"))
+			Expect(err).ToNot(HaveOccurred())
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(fakeAccessLogger.LogCallCount()).To(Equal(0))
+		})
+
+		Context("with a client read timeout", func() {
+			BeforeEach(func() {
+				config.ClientReadTimeout = 500 * time.Millisecond
+			})
+
+			It("closes passthrough connections that stall after the PROXY header", func() {
+				conn, err := net.Dial("tcp", net.JoinHostPort(config.Ip, strconv.Itoa(int(config.TLSPassthroughPort))))
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+
+				_, err = conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n"))
+				Expect(err).ToNot(HaveOccurred())
+
+				// the router closes the connection instead of waiting for the
+				// ClientHello, so the read ends before the deadline
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, err = ioutil.ReadAll(conn)
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+	})
+
 	Context("long requests", func() {
 		Context("http", func() {
-			BeforeEach(func() {
+			JustBeforeEach(func() {
 				app := test.NewSlowApp(
 					[]route.Uri{"slow-app.vcap.me"},
 					config.Port,
@@ -753,11 +1350,32 @@ var _ = Describe("Router", func() {
 			_, err := client.Do(req)
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("fails when the client uses a version below the minimum TLS version", func() {
+			app := test.NewGreetApp([]route.Uri{"test.vcap.me"}, config.Port, mbusClient, nil)
+			app.Listen()
+			Eventually(func() bool {
+				return appRegistered(registry, app)
+			}).Should(BeTrue())
+
+			uri := fmt.Sprintf("https://test.vcap.me:%d", config.SSLPort)
+			req, _ := http.NewRequest("GET", uri, nil)
+			tr := &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+					MinVersion:         tls.VersionTLS10,
+					MaxVersion:         tls.VersionTLS10,
+				},
+			}
+			client := http.Client{Transport: tr}
+			_, err := client.Do(req)
+			Expect(err).To(HaveOccurred())
+		})
 	})
 
 	Describe("SubscribeRegister", func() {
 		Context("when the register message JSON fails to unmarshall", func() {
-			BeforeEach(func() {
+			JustBeforeEach(func() {
 				// the port is too high
 				mbusClient.Publish("router.register", []byte(`
 {
@@ -808,6 +1426,8 @@ func verify_health_z(host string, r *rregistry.RouteRegistry) {
 	var req *http.Request
 	path := "/healthz"
 
+	r.Register(route.Uri("healthz.vcap.me"), route.NewEndpoint("", "1.2.3.4", 1234, "", nil, -1, ""))
+
 	req, _ = http.NewRequest("GET", "http://"+host+path, nil)
 	bytes := verify_success(req)
 	Expect(string(bytes)).To(Equal("ok"))
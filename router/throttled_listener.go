@@ -0,0 +1,114 @@
+package router
+
+import (
+	"net"
+	"sync"
+)
+
+// connThrottle counts the client connections open across the router's
+// listeners. Once high of them are open the listeners stop accepting
+// connections, leaving new clients in the kernel's backlog, until no more
+// than low are left.
+type connThrottle struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	high   int
+	low    int
+	open   int
+	paused bool
+}
+
+func newConnThrottle(high, low int) *connThrottle {
+	if high <= 0 {
+		return nil
+	}
+
+	t := &connThrottle{
+		high: high,
+		low:  low,
+	}
+	t.cond = sync.NewCond(&t.lock)
+	return t
+}
+
+// listener wraps the listener so that its connections count against the
+// throttle. A nil throttle returns the listener as is.
+func (t *connThrottle) listener(listener net.Listener) net.Listener {
+	if t == nil {
+		return listener
+	}
+
+	return &throttledListener{
+		Listener: listener,
+		throttle: t,
+	}
+}
+
+// wait blocks until a connection may be accepted or closed is set.
+func (t *connThrottle) wait(closed *bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.open >= t.high {
+		t.paused = true
+	}
+	for t.paused && !*closed {
+		if t.open <= t.low {
+			t.paused = false
+			break
+		}
+		t.cond.Wait()
+	}
+}
+
+func (t *connThrottle) opened() {
+	t.lock.Lock()
+	t.open++
+	t.lock.Unlock()
+}
+
+func (t *connThrottle) closed() {
+	t.lock.Lock()
+	t.open--
+	t.cond.Broadcast()
+	t.lock.Unlock()
+}
+
+type throttledListener struct {
+	net.Listener
+	throttle *connThrottle
+	closed   bool
+}
+
+func (l *throttledListener) Accept() (net.Conn, error) {
+	l.throttle.wait(&l.closed)
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.throttle.opened()
+	return &throttledConn{Conn: conn, throttle: l.throttle}, nil
+}
+
+// Close wakes up a pending Accept, which then fails on the closed listener.
+func (l *throttledListener) Close() error {
+	l.throttle.lock.Lock()
+	l.closed = true
+	l.throttle.cond.Broadcast()
+	l.throttle.lock.Unlock()
+
+	return l.Listener.Close()
+}
+
+type throttledConn struct {
+	net.Conn
+	throttle *connThrottle
+	once     sync.Once
+}
+
+func (c *throttledConn) Close() error {
+	c.once.Do(c.throttle.closed)
+	return c.Conn.Close()
+}
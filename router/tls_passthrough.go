@@ -0,0 +1,180 @@
+package router
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+var errClientHelloRead = errors.New("client hello read")
+
+// serveTLSPassthrough accepts TLS connections on the passthrough port and
+// hands each of them, without terminating TLS, to a backend registered for
+// TLS passthrough under the server name the client asks for.
+func (r *Router) serveTLSPassthrough(errChan chan error) error {
+	if r.config.TLSPassthroughPort == 0 {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", r.config.TLSPassthroughPort))
+	if err != nil {
+		r.logger.Fatalf("net.Listen: %s", err)
+		return err
+	}
+
+	listener = r.connThrottle.listener(listener)
+
+	if r.config.EnableProxyProtocol {
+		listener = newProxyProtocolListener(listener, r.config.ClientReadTimeout)
+	}
+
+	r.passthroughListener = listener
+	r.logger.Infof("Listening for TLS passthrough on %s", listener.Addr())
+
+	go func() {
+		defer close(r.passthroughServeDone)
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					time.Sleep(5 * time.Millisecond)
+					continue
+				}
+
+				r.stopLock.Lock()
+				if !r.stopping {
+					errChan <- err
+				}
+				r.stopLock.Unlock()
+				return
+			}
+
+			go r.passThrough(conn)
+		}
+	}()
+	return nil
+}
+
+func (r *Router) passThrough(conn net.Conn) {
+	defer conn.Close()
+
+	// draining waits for the connection like for a request in progress
+	r.HandleConnState(conn, http.StateActive)
+	defer r.HandleConnState(conn, http.StateClosed)
+
+	if r.config.ClientReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(r.config.ClientReadTimeout))
+	}
+
+	serverName, hello, err := peekServerName(conn)
+	if err != nil {
+		r.logger.Warnf("tls-passthrough.client-hello-failed: %s", err)
+		return
+	}
+
+	conn.SetReadDeadline(noDeadline)
+
+	var group *route.Pool
+	if pool := r.registry.Lookup(route.Uri(serverName)); pool != nil {
+		group = pool.PassthroughGroup()
+	}
+	if group == nil {
+		r.logger.Infof("tls-passthrough.unknown-route: %s", serverName)
+		return
+	}
+
+	iter := group.Endpoints("")
+
+	var backend net.Conn
+	var endpoint *route.Endpoint
+	for attempt := 0; attempt <= r.config.MaxRetries && backend == nil; attempt++ {
+		endpoint = iter.Next()
+		if endpoint == nil {
+			break
+		}
+
+		backend, err = net.DialTimeout("tcp", endpoint.CanonicalAddr(), r.config.DialTimeout)
+		if err != nil {
+			r.logger.Warnf("tls-passthrough.backend-failed: %s", err)
+			iter.EndpointFailed()
+			iter.RecordFailure(endpoint)
+		}
+	}
+	if backend == nil {
+		return
+	}
+	defer backend.Close()
+
+	iter.RecordSuccess(endpoint)
+	iter.PreRequest(endpoint)
+	defer iter.PostRequest(endpoint)
+
+	if _, err := backend.Write(hello); err != nil {
+		return
+	}
+
+	splice(conn, backend)
+}
+
+// peekServerName reads the ClientHello the TLS connection starts with and
+// returns the server name it asks for, along with the bytes read, which the
+// backend has to be sent before the rest of the connection.
+func peekServerName(conn net.Conn) (string, []byte, error) {
+	var read bytes.Buffer
+	var serverName string
+
+	err := tls.Server(readOnlyConn{Conn: conn, reader: io.TeeReader(conn, &read)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+
+	if serverName == "" {
+		if err == errClientHelloRead {
+			err = errors.New("no server name")
+		}
+		return "", nil, err
+	}
+
+	return serverName, read.Bytes(), nil
+}
+
+// readOnlyConn keeps the TLS handshake used to read the ClientHello from
+// writing to the client.
+type readOnlyConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c readOnlyConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// splice copies between the two connections until either of them is done.
+func splice(a, b net.Conn) {
+	done := make(chan bool, 2)
+
+	copy := func(dst io.Writer, src io.Reader) {
+		// don't care about errors here
+		io.Copy(dst, src)
+		done <- true
+	}
+
+	go copy(a, b)
+	go copy(b, a)
+
+	<-done
+}
@@ -0,0 +1,44 @@
+package router
+
+import (
+	"net"
+	"time"
+)
+
+// writeTimeoutListener hands out connections that fail a write to the client
+// when it does not complete within the timeout. The deadline is set for each
+// write, so long responses keep flowing as long as the client reads them.
+type writeTimeoutListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func newWriteTimeoutListener(listener net.Listener, timeout time.Duration) net.Listener {
+	if timeout <= 0 {
+		return listener
+	}
+
+	return &writeTimeoutListener{
+		Listener: listener,
+		timeout:  timeout,
+	}
+}
+
+func (l *writeTimeoutListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &writeTimeoutConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+type writeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *writeTimeoutConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
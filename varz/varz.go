@@ -2,7 +2,9 @@ package varz
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -32,11 +34,47 @@ type varz struct {
 	BadGateways    int     `json:"bad_gateways"`
 	RequestsPerSec float64 `json:"requests_per_sec"`
 
+	ProxyResponses proxyResponses `json:"proxy_responses"`
+
+	BackendErrors map[string]*backendErrors `json:"backend_errors"`
+
 	TopApps []topAppsEntry `json:"top10_app_requests"`
 
+	UriLatency UriLatency             `json:"latency_by_uri"`
+	UriBytes   map[string]*routeBytes `json:"bytes_by_uri"`
+
+	SelectionTime *TimeMetric `json:"backend_selection_time"`
+	QueueTime     *TimeMetric `json:"backend_queue_time"`
+
 	MillisSinceLastRegistryUpdate int64 `json:"ms_since_last_registry_update"`
 }
 
+// proxyResponses counts the responses sent to clients, including the ones
+// the router answers itself without reaching a backend.
+type proxyResponses struct {
+	Responses2xx int64 `json:"responses_2xx"`
+	Responses3xx int64 `json:"responses_3xx"`
+	Responses4xx int64 `json:"responses_4xx"`
+	Responses5xx int64 `json:"responses_5xx"`
+	ResponsesXxx int64 `json:"responses_xxx"`
+}
+
+// backendErrors counts the errors of a single backend, keyed by its
+// host:port in varz.
+type backendErrors struct {
+	ConnectionFailures int64 `json:"connection_failures"`
+	Timeouts           int64 `json:"timeouts"`
+	InvalidResponses   int64 `json:"invalid_responses"`
+	Responses5xx       int64 `json:"responses_5xx"`
+}
+
+// routeBytes totals the bytes of the requests routed to a URI and of the
+// responses sent back to their clients, headers included.
+type routeBytes struct {
+	RequestBytes  int64 `json:"request_bytes"`
+	ResponseBytes int64 `json:"response_bytes"`
+}
+
 type httpMetric struct {
 	Requests int64      `json:"requests"`
 	Rate     [3]float64 `json:"rate"`
@@ -157,6 +195,72 @@ func (x TaggedHttpMetric) CaptureResponse(t string, y *http.Response, z time.Dur
 	x.httpMetric(t).CaptureResponse(y, z)
 }
 
+// UriLatency keeps a bounded latency sample per routed URI.
+type UriLatency map[string]metrics.Histogram
+
+func NewUriLatency() UriLatency {
+	x := make(UriLatency)
+	return x
+}
+
+func (x UriLatency) MarshalJSON() ([]byte, error) {
+	p := []float64{0.50, 0.90, 0.99}
+
+	y := make(map[string]map[string]float64)
+	for uri, h := range x {
+		z := h.Percentiles(p)
+
+		l := make(map[string]float64)
+		for i, e := range p {
+			l[fmt.Sprintf("%d", int(e*100))] = z[i] / float64(time.Second)
+		}
+		y[uri] = l
+	}
+
+	return json.Marshal(y)
+}
+
+func (x UriLatency) CaptureResponse(uri route.Uri, duration time.Duration) {
+	if uri == "" {
+		return
+	}
+
+	h := x[uri.String()]
+	if h == nil {
+		h = metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015))
+		x[uri.String()] = h
+	}
+
+	h.Update(duration.Nanoseconds())
+}
+
+// TimeMetric keeps a bounded sample of the time spent in one step of routing
+// requests, apart from the time backends take to respond.
+type TimeMetric struct {
+	metrics.Histogram
+}
+
+func NewTimeMetric() *TimeMetric {
+	return &TimeMetric{metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015))}
+}
+
+func (x *TimeMetric) MarshalJSON() ([]byte, error) {
+	p := []float64{0.50, 0.90, 0.99}
+	z := x.Percentiles(p)
+
+	y := make(map[string]float64)
+	for i, e := range p {
+		y[fmt.Sprintf("%d", int(e*100))] = z[i] / float64(time.Second)
+	}
+	y["count"] = float64(x.Count())
+
+	return json.Marshal(y)
+}
+
+func (x *TimeMetric) Capture(duration time.Duration) {
+	x.Update(duration.Nanoseconds())
+}
+
 type Varz interface {
 	json.Marshaler
 
@@ -165,7 +269,21 @@ type Varz interface {
 	CaptureBadRequest(req *http.Request)
 	CaptureBadGateway(req *http.Request)
 	CaptureRoutingRequest(b *route.Endpoint, req *http.Request)
-	CaptureRoutingResponse(b *route.Endpoint, res *http.Response, startedAt time.Time, d time.Duration)
+	CaptureRoutingResponse(b *route.Endpoint, uri route.Uri, res *http.Response, startedAt time.Time, d time.Duration)
+	CaptureProxyResponse(status int)
+	CaptureBackendFailure(b *route.Endpoint, err error)
+	CaptureSelectionTime(d time.Duration)
+	CaptureQueueTime(d time.Duration)
+	CaptureRouteBytes(uri route.Uri, received, sent int64)
+
+	Counters() Counters
+}
+
+// Counters are the counts of varz that are cheap to read, without building
+// the whole of it.
+type Counters struct {
+	Requests     int64
+	Responses5xx int64
 }
 
 type RealVarz struct {
@@ -184,10 +302,55 @@ func NewVarz(r *registry.RouteRegistry) Varz {
 
 	x.All = NewHttpMetric()
 	x.Tags.Component = make(map[string]*HttpMetric)
+	x.UriLatency = NewUriLatency()
+	x.UriBytes = make(map[string]*routeBytes)
+	x.BackendErrors = make(map[string]*backendErrors)
+	x.SelectionTime = NewTimeMetric()
+	x.QueueTime = NewTimeMetric()
+
+	if r != nil {
+		r.OnChange(x.forget)
+	}
 
 	return x
 }
 
+// forget drops the metrics kept per URI and per backend once the route or
+// the backend is no longer registered, so that they do not pile up on a
+// router whose routes come and go.
+func (x *RealVarz) forget(event registry.RouteEvent) {
+	if event.Type == registry.EndpointRegistered {
+		return
+	}
+
+	var uris []string
+	for _, uri := range event.Uris {
+		if !x.r.IsRegistered(uri) {
+			uris = append(uris, uri.RouteKey().String())
+		}
+	}
+
+	addr := event.Endpoint.CanonicalAddr()
+
+	x.Lock()
+	_, failed := x.BackendErrors[addr]
+	x.Unlock()
+
+	// most backends never fail, walking the routing table for them is
+	// wasted
+	backendGone := failed && !x.r.HasBackend(addr)
+
+	x.Lock()
+	for _, uri := range uris {
+		delete(x.UriLatency, uri)
+		delete(x.UriBytes, uri)
+	}
+	if backendGone {
+		delete(x.BackendErrors, addr)
+	}
+	x.Unlock()
+}
+
 func (x *RealVarz) MarshalJSON() ([]byte, error) {
 	x.Lock()
 	defer x.Unlock()
@@ -262,7 +425,7 @@ func (x *RealVarz) CaptureRoutingRequest(b *route.Endpoint, req *http.Request) {
 	x.Unlock()
 }
 
-func (x *RealVarz) CaptureRoutingResponse(endpoint *route.Endpoint, response *http.Response, startedAt time.Time, duration time.Duration) {
+func (x *RealVarz) CaptureRoutingResponse(endpoint *route.Endpoint, uri route.Uri, response *http.Response, startedAt time.Time, duration time.Duration) {
 	x.Lock()
 
 	var tags string
@@ -275,6 +438,103 @@ func (x *RealVarz) CaptureRoutingResponse(endpoint *route.Endpoint, response *ht
 
 	x.CaptureAppStats(endpoint, startedAt)
 	x.varz.All.CaptureResponse(response, duration)
+	x.varz.UriLatency.CaptureResponse(uri, duration)
+
+	if response != nil && response.StatusCode/100 == 5 {
+		x.backendErrors(endpoint).Responses5xx++
+	}
+
+	x.Unlock()
+}
+
+// invalidResponse is implemented by the errors of backends that sent a
+// response that is not valid HTTP.
+type invalidResponse interface {
+	InvalidResponse() bool
+}
+
+func (x *RealVarz) CaptureBackendFailure(endpoint *route.Endpoint, err error) {
+	x.Lock()
+
+	var ne net.Error
+	var ie invalidResponse
+	if errors.As(err, &ne) && ne.Timeout() {
+		x.backendErrors(endpoint).Timeouts++
+	} else if errors.As(err, &ie) && ie.InvalidResponse() {
+		x.backendErrors(endpoint).InvalidResponses++
+	} else {
+		x.backendErrors(endpoint).ConnectionFailures++
+	}
+
+	x.Unlock()
+}
+
+func (x *RealVarz) backendErrors(endpoint *route.Endpoint) *backendErrors {
+	addr := endpoint.CanonicalAddr()
+
+	y := x.BackendErrors[addr]
+	if y == nil {
+		y = &backendErrors{}
+		x.BackendErrors[addr] = y
+	}
+
+	return y
+}
+
+func (x *RealVarz) CaptureSelectionTime(d time.Duration) {
+	x.Lock()
+	x.SelectionTime.Capture(d)
+	x.Unlock()
+}
+
+// CaptureQueueTime records the time a request waited for a connection slot
+// of a backend at its max_conns_per_backend limit. Requests that did not
+// have to wait are not recorded.
+func (x *RealVarz) CaptureQueueTime(d time.Duration) {
+	x.Lock()
+	x.QueueTime.Capture(d)
+	x.Unlock()
+}
+
+func (x *RealVarz) CaptureRouteBytes(uri route.Uri, received, sent int64) {
+	x.Lock()
+
+	y := x.UriBytes[uri.String()]
+	if y == nil {
+		y = &routeBytes{}
+		x.UriBytes[uri.String()] = y
+	}
+	y.RequestBytes += received
+	y.ResponseBytes += sent
+
+	x.Unlock()
+}
+
+func (x *RealVarz) Counters() Counters {
+	x.Lock()
+	defer x.Unlock()
+
+	return Counters{
+		Requests:     x.All.Requests.Count(),
+		Responses5xx: x.ProxyResponses.Responses5xx,
+	}
+}
+
+func (x *RealVarz) CaptureProxyResponse(status int) {
+	x.Lock()
+
+	switch status / 100 {
+	case 2:
+		x.ProxyResponses.Responses2xx++
+	case 3:
+		x.ProxyResponses.Responses3xx++
+	case 4:
+		x.ProxyResponses.Responses4xx++
+	case 5:
+		x.ProxyResponses.Responses5xx++
+	default:
+		x.ProxyResponses.ResponsesXxx++
+	}
 
 	x.Unlock()
 }
@@ -11,6 +11,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -44,7 +45,13 @@ var _ = Describe("Varz", func() {
 			"bad_gateways",
 			"requests_per_sec",
 			"top10_app_requests",
+			"latency_by_uri",
 			"ms_since_last_registry_update",
+			"proxy_responses",
+			"backend_errors",
+			"backend_selection_time",
+			"backend_queue_time",
+			"bytes_by_uri",
 		}
 
 		b, e := json.Marshal(v)
@@ -102,6 +109,41 @@ var _ = Describe("Varz", func() {
 		Expect(findValue(Varz, "bad_gateways")).To(Equal(float64(2)))
 	})
 
+	It("updates proxy responses by status class", func() {
+		Varz.CaptureProxyResponse(http.StatusOK)
+		Varz.CaptureProxyResponse(http.StatusFound)
+		Varz.CaptureProxyResponse(http.StatusNotFound)
+		Varz.CaptureProxyResponse(http.StatusBadGateway)
+		Varz.CaptureProxyResponse(http.StatusGatewayTimeout)
+		Varz.CaptureProxyResponse(0)
+
+		Expect(findValue(Varz, "proxy_responses", "responses_2xx")).To(Equal(float64(1)))
+		Expect(findValue(Varz, "proxy_responses", "responses_3xx")).To(Equal(float64(1)))
+		Expect(findValue(Varz, "proxy_responses", "responses_4xx")).To(Equal(float64(1)))
+		Expect(findValue(Varz, "proxy_responses", "responses_5xx")).To(Equal(float64(2)))
+		Expect(findValue(Varz, "proxy_responses", "responses_xxx")).To(Equal(float64(1)))
+	})
+
+	It("updates the errors of each backend", func() {
+		var t time.Time
+		var d time.Duration
+
+		b1 := route.NewEndpoint("", "10.0.0.1", 8080, "", nil, -1, "")
+		b2 := route.NewEndpoint("", "10.0.0.2", 8080, "", nil, -1, "")
+
+		Varz.CaptureBackendFailure(b1, errors.New("connection refused"))
+		Varz.CaptureBackendFailure(b1, timeoutError{})
+		Varz.CaptureBackendFailure(b1, invalidResponseError{})
+		Varz.CaptureRoutingResponse(b1, "example.com", &http.Response{StatusCode: http.StatusInternalServerError}, t, d)
+		Varz.CaptureRoutingResponse(b2, "example.com", &http.Response{StatusCode: http.StatusOK}, t, d)
+
+		Expect(findValue(Varz, "backend_errors", "10.0.0.1:8080", "connection_failures")).To(Equal(float64(1)))
+		Expect(findValue(Varz, "backend_errors", "10.0.0.1:8080", "timeouts")).To(Equal(float64(1)))
+		Expect(findValue(Varz, "backend_errors", "10.0.0.1:8080", "invalid_responses")).To(Equal(float64(1)))
+		Expect(findValue(Varz, "backend_errors", "10.0.0.1:8080", "responses_5xx")).To(Equal(float64(1)))
+		Expect(findValue(Varz, "backend_errors")).NotTo(HaveKey("10.0.0.2:8080"))
+	})
+
 	It("updates requests", func() {
 		b := &route.Endpoint{}
 		r := http.Request{}
@@ -148,9 +190,9 @@ var _ = Describe("Varz", func() {
 			StatusCode: http.StatusNotFound,
 		}
 
-		Varz.CaptureRoutingResponse(b, r1, t, d)
-		Varz.CaptureRoutingResponse(b, r2, t, d)
-		Varz.CaptureRoutingResponse(b, r2, t, d)
+		Varz.CaptureRoutingResponse(b, "example.com", r1, t, d)
+		Varz.CaptureRoutingResponse(b, "example.com", r2, t, d)
+		Varz.CaptureRoutingResponse(b, "example.com", r2, t, d)
 
 		Expect(findValue(Varz, "responses_2xx")).To(Equal(float64(1)))
 		Expect(findValue(Varz, "responses_4xx")).To(Equal(float64(2)))
@@ -180,9 +222,9 @@ var _ = Describe("Varz", func() {
 			StatusCode: http.StatusNotFound,
 		}
 
-		Varz.CaptureRoutingResponse(b1, r1, t, d)
-		Varz.CaptureRoutingResponse(b2, r2, t, d)
-		Varz.CaptureRoutingResponse(b2, r2, t, d)
+		Varz.CaptureRoutingResponse(b1, "example.com", r1, t, d)
+		Varz.CaptureRoutingResponse(b2, "example.com", r2, t, d)
+		Varz.CaptureRoutingResponse(b2, "example.com", r2, t, d)
 
 		Expect(findValue(Varz, "tags", "component", "cc", "responses_2xx")).To(Equal(float64(1)))
 		Expect(findValue(Varz, "tags", "component", "cc", "responses_4xx")).To(Equal(float64(2)))
@@ -197,7 +239,7 @@ var _ = Describe("Varz", func() {
 			StatusCode: http.StatusOK,
 		}
 
-		Varz.CaptureRoutingResponse(routeEndpoint, response, startedAt, duration)
+		Varz.CaptureRoutingResponse(routeEndpoint, "example.com", response, startedAt, duration)
 
 		Expect(findValue(Varz, "latency", "50").(float64)).To(Equal(float64(duration) / float64(time.Second)))
 		Expect(findValue(Varz, "latency", "75").(float64)).To(Equal(float64(duration) / float64(time.Second)))
@@ -205,6 +247,104 @@ var _ = Describe("Varz", func() {
 		Expect(findValue(Varz, "latency", "95").(float64)).To(Equal(float64(duration) / float64(time.Second)))
 		Expect(findValue(Varz, "latency", "99").(float64)).To(Equal(float64(duration) / float64(time.Second)))
 	})
+
+	It("updates response latency per uri", func() {
+		var routeEndpoint *route.Endpoint = &route.Endpoint{}
+		var startedAt = time.Now()
+
+		response := &http.Response{
+			StatusCode: http.StatusOK,
+		}
+
+		Varz.CaptureRoutingResponse(routeEndpoint, "foo.vcap.me", response, startedAt, 1*time.Millisecond)
+		Varz.CaptureRoutingResponse(routeEndpoint, "bar.vcap.me/path", response, startedAt, 2*time.Millisecond)
+		Varz.CaptureRoutingResponse(routeEndpoint, "bar.vcap.me/path", response, startedAt, 2*time.Millisecond)
+
+		for _, p := range []string{"50", "90", "99"} {
+			Expect(findValue(Varz, "latency_by_uri", "foo.vcap.me", p).(float64)).To(Equal(float64(time.Millisecond) / float64(time.Second)))
+			Expect(findValue(Varz, "latency_by_uri", "bar.vcap.me/path", p).(float64)).To(Equal(float64(2*time.Millisecond) / float64(time.Second)))
+		}
+	})
+
+	It("updates backend selection and queue times", func() {
+		Varz.CaptureSelectionTime(time.Millisecond)
+		Varz.CaptureQueueTime(200 * time.Millisecond)
+		Varz.CaptureQueueTime(200 * time.Millisecond)
+
+		Expect(findValue(Varz, "backend_selection_time", "count").(float64)).To(Equal(float64(1)))
+		Expect(findValue(Varz, "backend_selection_time", "50").(float64)).To(Equal(float64(time.Millisecond) / float64(time.Second)))
+		Expect(findValue(Varz, "backend_queue_time", "count").(float64)).To(Equal(float64(2)))
+		Expect(findValue(Varz, "backend_queue_time", "99").(float64)).To(Equal(0.2))
+	})
+
+	It("updates bytes by uri", func() {
+		Varz.CaptureRouteBytes("foo.vcap.me", 100, 2000)
+		Varz.CaptureRouteBytes("foo.vcap.me", 50, 1000)
+		Varz.CaptureRouteBytes("bar.vcap.me/path", 10, 20)
+
+		Expect(findValue(Varz, "bytes_by_uri", "foo.vcap.me", "request_bytes")).To(Equal(float64(150)))
+		Expect(findValue(Varz, "bytes_by_uri", "foo.vcap.me", "response_bytes")).To(Equal(float64(3000)))
+		Expect(findValue(Varz, "bytes_by_uri", "bar.vcap.me/path", "request_bytes")).To(Equal(float64(10)))
+		Expect(findValue(Varz, "bytes_by_uri", "bar.vcap.me/path", "response_bytes")).To(Equal(float64(20)))
+	})
+
+	Context("when routes go away", func() {
+		var endpoint *route.Endpoint
+
+		BeforeEach(func() {
+			endpoint = route.NewEndpoint("", "10.0.0.1", 8080, "", nil, -1, "")
+			Registry.Register("foo.vcap.me", endpoint)
+			Registry.Register("bar.vcap.me", endpoint)
+
+			for _, uri := range []route.Uri{"foo.vcap.me", "bar.vcap.me"} {
+				Varz.CaptureRoutingResponse(endpoint, uri, &http.Response{StatusCode: http.StatusOK}, time.Now(), time.Millisecond)
+				Varz.CaptureRouteBytes(uri, 10, 20)
+			}
+			Varz.CaptureBackendFailure(endpoint, errors.New("connection refused"))
+		})
+
+		It("forgets the metrics of an unregistered route", func() {
+			Registry.Unregister("foo.vcap.me", endpoint)
+
+			Expect(findValue(Varz, "latency_by_uri")).NotTo(HaveKey("foo.vcap.me"))
+			Expect(findValue(Varz, "bytes_by_uri")).NotTo(HaveKey("foo.vcap.me"))
+			Expect(findValue(Varz, "latency_by_uri")).To(HaveKey("bar.vcap.me"))
+			Expect(findValue(Varz, "bytes_by_uri")).To(HaveKey("bar.vcap.me"))
+		})
+
+		It("keeps the errors of a backend until it is registered for no route", func() {
+			Registry.Unregister("foo.vcap.me", endpoint)
+			Expect(findValue(Varz, "backend_errors")).To(HaveKey("10.0.0.1:8080"))
+
+			Registry.Unregister("bar.vcap.me", endpoint)
+			Expect(findValue(Varz, "backend_errors")).NotTo(HaveKey("10.0.0.1:8080"))
+		})
+
+		It("forgets the metrics of routes unregistered by pattern", func() {
+			_, err := Registry.UnregisterPattern("*.vcap.me")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(findValue(Varz, "latency_by_uri")).To(BeEmpty())
+			Expect(findValue(Varz, "bytes_by_uri")).To(BeEmpty())
+			Expect(findValue(Varz, "backend_errors")).NotTo(HaveKey("10.0.0.1:8080"))
+		})
+
+		It("keeps the metrics of a route that still has endpoints", func() {
+			other := route.NewEndpoint("", "10.0.0.2", 8080, "", nil, -1, "")
+			Registry.Register("foo.vcap.me", other)
+
+			Registry.Unregister("foo.vcap.me", endpoint)
+
+			Expect(findValue(Varz, "latency_by_uri")).To(HaveKey("foo.vcap.me"))
+			Expect(findValue(Varz, "bytes_by_uri")).To(HaveKey("foo.vcap.me"))
+		})
+	})
+
+	It("does not track latency without a uri", func() {
+		Varz.CaptureRoutingResponse(&route.Endpoint{}, "", &http.Response{}, time.Now(), time.Millisecond)
+
+		Expect(findValue(Varz, "latency_by_uri")).To(BeEmpty())
+	})
 })
 
 // Extract value using key(s) from JSON data
@@ -235,3 +375,14 @@ func findValue(varz Varz, x ...string) interface{} {
 
 	return z
 }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type invalidResponseError struct{}
+
+func (invalidResponseError) Error() string         { return "malformed HTTP response" }
+func (invalidResponseError) InvalidResponse() bool { return true }